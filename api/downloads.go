@@ -0,0 +1,283 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	guac2 "github.com/browsersec/KubeBrowse/internal/guac"
+	"github.com/browsersec/KubeBrowse/internal/metrics"
+	minio2 "github.com/browsersec/KubeBrowse/internal/minio"
+	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
+	"github.com/browsersec/KubeBrowse/internal/scanner"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxDownloadBytes = int64(100 << 20) // 100 MiB, like uploads
+	downloadLinkTTL  = 5 * time.Minute
+	downloadScanTime = 60 * time.Second
+)
+
+// newDownloadInterceptor intercepts the files the sandbox of a session sends to the user.
+// They are stored in MinIO and scanned for malware, and only clean files can be downloaded
+// through a short-lived link, see HandlerCreateDownloadLink. Without a download store or
+// a malware scanner downloads are refused.
+func newDownloadInterceptor(guacd io.Writer, connectionID string, store *minio2.DownloadStore, redisClient *redis.Client, malwareScanner scanner.Scanner) *guac2.DownloadInterceptor {
+	return guac2.NewDownloadInterceptor(guacd, &sessionDownloads{
+		connectionID: connectionID,
+		store:        store,
+		redisClient:  redisClient,
+		scanner:      malwareScanner,
+	})
+}
+
+// sessionDownloads receives the downloads of one session, see guac.DownloadHandler
+type sessionDownloads struct {
+	connectionID string
+	store        *minio2.DownloadStore
+	redisClient  *redis.Client
+	scanner      scanner.Scanner
+}
+
+func (s *sessionDownloads) StartDownload(filename, mimeType string) (guac2.DownloadWriter, error) {
+	if s.store == nil || s.scanner == nil {
+		metrics.Downloads.WithLabelValues("refused").Inc()
+		return nil, fmt.Errorf("downloads are not available")
+	}
+	if s.connectionID == "" {
+		metrics.Downloads.WithLabelValues("refused").Inc()
+		return nil, fmt.Errorf("downloads require a session")
+	}
+
+	id, err := redis2.NewDownloadID()
+	if err != nil {
+		return nil, err
+	}
+	download := &redis2.Download{
+		ID:           id,
+		ConnectionID: s.connectionID,
+		Filename:     filename,
+		MimeType:     mimeType,
+		Object:       minio2.DownloadObjectName(s.connectionID, id),
+		Status:       redis2.DownloadReceiving,
+		CreatedAt:    time.Now(),
+	}
+	if err = redis2.SaveDownload(s.redisClient, download); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Receiving download %q (%s) from session %s", filename, id, s.connectionID)
+	return &downloadWriter{
+		downloads: s,
+		download:  download,
+		object:    s.store.Create(download.Object, mimeType),
+	}, nil
+}
+
+// downloadWriter stores a download and starts its scan once it is complete
+type downloadWriter struct {
+	downloads *sessionDownloads
+	download  *redis2.Download
+
+	mu     sync.Mutex
+	object *minio2.DownloadWriter
+	done   bool
+}
+
+func (w *downloadWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return 0, fmt.Errorf("download closed")
+	}
+	if w.object.Size()+int64(len(p)) > maxDownloadBytes {
+		return 0, fmt.Errorf("file too large")
+	}
+	return w.object.Write(p)
+}
+
+func (w *downloadWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return nil
+	}
+	w.done = true
+
+	if err := w.object.Close(); err != nil {
+		w.downloads.fail(w.download, err)
+		return err
+	}
+	w.download.Size = w.object.Size()
+	w.download.Status = redis2.DownloadScanning
+	if err := redis2.SaveDownload(w.downloads.redisClient, w.download); err != nil {
+		logrus.Warnf("Failed to update download %s: %v", w.download.ID, err)
+	}
+	go w.downloads.scan(*w.download)
+	return nil
+}
+
+func (w *downloadWriter) Abort(reason error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	w.done = true
+
+	w.object.Abort(reason)
+	w.downloads.fail(w.download, reason)
+}
+
+// scan releases a download if the malware scanner finds it clean and removes it otherwise
+func (s *sessionDownloads) scan(download redis2.Download) {
+	ctx, cancel := context.WithTimeout(context.Background(), downloadScanTime)
+	defer cancel()
+
+	object, _, err := s.store.Open(ctx, download.Object)
+	if err != nil {
+		s.fail(&download, err)
+		return
+	}
+	verdict, err := s.scanner.Scan(ctx, object, download.Filename)
+	if closeErr := object.Close(); closeErr != nil {
+		logrus.Errorf("Failed to close download object: %v", closeErr)
+	}
+	if err != nil {
+		s.fail(&download, err)
+		return
+	}
+
+	download.ScannedAt = time.Now()
+	if verdict.Infected {
+		download.Status = redis2.DownloadInfected
+		download.Viruses = verdict.Threats
+		logrus.Warnf("Download %q (%s) of session %s is infected: %v", download.Filename, download.ID, download.ConnectionID, verdict.Threats)
+		if err := s.store.Remove(ctx, download.Object); err != nil {
+			logrus.Errorf("Failed to remove infected download %s: %v", download.ID, err)
+		}
+	} else {
+		download.Status = redis2.DownloadClean
+		logrus.Infof("Download %q (%s) of session %s is clean", download.Filename, download.ID, download.ConnectionID)
+	}
+	metrics.Downloads.WithLabelValues(download.Status).Inc()
+
+	if err := redis2.SaveDownload(s.redisClient, &download); err != nil {
+		logrus.Errorf("Failed to update download %s: %v", download.ID, err)
+	}
+}
+
+// fail marks a download as failed, it is never released
+func (s *sessionDownloads) fail(download *redis2.Download, err error) {
+	logrus.Warnf("Download %q (%s) of session %s failed: %v", download.Filename, download.ID, download.ConnectionID, err)
+	metrics.Downloads.WithLabelValues(redis2.DownloadFailed).Inc()
+
+	download.Status = redis2.DownloadFailed
+	download.Error = err.Error()
+	if saveErr := redis2.SaveDownload(s.redisClient, download); saveErr != nil {
+		logrus.Errorf("Failed to update download %s: %v", download.ID, saveErr)
+	}
+	if s.store != nil {
+		if removeErr := s.store.Remove(context.Background(), download.Object); removeErr != nil {
+			logrus.Debugf("Failed to remove failed download %s: %v", download.ID, removeErr)
+		}
+	}
+}
+
+// HandlerListDownloads returns the files the sandbox of a session sent and their scan status
+func HandlerListDownloads(c *gin.Context, redisClient *redis.Client) {
+	connectionID := c.Param("connectionID")
+
+	downloads, err := redis2.ListDownloads(redisClient, connectionID)
+	if err != nil {
+		logrus.Errorf("Failed to list downloads of session %s: %v", connectionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list downloads"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"downloads": downloads})
+}
+
+// HandlerCreateDownloadLink creates a short-lived link to a clean download
+func HandlerCreateDownloadLink(c *gin.Context, redisClient *redis.Client) {
+	connectionID := c.Param("connectionID")
+
+	download, err := redis2.GetDownload(redisClient, c.Param("downloadID"))
+	if errors.Is(err, redis2.ErrDownloadNotFound) || (err == nil && download.ConnectionID != connectionID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Download not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to get download of session %s: %v", connectionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get download"})
+		return
+	}
+	if download.Status != redis2.DownloadClean {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Download is %s", download.Status), "download": download})
+		return
+	}
+
+	link, err := redis2.CreateDownloadLink(redisClient, download, downloadLinkTTL)
+	if err != nil {
+		logrus.Errorf("Failed to create download link of session %s: %v", connectionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"link":         link,
+		"download_url": fmt.Sprintf("/downloads/%s", link.Token),
+	})
+}
+
+// HandlerDownload sends the file of a download link
+func HandlerDownload(c *gin.Context, redisClient *redis.Client, store *minio2.DownloadStore) {
+	link, err := redis2.GetDownloadLink(redisClient, c.Param("token"))
+	if errors.Is(err, redis2.ErrDownloadLinkNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Download link not found or expired"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to get download link: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get download link"})
+		return
+	}
+
+	download, err := redis2.GetDownload(redisClient, link.DownloadID)
+	if err != nil || download.Status != redis2.DownloadClean {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Download not found"})
+		return
+	}
+	if store == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Downloads are not configured"})
+		return
+	}
+
+	object, size, err := store.Open(c.Request.Context(), download.Object)
+	if err != nil {
+		logrus.Errorf("Failed to open download %s: %v", download.ID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Download not found"})
+		return
+	}
+	defer func() {
+		if err := object.Close(); err != nil {
+			logrus.Errorf("Failed to close download object: %v", err)
+		}
+	}()
+
+	contentType := download.MimeType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": download.Filename}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.DataFromReader(http.StatusOK, size, contentType, object, nil)
+}
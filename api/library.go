@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/browsersec/KubeBrowse/internal/auth"
+	minio2 "github.com/browsersec/KubeBrowse/internal/minio"
+	"github.com/browsersec/KubeBrowse/internal/reputation"
+	"github.com/browsersec/KubeBrowse/internal/scanner"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// libraryTagTimeout bounds tagging a stored upload with its scan verdict
+const libraryTagTimeout = 10 * time.Second
+
+// uploadOwner returns the ID of the signed in user of a request, whose library uploads are
+// stored in, or minio2.AnonymousOwner
+func uploadOwner(c *gin.Context) string {
+	if user, ok := c.Get(auth.UserContextKey); ok {
+		if authUser, ok := user.(*auth.User); ok {
+			return authUser.ID.String()
+		}
+	}
+	return minio2.AnonymousOwner
+}
+
+// tagStoredUpload tags an upload stored in the library with the verdict of its scan, files
+// that could not be scanned are tagged unscanned
+func tagStoredUpload(library *minio2.FileLibrary, results []UploadResult) {
+	stored := results[uploadToStorage]
+	data, ok := stored.Data.(map[string]interface{})
+	if !stored.Success || !ok {
+		return
+	}
+	object, _ := data["object_name"].(string)
+
+	verdict := minio2.VerdictUnscanned
+	if scanned, err := scanVerdict(results[uploadToScanner]); err == nil {
+		verdict = minio2.VerdictClean
+		if scanned.Infected {
+			verdict = minio2.VerdictInfected
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), libraryTagTimeout)
+	defer cancel()
+	if err := library.SetVerdict(ctx, object, verdict); err != nil {
+		logrus.Errorf("Failed to tag %s with its scan verdict %s: %v", object, verdict, err)
+		return
+	}
+	data["scan_verdict"] = verdict
+}
+
+// HandlerListLibraryFiles returns the newest files the user uploaded, which can be sent to
+// their later sessions
+func HandlerListLibraryFiles(c *gin.Context, library *minio2.FileLibrary) {
+	limit := 100
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = parsed
+	}
+
+	files, err := library.List(c.Request.Context(), uploadOwner(c), limit)
+	if err != nil {
+		logrus.Errorf("Failed to list library files: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list files"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"files": files})
+}
+
+// HandlerSendLibraryFile sends a file from the library of the user to the sandbox of a
+// session without uploading it again. It is scanned again unless its hash has a known
+// verdict; files found infected when they were uploaded are refused.
+func HandlerSendLibraryFile(c *gin.Context, redisClient *redis.Client, library *minio2.FileLibrary, quarantine *minio2.QuarantineStore, malwareScanner scanner.Scanner, fileReputation *reputation.Service) {
+	start := time.Now()
+	connectionID := c.Param("connectionID")
+
+	owner := uploadOwner(c)
+	if owner == minio2.AnonymousOwner {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	url, session, err := getFQDNURL(connectionID, redisClient)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+
+	file, stored, err := library.Open(ctx, owner, c.Param("fileID"))
+	if errors.Is(err, minio2.ErrFileNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to open library file %s: %v", c.Param("fileID"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open file"})
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	if stored.ScanVerdict == minio2.VerdictInfected {
+		c.JSON(http.StatusForbidden, gin.H{"error": "file is infected"})
+		return
+	}
+
+	logrus.Infof("Sending library file %s (%q) to session %s", stored.ID, stored.Filename, connectionID)
+	results, err := deliverUpload(ctx, file, stored.Filename, connectionID, session, url, newSandboxDestinations(malwareScanner), quarantine, fileReputation, stored.Size)
+	respondUpload(c, start, connectionID, results, err, sandboxUploadSucceeded)
+}
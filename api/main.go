@@ -3,13 +3,16 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/browsersec/KubeBrowse/internal/guac"
+	"github.com/browsersec/KubeBrowse/internal/history"
 	k8s2 "github.com/browsersec/KubeBrowse/internal/k8s"
 
 	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
@@ -17,14 +20,18 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 // Struct for request body
 type DeploySessionRequest struct {
-	Height string `json:"height"`
-	Width  string `json:"width"`
-	Share  bool   `json:"share,omitempty"` // Added optional share field
+	Height  string `json:"height"`
+	Width   string `json:"width"`
+	Share   bool   `json:"share,omitempty"`   // Added optional share field
+	Record  bool   `json:"record,omitempty"`  // Record the session to MinIO
+	Profile string `json:"profile,omitempty"` // Sandbox profile, defaults to the profile of the route
 }
 
 // DeployOffice godoc
@@ -39,7 +46,7 @@ type DeploySessionRequest struct {
 // @Failure 503 {object} gin.H{"error":string}
 // @Failure 500 {object} gin.H{"error":string}
 // @Router /test/deploy-office [post]
-func DeployOffice(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace string, redisClient *redis.Client, tunnelStore *guac.ActiveTunnelStore) {
+func DeployOffice(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace string, redisClient *redis.Client, tunnelStore *guac.ActiveTunnelStore, warmPool *k8s2.WarmPool, sessions *k8s2.BrowserSessionController, sessionHistory *history.Service) {
 
 	if k8sClient == nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{
@@ -55,11 +62,27 @@ func DeployOffice(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace
 		return
 	}
 
+	if reqBody.Profile == "" {
+		reqBody.Profile = k8s2.SandboxTypeOffice
+	}
+	profile, ok := k8s2.GetSandboxProfile(reqBody.Profile)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown sandbox profile: %s", reqBody.Profile)})
+		return
+	}
+
 	// Generate a unique pod name
 	podName := "office-" + uuid.New().String()[0:8]
 
-	// Create an office sandbox pod
-	pod, err := k8s2.CreateOfficeSandboxPod(k8sClient, k8sNamespace, podName)
+	// Generate a unique connection ID
+	connectionID := uuid.New().String()
+
+	pod, err := deploySandboxPod(k8sClient, k8sNamespace, profile, podName, sessionOwner(c), connectionID, reqBody, warmPool, sessions)
+	if errors.Is(err, errPodNotReady) {
+		logrus.Errorf("Pod not ready: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Pod not ready for RDP connection"})
+		return
+	}
 	if err != nil {
 		logrus.Errorf("Failed to create office pod: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -71,17 +94,14 @@ func DeployOffice(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace
 	// Construct the FQDN
 	fqdn := fmt.Sprintf("%s.sandbox-instances.browser-sandbox.svc.cluster.local", pod.Name)
 
-	// Generate a unique connection ID
-	connectionID := uuid.New().String()
-
-	// Wait for pod readiness and RDP port
-	err = k8s2.WaitForPodReadyAndRDP(k8sClient, k8sNamespace, pod.Name, fqdn, 120*time.Second)
+	// Every pod has its own login, it is only kept in the session's connection parameters
+	credentials, err := k8s2.GetSandboxCredentials(k8sClient, k8sNamespace, pod.Name)
 	if err != nil {
-		logrus.Errorf("Pod not ready: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Pod not ready for RDP connection"})
-
+		logrus.Errorf("Failed to get credentials of pod %s: %v", pod.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sandbox credentials"})
 		return
 	}
+
 	podIP := pod.Status.PodIP
 	if podIP == "" {
 		logrus.Errorf("Pod IP is empty for connectionID: %s", connectionID)
@@ -96,11 +116,11 @@ func DeployOffice(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace
 
 	// Store connection parameters in memory (in a real implementation, use a secure storage)
 	params := url.Values{}
-	params.Set("scheme", "rdp")
+	params.Set("scheme", profile.Protocol())
 	params.Set("hostname", fqdn)
-	params.Set("username", "rdpuser")
-	params.Set("password", "money4band")
-	params.Set("port", "3389")
+	params.Set("username", credentials.Username)
+	params.Set("password", credentials.Password)
+	params.Set("port", strconv.Itoa(profile.Port()))
 	params.Set("security", "")
 	params.Set("width", reqBody.Width)
 	params.Set("height", reqBody.Height)
@@ -119,19 +139,28 @@ func DeployOffice(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace
 		ConnectionParams: map[string]string{
 			"hostname":    fqdn,
 			"ignore-cert": "true",
-			"password":    "money4band",
-			"port":        "3389",
-			"scheme":      "rdp",
+			"password":    credentials.Password,
+			"port":        strconv.Itoa(profile.Port()),
+			"scheme":      profile.Protocol(),
 			"security":    "",
-			"username":    "rdpuser",
+			"username":    credentials.Username,
 			"height":      reqBody.Height,
 			"width":       reqBody.Width,
 			"uuid":        connectionID,
 		},
-		Share: reqBody.Share, // Include the share value
+		Share:   reqBody.Share, // Include the share value
+		Record:  reqBody.Record,
+		Profile: profile.Name,
+		OwnerID: sessionOwner(c),
 	}
 	data, _ := json.Marshal(session)
 	redisClient.Set(context.Background(), "session:"+connectionID, data, 0)
+	sessionHistory.Created(history.Session{
+		ConnectionID: connectionID,
+		Profile:      profile.Name,
+		PodName:      pod.Name,
+		NodeName:     pod.Spec.NodeName,
+	}, session.OwnerID)
 
 	// Return only the connection ID to the client
 	c.JSON(http.StatusCreated, gin.H{
@@ -143,6 +172,45 @@ func DeployOffice(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace
 	})
 }
 
+var errPodNotReady = errors.New("pod not ready for RDP connection")
+
+// deploySandboxPod returns a sandbox pod of owner accepting RDP connections for a new session.
+// With the BrowserSession controller the pod is provisioned through a BrowserSession
+// named after the connection ID, otherwise it is taken from the warm pool or created here.
+func deploySandboxPod(k8sClient *kubernetes.Clientset, k8sNamespace string, profile *k8s2.SandboxProfile, podName, owner, connectionID string, reqBody DeploySessionRequest, warmPool *k8s2.WarmPool, sessions *k8s2.BrowserSessionController) (*corev1.Pod, error) {
+	if sessions != nil {
+		session, err := sessions.Provision(context.Background(), connectionID, k8s2.BrowserSessionSpec{
+			Type:           profile.Name,
+			User:           owner,
+			TimeoutSeconds: int64(SESSION_TIMEOUT) * 60,
+			Share:          reqBody.Share,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errPodNotReady, err)
+		}
+		return k8sClient.CoreV1().Pods(k8sNamespace).Get(context.Background(), session.Status.PodName, metav1.GetOptions{})
+	}
+
+	// Take a ready pod from the warm pool if there is one, otherwise create one and wait for it
+	if pod, warm := warmPool.Acquire(profile.Name, owner, connectionID); warm {
+		return pod, nil
+	}
+
+	pod, err := k8s2.CreateSandboxPod(k8sClient, k8sNamespace, profile.Name, podName, k8s2.SandboxPodOptions{
+		Labels:      map[string]string{"user": owner},
+		Annotations: map[string]string{k8s2.ConnectionIDAnnotation: connectionID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fqdn := fmt.Sprintf("%s.sandbox-instances.browser-sandbox.svc.cluster.local", pod.Name)
+	if err = k8s2.WaitForPodReady(k8sClient, k8sNamespace, pod.Name, fqdn, profile.Port(), 120*time.Second); err != nil {
+		return nil, fmt.Errorf("%w: %v", errPodNotReady, err)
+	}
+	return pod, nil
+}
+
 // DeployBrowser godoc
 // @Summary New route for deploying and connecting to browser pod with RDP credentials
 // @Schemes
@@ -155,7 +223,7 @@ func DeployOffice(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace
 // @Failure 503 {object} gin.H{"error":string}
 // @Failure 500 {object} gin.H{"error":string}
 // @Router /test/deploy-browser [post]
-func DeployBrowser(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace string, redisClient *redis.Client, tunnelStore *guac.ActiveTunnelStore) {
+func DeployBrowser(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace string, redisClient *redis.Client, tunnelStore *guac.ActiveTunnelStore, warmPool *k8s2.WarmPool, sessions *k8s2.BrowserSessionController, sessionHistory *history.Service) {
 
 	if k8sClient == nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{
@@ -171,13 +239,29 @@ func DeployBrowser(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace
 		return
 	}
 
+	if reqBody.Profile == "" {
+		reqBody.Profile = k8s2.SandboxTypeBrowser
+	}
+	profile, ok := k8s2.GetSandboxProfile(reqBody.Profile)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown sandbox profile: %s", reqBody.Profile)})
+		return
+	}
+
 	// Generate a unique pod name
 	podName := "browser-" + uuid.New().String()[0:8]
 
-	// Create an office sandbox pod
-	pod, err := k8s2.CreateBrowserSandboxPod(k8sClient, k8sNamespace, podName)
+	// Generate a unique connection ID
+	connectionID := uuid.New().String()
+
+	pod, err := deploySandboxPod(k8sClient, k8sNamespace, profile, podName, sessionOwner(c), connectionID, reqBody, warmPool, sessions)
+	if errors.Is(err, errPodNotReady) {
+		logrus.Errorf("Pod not ready: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Pod not ready for RDP connection"})
+		return
+	}
 	if err != nil {
-		logrus.Errorf("Failed to create office pod: %v", err)
+		logrus.Errorf("Failed to create browser pod: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": fmt.Sprintf("Failed to create browser pod: %v", err),
 		})
@@ -187,16 +271,14 @@ func DeployBrowser(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace
 	// Construct the FQDN
 	fqdn := fmt.Sprintf("%s.sandbox-instances.browser-sandbox.svc.cluster.local", pod.Name)
 
-	// Generate a unique connection ID
-	connectionID := uuid.New().String()
-
-	// Wait for pod readiness and RDP port
-	err = k8s2.WaitForPodReadyAndRDP(k8sClient, k8sNamespace, pod.Name, fqdn, 120*time.Second)
+	// Every pod has its own login, it is only kept in the session's connection parameters
+	credentials, err := k8s2.GetSandboxCredentials(k8sClient, k8sNamespace, pod.Name)
 	if err != nil {
-		logrus.Errorf("Pod not ready: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Pod not ready for RDP connection"})
+		logrus.Errorf("Failed to get credentials of pod %s: %v", pod.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sandbox credentials"})
 		return
 	}
+
 	podIP := pod.Status.PodIP
 	if podIP == "" {
 		logrus.Errorf("Pod IP is empty for connectionID: %s", connectionID)
@@ -211,11 +293,11 @@ func DeployBrowser(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace
 
 	// Store connection parameters in memory (in a real implementation, use a secure storage)
 	params := url.Values{}
-	params.Set("scheme", "rdp")
+	params.Set("scheme", profile.Protocol())
 	params.Set("hostname", fqdn)
-	params.Set("username", "rdpuser")
-	params.Set("password", "money4band")
-	params.Set("port", "3389")
+	params.Set("username", credentials.Username)
+	params.Set("password", credentials.Password)
+	params.Set("port", strconv.Itoa(profile.Port()))
 	params.Set("security", "")
 	params.Set("width", reqBody.Width)
 	params.Set("height", reqBody.Height)
@@ -234,19 +316,28 @@ func DeployBrowser(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace
 		ConnectionParams: map[string]string{
 			"hostname":    fqdn,
 			"ignore-cert": "true",
-			"password":    "money4band",
-			"port":        "3389",
-			"scheme":      "rdp",
+			"password":    credentials.Password,
+			"port":        strconv.Itoa(profile.Port()),
+			"scheme":      profile.Protocol(),
 			"security":    "",
-			"username":    "rdpuser",
+			"username":    credentials.Username,
 			"height":      reqBody.Height,
 			"width":       reqBody.Width,
 			"uuid":        connectionID,
 		},
-		Share: reqBody.Share, // Include the share value
+		Share:   reqBody.Share, // Include the share value
+		Record:  reqBody.Record,
+		Profile: profile.Name,
+		OwnerID: sessionOwner(c),
 	}
 	data, _ := json.Marshal(session)
 	redisClient.Set(context.Background(), "session:"+connectionID, data, 0)
+	sessionHistory.Created(history.Session{
+		ConnectionID: connectionID,
+		Profile:      profile.Name,
+		PodName:      pod.Name,
+		NodeName:     pod.Spec.NodeName,
+	}, session.OwnerID)
 
 	// Return only the connection ID to the client
 	c.JSON(http.StatusCreated, gin.H{
@@ -295,104 +386,7 @@ func HandlerConnectionID(c *gin.Context, tunnelStore *guac.ActiveTunnelStore, re
 	})
 }
 
-func HandlerShareSession(c *gin.Context, tunnelStore *guac.ActiveTunnelStore, redisClient *redis.Client) {
-	connectionID := c.Param("connectionID")
-	if connectionID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Connection ID is required"})
-		return
-	}
-
-	ctx := context.Background()
-	sessionKey := fmt.Sprintf("session:%s", connectionID)
-
-	// Get current session data and TTL
-	sessionJSON, err := redisClient.Get(ctx, sessionKey).Result()
-	if err != nil {
-		logrus.Errorf("Failed to get session data for %s: %v", connectionID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session data"})
-		return
-	}
-
-	// Get current TTL before modifying the session
-	currentTTL, err := redisClient.TTL(ctx, sessionKey).Result()
-	if err != nil {
-		logrus.Errorf("Failed to get TTL for session %s: %v", connectionID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session TTL"})
-		return
-	}
-
-	// If TTL is -1 (no expiration) or -2 (key doesn't exist), handle appropriately
-	if currentTTL == -2*time.Second {
-		logrus.Errorf("Session %s does not exist", connectionID)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
-		return
-	}
-	if currentTTL == -1*time.Second {
-		// Session has no expiration, set a default timeout
-		currentTTL = 10 * time.Minute
-		logrus.Warnf("Session %s has no TTL, setting default 10 minutes", connectionID)
-	}
-
-	// Parse session data
-	var sessionData redis2.SessionData
-	err = json.Unmarshal([]byte(sessionJSON), &sessionData)
-	if err != nil {
-		logrus.Errorf("Failed to unmarshal session data for %s: %v", connectionID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unmarshal session data"})
-		return
-	}
-
-	if sessionData.Share {
-		logrus.Warnf("Session %s is already shared, skipping update", connectionID)
-		c.JSON(http.StatusOK, gin.H{
-			"message":       "Session is already shared",
-			"status":        "ready",
-			"websocket_url": fmt.Sprintf("/websocket-tunnel/share?uuid=%s", connectionID),
-		})
-		return
-	}
-
-	// Update the share flag
-	sessionData.Share = true
-	logrus.Infof("Enabling sharing for session %s (TTL: %v)", connectionID, currentTTL.Round(time.Second))
-
-	// Marshal updated session data
-	updatedData, err := json.Marshal(sessionData)
-	if err != nil {
-		logrus.Errorf("Failed to marshal updated session data for %s: %v", connectionID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal session data"})
-		return
-	}
-
-	// Update Redis with preserved TTL
-	err = redisClient.Set(ctx, sessionKey, updatedData, currentTTL).Err()
-	if err != nil {
-		logrus.Errorf("Failed to update session data for %s: %v", connectionID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update session data"})
-		return
-	}
-
-	logrus.Infof("Successfully enabled sharing for session %s with preserved TTL %v", connectionID, currentTTL.Round(time.Second))
-
-	// Check if connection parameters exist
-	_, exists := tunnelStore.GetConnectionParams(connectionID)
-	if !exists {
-		logrus.Errorf("Connection parameters not found for %s", connectionID)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Connection parameters not found"})
-		return
-	}
-
-	// Construct the websocket URL with only the connection ID
-	wsURL := fmt.Sprintf("/websocket-tunnel/share?uuid=%s", connectionID)
-
-	c.JSON(http.StatusOK, gin.H{
-		"websocket_url": wsURL,
-		"status":        "ready",
-		"message":       "Session sharing enabled successfully",
-	})
-}
-
-func HandlerBrowserPod(c *gin.Context, tunnelStore *guac.ActiveTunnelStore, k8sClient *kubernetes.Clientset, k8sNamespace string) {
+func HandlerBrowserPod(c *gin.Context, tunnelStore *guac.ActiveTunnelStore, k8sClient *kubernetes.Clientset, k8sNamespace string, sessions *k8s2.BrowserSessionController) {
 	if k8sClient == nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"error": "Kubernetes client not initialized",
@@ -400,11 +394,8 @@ func HandlerBrowserPod(c *gin.Context, tunnelStore *guac.ActiveTunnelStore, k8sC
 		return
 	}
 
-	// Generate a dummy user ID for testing
-	userID := "test-" + uuid.New().String()[0:8]
-
 	// Create a browser sandbox pod
-	pod, err := k8s2.CreateBrowserSandboxPod(k8sClient, k8sNamespace, userID+"-browser")
+	pod, err := createTestSandboxPod(c, k8sClient, k8sNamespace, k8s2.SandboxTypeBrowser, sessions)
 	if err != nil {
 		logrus.Errorf("Failed to create browser pod: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -426,7 +417,7 @@ func HandlerBrowserPod(c *gin.Context, tunnelStore *guac.ActiveTunnelStore, k8sC
 	})
 }
 
-func HandlerOfficePod(c *gin.Context, tunnelStore *guac.ActiveTunnelStore, k8sClient *kubernetes.Clientset, k8sNamespace string) {
+func HandlerOfficePod(c *gin.Context, tunnelStore *guac.ActiveTunnelStore, k8sClient *kubernetes.Clientset, k8sNamespace string, sessions *k8s2.BrowserSessionController) {
 	if k8sClient == nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"error": "Kubernetes client not initialized",
@@ -434,11 +425,8 @@ func HandlerOfficePod(c *gin.Context, tunnelStore *guac.ActiveTunnelStore, k8sCl
 		return
 	}
 
-	// Generate a dummy user ID for testing
-	userID := "test-" + uuid.New().String()[0:8]
-
 	// Create an office sandbox pod
-	pod, err := k8s2.CreateOfficeSandboxPod(k8sClient, k8sNamespace, userID+"-office")
+	pod, err := createTestSandboxPod(c, k8sClient, k8sNamespace, k8s2.SandboxTypeOffice, sessions)
 	if err != nil {
 		logrus.Errorf("Failed to create office pod: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -460,3 +448,25 @@ func HandlerOfficePod(c *gin.Context, tunnelStore *guac.ActiveTunnelStore, k8sCl
 	})
 
 }
+
+// createTestSandboxPod creates a sandbox pod of the signed in user for the test routes. With
+// the BrowserSession controller the pod belongs to a BrowserSession and is ready when returned.
+func createTestSandboxPod(c *gin.Context, k8sClient *kubernetes.Clientset, k8sNamespace, sandboxType string, sessions *k8s2.BrowserSessionController) (*corev1.Pod, error) {
+	if sessions != nil {
+		session, err := sessions.Provision(context.Background(), uuid.New().String(), k8s2.BrowserSessionSpec{
+			Type:           sandboxType,
+			User:           sessionOwner(c),
+			TimeoutSeconds: int64(SESSION_TIMEOUT) * 60,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return k8sClient.CoreV1().Pods(k8sNamespace).Get(context.Background(), session.Status.PodName, metav1.GetOptions{})
+	}
+
+	// Generate a dummy user ID for testing
+	userID := "test-" + uuid.New().String()[0:8]
+	return k8s2.CreateSandboxPod(k8sClient, k8sNamespace, sandboxType, userID+"-"+sandboxType, k8s2.SandboxPodOptions{
+		Labels: map[string]string{"user": sessionOwner(c)},
+	})
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/browsersec/KubeBrowse/internal/k8s"
+	"github.com/browsersec/KubeBrowse/internal/policy"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// DomainCategoriesRequest sets the categories of a domain
+type DomainCategoriesRequest struct {
+	Categories []string `json:"categories" binding:"required"`
+}
+
+// HandlerListPolicies returns the URL filtering policies of all profiles
+func HandlerListPolicies(c *gin.Context, policies *policy.Service) {
+	list, err := policies.ListPolicies(c.Request.Context())
+	if err != nil {
+		logrus.Errorf("Failed to list egress policies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list policies"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"policies": list})
+}
+
+// HandlerGetPolicy returns the URL filtering policy of a profile
+func HandlerGetPolicy(c *gin.Context, policies *policy.Service) {
+	p, err := policies.GetPolicy(c.Request.Context(), c.Param("profile"))
+	if errors.Is(err, policy.ErrPolicyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Policy not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to get egress policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policy"})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// HandlerPutPolicy creates or replaces the URL filtering policy of a profile
+func HandlerPutPolicy(c *gin.Context, policies *policy.Service) {
+	var req policy.Policy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	req.Profile = c.Param("profile")
+	if _, ok := k8s.GetSandboxProfile(req.Profile); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown sandbox profile: " + req.Profile})
+		return
+	}
+
+	saved, err := policies.PutPolicy(c.Request.Context(), req)
+	var invalid *policy.ValidationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to save egress policy of %s: %v", req.Profile, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save policy"})
+		return
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+// HandlerDeletePolicy deletes the URL filtering policy of a profile
+func HandlerDeletePolicy(c *gin.Context, policies *policy.Service) {
+	err := policies.DeletePolicy(c.Request.Context(), c.Param("profile"))
+	if errors.Is(err, policy.ErrPolicyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Policy not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to delete egress policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Policy deleted"})
+}
+
+// HandlerListDomainCategories returns the categories of all categorised domains
+func HandlerListDomainCategories(c *gin.Context, policies *policy.Service) {
+	categories, err := policies.ListDomainCategories(c.Request.Context())
+	if err != nil {
+		logrus.Errorf("Failed to list domain categories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list domain categories"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"domains": categories})
+}
+
+// HandlerPutDomainCategories replaces the categories of a domain
+func HandlerPutDomainCategories(c *gin.Context, policies *policy.Service) {
+	var req DomainCategoriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	domain := c.Param("domain")
+	err := policies.SetDomainCategories(c.Request.Context(), domain, req.Categories)
+	var invalid *policy.ValidationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to set categories of %s: %v", domain, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set domain categories"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"domain": policy.NormalizeDomain(domain), "categories": req.Categories})
+}
+
+// HandlerDeleteDomainCategories removes all categories of a domain
+func HandlerDeleteDomainCategories(c *gin.Context, policies *policy.Service) {
+	err := policies.DeleteDomainCategories(c.Request.Context(), c.Param("domain"))
+	if errors.Is(err, policy.ErrDomainNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Domain has no categories"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to delete domain categories: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete domain categories"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Domain categories deleted"})
+}
+
+// HandlerListBlockedRequests returns the most recent requests blocked by the egress proxy,
+// optionally only those of the session given by the connection_id query parameter
+func HandlerListBlockedRequests(c *gin.Context, policies *policy.Service) {
+	limit := 100
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		limit = parsed
+	}
+
+	requests, err := policies.ListBlocked(c.Request.Context(), c.Query("connection_id"), int32(limit))
+	if err != nil {
+		logrus.Errorf("Failed to list blocked requests: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list blocked requests"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"blocked_requests": requests})
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	minio2 "github.com/browsersec/KubeBrowse/internal/minio"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// HandlerGetRecording returns the recorded segments of a session, one for each of its
+// tunnels, with the websocket URLs to play them back one by one or all together
+func HandlerGetRecording(c *gin.Context, recordings *minio2.RecordingStore) {
+	connectionID := c.Param("connectionID")
+	if connectionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Connection ID is required"})
+		return
+	}
+
+	if recordings == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Session recording is not configured"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	metas, err := recordings.List(ctx, connectionID)
+	if err != nil {
+		logrus.Debugf("No recording metadata for session %s: %v", connectionID, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recording not found"})
+		return
+	}
+
+	status := "ready"
+	truncated := false
+	segments := make([]gin.H, 0, len(metas))
+	for _, meta := range metas {
+		segmentStatus := "ready"
+		if meta.StoppedAt.IsZero() {
+			segmentStatus = "recording"
+			status = "recording"
+		}
+		truncated = truncated || meta.Truncated
+		segments = append(segments, gin.H{
+			"recording":     meta,
+			"websocket_url": fmt.Sprintf("/websocket-tunnel/playback?uuid=%s&segment=%s", url.QueryEscape(connectionID), url.QueryEscape(meta.TunnelUUID)),
+			"status":        segmentStatus,
+			"truncated":     meta.Truncated,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"segments":      segments,
+		"websocket_url": fmt.Sprintf("/websocket-tunnel/playback?uuid=%s", url.QueryEscape(connectionID)),
+		"status":        status,
+		"truncated":     truncated,
+	})
+}
+
+// OpenRecording opens the recording of the session given by the uuid query parameter for
+// playback, only the segment of one of its tunnels if the segment parameter is set
+func OpenRecording(request *http.Request, recordings *minio2.RecordingStore) (io.ReadCloser, error) {
+	if recordings == nil {
+		return nil, fmt.Errorf("session recording is not configured")
+	}
+
+	uuid := request.URL.Query().Get("uuid")
+	if uuid == "" {
+		return nil, fmt.Errorf("no UUID provided")
+	}
+	segment := request.URL.Query().Get("segment")
+
+	recording, err := recordings.Open(request.Context(), uuid, segment)
+	if err != nil {
+		logrus.Warnf("Failed to open recording for playback of %s: %v", uuid, err)
+		return nil, err
+	}
+
+	logrus.Infof("Playing back recording of session %s", uuid)
+	return recording, nil
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/browsersec/KubeBrowse/internal/reputation"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// FileReputationRequest sets the verdict of a file hash
+type FileReputationRequest struct {
+	Verdict string   `json:"verdict" binding:"required"`
+	Threats []string `json:"threats"`
+	Note    string   `json:"note"`
+}
+
+// ImportFileReputationsRequest sets the verdicts of several file hashes
+type ImportFileReputationsRequest struct {
+	Hashes []reputation.Entry `json:"hashes" binding:"required"`
+}
+
+// reputationLimit returns the limit query parameter of list requests, 100 by default
+func reputationLimit(c *gin.Context) (int32, bool) {
+	limit := 100
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return 0, false
+		}
+		limit = parsed
+	}
+	return int32(limit), true
+}
+
+// reputationHash returns the hash of the request path
+func reputationHash(c *gin.Context) (string, bool) {
+	hash, err := reputation.NormalizeHash(c.Param("sha256"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return hash, true
+}
+
+// HandlerListFileReputations returns the most recently updated verdicts of file hashes
+func HandlerListFileReputations(c *gin.Context, fileReputation *reputation.Service) {
+	limit, ok := reputationLimit(c)
+	if !ok {
+		return
+	}
+
+	entries, err := fileReputation.List(c.Request.Context(), limit)
+	if err != nil {
+		logrus.Errorf("Failed to list file reputations: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list file hashes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hashes": entries})
+}
+
+// HandlerGetFileReputation returns the verdict of a file hash
+func HandlerGetFileReputation(c *gin.Context, fileReputation *reputation.Service) {
+	hash, ok := reputationHash(c)
+	if !ok {
+		return
+	}
+
+	entry, err := fileReputation.Get(c.Request.Context(), hash)
+	if errors.Is(err, reputation.ErrHashNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hash not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to get file reputation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file hash"})
+		return
+	}
+	c.JSON(http.StatusOK, entry)
+}
+
+// HandlerPutFileReputation sets the verdict of a file hash. Files with a malicious hash are
+// blocked, files with a clean hash are delivered without being scanned.
+func HandlerPutFileReputation(c *gin.Context, fileReputation *reputation.Service) {
+	hash, ok := reputationHash(c)
+	if !ok {
+		return
+	}
+	var req FileReputationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	saved, err := fileReputation.Put(c.Request.Context(), reputation.Entry{
+		SHA256:  hash,
+		Verdict: req.Verdict,
+		Threats: req.Threats,
+		Note:    req.Note,
+	})
+	if err != nil {
+		logrus.Warnf("Failed to set the reputation of %s: %v", hash, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	logrus.Infof("File hash %s marked %s", saved.SHA256, saved.Verdict)
+	c.JSON(http.StatusOK, saved)
+}
+
+// HandlerImportFileReputations sets the verdicts of a list of file hashes, stopping at the
+// first invalid one
+func HandlerImportFileReputations(c *gin.Context, fileReputation *reputation.Service) {
+	var req ImportFileReputationsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	imported := 0
+	for _, entry := range req.Hashes {
+		if _, err := fileReputation.Put(c.Request.Context(), entry); err != nil {
+			logrus.Warnf("Failed to import the reputation of %s: %v", entry.SHA256, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "imported": imported})
+			return
+		}
+		imported++
+	}
+	logrus.Infof("Imported the reputation of %d file hashes", imported)
+	c.JSON(http.StatusOK, gin.H{"imported": imported})
+}
+
+// HandlerDeleteFileReputation forgets the verdict of a file hash, files with it are scanned again
+func HandlerDeleteFileReputation(c *gin.Context, fileReputation *reputation.Service) {
+	hash, ok := reputationHash(c)
+	if !ok {
+		return
+	}
+
+	err := fileReputation.Delete(c.Request.Context(), hash)
+	if errors.Is(err, reputation.ErrHashNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hash not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to delete file reputation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file hash"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "File hash deleted"})
+}
+
+// HandlerListFileDeliveries returns the most recent uploads of files with a hash, telling
+// which sessions received the file
+func HandlerListFileDeliveries(c *gin.Context, fileReputation *reputation.Service) {
+	hash, ok := reputationHash(c)
+	if !ok {
+		return
+	}
+	limit, ok := reputationLimit(c)
+	if !ok {
+		return
+	}
+
+	deliveries, err := fileReputation.ListDeliveries(c.Request.Context(), hash, limit)
+	if err != nil {
+		logrus.Errorf("Failed to list file deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list file deliveries"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sha256": hash, "deliveries": deliveries})
+}
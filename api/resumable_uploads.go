@@ -0,0 +1,537 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	minio2 "github.com/browsersec/KubeBrowse/internal/minio"
+	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
+	"github.com/browsersec/KubeBrowse/internal/reputation"
+	"github.com/browsersec/KubeBrowse/internal/scanner"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/minio/minio-go/v7"
+	"github.com/sirupsen/logrus"
+)
+
+// Resumable uploads implement the core of the tus protocol 1.0.0 with the creation and
+// termination extensions, see https://tus.io/protocols/resumable-upload
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+	// tusContentType is the content type of the requests that send data
+	tusContentType = "application/offset+octet-stream"
+	// resumableLockTTL is how long the lock of a request writing to an upload lasts unless
+	// the request refreshes it, which it does every resumableLockRefresh while it writes
+	resumableLockTTL     = 15 * time.Minute
+	resumableLockRefresh = resumableLockTTL / 3
+	// resumableDeliveryTimeout bounds scanning and delivering a completed upload
+	resumableDeliveryTimeout = 30 * time.Minute
+)
+
+// errResumableLockLost is the error of writes to an upload whose lock expired meanwhile
+var errResumableLockLost = errors.New("upload lock was lost")
+
+// ResumableUploads holds what resumable upload handlers need to store uploads and deliver
+// them once they are complete
+type ResumableUploads struct {
+	RedisClient    *redis.Client
+	Store          *minio2.ResumableStore
+	Library        *minio2.FileLibrary
+	Quarantine     *minio2.QuarantineStore
+	MalwareScanner scanner.Scanner
+	Reputation     *reputation.Service
+	MaxUploadBytes int64
+}
+
+// setTusHeaders sets the headers of all tus responses
+func setTusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+}
+
+// checkTusVersion refuses requests of clients speaking another version of tus
+func checkTusVersion(c *gin.Context) bool {
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "unsupported tus version"})
+		return false
+	}
+	return true
+}
+
+// HandlerResumableUploadOptions describes the tus protocol supported by the server
+func HandlerResumableUploadOptions(c *gin.Context, uploads *ResumableUploads) {
+	setTusHeaders(c)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(uploads.MaxUploadBytes, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// HandlerCreateResumableUpload starts a resumable upload to the sandbox of a session. The
+// size of the file is given in Upload-Length and its name in the filename of Upload-Metadata.
+func HandlerCreateResumableUpload(c *gin.Context, uploads *ResumableUploads) {
+	setTusHeaders(c)
+	if !checkTusVersion(c) {
+		return
+	}
+	connectionID := c.Param("connectionID")
+
+	if _, _, err := getFQDNURL(connectionID, uploads.RedisClient); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length is required"})
+		return
+	}
+	if length == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is empty"})
+		return
+	}
+	if length > uploads.MaxUploadBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
+		return
+	}
+
+	filename := tusFilename(c.GetHeader("Upload-Metadata"))
+	if filename == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filename is required in Upload-Metadata"})
+		return
+	}
+
+	id, err := redis2.NewUploadID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	object := minio2.ResumableObjectName(connectionID, id)
+	multipartID, err := uploads.Store.Start(c.Request.Context(), object)
+	if err != nil {
+		logrus.Errorf("Failed to start resumable upload for session %s: %v", connectionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start upload"})
+		return
+	}
+
+	upload := &redis2.ResumableUpload{
+		ID:           id,
+		ConnectionID: connectionID,
+		Owner:        uploadOwner(c),
+		Filename:     filename,
+		Length:       length,
+		Object:       object,
+		MultipartID:  multipartID,
+		Status:       redis2.UploadReceiving,
+		CreatedAt:    time.Now(),
+	}
+	if err := redis2.SaveResumableUpload(uploads.RedisClient, upload); err != nil {
+		_ = uploads.Store.Abort(context.Background(), object, multipartID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	logrus.Infof("Started resumable upload %s of %q (%d bytes) to session %s", id, filename, length, connectionID)
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+id)
+	c.Status(http.StatusCreated)
+}
+
+// tusFilename returns the file name in tus Upload-Metadata, comma separated keys with
+// base64 encoded values
+func tusFilename(metadata string) string {
+	for _, pair := range strings.Split(metadata, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key != "filename" && key != "name" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return ""
+		}
+		name := path.Base(strings.ReplaceAll(string(decoded), "\\", "/"))
+		if name == "." || name == "/" {
+			return ""
+		}
+		return name
+	}
+	return ""
+}
+
+// resumableUpload returns the upload of the request, which must belong to its session
+func resumableUpload(c *gin.Context, redisClient *redis.Client) *redis2.ResumableUpload {
+	upload, err := redis2.GetResumableUpload(redisClient, c.Param("uploadID"))
+	if errors.Is(err, redis2.ErrUploadNotFound) || (err == nil && upload.ConnectionID != c.Param("connectionID")) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return nil
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return upload
+}
+
+// HandlerResumableUploadOffset returns how much of an upload was received, for clients to resume it
+func HandlerResumableUploadOffset(c *gin.Context, uploads *ResumableUploads) {
+	setTusHeaders(c)
+	if !checkTusVersion(c) {
+		return
+	}
+	upload := resumableUpload(c, uploads.RedisClient)
+	if upload == nil {
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Status(http.StatusOK)
+}
+
+// HandlerGetResumableUpload returns the status of an upload and, once it was delivered,
+// the results of delivering it
+func HandlerGetResumableUpload(c *gin.Context, uploads *ResumableUploads) {
+	upload := resumableUpload(c, uploads.RedisClient)
+	if upload == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       upload.ID,
+		"filename": upload.Filename,
+		"length":   upload.Length,
+		"offset":   upload.Offset,
+		"status":   upload.Status,
+		"results":  upload.Results,
+		"error":    upload.Error,
+	})
+}
+
+// HandlerPatchResumableUpload appends the body of the request to an upload at Upload-Offset.
+// If the request breaks off, what was received is kept and the client resumes from the new
+// offset. Once the whole file arrived it is scanned and delivered like a single upload; a
+// request to a complete upload whose delivery stalled delivers it again.
+func HandlerPatchResumableUpload(c *gin.Context, uploads *ResumableUploads) {
+	setTusHeaders(c)
+	if !checkTusVersion(c) {
+		return
+	}
+	if c.ContentType() != tusContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + tusContentType})
+		return
+	}
+	upload := resumableUpload(c, uploads.RedisClient)
+	if upload == nil {
+		return
+	}
+
+	lockToken, err := redis2.LockResumableUpload(uploads.RedisClient, upload.ID, resumableLockTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if lockToken == "" {
+		c.JSON(http.StatusLocked, gin.H{"error": "upload is being written by another request"})
+		return
+	}
+	defer func() {
+		if err := redis2.UnlockResumableUpload(uploads.RedisClient, upload.ID, lockToken); err != nil {
+			logrus.Errorf("Failed to unlock upload %s: %v", upload.ID, err)
+		}
+	}()
+
+	// reload the upload now that no other request writes to it
+	if upload = resumableUpload(c, uploads.RedisClient); upload == nil {
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the upload"})
+		return
+	}
+	// a delivery left behind by a server that stopped is started again
+	if upload.ProcessingStalled() {
+		upload.ProcessingDeadline = time.Now().Add(resumableDeliveryTimeout)
+		if !updateResumableUpload(c, uploads.RedisClient, upload) {
+			return
+		}
+		logrus.Warnf("Restarting the delivery of resumable upload %s", upload.ID)
+		go uploads.deliver(*upload)
+		c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if upload.Status != redis2.UploadReceiving {
+		c.JSON(http.StatusConflict, gin.H{"error": "upload is already complete"})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	stopRefresh := refreshResumableLock(ctx, cancel, uploads.RedisClient, upload.ID, lockToken)
+	body := io.LimitReader(c.Request.Body, upload.Length-upload.Offset)
+	writeErr := writeResumableData(ctx, uploads.Store, upload, body)
+	cancel()
+	// another request may have taken over an upload whose lock expired, its state wins
+	if err := stopRefresh(); err != nil {
+		logrus.Warnf("Dropped write to resumable upload %s: %v", upload.ID, err)
+		c.JSON(http.StatusConflict, gin.H{"error": "upload lock was lost, resume from the offset of the upload"})
+		return
+	}
+	complete := upload.Offset == upload.Length
+	if complete {
+		upload.Status = redis2.UploadProcessing
+		upload.ProcessingDeadline = time.Now().Add(resumableDeliveryTimeout)
+	}
+	if !updateResumableUpload(c, uploads.RedisClient, upload) {
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+
+	if writeErr != nil && !complete {
+		logrus.Warnf("Resumable upload %s stopped at %d of %d bytes: %v", upload.ID, upload.Offset, upload.Length, writeErr)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store upload, resume from Upload-Offset"})
+		return
+	}
+	if complete {
+		go uploads.deliver(*upload)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// updateResumableUpload stores an upload read by the request, unless it was deleted or
+// written by another request meanwhile
+func updateResumableUpload(c *gin.Context, redisClient *redis.Client, upload *redis2.ResumableUpload) bool {
+	err := redis2.UpdateResumableUpload(redisClient, upload)
+	switch {
+	case errors.Is(err, redis2.ErrUploadNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+	case errors.Is(err, redis2.ErrUploadChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": "upload was changed by another request"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	return err == nil
+}
+
+// refreshResumableLock refreshes the lock of an upload until ctx is done, calling cancel
+// if the lock is lost so that the write stops. The returned function waits for the
+// refreshes to stop once ctx is done and fails if the lock is no longer held.
+func refreshResumableLock(ctx context.Context, cancel context.CancelFunc, redisClient *redis.Client, id, token string) func() error {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(resumableLockRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			held, err := redis2.RefreshResumableUploadLock(redisClient, id, token, resumableLockTTL)
+			if err != nil {
+				logrus.Warnf("Failed to refresh lock of upload %s: %v", id, err)
+				continue
+			}
+			if !held {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	return func() error {
+		<-stopped
+		held, err := redis2.RefreshResumableUploadLock(redisClient, id, token, resumableLockTTL)
+		if err != nil {
+			return err
+		}
+		if !held {
+			return errResumableLockLost
+		}
+		return nil
+	}
+}
+
+// resumableDataStore stores the data of resumable uploads, see minio2.ResumableStore
+type resumableDataStore interface {
+	PutPart(ctx context.Context, object, multipartID string, number int, data []byte) (string, error)
+	PutPending(ctx context.Context, object string, data []byte) error
+	ReadPending(ctx context.Context, object string, buf []byte) error
+}
+
+// writeResumableData stores data at the offset of an upload in parts of
+// minio2.ResumablePartSize, keeping what does not fill a part as pending data. The offset
+// of the upload is advanced by what was stored, even if reading data failed midway.
+func writeResumableData(ctx context.Context, store resumableDataStore, upload *redis2.ResumableUpload, data io.Reader) error {
+	buf := make([]byte, minio2.ResumablePartSize)
+	// offset of the start of buf in the upload
+	base := upload.Offset - upload.PendingSize
+	n := int(upload.PendingSize)
+	if n > 0 {
+		if err := store.ReadPending(ctx, upload.Object, buf[:n]); err != nil {
+			// start over from the last complete part
+			upload.Offset, upload.PendingSize = base, 0
+			return err
+		}
+	}
+
+	for {
+		read, readErr := io.ReadFull(data, buf[n:])
+		n += read
+
+		if n == len(buf) || (base+int64(n) == upload.Length && n > 0) {
+			number := len(upload.Parts) + 1
+			etag, err := store.PutPart(ctx, upload.Object, upload.MultipartID, number, buf[:n])
+			if err != nil {
+				upload.Offset, upload.PendingSize = base, 0
+				return err
+			}
+			upload.Parts = append(upload.Parts, redis2.UploadPart{Number: number, ETag: etag, Size: int64(n)})
+			base += int64(n)
+			n = 0
+		}
+
+		if readErr != nil {
+			upload.Offset, upload.PendingSize = base+int64(n), int64(n)
+			if n > 0 {
+				if err := store.PutPending(ctx, upload.Object, buf[:n]); err != nil {
+					upload.Offset, upload.PendingSize = base, 0
+					return err
+				}
+			}
+			if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return readErr
+		}
+	}
+}
+
+// deliver assembles a completed upload and scans and delivers it like a single upload
+func (u *ResumableUploads) deliver(upload redis2.ResumableUpload) {
+	ctx, cancel := context.WithDeadline(context.Background(), upload.ProcessingDeadline)
+	defer cancel()
+
+	results, err := u.assembleAndDeliver(ctx, &upload)
+	if err != nil {
+		logrus.Errorf("Failed to deliver resumable upload %s to session %s: %v", upload.ID, upload.ConnectionID, err)
+		upload.Status = redis2.UploadFailed
+		upload.Error = err.Error()
+	} else {
+		upload.Status = redis2.UploadCompleted
+		logrus.Infof("Delivered resumable upload %s of %q to session %s", upload.ID, upload.Filename, upload.ConnectionID)
+	}
+	if results != nil {
+		if data, err := json.Marshal(results); err == nil {
+			upload.Results = data
+		}
+	}
+
+	// the upload only passes through MinIO, the storage destination keeps its own copy
+	if err := u.Store.Remove(ctx, upload.Object); err != nil {
+		logrus.Errorf("Failed to remove assembled upload %s: %v", upload.Object, err)
+	}
+	// the upload may have been deleted, or its delivery restarted, after the deadline
+	if err := redis2.UpdateResumableUpload(u.RedisClient, &upload); err != nil {
+		logrus.Errorf("Failed to update upload %s: %v", upload.ID, err)
+	}
+}
+
+func (u *ResumableUploads) assembleAndDeliver(ctx context.Context, upload *redis2.ResumableUpload) ([]UploadResult, error) {
+	parts := make([]minio.CompletePart, len(upload.Parts))
+	for i, part := range upload.Parts {
+		parts[i] = minio.CompletePart{PartNumber: part.Number, ETag: part.ETag}
+	}
+	if err := u.Store.Complete(ctx, upload.Object, upload.MultipartID, parts); err != nil {
+		return nil, err
+	}
+	_ = u.Store.RemovePending(ctx, upload.Object)
+
+	url, session, err := getFQDNURL(upload.ConnectionID, u.RedisClient)
+	if err != nil {
+		return nil, err
+	}
+	file, err := u.Store.Open(ctx, upload.Object)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	destinations := newUploadDestinations(u.Library, upload.Owner, upload.ConnectionID, u.MalwareScanner)
+	results, err := deliverUpload(ctx, file, upload.Filename, upload.ConnectionID, session, url, destinations, u.Quarantine, u.Reputation, u.MaxUploadBytes)
+	if err != nil {
+		return nil, err
+	}
+	tagStoredUpload(u.Library, results)
+	allUploadsSucceeded(results)
+	return results, nil
+}
+
+// HandlerDeleteResumableUpload cancels an upload and removes what was received. Uploads
+// being written or delivered are kept, unless their delivery stalled.
+func HandlerDeleteResumableUpload(c *gin.Context, uploads *ResumableUploads) {
+	setTusHeaders(c)
+	if !checkTusVersion(c) {
+		return
+	}
+	upload := resumableUpload(c, uploads.RedisClient)
+	if upload == nil {
+		return
+	}
+
+	// a request writing to the upload would store it again after it was deleted
+	lockToken, err := redis2.LockResumableUpload(uploads.RedisClient, upload.ID, resumableLockTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if lockToken == "" {
+		c.JSON(http.StatusLocked, gin.H{"error": "upload is being written by another request"})
+		return
+	}
+	defer func() {
+		if err := redis2.UnlockResumableUpload(uploads.RedisClient, upload.ID, lockToken); err != nil {
+			logrus.Errorf("Failed to unlock upload %s: %v", upload.ID, err)
+		}
+	}()
+
+	if upload = resumableUpload(c, uploads.RedisClient); upload == nil {
+		return
+	}
+	if upload.Status == redis2.UploadProcessing && !upload.ProcessingStalled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "upload is being delivered"})
+		return
+	}
+
+	switch upload.Status {
+	case redis2.UploadReceiving:
+		if err := uploads.Store.Abort(c.Request.Context(), upload.Object, upload.MultipartID); err != nil {
+			logrus.Warnf("Failed to abort resumable upload %s: %v", upload.ID, err)
+		}
+	case redis2.UploadProcessing:
+		// the delivery may have stopped before or after the parts were assembled
+		_ = uploads.Store.Abort(c.Request.Context(), upload.Object, upload.MultipartID)
+		_ = uploads.Store.RemovePending(c.Request.Context(), upload.Object)
+		if err := uploads.Store.Remove(c.Request.Context(), upload.Object); err != nil {
+			logrus.Warnf("Failed to remove resumable upload %s: %v", upload.ID, err)
+		}
+	}
+	if err := redis2.DeleteResumableUpload(uploads.RedisClient, upload.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
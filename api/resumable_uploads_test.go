@@ -0,0 +1,205 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	minio2 "github.com/browsersec/KubeBrowse/internal/minio"
+	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+type fakeResumableStore struct {
+	parts   map[int][]byte
+	pending []byte
+	// failPart makes storing the part with this number fail
+	failPart int
+}
+
+func (s *fakeResumableStore) PutPart(ctx context.Context, object, multipartID string, number int, data []byte) (string, error) {
+	if number == s.failPart {
+		return "", errors.New("minio unavailable")
+	}
+	s.parts[number] = append([]byte(nil), data...)
+	return fmt.Sprintf("etag-%d", number), nil
+}
+
+func (s *fakeResumableStore) PutPending(ctx context.Context, object string, data []byte) error {
+	s.pending = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *fakeResumableStore) ReadPending(ctx context.Context, object string, buf []byte) error {
+	if len(buf) != len(s.pending) {
+		return io.ErrUnexpectedEOF
+	}
+	copy(buf, s.pending)
+	return nil
+}
+
+// stored returns the parts of an upload one after the other
+func (s *fakeResumableStore) stored(upload *redis2.ResumableUpload) []byte {
+	var data []byte
+	for _, part := range upload.Parts {
+		data = append(data, s.parts[part.Number]...)
+	}
+	return data
+}
+
+func uploadData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestWriteResumableDataResumesPartialPart(t *testing.T) {
+	data := uploadData(minio2.ResumablePartSize + 10)
+	store := &fakeResumableStore{parts: map[int][]byte{}}
+	upload := &redis2.ResumableUpload{Length: int64(len(data))}
+
+	if err := writeResumableData(context.Background(), store, upload, bytes.NewReader(data[:100])); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	if upload.Offset != 100 || upload.PendingSize != 100 || len(upload.Parts) != 0 {
+		t.Fatalf("after first write offset=%d pending=%d parts=%d, want 100 pending bytes", upload.Offset, upload.PendingSize, len(upload.Parts))
+	}
+
+	if err := writeResumableData(context.Background(), store, upload, bytes.NewReader(data[100:])); err != nil {
+		t.Fatalf("second write: %v", err)
+	}
+	if upload.Offset != upload.Length || upload.PendingSize != 0 {
+		t.Fatalf("after second write offset=%d pending=%d, want complete", upload.Offset, upload.PendingSize)
+	}
+	if len(upload.Parts) != 2 || upload.Parts[0].Size != minio2.ResumablePartSize || upload.Parts[1].Size != 10 {
+		t.Fatalf("parts=%+v, want a full part and the last 10 bytes", upload.Parts)
+	}
+	if !bytes.Equal(store.stored(upload), data) {
+		t.Errorf("stored data differs from the upload")
+	}
+}
+
+func TestWriteResumableDataAtPartBoundary(t *testing.T) {
+	data := uploadData(2 * minio2.ResumablePartSize)
+	store := &fakeResumableStore{parts: map[int][]byte{}}
+	upload := &redis2.ResumableUpload{Length: int64(len(data))}
+
+	if err := writeResumableData(context.Background(), store, upload, bytes.NewReader(data[:minio2.ResumablePartSize])); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	if upload.Offset != minio2.ResumablePartSize || upload.PendingSize != 0 || len(upload.Parts) != 1 || store.pending != nil {
+		t.Fatalf("after first write offset=%d pending=%d parts=%d, want one part and nothing pending", upload.Offset, upload.PendingSize, len(upload.Parts))
+	}
+
+	if err := writeResumableData(context.Background(), store, upload, bytes.NewReader(data[minio2.ResumablePartSize:])); err != nil {
+		t.Fatalf("second write: %v", err)
+	}
+	if upload.Offset != upload.Length || len(upload.Parts) != 2 {
+		t.Fatalf("after second write offset=%d parts=%d, want complete in two parts", upload.Offset, len(upload.Parts))
+	}
+	if !bytes.Equal(store.stored(upload), data) {
+		t.Errorf("stored data differs from the upload")
+	}
+}
+
+func TestWriteResumableDataPutPartFailure(t *testing.T) {
+	data := uploadData(2 * minio2.ResumablePartSize)
+	store := &fakeResumableStore{parts: map[int][]byte{}, failPart: 2}
+	upload := &redis2.ResumableUpload{Length: int64(len(data))}
+
+	// the second part fails after 100 bytes of it were kept pending by an earlier request
+	first := minio2.ResumablePartSize + 100
+	if err := writeResumableData(context.Background(), store, upload, bytes.NewReader(data[:first])); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	if err := writeResumableData(context.Background(), store, upload, bytes.NewReader(data[first:])); err == nil {
+		t.Fatalf("second write succeeded, want the error of PutPart")
+	}
+	// the client resumes from the last stored part
+	if upload.Offset != minio2.ResumablePartSize || upload.PendingSize != 0 || len(upload.Parts) != 1 {
+		t.Fatalf("offset=%d pending=%d parts=%d, want to resume after the first part", upload.Offset, upload.PendingSize, len(upload.Parts))
+	}
+
+	store.failPart = 0
+	if err := writeResumableData(context.Background(), store, upload, bytes.NewReader(data[upload.Offset:])); err != nil {
+		t.Fatalf("resumed write: %v", err)
+	}
+	if upload.Offset != upload.Length || !bytes.Equal(store.stored(upload), data) {
+		t.Errorf("offset=%d, want the resumed upload complete and intact", upload.Offset)
+	}
+}
+
+func TestWriteResumableDataKeepsDataBeforeReadError(t *testing.T) {
+	data := uploadData(1000)
+	store := &fakeResumableStore{parts: map[int][]byte{}}
+	upload := &redis2.ResumableUpload{Length: int64(len(data))}
+
+	readErr := errors.New("connection reset")
+	err := writeResumableData(context.Background(), store, upload, &failingReader{data: data[:300], err: readErr})
+	if !errors.Is(err, readErr) {
+		t.Fatalf("writeResumableData()=%v, want %v", err, readErr)
+	}
+	if upload.Offset != 300 || upload.PendingSize != 300 || !bytes.Equal(store.pending, data[:300]) {
+		t.Errorf("offset=%d pending=%d, want the 300 bytes received kept pending", upload.Offset, upload.PendingSize)
+	}
+}
+
+func TestHandlerDeleteResumableUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, test := range []struct {
+		name     string
+		status   string
+		deadline time.Time
+		locked   bool
+		want     int
+	}{
+		{"being written", redis2.UploadReceiving, time.Time{}, true, http.StatusLocked},
+		{"being delivered", redis2.UploadProcessing, time.Now().Add(time.Minute), false, http.StatusConflict},
+		{"completed", redis2.UploadCompleted, time.Time{}, false, http.StatusNoContent},
+		{"failed", redis2.UploadFailed, time.Time{}, false, http.StatusNoContent},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			redisClient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+			defer redisClient.Close()
+			uploads := &ResumableUploads{RedisClient: redisClient}
+
+			upload := &redis2.ResumableUpload{ID: "upload", ConnectionID: "session", Status: test.status, ProcessingDeadline: test.deadline}
+			if err := redis2.SaveResumableUpload(redisClient, upload); err != nil {
+				t.Fatal(err)
+			}
+			if test.locked {
+				if token, err := redis2.LockResumableUpload(redisClient, upload.ID, time.Minute); err != nil || token == "" {
+					t.Fatalf("LockResumableUpload()=%q, %v", token, err)
+				}
+			}
+
+			router := gin.New()
+			router.DELETE("/sessions/:connectionID/uploads/:uploadID", func(c *gin.Context) {
+				HandlerDeleteResumableUpload(c, uploads)
+			})
+			request := httptest.NewRequest(http.MethodDelete, "/sessions/session/uploads/upload", nil)
+			request.Header.Set("Tus-Resumable", tusVersion)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.want {
+				t.Fatalf("status=%d, want %d", recorder.Code, test.want)
+			}
+			_, err := redis2.GetResumableUpload(redisClient, upload.ID)
+			if deleted := errors.Is(err, redis2.ErrUploadNotFound); deleted != (test.want == http.StatusNoContent) {
+				t.Errorf("upload deleted=%v", deleted)
+			}
+		})
+	}
+}
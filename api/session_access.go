@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/browsersec/KubeBrowse/internal/auth"
+	minio2 "github.com/browsersec/KubeBrowse/internal/minio"
+	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// sessionOwner returns the ID of the signed in user deploying a session, empty when
+// authentication is disabled
+func sessionOwner(c *gin.Context) string {
+	if user, ok := auth.CurrentUser(c); ok {
+		return user.ID.String()
+	}
+	return ""
+}
+
+// canAccessSession reports whether a user may act on a session of an owner. Sessions
+// deployed without authentication have no owner and are left to operators.
+func canAccessSession(user *auth.User, ownerID string) bool {
+	return user.Can(auth.PermSessionManage) || (ownerID != "" && ownerID == user.ID.String())
+}
+
+// requestConnectionID returns the session a request acts on, from the path of session
+// routes or the uuid query parameter of tunnel requests
+func requestConnectionID(c *gin.Context) string {
+	if connectionID := c.Param("connectionID"); connectionID != "" {
+		return connectionID
+	}
+	return c.Query("uuid")
+}
+
+// openedTunnelRequest reports whether a request reads from or writes to an HTTP tunnel that
+// was already opened, it is addressed by the random UUID its connect request returned
+func openedTunnelRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.RawQuery, "read:") || strings.HasPrefix(r.URL.RawQuery, "write:")
+}
+
+// RequireSessionOwner is a middleware that only lets the user who deployed the session of a
+// request, or users allowed to manage every session, act on it. Tunnels opened from raw
+// connection parameters reach any host and are reserved to the latter. Guests join sessions
+// through share links, which grant access by their token instead.
+func RequireSessionOwner(redisClient *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := auth.CurrentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		connectionID := requestConnectionID(c)
+		if connectionID == "" {
+			if !openedTunnelRequest(c.Request) && !user.Can(auth.PermSessionManage) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "A session is required"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		session, err := redis2.GetSessionData(redisClient, connectionID)
+		if err != nil && !errors.Is(err, redis2.ErrSessionNotFound) {
+			logrus.Errorf("Failed to get session %s to check its owner: %v", connectionID, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session data"})
+			return
+		}
+		if err != nil {
+			// operators get the answer of the handler, which may clean up after the session
+			if user.Can(auth.PermSessionManage) {
+				c.Next()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+
+		// sessions of other users are not found rather than forbidden, not to confirm they exist
+		if !canAccessSession(user, session.OwnerID) {
+			logrus.Warnf("User %s denied access to session %s of %q", user.Email, connectionID, session.OwnerID)
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRecordingAccess is a middleware that only lets the owner of a recorded session,
+// operators and auditors play back its recording
+func RequireRecordingAccess(recordings *minio2.RecordingStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := auth.CurrentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		// without a store the handlers report that recording is not configured
+		if recordings == nil || user.Can(auth.PermAuditRead) {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		connectionID := requestConnectionID(c)
+		// the segments of a session all belong to its owner
+		segments, err := recordings.List(ctx, connectionID)
+		if err != nil || !canAccessSession(user, segments[0].OwnerID) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Recording not found"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -1,11 +1,14 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/browsersec/KubeBrowse/internal/cleanup"
+	"github.com/browsersec/KubeBrowse/internal/history"
+	"github.com/browsersec/KubeBrowse/internal/k8s"
 	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
@@ -32,7 +35,7 @@ type ExtendSessionResponse struct {
 }
 
 // HandlerExtendSession handles session timeout extension requests
-func HandlerExtendSession(c *gin.Context, redisClient *redis.Client, cleanupService *cleanup.SessionCleanupService) {
+func HandlerExtendSession(c *gin.Context, redisClient *redis.Client, cleanupService *cleanup.SessionCleanupService, sessions *k8s.BrowserSessionController, sessionHistory *history.Service) {
 	connectionID := c.Param("connectionID")
 	if connectionID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -96,6 +99,15 @@ func HandlerExtendSession(c *gin.Context, redisClient *redis.Client, cleanupServ
 		newTimeLeft = timeLeft + extensionDuration // More accurate fallback
 	}
 
+	// Keep the BrowserSession from expiring before the Redis session
+	if sessions != nil {
+		if err := sessions.Extend(context.Background(), connectionID, time.Now().Add(newTimeLeft)); err != nil {
+			logrus.Warnf("Error extending BrowserSession %s: %v", connectionID, err)
+		}
+	}
+
+	sessionHistory.Extended(connectionID)
+
 	logrus.Infof("Successfully extended session %s by %d minutes", connectionID, req.ExtensionMinutes)
 
 	c.JSON(http.StatusOK, ExtendSessionResponse{
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/browsersec/KubeBrowse/internal/auth"
+	"github.com/browsersec/KubeBrowse/internal/history"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// historyPage returns the limit and offset query parameters of history requests, the 20
+// newest sessions by default
+func historyPage(c *gin.Context) (int32, int32, bool) {
+	var limit, offset int64 = 20, 0
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || parsed <= 0 || parsed > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return 0, 0, false
+		}
+		limit = parsed
+	}
+	if value := c.Query("offset"); value != "" {
+		// offsets beyond an int32 would wrap around to negative offsets, which the database refuses
+		parsed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a positive number"})
+			return 0, 0, false
+		}
+		offset = parsed
+	}
+	return int32(limit), int32(offset), true
+}
+
+// HandlerListSessionHistory returns a page of the past and running sandbox sessions of the
+// signed in user, newest first
+func HandlerListSessionHistory(c *gin.Context, sessionHistory *history.Service) {
+	user, ok := auth.CurrentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	limit, offset, ok := historyPage(c)
+	if !ok {
+		return
+	}
+
+	sessions, total, err := sessionHistory.ListByUser(c.Request.Context(), user.ID, limit, offset)
+	if err != nil {
+		logrus.Errorf("Failed to list sessions of user %s: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHistoryPage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, test := range []struct {
+		query  string
+		ok     bool
+		limit  int32
+		offset int32
+	}{
+		{"", true, 20, 0},
+		{"?limit=100&offset=40", true, 100, 40},
+		{"?offset=2147483647", true, 20, 2147483647},
+		{"?limit=0", false, 0, 0},
+		{"?limit=101", false, 0, 0},
+		{"?limit=-1", false, 0, 0},
+		{"?limit=ten", false, 0, 0},
+		{"?offset=-20", false, 0, 0},
+		{"?offset=2147483648", false, 0, 0},
+		{"?offset=4294967316", false, 0, 0},
+	} {
+		t.Run(test.query, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodGet, "/me/sessions"+test.query, nil)
+
+			limit, offset, ok := historyPage(c)
+			if ok != test.ok || limit != test.limit || offset != test.offset {
+				t.Errorf("historyPage()=%d, %d, %v, want %d, %d, %v", limit, offset, ok, test.limit, test.offset, test.ok)
+			}
+			if !ok && recorder.Code != http.StatusBadRequest {
+				t.Errorf("status=%d, want %d", recorder.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
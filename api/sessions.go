@@ -10,19 +10,21 @@ import (
 	"fmt"
 
 	guac2 "github.com/browsersec/KubeBrowse/internal/guac"
+	"github.com/browsersec/KubeBrowse/internal/history"
 	"github.com/browsersec/KubeBrowse/internal/k8s"
 	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
 )
 
 // Endpoint to stop a specific WebSocket session
-func HandlerStopWSSession(c *gin.Context, redisClient *redis.Client, k8sClient *kubernetes.Clientset, server *guac2.Server) {
+func HandlerStopWSSession(c *gin.Context, redisClient *redis.Client, k8sClient *kubernetes.Clientset, server *guac2.Server, sessions *k8s.BrowserSessionController, sessionHistory *history.Service) {
 	connectionID := c.Param("connectionID")
 
-	if err := stopWSSession(connectionID, redisClient, k8sClient, server); err != nil {
+	if err := stopWSSession(connectionID, redisClient, k8sClient, server, sessions, sessionHistory); err != nil {
 		logrus.Errorf("Failed to stop WebSocket session: %v", err)
 		// c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		if errors.Is(err, redis.Nil) {
@@ -36,11 +38,11 @@ func HandlerStopWSSession(c *gin.Context, redisClient *redis.Client, k8sClient *
 }
 
 // StopWSSession is an exported version of stopWSSession that can be used by other packages
-func StopWSSession(connectionID string, redisClient *redis.Client, k8sClient *kubernetes.Clientset, server *guac2.Server) error {
-	return stopWSSession(connectionID, redisClient, k8sClient, server)
+func StopWSSession(connectionID string, redisClient *redis.Client, k8sClient *kubernetes.Clientset, server *guac2.Server, sessions *k8s.BrowserSessionController, sessionHistory *history.Service) error {
+	return stopWSSession(connectionID, redisClient, k8sClient, server, sessions, sessionHistory)
 }
 
-func stopWSSession(connectionID string, redisClient *redis.Client, k8sClient *kubernetes.Clientset, server *guac2.Server) error {
+func stopWSSession(connectionID string, redisClient *redis.Client, k8sClient *kubernetes.Clientset, server *guac2.Server, sessions *k8s.BrowserSessionController, sessionHistory *history.Service) error {
 
 	if connectionID == "" {
 		return fmt.Errorf("connection ID is required")
@@ -72,8 +74,19 @@ func stopWSSession(connectionID string, redisClient *redis.Client, k8sClient *ku
 		}
 	}
 
-	// Delete the pod
-	if err = k8s.DeletePod(k8sClient, session.PodName); err != nil {
+	// Recorded before the pod is deleted, which the BrowserSession controller sees as a failure
+	sessionHistory.Ended(connectionID, history.EndUserStop)
+
+	// Delete the BrowserSession, which deletes its pod, or the pod if the session has none
+	if sessions != nil {
+		err = sessions.Delete(context.Background(), connectionID)
+		if apierrors.IsNotFound(err) {
+			err = k8s.DeletePod(k8sClient, session.PodName)
+		}
+	} else {
+		err = k8s.DeletePod(k8sClient, session.PodName)
+	}
+	if err != nil {
 		logrus.Errorf("Failed to delete pod: %v", err)
 	}
 
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/browsersec/KubeBrowse/internal/guac"
+	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of share links created without explicit settings
+const (
+	defaultShareExpiry     = 60 * time.Minute
+	defaultShareMaxViewers = 5
+)
+
+// ShareSessionRequest configures a new share link, all fields are optional
+type ShareSessionRequest struct {
+	Mode             string `json:"mode" binding:"omitempty,oneof=view-only interactive"`
+	ExpiresInMinutes int    `json:"expires_in_minutes" binding:"omitempty,min=1,max=1440"`
+	MaxViewers       int    `json:"max_viewers" binding:"omitempty,min=1,max=50"`
+}
+
+// ShareLinkResponse is a share link together with the websocket URL that joins it
+type ShareLinkResponse struct {
+	redis2.ShareLink
+	WebsocketURL string `json:"websocket_url"`
+}
+
+func newShareLinkResponse(share redis2.ShareLink) ShareLinkResponse {
+	return ShareLinkResponse{
+		ShareLink:    share,
+		WebsocketURL: fmt.Sprintf("/websocket-tunnel/share?token=%s", share.Token),
+	}
+}
+
+// HandlerShareSession creates a share link to a session. Viewers join the session with the
+// token of the link until it expires, is revoked or has its maximum number of viewers.
+func HandlerShareSession(c *gin.Context, tunnelStore *guac.ActiveTunnelStore, redisClient *redis.Client) {
+	connectionID := c.Param("connectionID")
+	if connectionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Connection ID is required"})
+		return
+	}
+
+	req := ShareSessionRequest{}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request: %v", err)})
+			return
+		}
+	}
+	if req.Mode == "" {
+		req.Mode = redis2.ShareModeViewOnly
+	}
+	expiry := defaultShareExpiry
+	if req.ExpiresInMinutes > 0 {
+		expiry = time.Duration(req.ExpiresInMinutes) * time.Minute
+	}
+	if req.MaxViewers == 0 {
+		req.MaxViewers = defaultShareMaxViewers
+	}
+
+	exists, err := redis2.CheckSessionExists(redisClient, connectionID)
+	if err != nil {
+		logrus.Errorf("Failed to check session %s: %v", connectionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session data"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	// Check if connection parameters exist
+	if _, exists = tunnelStore.GetConnectionParams(connectionID); !exists {
+		logrus.Errorf("Connection parameters not found for %s", connectionID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Connection parameters not found"})
+		return
+	}
+
+	share, err := redis2.CreateShareLink(redisClient, connectionID, req.Mode, req.MaxViewers, expiry)
+	if err != nil {
+		logrus.Errorf("Failed to create share link for session %s: %v", connectionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
+	}
+
+	logrus.Infof("Created %s share link for session %s (max viewers: %d, expires: %v)",
+		share.Mode, connectionID, share.MaxViewers, share.ExpireAt.Format(time.RFC3339))
+
+	response := newShareLinkResponse(*share)
+	c.JSON(http.StatusOK, gin.H{
+		"share":         response,
+		"websocket_url": response.WebsocketURL,
+		"status":        "ready",
+		"message":       "Share link created successfully",
+	})
+}
+
+// HandlerListShares returns the active share links of a session
+func HandlerListShares(c *gin.Context, redisClient *redis.Client) {
+	connectionID := c.Param("connectionID")
+
+	shares, err := redis2.ListShareLinks(redisClient, connectionID)
+	if err != nil {
+		logrus.Errorf("Failed to list share links of session %s: %v", connectionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list share links"})
+		return
+	}
+
+	response := make([]ShareLinkResponse, 0, len(shares))
+	for _, share := range shares {
+		response = append(response, newShareLinkResponse(share))
+	}
+	c.JSON(http.StatusOK, gin.H{"shares": response})
+}
+
+// HandlerRevokeShare revokes a share link of a session and disconnects its viewers
+func HandlerRevokeShare(c *gin.Context, redisClient *redis.Client) {
+	connectionID := c.Param("connectionID")
+	token := c.Param("token")
+
+	viewers, err := redis2.RevokeShareLink(redisClient, connectionID, token)
+	if errors.Is(err, redis2.ErrShareNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to revoke share link of session %s: %v", connectionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+		return
+	}
+
+	// viewers connected to other replicas are disconnected by WatchShareRevocations
+	shareViewers.closeAll(token)
+	logrus.Infof("Revoked share link of session %s, disconnecting %d viewers", connectionID, viewers)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked", "disconnected_viewers": viewers})
+}
+
+// WatchShareRevocations disconnects the viewers connected to this replica of share links
+// revoked through any replica, until stopChan is closed
+func WatchShareRevocations(redisClient *redis.Client, stopChan <-chan struct{}) {
+	redis2.WatchShareRevocations(redisClient, func(token string) {
+		if disconnected := shareViewers.closeAll(token); disconnected > 0 {
+			logrus.Infof("Disconnected %d viewers of a revoked share link", disconnected)
+		}
+	}, stopChan)
+}
+
+// shareTunnel is the tunnel of a viewer that joined a session through a share link. Closing
+// it releases the viewer's place on the link.
+type shareTunnel struct {
+	guac.Tunnel
+	share       redis2.ShareLink
+	filters     *guac.FilterChain
+	redisClient *redis.Client
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// newShareTunnel creates the tunnel of a viewer, whose instructions pass through filters
+func newShareTunnel(tunnel guac.Tunnel, share redis2.ShareLink, filters *guac.FilterChain, redisClient *redis.Client) *shareTunnel {
+	t := &shareTunnel{
+		Tunnel:      tunnel,
+		share:       share,
+		filters:     filters,
+		redisClient: redisClient,
+		done:        make(chan struct{}),
+	}
+	shareViewers.add(share.Token, t)
+	go t.disconnectOnExpiry()
+	return t
+}
+
+// disconnectOnExpiry closes the tunnel when the share link expires, viewers lose access
+// together with the link
+func (t *shareTunnel) disconnectOnExpiry() {
+	timer := time.NewTimer(time.Until(t.share.ExpireAt))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		logrus.Infof("Share link of session %s expired, disconnecting viewer", t.share.ConnectionID)
+		_ = t.Close()
+	case <-t.done:
+	}
+}
+
+// Filters implements guac.FilteredTunnel
+func (t *shareTunnel) Filters() *guac.FilterChain {
+	return t.filters
+}
+
+func (t *shareTunnel) Close() (err error) {
+	t.closeOnce.Do(func() {
+		err = t.Tunnel.Close()
+		t.filters.Close()
+		close(t.done)
+		shareViewers.remove(t.share.Token, t)
+		if leaveErr := redis2.LeaveShareLink(t.redisClient, t.share.Token); leaveErr != nil {
+			logrus.Warnf("Failed to release viewer of share link for session %s: %v", t.share.ConnectionID, leaveErr)
+		}
+	})
+	return err
+}
+
+// shareViewerRegistry tracks the connected viewers of each share link so they can be
+// disconnected when the link is revoked
+type shareViewerRegistry struct {
+	sync.Mutex
+	viewers map[string]map[*shareTunnel]struct{}
+}
+
+var shareViewers = &shareViewerRegistry{viewers: make(map[string]map[*shareTunnel]struct{})}
+
+func (r *shareViewerRegistry) add(token string, tunnel *shareTunnel) {
+	r.Lock()
+	defer r.Unlock()
+	if r.viewers[token] == nil {
+		r.viewers[token] = make(map[*shareTunnel]struct{})
+	}
+	r.viewers[token][tunnel] = struct{}{}
+}
+
+func (r *shareViewerRegistry) remove(token string, tunnel *shareTunnel) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.viewers[token], tunnel)
+	if len(r.viewers[token]) == 0 {
+		delete(r.viewers, token)
+	}
+}
+
+// closeAll disconnects all viewers of a share link and returns how many there were
+func (r *shareViewerRegistry) closeAll(token string) int {
+	r.Lock()
+	tunnels := make([]*shareTunnel, 0, len(r.viewers[token]))
+	for tunnel := range r.viewers[token] {
+		tunnels = append(tunnels, tunnel)
+	}
+	r.Unlock()
+
+	for _, tunnel := range tunnels {
+		if err := tunnel.Close(); err != nil {
+			logrus.Debugf("Error closing shared tunnel: %v", err)
+		}
+	}
+	return len(tunnels)
+}
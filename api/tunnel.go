@@ -11,9 +11,15 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/browsersec/KubeBrowse/internal/auth"
 	"github.com/browsersec/KubeBrowse/internal/cleanup"
 	guac2 "github.com/browsersec/KubeBrowse/internal/guac"
+	"github.com/browsersec/KubeBrowse/internal/history"
+	k8s2 "github.com/browsersec/KubeBrowse/internal/k8s"
+	"github.com/browsersec/KubeBrowse/internal/metrics"
+	minio2 "github.com/browsersec/KubeBrowse/internal/minio"
 	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
+	"github.com/browsersec/KubeBrowse/internal/scanner"
 	"github.com/go-redis/redis/v8"
 	uuid2 "github.com/google/uuid"
 	"github.com/sirupsen/logrus"
@@ -34,7 +40,10 @@ func init() {
 
 // DemoDoConnect creates the tunnel to the remote machine (via guacd)
 // Now accepts ActiveTunnelStore to register the tunnel
-func DemoDoConnect(request *http.Request, tunnelStore *guac2.ActiveTunnelStore, redisClient *redis.Client, guacdAddr string, cleanupService *cleanup.SessionCleanupService) (guac2.Tunnel, error) {
+// If the session asked for recording and a recording store is configured, the tunnel is wrapped in a RecordingTunnel
+// Files the sandbox sends are kept in the download store until the malware scanner finds them clean
+// The connection and the bytes exchanged over the tunnel are recorded in the session history
+func DemoDoConnect(request *http.Request, tunnelStore *guac2.ActiveTunnelStore, redisClient *redis.Client, guacdAddr string, cleanupService *cleanup.SessionCleanupService, recordings *minio2.RecordingStore, downloads *minio2.DownloadStore, malwareScanner scanner.Scanner, sessionHistory *history.Service) (guac2.Tunnel, error) {
 	config := guac2.NewGuacamoleConfiguration()
 	var query url.Values
 	uuid := request.URL.Query().Get("uuid")
@@ -47,7 +56,7 @@ func DemoDoConnect(request *http.Request, tunnelStore *guac2.ActiveTunnelStore,
 			return nil, fmt.Errorf("session not found")
 		}
 		err = json.Unmarshal([]byte(val), &session)
-		logrus.Debugf("Retrieved session data for UUID %s: %+v", uuid, session)
+		logrus.Debugf("Retrieved session data for UUID %s (pod %s)", uuid, session.PodName)
 		if err != nil {
 			logrus.Errorf("Failed to unmarshal session data for UUID %s: %v", uuid, err)
 			return nil, fmt.Errorf("failed to unmarshal session data")
@@ -140,6 +149,14 @@ func DemoDoConnect(request *http.Request, tunnelStore *guac2.ActiveTunnelStore,
 		}
 	}
 
+	// Only connect with the protocols the sandbox profile allows
+	if session.Profile != "" {
+		if profile, ok := k8s2.GetSandboxProfile(session.Profile); ok && !profile.AllowsProtocol(query.Get("scheme")) {
+			logrus.Errorf("Protocol %q is not allowed by sandbox profile %s", query.Get("scheme"), session.Profile)
+			return nil, fmt.Errorf("protocol not allowed")
+		}
+	}
+
 	config.Protocol = query.Get("scheme")
 	config.Parameters = map[string]string{}
 	for k, v := range query {
@@ -166,9 +183,11 @@ func DemoDoConnect(request *http.Request, tunnelStore *guac2.ActiveTunnelStore,
 	config.AudioMimetypes = []string{"audio/L16", "rate=44100", "channels=2"}
 
 	logrus.Debugf("Attempting to connect to guacd at %s", guacdAddr)
+	handshakeStart := time.Now()
 	addr, err := net.ResolveTCPAddr("tcp", guacdAddr)
 	if err != nil {
 		logrus.Errorf("Failed to resolve guacd address %s: %v", guacdAddr, err)
+		metrics.GuacdHandshakeErrors.WithLabelValues("resolve").Inc()
 		return nil, err
 	}
 
@@ -180,6 +199,7 @@ func DemoDoConnect(request *http.Request, tunnelStore *guac2.ActiveTunnelStore,
 	conn, err := dialer.Dial("tcp", addr.String())
 	if err != nil {
 		logrus.Errorf("Failed to connect to guacd at %s: %v", addr.String(), err)
+		metrics.GuacdHandshakeErrors.WithLabelValues("connect").Inc()
 		return nil, err
 	}
 
@@ -198,11 +218,11 @@ func DemoDoConnect(request *http.Request, tunnelStore *guac2.ActiveTunnelStore,
 		paramsCopy[k] = v
 	}
 	sanitisedCfg.Parameters = paramsCopy
-	if session.Share {
-		if _, ok := sanitisedCfg.Parameters["password"]; ok {
-			sanitisedCfg.Parameters["password"] = "********"
-		}
-	} else {
+	// the password is unique to the pod and must not end up in the logs
+	if _, ok := sanitisedCfg.Parameters["password"]; ok {
+		sanitisedCfg.Parameters["password"] = "********"
+	}
+	if !session.Share {
 		sanitisedCfg.ConnectionID = ""
 	}
 
@@ -232,18 +252,44 @@ func DemoDoConnect(request *http.Request, tunnelStore *guac2.ActiveTunnelStore,
 				err,
 				conn.LocalAddr().String(),
 				conn.RemoteAddr().String())
+			metrics.GuacdHandshakeErrors.WithLabelValues("handshake").Inc()
 			return nil, err
 		}
 	case <-ctx.Done():
 		logrus.Errorf("Handshake timed out after 40 seconds. Connection details - Local: %s, Remote: %s",
 			conn.LocalAddr().String(),
 			conn.RemoteAddr().String())
+		metrics.GuacdHandshakeErrors.WithLabelValues("timeout").Inc()
 		return nil, fmt.Errorf("handshake timed out: %v", ctx.Err())
 	}
 
+	metrics.GuacdHandshakeDuration.Observe(time.Since(handshakeStart).Seconds())
 	logrus.Debug("Handshake completed successfully")
 
-	tunnel := guac2.NewSimpleTunnel(stream)
+	var tunnel guac2.Tunnel = guac2.NewSimpleTunnel(stream)
+
+	if session.Record {
+		tunnel = startRecording(tunnel, session, recordings)
+	}
+
+	if uuid != "" {
+		tunnel = guac2.NewCountingTunnel(tunnel, func(bytes int64) {
+			sessionHistory.Transferred(uuid, bytes)
+		})
+		sessionHistory.Connected(uuid)
+	}
+
+	roles := []string{guac2.RoleOwner}
+	if user, ok := auth.RequestUser(request); ok {
+		roles = append(roles, string(user.Role))
+	}
+	filters, err := sessionFilters(&session, roles)
+	if err != nil {
+		_ = tunnel.Close()
+		return nil, err
+	}
+	filters = filters.WithDownloadInterceptor(newDownloadInterceptor(stream, uuid, downloads, redisClient, malwareScanner))
+	tunnel = guac2.NewFilteredTunnel(tunnel, filters)
 
 	// Register the tunnel with its ConnectionID after handshake
 	if tunnel != nil && tunnel.ConnectionID() != "" {
@@ -283,17 +329,81 @@ func DemoDoConnect(request *http.Request, tunnelStore *guac2.ActiveTunnelStore,
 	return tunnel, nil
 }
 
-func DoConnectShare(request http.Request, tunnelStore *guac2.ActiveTunnelStore, redisClient *redis.Client, guacdAddr string, cleanupService *cleanup.SessionCleanupService) (guac2.Tunnel, error) {
+// startRecording wraps the tunnel so that the guacd output of the session is stored in MinIO.
+// The unwrapped tunnel is returned if recording cannot be started so the session is not refused.
+func startRecording(tunnel guac2.Tunnel, session redis2.SessionData, recordings *minio2.RecordingStore) guac2.Tunnel {
+	if recordings == nil {
+		logrus.Warnf("Recording requested for session %s but no recording store is configured", session.ConnectionID)
+		return tunnel
+	}
+
+	connectionID := session.ConnectionID
+	if connectionID == "" {
+		connectionID = tunnel.ConnectionID()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sink, err := recordings.Create(ctx, minio2.RecordingMetadata{
+		ConnectionID:       connectionID,
+		TunnelConnectionID: tunnel.ConnectionID(),
+		TunnelUUID:         tunnel.GetUUID(),
+		PodName:            session.PodName,
+		OwnerID:            session.OwnerID,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to start recording for session %s: %v", connectionID, err)
+		return tunnel
+	}
+
+	return guac2.NewRecordingTunnel(tunnel, sink)
+}
+
+func DoConnectShare(request http.Request, tunnelStore *guac2.ActiveTunnelStore, redisClient *redis.Client, guacdAddr string, cleanupService *cleanup.SessionCleanupService) (tunnel guac2.Tunnel, err error) {
 	config := guac2.ExistingGuacamoleConfiguration()
 	var query url.Values
-	uuid := request.URL.Query().Get("uuid")
+	token := request.URL.Query().Get("token")
 	var storedConnectionID string
 	var exists bool
 
-	// Check if UUID is provided
-	if uuid == "" {
-		logrus.Warn("No UUID provided for shared connection")
-		return nil, fmt.Errorf("no UUID provided")
+	// Check if a share token is provided
+	if token == "" {
+		logrus.Warn("No share token provided for shared connection")
+		return nil, fmt.Errorf("no share token provided")
+	}
+
+	// Take a place on the share link, released again if joining fails
+	share, err := redis2.JoinShareLink(redisClient, token)
+	if err != nil {
+		logrus.Warnf("Refused shared connection: %v", err)
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			if leaveErr := redis2.LeaveShareLink(redisClient, token); leaveErr != nil {
+				logrus.Warnf("Failed to release viewer of share link: %v", leaveErr)
+			}
+		}
+	}()
+	uuid := share.ConnectionID
+
+	session, err := redis2.GetSessionData(redisClient, uuid)
+	if err != nil {
+		logrus.Debugf("No session found for shared UUID %s: %v", uuid, err)
+		return nil, fmt.Errorf("shared session is no longer available")
+	}
+	var viewOnlyFilter guac2.InstructionFilter
+	if share.Mode == redis2.ShareModeViewOnly {
+		viewOnlyFilter = guac2.ViewOnlyFilter()
+	}
+	roles := []string{guac2.RoleViewer}
+	if user, ok := auth.RequestUser(&request); ok {
+		roles = append(roles, string(user.Role))
+	}
+	filters, err := sessionFilters(session, roles, viewOnlyFilter)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get the stored connection ID
@@ -416,9 +526,9 @@ func DoConnectShare(request http.Request, tunnelStore *guac2.ActiveTunnelStore,
 	}
 
 	// Create the tunnel
-	tunnel := guac2.NewSimpleTunnel(stream)
+	simpleTunnel := guac2.NewSimpleTunnel(stream)
 
-	if tunnel == nil {
+	if simpleTunnel == nil {
 		err := conn.Close()
 		if err != nil {
 			logrus.Errorf("Failed to close connection after creating shared tunnel: %v", err)
@@ -427,7 +537,28 @@ func DoConnectShare(request http.Request, tunnelStore *guac2.ActiveTunnelStore,
 		return nil, fmt.Errorf("failed to create shared tunnel")
 	}
 
-	logrus.Infof("Successfully created shared tunnel for connection ID: %s", storedConnectionID)
+	logrus.Infof("Successfully created %s shared tunnel for connection ID: %s", share.Mode, storedConnectionID)
 
-	return tunnel, nil
+	// The viewer leaves the share link when the tunnel closes
+	return newShareTunnel(simpleTunnel, *share, filters, redisClient), nil
+}
+
+// sessionFilters returns the filter chain of a connection to a session: the filters passed
+// in, the input policy of the profile and its clipboard inspection. The roles are the role
+// of the connection in the session and the role of its signed-in user, if any.
+// It is nil if nothing is filtered.
+func sessionFilters(session *redis2.SessionData, roles []string, filters ...guac2.InstructionFilter) (*guac2.FilterChain, error) {
+	profile, ok := k8s2.GetSandboxProfile(session.Profile)
+	if !ok {
+		return guac2.NewFilterChain(filters...), nil
+	}
+	policyFilter, err := profile.InputPolicy.Filter(roles...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input policy of profile %s: %w", profile.Name, err)
+	}
+	clipboard, err := guac2.NewClipboardInspector(session.ConnectionID, profile.Clipboard)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clipboard policy of profile %s: %w", profile.Name, err)
+	}
+	return guac2.NewFilterChain(append(filters, policyFilter)...).WithClipboardInspector(clipboard), nil
 }
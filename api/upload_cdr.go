@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/browsersec/KubeBrowse/internal/cdr"
+	"github.com/sirupsen/logrus"
+)
+
+// deliverDisarmed streams the original of a staged document to the destinations other than
+// the sandbox, so MinIO keeps it, and a disarmed copy to the sandbox. What was removed is
+// added to the result of the sandbox as "cdr". Documents that cannot be disarmed are not
+// delivered to the sandbox.
+func deliverDisarmed(ctx context.Context, staged *os.File, size int64, filename string, destinations []uploadDestination, maxUploadBytes int64) ([]UploadResult, error) {
+	others := make([]uploadDestination, len(destinations))
+	copy(others, destinations)
+	others[uploadToSandbox] = nil
+
+	var sandboxResult UploadResult
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sandboxResult = recordUpload(deliverDisarmedCopy(ctx, staged, size, filename, destinations[uploadToSandbox]))
+	}()
+
+	results, err := performConcurrentUploads(ctx, io.NewSectionReader(staged, 0, size), filename, others, maxUploadBytes)
+	<-done
+	results[uploadToSandbox] = sandboxResult
+	return results, err
+}
+
+// deliverDisarmedCopy disarms a staged document into a file of its own and streams it to the sandbox
+func deliverDisarmedCopy(ctx context.Context, staged *os.File, size int64, filename string, sandbox uploadDestination) UploadResult {
+	disarmed, err := os.CreateTemp("", "kubebrowse-cdr-*")
+	if err != nil {
+		logrus.Errorf("Failed to stage disarmed copy of %q: %v", filename, err)
+		return UploadResult{Service: "file_upload", Success: false, Error: "not delivered, failed to stage disarmed copy"}
+	}
+	defer removeStagedFile(disarmed)
+
+	report, err := cdr.Disarm(filename, staged, size, disarmed)
+	if err != nil {
+		logrus.Warnf("Failed to disarm upload %q, not delivering it: %v", filename, err)
+		return UploadResult{Service: "file_upload", Success: false, Error: "not delivered, content disarm failed: " + err.Error()}
+	}
+	info, err := disarmed.Stat()
+	if err != nil {
+		return UploadResult{Service: "file_upload", Success: false, Error: "not delivered, failed to read disarmed copy: " + err.Error()}
+	}
+	logrus.Infof("Disarmed upload %q, removed %d items", filename, len(report.Removed))
+
+	result := sandbox(ctx, io.NewSectionReader(disarmed, 0, info.Size()), filename)
+	data, ok := result.Data.(map[string]interface{})
+	if !ok {
+		data = map[string]interface{}{}
+	}
+	data["cdr"] = report
+	result.Data = data
+	return result
+}
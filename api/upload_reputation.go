@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"io"
+	"os"
+
+	minio2 "github.com/browsersec/KubeBrowse/internal/minio"
+	"github.com/browsersec/KubeBrowse/internal/reputation"
+	"github.com/browsersec/KubeBrowse/internal/scanner"
+	"github.com/sirupsen/logrus"
+)
+
+// reputationScanner names the reputation cache as the scanner of known verdicts
+const reputationScanner = "reputation"
+
+// lookupReputation returns the known verdict of a hash, nil if the file has to be scanned
+func lookupReputation(ctx context.Context, fileReputation *reputation.Service, hash string) *reputation.Entry {
+	known, err := fileReputation.Lookup(ctx, hash)
+	if err != nil {
+		logrus.Warnf("Failed to look up the reputation of %s, scanning the file: %v", hash, err)
+		return nil
+	}
+	return known
+}
+
+// deliverKnownUpload delivers a staged upload whose hash has a known verdict without
+// scanning it. Known malicious files are quarantined instead, whatever the profile of the
+// session. The known verdict is reported as the result of the scanner.
+func deliverKnownUpload(ctx context.Context, staged *os.File, size int64, filename, connectionID string, known *reputation.Entry, destinations []uploadDestination, quarantine *minio2.QuarantineStore, checks uploadChecks, maxUploadBytes int64) ([]UploadResult, error) {
+	verdict := &scanner.Result{Scanner: reputationScanner, Infected: known.Malicious(), Threats: known.Threats}
+	cached := UploadResult{
+		Service: "clamav",
+		Success: true,
+		Data: map[string]interface{}{
+			"scanner":    reputationScanner,
+			"response":   scanResponse(filename, verdict),
+			"verdict":    verdict,
+			"sha256":     known.SHA256,
+			"reputation": known,
+		},
+	}
+
+	if known.Malicious() {
+		logrus.Warnf("Upload %q to session %s is known to be malicious: %v", filename, connectionID, known.Threats)
+		results := withheldUploadResults(destinations, "not delivered, the file is known to be malicious")
+		results[uploadToScanner] = cached
+		quarantined := recordUpload(quarantineUpload(ctx, io.NewSectionReader(staged, 0, size), size, filename, connectionID, verdict, quarantine))
+		return append(results, quarantined), nil
+	}
+
+	deliver := make([]uploadDestination, len(destinations))
+	copy(deliver, destinations)
+	deliver[uploadToScanner] = nil
+	results, err := deliverStaged(ctx, staged, size, filename, deliver, checks, maxUploadBytes)
+	results[uploadToScanner] = cached
+	return results, err
+}
+
+// rememberUpload records the verdict of the scan of a new file and its delivery
+func rememberUpload(ctx context.Context, fileReputation *reputation.Service, hash string, size int64, filename, connectionID string, results []UploadResult) {
+	if len(results) == 0 {
+		return
+	}
+	// the upload may have used up its time, what it found out is still worth keeping
+	ctx = context.WithoutCancel(ctx)
+
+	if data, ok := results[uploadToScanner].Data.(map[string]interface{}); ok {
+		data["sha256"] = hash
+	}
+
+	verdict := ""
+	if scanned, err := scanVerdict(results[uploadToScanner]); err == nil {
+		if err := fileReputation.RecordScan(ctx, hash, scanned); err != nil {
+			logrus.Errorf("Failed to record the reputation of %s: %v", hash, err)
+		}
+		verdict = reputation.VerdictClean
+		if scanned.Infected {
+			verdict = reputation.VerdictMalicious
+		}
+	}
+	logUploadDelivery(ctx, fileReputation, hash, size, filename, connectionID, verdict, results)
+}
+
+// logUploadDelivery records which session received a file, or was refused it
+func logUploadDelivery(ctx context.Context, fileReputation *reputation.Service, hash string, size int64, filename, connectionID, verdict string, results []UploadResult) {
+	err := fileReputation.LogDelivery(context.WithoutCancel(ctx), reputation.Delivery{
+		SHA256:       hash,
+		ConnectionID: connectionID,
+		Filename:     filename,
+		Size:         size,
+		Verdict:      verdict,
+		Delivered:    results[uploadToSandbox].Success,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to log delivery of %q to session %s: %v", filename, connectionID, err)
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	sqlc "github.com/browsersec/KubeBrowse/db/sqlc"
+	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
+	"github.com/browsersec/KubeBrowse/internal/reputation"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestDeliverUploadLooksUpReputationFirst(t *testing.T) {
+	upload := []byte("MZ not a real executable")
+	sum := sha256.Sum256(upload)
+	hash := hex.EncodeToString(sum[:])
+
+	for _, test := range []struct {
+		name      string
+		verdict   string
+		delivered bool
+	}{
+		{"blocked hash", reputation.VerdictMalicious, false},
+		{"unknown hash", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("sqlmock.New()=%v", err)
+			}
+			defer db.Close()
+			lookup := mock.ExpectQuery("FROM file_reputations").WithArgs(hash)
+			if test.verdict != "" {
+				lookup.WillReturnRows(sqlmock.NewRows([]string{"sha256", "verdict", "threats", "source", "scanner", "note", "created_at", "updated_at"}).
+					AddRow(hash, test.verdict, "{Blocked.Test}", reputation.SourceAdmin, "", "imported", time.Now(), time.Now()))
+			} else {
+				lookup.WillReturnError(sql.ErrNoRows)
+			}
+			mock.ExpectExec("INSERT INTO file_deliveries").WillReturnResult(sqlmock.NewResult(1, 1))
+
+			redisClient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+			defer redisClient.Close()
+			fileReputation := reputation.NewService(sqlc.New(db), redisClient, time.Hour, time.Hour)
+
+			var sandboxRequests, storageBytes atomic.Int64
+			sandbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sandboxRequests.Add(1)
+				_, _ = io.Copy(io.Discard, r.Body)
+			}))
+			defer sandbox.Close()
+
+			destinations := make([]uploadDestination, 3)
+			destinations[uploadToScanner] = func(ctx context.Context, file io.Reader, filename string) UploadResult {
+				_, _ = io.Copy(io.Discard, file)
+				return UploadResult{Service: "clamav", Success: true}
+			}
+			destinations[uploadToStorage] = func(ctx context.Context, file io.Reader, filename string) UploadResult {
+				n, _ := io.Copy(io.Discard, file)
+				storageBytes.Add(n)
+				return UploadResult{Service: "minio", Success: true}
+			}
+
+			// sessions without a profile neither scan first nor disarm uploads
+			results, err := deliverUpload(context.Background(), bytes.NewReader(upload), "setup.exe", "conn", &redis2.SessionData{}, sandbox.URL, destinations, nil, fileReputation, 1<<20)
+			if err != nil {
+				t.Fatalf("deliverUpload()=%v", err)
+			}
+
+			delivered := sandboxRequests.Load() > 0
+			if delivered != test.delivered || results[uploadToSandbox].Success != test.delivered {
+				t.Errorf("sandbox received the upload=%v with result %+v, want %v", delivered, results[uploadToSandbox], test.delivered)
+			}
+			if stored := storageBytes.Load() > 0; stored != test.delivered {
+				t.Errorf("storage received the upload=%v, want %v", stored, test.delivered)
+			}
+			if err = mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
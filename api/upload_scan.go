@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/browsersec/KubeBrowse/internal/cdr"
+	k8s2 "github.com/browsersec/KubeBrowse/internal/k8s"
+	minio2 "github.com/browsersec/KubeBrowse/internal/minio"
+	"github.com/browsersec/KubeBrowse/internal/reputation"
+	"github.com/browsersec/KubeBrowse/internal/scanner"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	errScannerUnavailable = errors.New("virus scanning is not available")
+	errUploadStaging      = errors.New("failed to stage upload")
+)
+
+// uploadServices names the destinations of an upload in their results, by position
+var uploadServices = []string{
+	uploadToSandbox: "file_upload",
+	uploadToScanner: "clamav",
+	uploadToStorage: "minio",
+}
+
+// uploadChecks are what the profile of a session asks for before uploads reach its sandbox
+type uploadChecks struct {
+	// scanFirst delivers uploads only once the malware scanner found them clean
+	scanFirst bool
+	// disarm delivers a disarmed copy of documents to the sandbox
+	disarm bool
+	// reputation skips scanning files whose hash has a known verdict and remembers the
+	// verdicts of new files
+	reputation *reputation.Service
+}
+
+// uploadChecksFor returns the checks of a sandbox profile for an uploaded file
+func uploadChecksFor(profileName, filename string) uploadChecks {
+	profile, ok := k8s2.GetSandboxProfile(profileName)
+	if !ok {
+		return uploadChecks{}
+	}
+	return uploadChecks{
+		scanFirst: profile.ScansUploadsFirst(),
+		disarm:    profile.ContentDisarm && cdr.Supported(filename),
+	}
+}
+
+// staged reports whether the upload has to be staged on disk before it is delivered. The
+// reputation of an upload is looked up by its hash, which is only known once it was read.
+func (c uploadChecks) staged() bool {
+	return c.scanFirst || c.disarm || c.reputation != nil
+}
+
+// performStagedUpload stages file on disk before it is delivered. With scanFirst the
+// malware scanner scans it before it is sent anywhere else: clean files are then streamed
+// to the other configured destinations, infected files are quarantined and files that could
+// not be scanned are dropped. The result of quarantining follows the results of the
+// destinations. With disarm the sandbox receives a disarmed copy, see deliverDisarmed.
+// With reputation, files whose hash has a known verdict are not scanned again, see
+// deliverKnownUpload.
+func performStagedUpload(ctx context.Context, file io.Reader, filename, connectionID string, destinations []uploadDestination, quarantine *minio2.QuarantineStore, checks uploadChecks, maxUploadBytes int64) (results []UploadResult, err error) {
+	scan := destinations[uploadToScanner]
+	staged, size, hash, err := stageUpload(file, maxUploadBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer removeStagedFile(staged)
+
+	if checks.reputation != nil {
+		if known := lookupReputation(ctx, checks.reputation, hash); known != nil {
+			results, err = deliverKnownUpload(ctx, staged, size, filename, connectionID, known, destinations, quarantine, checks, maxUploadBytes)
+			logUploadDelivery(ctx, checks.reputation, hash, size, filename, connectionID, known.Verdict, results)
+			return results, err
+		}
+		defer func() {
+			rememberUpload(ctx, checks.reputation, hash, size, filename, connectionID, results)
+		}()
+	}
+
+	if checks.scanFirst && scan == nil {
+		return nil, errScannerUnavailable
+	}
+
+	if !checks.scanFirst {
+		return deliverStaged(ctx, staged, size, filename, destinations, checks, maxUploadBytes)
+	}
+
+	scanned := recordUpload(scan(ctx, io.NewSectionReader(staged, 0, size), filename))
+	verdict, err := scanVerdict(scanned)
+	if err != nil {
+		logrus.Warnf("Upload %q to session %s was not scanned, dropping it: %v", filename, connectionID, err)
+		results = withheldUploadResults(destinations, "not delivered, the file could not be scanned")
+		results[uploadToScanner] = scanned
+		return results, nil
+	}
+
+	if verdict.Infected {
+		logrus.Warnf("Upload %q to session %s is infected: %v", filename, connectionID, verdict.Threats)
+		results = withheldUploadResults(destinations, "not delivered, the file is infected")
+		results[uploadToScanner] = scanned
+		quarantined := recordUpload(quarantineUpload(ctx, io.NewSectionReader(staged, 0, size), size, filename, connectionID, verdict, quarantine))
+		return append(results, quarantined), nil
+	}
+
+	// The scanner already saw the file, only the other destinations receive it now
+	deliver := make([]uploadDestination, len(destinations))
+	copy(deliver, destinations)
+	deliver[uploadToScanner] = nil
+	results, err = deliverStaged(ctx, staged, size, filename, deliver, checks, maxUploadBytes)
+	results[uploadToScanner] = scanned
+	return results, err
+}
+
+// deliverStaged streams a staged upload to the destinations
+func deliverStaged(ctx context.Context, staged *os.File, size int64, filename string, destinations []uploadDestination, checks uploadChecks, maxUploadBytes int64) ([]UploadResult, error) {
+	if checks.disarm {
+		return deliverDisarmed(ctx, staged, size, filename, destinations, maxUploadBytes)
+	}
+	return performConcurrentUploads(ctx, io.NewSectionReader(staged, 0, size), filename, destinations, maxUploadBytes)
+}
+
+// stageUpload copies an upload to a temporary file and returns its hex encoded SHA-256
+// hash, failing with errUploadTooLarge after limit bytes. The caller removes the file with
+// removeStagedFile.
+func stageUpload(file io.Reader, limit int64) (*os.File, int64, string, error) {
+	staged, err := os.CreateTemp("", "kubebrowse-upload-*")
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("%w: %v", errUploadStaging, err)
+	}
+
+	hash := sha256.New()
+	size, err := io.CopyN(io.MultiWriter(stagingWriter{staged}, hash), file, limit+1)
+	if err == io.EOF {
+		err = nil
+	} else if err == nil {
+		err = errUploadTooLarge
+	}
+	if err != nil {
+		removeStagedFile(staged)
+		return nil, 0, "", err
+	}
+	return staged, size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// removeStagedFile closes and removes a staged file
+func removeStagedFile(staged *os.File) {
+	_ = staged.Close()
+	if err := os.Remove(staged.Name()); err != nil {
+		logrus.Errorf("Failed to remove staged upload %s: %v", staged.Name(), err)
+	}
+}
+
+// stagingWriter marks failures to write a staged upload, telling them apart from failures
+// to read the request
+type stagingWriter struct {
+	file *os.File
+}
+
+func (w stagingWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("%w: %v", errUploadStaging, err)
+	}
+	return n, nil
+}
+
+// withheldUploadResults returns failed results for the configured destinations an upload
+// was not sent to. Destinations that are not configured have an empty result.
+func withheldUploadResults(destinations []uploadDestination, reason string) []UploadResult {
+	results := make([]UploadResult, len(destinations))
+	for i, destination := range destinations {
+		if destination != nil {
+			results[i] = UploadResult{Service: uploadServices[i], Success: false, Error: reason}
+		}
+	}
+	return results
+}
+
+// quarantineUpload stores an infected upload in the quarantine bucket with its scan result
+func quarantineUpload(ctx context.Context, file io.Reader, size int64, filename, connectionID string, verdict *scanner.Result, quarantine *minio2.QuarantineStore) UploadResult {
+	if quarantine == nil {
+		return UploadResult{Service: "quarantine", Success: false, Error: "quarantine bucket not configured"}
+	}
+
+	scanResult, err := json.Marshal(verdict)
+	if err != nil {
+		return UploadResult{Service: "quarantine", Success: false, Error: "failed to encode scan result: " + err.Error()}
+	}
+
+	// the filename comes from the user, escaping it keeps the object in the folder of the session
+	objectName := fmt.Sprintf("%s/%d_%s", connectionID, time.Now().Unix(), url.PathEscape(filename))
+	info, err := quarantine.Put(ctx, objectName, file, size, map[string]string{
+		"Connection-Id": connectionID,
+		"Filename":      url.PathEscape(filename),
+		"Scan-Result":   string(scanResult),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to quarantine upload %q of session %s: %v", filename, connectionID, err)
+		return UploadResult{Service: "quarantine", Success: false, Error: "upload failed: " + err.Error()}
+	}
+
+	return UploadResult{
+		Service: "quarantine",
+		Success: true,
+		Data: map[string]interface{}{
+			"bucket":      quarantine.Bucket(),
+			"object_name": objectName,
+			"size":        info.Size,
+		},
+	}
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"mime/multipart"
+	"sync"
+)
+
+const (
+	// uploadChunkSize is the size of the chunks uploads are copied in
+	uploadChunkSize = 32 << 10
+	// uploadQueueLength is the number of chunks queued for each destination before the
+	// copy waits for it, which bounds the memory of an upload
+	uploadQueueLength = 8
+)
+
+var errUploadTooLarge = errors.New("file too large")
+
+// uploadFanOut copies an upload to several destinations concurrently without holding the
+// whole file in memory. Each destination reads from its own bounded queue of chunks; when
+// a queue is full the copy waits, so the slowest destination sets the pace of the upload.
+// A destination that stops reading is dropped without affecting the others.
+type uploadFanOut struct {
+	branches []*uploadBranch
+}
+
+func newUploadFanOut(destinations int) *uploadFanOut {
+	f := &uploadFanOut{}
+	for i := 0; i < destinations; i++ {
+		f.branches = append(f.branches, &uploadBranch{
+			chunks:  make(chan []byte, uploadQueueLength),
+			stopped: make(chan struct{}),
+		})
+	}
+	return f
+}
+
+// copyFrom copies src to all destinations, failing with errUploadTooLarge after limit bytes
+func (f *uploadFanOut) copyFrom(src io.Reader, limit int64) error {
+	var total int64
+	for {
+		// chunks are shared by the destinations and never written to again
+		chunk := make([]byte, uploadChunkSize)
+		n, err := src.Read(chunk)
+		if n > 0 {
+			total += int64(n)
+			if total > limit {
+				f.finish(errUploadTooLarge)
+				return errUploadTooLarge
+			}
+			for _, branch := range f.branches {
+				select {
+				case branch.chunks <- chunk[:n]:
+				case <-branch.stopped:
+				}
+			}
+		}
+		if err == io.EOF {
+			f.finish(io.EOF)
+			return nil
+		}
+		if err != nil {
+			f.finish(err)
+			return err
+		}
+	}
+}
+
+// finish ends the upload of all destinations, they read err once their queue is empty
+func (f *uploadFanOut) finish(err error) {
+	for _, branch := range f.branches {
+		branch.err = err
+		close(branch.chunks)
+	}
+}
+
+// uploadBranch is the reader of one destination of an uploadFanOut
+type uploadBranch struct {
+	chunks  chan []byte
+	current []byte
+	err     error
+
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func (b *uploadBranch) Read(p []byte) (int, error) {
+	for len(b.current) == 0 {
+		chunk, ok := <-b.chunks
+		if !ok {
+			return 0, b.err
+		}
+		b.current = chunk
+	}
+	n := copy(p, b.current)
+	b.current = b.current[n:]
+	return n, nil
+}
+
+// stop is called once the destination is done, so the copy no longer waits for it
+func (b *uploadBranch) stop() {
+	b.stopOnce.Do(func() {
+		close(b.stopped)
+	})
+}
+
+// multipartBody streams file as a multipart form with the given file field and extra
+// fields. The returned body must be closed, which the HTTP client does for request bodies.
+func multipartBody(fileField, filename string, file io.Reader, fields map[string]string) (io.ReadCloser, string) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	go func() {
+		part, err := writer.CreateFormFile(fileField, filename)
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		for name, value := range fields {
+			if err == nil {
+				err = writer.WriteField(name, value)
+			}
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	return pr, writer.FormDataContentType()
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+// readBranches reads every branch of f concurrently, stopping each after max bytes
+// if max is not negative, and returns what each read and the error it ended with
+func readBranches(f *uploadFanOut, max ...int64) ([][]byte, []error, func()) {
+	data := make([][]byte, len(f.branches))
+	errs := make([]error, len(f.branches))
+	var wg sync.WaitGroup
+	for i, branch := range f.branches {
+		wg.Add(1)
+		go func(i int, branch *uploadBranch) {
+			defer wg.Done()
+			defer branch.stop()
+			var reader io.Reader = branch
+			if max[i] >= 0 {
+				reader = io.LimitReader(branch, max[i])
+			}
+			buf := &bytes.Buffer{}
+			_, errs[i] = io.Copy(buf, reader)
+			if max[i] < 0 && errs[i] == nil {
+				// io.Copy hides the io.EOF of a branch that completed
+				errs[i] = io.EOF
+			}
+			data[i] = buf.Bytes()
+		}(i, branch)
+	}
+	return data, errs, wg.Wait
+}
+
+func TestUploadFanOutCopiesToEveryBranch(t *testing.T) {
+	upload := bytes.Repeat([]byte("0123456789"), uploadChunkSize/4)
+
+	f := newUploadFanOut(3)
+	data, errs, wait := readBranches(f, -1, -1, -1)
+	if err := f.copyFrom(bytes.NewReader(upload), int64(len(upload))); err != nil {
+		t.Fatalf("copyFrom()=%v", err)
+	}
+	wait()
+
+	for i := range data {
+		if !bytes.Equal(data[i], upload) {
+			t.Errorf("branch %d read %d bytes, want %d", i, len(data[i]), len(upload))
+		}
+		if errs[i] != io.EOF {
+			t.Errorf("branch %d ended with %v, want io.EOF", i, errs[i])
+		}
+	}
+}
+
+func TestUploadFanOutDropsBranchThatStopsEarly(t *testing.T) {
+	// more chunks than the queue of the stopped branch holds
+	upload := bytes.Repeat([]byte("x"), uploadChunkSize*(uploadQueueLength+4))
+
+	f := newUploadFanOut(2)
+	data, _, wait := readBranches(f, 10, -1)
+	if err := f.copyFrom(bytes.NewReader(upload), int64(len(upload))); err != nil {
+		t.Fatalf("copyFrom()=%v", err)
+	}
+	wait()
+
+	if len(data[0]) != 10 {
+		t.Errorf("stopped branch read %d bytes, want 10", len(data[0]))
+	}
+	if !bytes.Equal(data[1], upload) {
+		t.Errorf("other branch read %d bytes, want %d", len(data[1]), len(upload))
+	}
+}
+
+func TestUploadFanOutFailsOverLimit(t *testing.T) {
+	upload := bytes.Repeat([]byte("x"), uploadChunkSize*3)
+
+	f := newUploadFanOut(2)
+	_, errs, wait := readBranches(f, -1, -1)
+	if err := f.copyFrom(bytes.NewReader(upload), uploadChunkSize*2); !errors.Is(err, errUploadTooLarge) {
+		t.Fatalf("copyFrom()=%v, want %v", err, errUploadTooLarge)
+	}
+	wait()
+
+	for i, err := range errs {
+		if !errors.Is(err, errUploadTooLarge) {
+			t.Errorf("branch %d ended with %v, want %v", i, err, errUploadTooLarge)
+		}
+	}
+}
+
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestUploadFanOutPassesReadErrorToEveryBranch(t *testing.T) {
+	readErr := errors.New("connection reset")
+
+	f := newUploadFanOut(2)
+	data, errs, wait := readBranches(f, -1, -1)
+	if err := f.copyFrom(&failingReader{data: []byte("partial"), err: readErr}, 1<<20); !errors.Is(err, readErr) {
+		t.Fatalf("copyFrom()=%v, want %v", err, readErr)
+	}
+	wait()
+
+	for i := range errs {
+		if string(data[i]) != "partial" {
+			t.Errorf("branch %d read %q, want %q", i, data[i], "partial")
+		}
+		if !errors.Is(errs[i], readErr) {
+			t.Errorf("branch %d ended with %v, want %v", i, errs[i], readErr)
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package api
 
 import (
-	"bytes"
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
@@ -13,10 +13,13 @@ import (
 	"sync"
 	"time"
 
+	"github.com/browsersec/KubeBrowse/internal/metrics"
+	minio2 "github.com/browsersec/KubeBrowse/internal/minio"
 	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
+	"github.com/browsersec/KubeBrowse/internal/reputation"
+	"github.com/browsersec/KubeBrowse/internal/scanner"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
-	"github.com/minio/minio-go/v7"
 	"github.com/sirupsen/logrus"
 	"k8s.io/client-go/kubernetes"
 )
@@ -36,25 +39,32 @@ type MultiUploadResponse struct {
 	Message string         `json:"message"`
 }
 
-// FileBuffer holds file data for concurrent uploads
-type FileBuffer struct {
-	Data     []byte
-	Filename string
-	Size     int64
-}
+// uploadTimeout bounds the whole upload, streaming the file to all destinations and their responses
+const uploadTimeout = 5 * time.Minute
+
+// uploadDestination receives a streamed upload
+type uploadDestination func(ctx context.Context, file io.Reader, filename string) UploadResult
 
-func getFQDNURL(connectionID string, redisClient *redis.Client) (string, error) {
+// Positions of the destinations of an upload and their results
+const (
+	uploadToSandbox = iota
+	uploadToScanner
+	uploadToStorage
+)
+
+// getFQDNURL returns the upload URL of the sandbox of a session and the session
+func getFQDNURL(connectionID string, redisClient *redis.Client) (string, *redis2.SessionData, error) {
 	val, err := redisClient.Get(context.Background(), "session:"+connectionID).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return "", fmt.Errorf("session not found")
+			return "", nil, fmt.Errorf("session not found")
 		}
-		return "", fmt.Errorf("redis get session: %w", err)
+		return "", nil, fmt.Errorf("redis get session: %w", err)
 	}
 
 	var session redis2.SessionData
 	if err := json.Unmarshal([]byte(val), &session); err != nil {
-		return "", fmt.Errorf("failed to unmarshal session data")
+		return "", nil, fmt.Errorf("failed to unmarshal session data")
 	}
 
 	u := url.URL{
@@ -63,106 +73,94 @@ func getFQDNURL(connectionID string, redisClient *redis.Client) (string, error)
 		Path:   "upload",
 	}
 	logrus.Debugf("Resolved upload URL for %s", connectionID)
-	return u.String(), nil
+	return u.String(), &session, nil
 }
 
-// HandlerUploadFile handles file uploads to multiple destinations concurrently
-func HandlerUploadFile(c *gin.Context, redisClient *redis.Client, k8sClient *kubernetes.Clientset, minioClient *minio.Client, minioBucket string, clamavurl string, timeout time.Duration) {
-	start := time.Now()
+// HandlerUploadFile streams an upload to the sandbox, the malware scanner and the file
+// library of the user in MinIO concurrently. For sandbox profiles that scan uploads first,
+// infected files are quarantined instead.
+func HandlerUploadFile(c *gin.Context, redisClient *redis.Client, k8sClient *kubernetes.Clientset, library *minio2.FileLibrary, quarantine *minio2.QuarantineStore, malwareScanner scanner.Scanner, fileReputation *reputation.Service, maxUploadBytes int64) {
+	destinations := newUploadDestinations(library, uploadOwner(c), c.Param("connectionID"), malwareScanner)
+	handleUpload(c, redisClient, destinations, quarantine, fileReputation, maxUploadBytes, func(results []UploadResult) bool {
+		tagStoredUpload(library, results)
+		return allUploadsSucceeded(results)
+	})
+}
 
-	// Get connection URL
-	url, err := getFQDNURL(c.Param("connectionID"), redisClient)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+// newUploadDestinations returns the destinations of an upload to the sandbox, the malware
+// scanner and the library of owner in MinIO. The sandbox destination is set for each upload
+// by deliverUpload.
+func newUploadDestinations(library *minio2.FileLibrary, owner, connectionID string, malwareScanner scanner.Scanner) []uploadDestination {
+	destinations := make([]uploadDestination, 3)
 
-	if url == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid connection ID"})
-		return
+	if malwareScanner != nil {
+		destinations[uploadToScanner] = func(ctx context.Context, file io.Reader, filename string) UploadResult {
+			return scanUpload(ctx, file, filename, malwareScanner)
+		}
 	}
-
-	// Get uploaded file
-	fileHeader, err := c.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
-		return
+	if library != nil {
+		destinations[uploadToStorage] = func(ctx context.Context, file io.Reader, filename string) UploadResult {
+			return storeUpload(ctx, file, filename, library, owner, connectionID)
+		}
 	}
+	return destinations
+}
 
-	const maxUploadBytes = int64(100 << 20) // 100 MiB; make configurable
-	if fileHeader.Size <= 0 || fileHeader.Size > maxUploadBytes {
-		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
-		return
+// allUploadsSucceeded fills in the results of the unconfigured destinations of
+// newUploadDestinations, an upload succeeds if it reached all of them
+func allUploadsSucceeded(results []UploadResult) bool {
+	if results[uploadToScanner].Service == "" {
+		results[uploadToScanner] = UploadResult{Service: "clamav", Success: false, Error: "malware scanner not configured"}
 	}
-
-	// Read file once into memory buffer
-	fileBuffer, err := readFileToBuffer(fileHeader)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file: " + err.Error()})
-		return
+	if results[uploadToStorage].Service == "" {
+		results[uploadToStorage] = UploadResult{Service: "minio", Success: false, Error: "MinIO client or bucket not configured"}
 	}
-
-	// Create context with timeout for all operations
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Perform concurrent uploads
-	results := performConcurrentUploads(ctx, fileBuffer, url, minioClient, minioBucket, clamavurl, timeout)
-
-	// Determine overall success
-	overallSuccess := true
 	for _, result := range results {
 		if !result.Success {
-			overallSuccess = false
-			break
+			return false
 		}
 	}
+	return true
+}
 
-	statusCode := http.StatusOK
-	if !overallSuccess {
-		statusCode = http.StatusMultiStatus // 207
-	}
-
-	response := MultiUploadResponse{
-		Success: overallSuccess,
-		Results: results,
-		Message: fmt.Sprintf("Upload completed in %v", time.Since(start)),
-	}
-
-	c.JSON(statusCode, response)
+// HandlerUploadFileWithoutMinio streams an upload to the sandbox and the malware scanner, without MinIO storage
+func HandlerUploadFileWithoutMinio(c *gin.Context, redisClient *redis.Client, k8sClient *kubernetes.Clientset, malwareScanner scanner.Scanner, fileReputation *reputation.Service, maxUploadBytes int64) {
+	handleUpload(c, redisClient, newSandboxDestinations(malwareScanner), nil, fileReputation, maxUploadBytes, sandboxUploadSucceeded)
 }
 
-// readFileToBuffer reads the uploaded file into a buffer for concurrent use
-func readFileToBuffer(fileHeader *multipart.FileHeader) (*FileBuffer, error) {
-	srcFile, err := fileHeader.Open()
-	if err != nil {
-		return nil, fmt.Errorf("cannot open uploaded file: %w", err)
-	}
-	defer func() {
-		if err := srcFile.Close(); err != nil {
-			logrus.Errorf("Failed to close source file: %v", err)
-		}
-	}()
+// newSandboxDestinations returns the destinations of an upload to the sandbox and the
+// malware scanner only
+func newSandboxDestinations(malwareScanner scanner.Scanner) []uploadDestination {
+	destinations := make([]uploadDestination, 2)
 
-	// Read entire file into memory
-	data, err := io.ReadAll(srcFile)
-	if err != nil {
-		return nil, fmt.Errorf("cannot read file data: %w", err)
+	if malwareScanner != nil {
+		destinations[uploadToScanner] = func(ctx context.Context, file io.Reader, filename string) UploadResult {
+			return scanUpload(ctx, file, filename, malwareScanner)
+		}
 	}
+	return destinations
+}
 
-	return &FileBuffer{
-		Data:     data,
-		Filename: fileHeader.Filename,
-		Size:     fileHeader.Size,
-	}, nil
+// sandboxUploadSucceeded fills in the result of an unconfigured scanner of
+// newSandboxDestinations, an upload succeeds if it reached the sandbox
+func sandboxUploadSucceeded(results []UploadResult) bool {
+	if results[uploadToScanner].Service == "" {
+		results[uploadToScanner] = UploadResult{Service: "clamav", Success: false, Error: "malware scanner not configured"}
+	}
+	// only the upload to the sandbox counts for success
+	return results[uploadToSandbox].Success
 }
 
-// HandlerUploadFileWithoutMinio handles file uploads without MinIO storage
-func HandlerUploadFileWithoutMinio(c *gin.Context, redisClient *redis.Client, k8sClient *kubernetes.Clientset, clamavurl string, timeout time.Duration) {
+// handleUpload streams the file of a multipart upload once to the sandbox of the session and
+// the other configured destinations, or stages it to scan or disarm it first if the profile
+// of the session asks for it. succeeded fills in the results of unconfigured destinations
+// and decides if the upload succeeded.
+func handleUpload(c *gin.Context, redisClient *redis.Client, destinations []uploadDestination, quarantine *minio2.QuarantineStore, fileReputation *reputation.Service, maxUploadBytes int64, succeeded func([]UploadResult) bool) {
 	start := time.Now()
+	connectionID := c.Param("connectionID")
 
 	// Get connection URL
-	url, err := getFQDNURL(c.Param("connectionID"), redisClient)
+	url, session, err := getFQDNURL(connectionID, redisClient)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -173,53 +171,61 @@ func HandlerUploadFileWithoutMinio(c *gin.Context, redisClient *redis.Client, k8
 		return
 	}
 
-	// Get uploaded file
-	fileHeader, err := c.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+	if c.Request.ContentLength > maxUploadBytes+multipartOverhead {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
 		return
 	}
 
-	const maxUploadBytes = int64(100 << 20) // 100 MiB; make configurable
-	if fileHeader.Size <= 0 || fileHeader.Size > maxUploadBytes {
-		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
+	// Find the file in the multipart body without reading it
+	file, err := uploadedFilePart(c.Request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
 		return
 	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logrus.Errorf("Failed to close uploaded file: %v", err)
+		}
+	}()
+	filename := file.FileName()
 
-	// Read file once into memory buffer
-	fileBuffer, err := readFileToBuffer(fileHeader)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file: " + err.Error()})
+	// Refuse empty files before anything is sent to the destinations
+	content := bufio.NewReaderSize(file, uploadChunkSize)
+	if _, err := content.Peek(1); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is empty"})
 		return
 	}
 
 	// Create context with timeout for all operations
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
 	defer cancel()
 
-	// Perform limited uploads (only to container and ClamAV)
-	results := make([]UploadResult, 2)
-
-	// Upload to Office/Browser container (primary function)
-	results[0] = uploadOfficeContainer(ctx, fileBuffer, url)
-
-	// Try ClamAV scan if URL is provided
-	if clamavurl != "" {
-		// Use a shorter timeout for ClamAV scan
-		scanCtx, scanCancel := context.WithTimeout(ctx, timeout*time.Second)
-		defer scanCancel()
+	results, err := deliverUpload(ctx, content, filename, connectionID, session, url, destinations, quarantine, fileReputation, maxUploadBytes)
+	respondUpload(c, start, connectionID, results, err, succeeded)
+}
 
-		results[1] = uploadToClamAV(scanCtx, fileBuffer, clamavurl, timeout*time.Second)
-	} else {
-		results[1] = UploadResult{
-			Service: "clamav",
-			Success: false,
-			Error:   "ClamAV service address not configured",
-		}
+// respondUpload answers an upload request with the results of delivering the upload or the
+// error that stopped it
+func respondUpload(c *gin.Context, start time.Time, connectionID string, results []UploadResult, err error, succeeded func([]UploadResult) bool) {
+	if errors.Is(err, errScannerUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "uploads must be scanned but virus scanning is not available"})
+		return
+	}
+	if errors.Is(err, errUploadTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file too large"})
+		return
+	}
+	if errors.Is(err, errUploadStaging) {
+		logrus.Errorf("Failed to stage upload for session %s: %v", connectionID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to stage file"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file: " + err.Error()})
+		return
 	}
 
-	// Determine overall success - only consider container upload for success
-	overallSuccess := results[0].Success
+	overallSuccess := succeeded(results)
 
 	statusCode := http.StatusOK
 	if !overallSuccess {
@@ -235,92 +241,95 @@ func HandlerUploadFileWithoutMinio(c *gin.Context, redisClient *redis.Client, k8
 	c.JSON(statusCode, response)
 }
 
-// performConcurrentUploads executes all uploads concurrently
-func performConcurrentUploads(ctx context.Context, fileBuffer *FileBuffer, officePodUrl string, minioClient *minio.Client, minioBucket string, clamavAddr string, timeout time.Duration) []UploadResult {
-	var wg sync.WaitGroup
-	results := make([]UploadResult, 3)
-	mutex := &sync.Mutex{}
-
-	// Upload to Docker container - this is the primary function
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		result := uploadOfficeContainer(ctx, fileBuffer, officePodUrl)
-		mutex.Lock()
-		results[0] = result
-		mutex.Unlock()
-	}()
-
-	// Upload to ClamAV - make this optional with its own timeout
-	if clamavAddr != "" {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			// Create a shorter context for ClamAV to prevent blocking other operations
-			scanCtx, scanCancel := context.WithTimeout(ctx, timeout*time.Second)
-			defer scanCancel()
-
-			result := uploadToClamAV(scanCtx, fileBuffer, clamavAddr, timeout*time.Second)
-			mutex.Lock()
-			results[1] = result
-			mutex.Unlock()
-		}()
-	} else {
-		results[1] = UploadResult{Service: "clamav", Success: false, Error: "ClamAV service address not configured"}
+// deliverUpload streams an upload to the sandbox of a session at url and the other
+// destinations, staging it first if the profile of the session asks to scan or disarm it or
+// to look up the verdict of its hash when fileReputation is set
+func deliverUpload(ctx context.Context, content io.Reader, filename, connectionID string, session *redis2.SessionData, url string, destinations []uploadDestination, quarantine *minio2.QuarantineStore, fileReputation *reputation.Service, maxUploadBytes int64) ([]UploadResult, error) {
+	destinations[uploadToSandbox] = func(ctx context.Context, file io.Reader, filename string) UploadResult {
+		return uploadOfficeContainer(ctx, file, filename, url)
 	}
 
-	// Upload to MinIO - make sure client and bucket are valid
-	if minioClient != nil && minioBucket != "" {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			result := uploadToMinIO(ctx, fileBuffer, minioClient, minioBucket)
-			mutex.Lock()
-			results[2] = result
-			mutex.Unlock()
-		}()
-	} else {
-		results[2] = UploadResult{Service: "minio", Success: false, Error: "MinIO client or bucket not configured"}
+	checks := uploadChecksFor(session.Profile, filename)
+	checks.reputation = fileReputation
+	if checks.staged() {
+		return performStagedUpload(ctx, content, filename, connectionID, destinations, quarantine, checks, maxUploadBytes)
 	}
-
-	wg.Wait()
-	return results
+	return performConcurrentUploads(ctx, content, filename, destinations, maxUploadBytes)
 }
 
-// uploadOfficeContainer uploads file to a office container
-func uploadOfficeContainer(ctx context.Context, fileBuffer *FileBuffer, url string) UploadResult {
-	// Create multipart form data
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
+// multipartOverhead is the room left for multipart headers and other fields when checking
+// the size of a request against the upload limit
+const multipartOverhead = 1 << 20
 
-	// Add file
-	part, err := writer.CreateFormFile("file", fileBuffer.Filename)
+// uploadedFilePart returns the part of a multipart request holding the uploaded file
+func uploadedFilePart(request *http.Request) (*multipart.Part, error) {
+	reader, err := request.MultipartReader()
 	if err != nil {
-		return UploadResult{Service: "file_upload", Success: false, Error: "failed to create form file: " + err.Error()}
+		return nil, err
 	}
-
-	if _, err := part.Write(fileBuffer.Data); err != nil {
-		return UploadResult{Service: "file_upload", Success: false, Error: "failed to write file data: " + err.Error()}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			return part, nil
+		}
+		_ = part.Close()
 	}
+}
+
+// performConcurrentUploads streams file to all configured destinations concurrently and
+// returns their results in order. Destinations that are not configured have an empty result.
+func performConcurrentUploads(ctx context.Context, file io.Reader, filename string, destinations []uploadDestination, maxUploadBytes int64) ([]UploadResult, error) {
+	var wg sync.WaitGroup
+	results := make([]UploadResult, len(destinations))
+	fanOut := newUploadFanOut(len(destinations))
+
+	for i, destination := range destinations {
+		branch := fanOut.branches[i]
+		if destination == nil {
+			branch.stop()
+			continue
+		}
 
-	// Add openNow field
-	if err := writer.WriteField("openNow", "true"); err != nil {
-		return UploadResult{Service: "file_upload", Success: false, Error: "failed to write openNow field: " + err.Error()}
+		wg.Add(1)
+		go func(i int, destination uploadDestination) {
+			defer wg.Done()
+			defer branch.stop()
+			results[i] = recordUpload(destination(ctx, branch, filename))
+		}(i, destination)
 	}
 
-	if err := writer.Close(); err != nil {
-		return UploadResult{Service: "file_upload", Success: false, Error: "failed to close writer: " + err.Error()}
+	err := fanOut.copyFrom(file, maxUploadBytes)
+	wg.Wait()
+	return results, err
+}
+
+// recordUpload counts the result of an upload to one of the services
+func recordUpload(result UploadResult) UploadResult {
+	outcome := metrics.ResultSuccess
+	if !result.Success {
+		outcome = metrics.ResultFailure
 	}
+	metrics.Uploads.WithLabelValues(result.Service, outcome).Inc()
+	return result
+}
+
+// uploadOfficeContainer streams a file to the upload endpoint of a sandbox
+func uploadOfficeContainer(ctx context.Context, file io.Reader, filename string, url string) UploadResult {
+	body, contentType := multipartBody("file", filename, file, map[string]string{"openNow": "true"})
 
 	// Create request
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &buf)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
 	if err != nil {
+		_ = body.Close()
 		return UploadResult{Service: "file_upload", Success: false, Error: "failed to create request: " + err.Error()}
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Content-Type", contentType)
 
-	// Send request
-	client := &http.Client{Timeout: 15 * time.Second}
+	// Send request, the upload context bounds the time it takes to stream the file
+	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		return UploadResult{Service: "file_upload", Success: false, Error: "request failed: " + err.Error()}
@@ -338,164 +347,67 @@ func uploadOfficeContainer(ctx context.Context, fileBuffer *FileBuffer, url stri
 	return UploadResult{Service: "file_upload", Success: false, Error: fmt.Sprintf("HTTP %d", resp.StatusCode)}
 }
 
-// ClamAVScanResult represents the structure of ClamAV scan result
-type ClamAVScanResult struct {
-	Success bool `json:"success"`
-	Data    struct {
-		Result []struct {
-			Name       string   `json:"name"`
-			IsInfected bool     `json:"is_infected"`
-			Viruses    []string `json:"viruses,omitempty"`
-		} `json:"result"`
-	} `json:"data"`
-}
-
-// uploadToClamAV uploads file to ClamAV for virus scanning
-func uploadToClamAV(ctx context.Context, fileBuffer *FileBuffer, clamavurl string, timeout time.Duration) UploadResult {
-	// Create multipart form payload
-	payload := &bytes.Buffer{}
-	writer := multipart.NewWriter(payload)
-
-	// Create form file part with "FILES" as the field name
-	part, err := writer.CreateFormFile("FILES", fileBuffer.Filename)
-	if err != nil {
-		return UploadResult{Service: "clamav", Success: false, Error: "failed to create form file: " + err.Error()}
-	}
-
-	// Write file data to the form part
-	if _, err := part.Write(fileBuffer.Data); err != nil {
-		return UploadResult{Service: "clamav", Success: false, Error: "failed to write file data: " + err.Error()}
-	}
-
-	// Close the writer
-	if err := writer.Close(); err != nil {
-		return UploadResult{Service: "clamav", Success: false, Error: "failed to close writer: " + err.Error()}
-	}
-
-	// Create scan URL
-	scanURL := clamavurl + "/api/v1/scan"
-
-	logrus.Infof("Sending scan request to ClamAV at %s", scanURL)
-
-	// Create request with context
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, scanURL, payload)
+// scanUpload streams a file to the malware scanner. The result is reported as the
+// "clamav" service in the shape of the ClamAV API response, which is what clients read.
+func scanUpload(ctx context.Context, file io.Reader, filename string, malwareScanner scanner.Scanner) UploadResult {
+	verdict, err := malwareScanner.Scan(ctx, file, filename)
 	if err != nil {
-		return UploadResult{Service: "clamav", Success: false, Error: "failed to create request: " + err.Error()}
-	}
-
-	// Set proper headers for multipart/form-data
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	// Send request with timeout - use a shorter timeout to prevent blocking other uploads
-	client := &http.Client{Timeout: timeout}
-	resp, err := client.Do(req)
-	if err != nil {
-		// Log the error but don't treat ClamAV failure as critical
-		logrus.Warnf("ClamAV scan failed: %v", err)
+		logrus.Warnf("Malware scan of %q with %s failed: %v", filename, malwareScanner.Name(), err)
 		return UploadResult{
 			Service: "clamav",
 			Success: false,
-			Error:   "scan request failed: " + err.Error(),
-			Data: map[string]interface{}{
-				"endpoint": scanURL,
-				"status":   "File upload succeeded but virus scan was skipped",
-			},
-		}
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logrus.Errorf("Failed to close ClamAV response body: %v", err)
-		}
-	}()
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return UploadResult{Service: "clamav", Success: false, Error: "failed to read response: " + err.Error()}
-	}
-
-	// Parse the ClamAV specific response format
-	var scanResult ClamAVScanResult
-	var responseData map[string]interface{}
-
-	// Try to parse as structured ClamAV response first
-	err = json.Unmarshal(body, &scanResult)
-	if err != nil {
-		// If parsing as ClamAV format fails, fallback to generic JSON parsing
-		if jsonErr := json.Unmarshal(body, &responseData); jsonErr != nil {
-			// If all JSON parsing fails, treat as plain text
-			responseStr := string(body)
-			responseData = map[string]interface{}{
-				"raw_response": responseStr,
-			}
-		}
-	} else {
-		// Successfully parsed as ClamAV response, convert to map for consistent return format
-		responseData = map[string]interface{}{
-			"success": scanResult.Success,
-			"data": map[string]interface{}{
-				"result": scanResult.Data.Result,
-			},
-		}
-
-		// Check if any viruses were detected
-		infected := false
-		detectedViruses := []string{}
-
-		for _, result := range scanResult.Data.Result {
-			if result.IsInfected {
-				infected = true
-				detectedViruses = append(detectedViruses, result.Viruses...)
-			}
-		}
-
-		responseData["infected"] = infected
-		if infected {
-			responseData["viruses"] = detectedViruses
-		}
-	}
-
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		return UploadResult{
-			Service: "clamav",
-			Success: true,
-			Data: map[string]interface{}{
-				"status_code": resp.StatusCode,
-				"response":    responseData,
-				"endpoint":    scanURL,
-			},
+			Error:   "scan failed: " + err.Error(),
+			Data:    map[string]interface{}{"scanner": malwareScanner.Name()},
 		}
 	}
 
 	return UploadResult{
 		Service: "clamav",
-		Success: false,
-		Error:   fmt.Sprintf("scan failed with HTTP %d", resp.StatusCode),
+		Success: true,
 		Data: map[string]interface{}{
-			"status_code": resp.StatusCode,
-			"response":    responseData,
-			"endpoint":    scanURL,
+			"scanner":  malwareScanner.Name(),
+			"response": scanResponse(filename, verdict),
+			"verdict":  verdict,
 		},
 	}
 }
 
-// uploadToMinIO uploads file to MinIO S3 bucket
-func uploadToMinIO(ctx context.Context, fileBuffer *FileBuffer, minioClient *minio.Client, bucket string) UploadResult {
-	// Generate object name with timestamp to avoid conflicts
-	objectName := fmt.Sprintf("%d_%s", time.Now().Unix(), fileBuffer.Filename)
-
-	// Upload to MinIO
-	info, err := minioClient.PutObject(
-		ctx,
-		bucket,
-		objectName,
-		bytes.NewReader(fileBuffer.Data),
-		fileBuffer.Size,
-		minio.PutObjectOptions{
-			ContentType: "application/octet-stream",
+// scanResponse returns a verdict in the shape of the ClamAV API response
+func scanResponse(filename string, verdict *scanner.Result) map[string]interface{} {
+	response := map[string]interface{}{
+		"success":  true,
+		"infected": verdict.Infected,
+		"data": map[string]interface{}{
+			"result": []map[string]interface{}{{
+				"name":        filename,
+				"is_infected": verdict.Infected,
+				"viruses":     verdict.Threats,
+			}},
 		},
-	)
+	}
+	if verdict.Infected {
+		response["viruses"] = verdict.Threats
+	}
+	return response
+}
+
+// scanVerdict returns the verdict of a scan, failing unless the scan completed
+func scanVerdict(result UploadResult) (*scanner.Result, error) {
+	if !result.Success {
+		return nil, fmt.Errorf("virus scan failed: %s", result.Error)
+	}
+	data, _ := result.Data.(map[string]interface{})
+	verdict, ok := data["verdict"].(*scanner.Result)
+	if !ok {
+		return nil, fmt.Errorf("virus scan returned no verdict")
+	}
+	return verdict, nil
+}
 
+// storeUpload streams a file to the library of its owner, tagged with the session it was
+// uploaded to. Its scan verdict is pending until tagStoredUpload sets it.
+func storeUpload(ctx context.Context, file io.Reader, filename string, library *minio2.FileLibrary, owner, connectionID string) UploadResult {
+	stored, err := library.Put(ctx, owner, connectionID, filename, file)
 	if err != nil {
 		return UploadResult{Service: "minio", Success: false, Error: "upload failed: " + err.Error()}
 	}
@@ -504,10 +416,11 @@ func uploadToMinIO(ctx context.Context, fileBuffer *FileBuffer, minioClient *min
 		Service: "minio",
 		Success: true,
 		Data: map[string]interface{}{
-			"bucket":      bucket,
-			"object_name": objectName,
-			"size":        info.Size,
-			"etag":        info.ETag,
+			"bucket":       library.Bucket(),
+			"object_name":  stored.Object,
+			"file_id":      stored.ID,
+			"size":         stored.Size,
+			"scan_verdict": stored.ScanVerdict,
 		},
 	}
 }
@@ -6,11 +6,14 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	sqlc "github.com/browsersec/KubeBrowse/db/sqlc"
@@ -18,9 +21,14 @@ import (
 	"github.com/browsersec/KubeBrowse/internal/cleanup"
 	"github.com/browsersec/KubeBrowse/internal/email"
 	guac2 "github.com/browsersec/KubeBrowse/internal/guac"
+	"github.com/browsersec/KubeBrowse/internal/history"
 	"github.com/browsersec/KubeBrowse/internal/k8s"
 	"github.com/browsersec/KubeBrowse/internal/logging"
+	"github.com/browsersec/KubeBrowse/internal/metrics"
 	"github.com/browsersec/KubeBrowse/internal/middleware"
+	"github.com/browsersec/KubeBrowse/internal/policy"
+	"github.com/browsersec/KubeBrowse/internal/reputation"
+	"github.com/browsersec/KubeBrowse/internal/scanner"
 	"github.com/browsersec/KubeBrowse/internal/tracing"
 
 	"github.com/browsersec/KubeBrowse/api"
@@ -34,6 +42,7 @@ import (
 	"github.com/sirupsen/logrus"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -58,10 +67,12 @@ var queries *sqlc.Queries
 var emailService *email.Service
 
 type MinioConfig struct {
-	bucketName string
-	minioAddr  string
-	accessKey  string
-	secretKey  string
+	bucketName           string
+	recordingBucketName  string
+	quarantineBucketName string
+	minioAddr            string
+	accessKey            string
+	secretKey            string
 }
 
 // GinHandlerAdapter adapts http.Handler to gin.HandlerFunc
@@ -147,10 +158,12 @@ func main() {
 	auth.InitializeGoth()
 
 	minioConfig := &MinioConfig{
-		bucketName: os.Getenv("MINIO_BUCKET"),
-		minioAddr:  minioAddr,
-		accessKey:  os.Getenv("MINIO_ACCESS_KEY"),
-		secretKey:  os.Getenv("MINIO_SECRET_KEY"),
+		bucketName:           os.Getenv("MINIO_BUCKET"),
+		recordingBucketName:  os.Getenv("MINIO_RECORDING_BUCKET"),
+		quarantineBucketName: os.Getenv("MINIO_QUARANTINE_BUCKET"),
+		minioAddr:            minioAddr,
+		accessKey:            os.Getenv("MINIO_ACCESS_KEY"),
+		secretKey:            os.Getenv("MINIO_SECRET_KEY"),
 	}
 
 	// Use default bucket name if not set in environment
@@ -159,6 +172,16 @@ func main() {
 		logrus.Info("Using default bucket name: kubebrowse-files")
 	}
 
+	if minioConfig.recordingBucketName == "" {
+		minioConfig.recordingBucketName = "kubebrowse-recordings"
+		logrus.Info("Using default recording bucket name: kubebrowse-recordings")
+	}
+
+	if minioConfig.quarantineBucketName == "" {
+		minioConfig.quarantineBucketName = "kubebrowse-quarantine"
+		logrus.Info("Using default quarantine bucket name: kubebrowse-quarantine")
+	}
+
 	if minioConfig.accessKey == "" || minioConfig.secretKey == "" {
 		logrus.Warn("MINIO_ACCESS_KEY and/or MINIO_SECRET_KEY environment variables not set, MinIO uploads will fail")
 	}
@@ -171,6 +194,11 @@ func main() {
 
 	// Initialize MinIO client with more robust error handling
 	var minioClient *minio.MinioClient
+	var recordingStore *minio.RecordingStore
+	var downloadStore *minio.DownloadStore
+	var quarantineStore *minio.QuarantineStore
+	var resumableStore *minio.ResumableStore
+	var fileLibrary *minio.FileLibrary
 	if minioConfig.accessKey != "" && minioConfig.secretKey != "" {
 		var err error
 		minioClient, err = minio.NewMinioClient(minioConfig.minioAddr, minioConfig.accessKey, minioConfig.secretKey, false)
@@ -185,7 +213,66 @@ func main() {
 				logrus.Warn("Continuing without MinIO bucket creation")
 			} else {
 				logrus.Infof("Successfully connected to MinIO with bucket: %s", minioConfig.bucketName)
+				// Files sent from sandboxes wait in the files bucket until they are scanned
+				downloadStore = minio.NewDownloadStore(minioClient, minioConfig.bucketName)
+				// Resumable uploads are assembled there before they are delivered
+				resumableStore = minio.NewResumableStore(minioClient, minioConfig.bucketName)
+				// Uploads are kept there under a prefix of their owner
+				fileLibrary = minio.NewFileLibrary(minioClient, minioConfig.bucketName)
+				retentionDays := 30
+				if days := os.Getenv("MINIO_FILE_RETENTION_DAYS"); days != "" {
+					if parsed, err := strconv.Atoi(days); err == nil && parsed >= 0 {
+						retentionDays = parsed
+					} else {
+						logrus.Warnf("Invalid MINIO_FILE_RETENTION_DAYS %q, keeping files for %d days", days, retentionDays)
+					}
+				}
+				if err := fileLibrary.ApplyRetention(context.Background(), retentionDays); err != nil {
+					logrus.Warnf("Failed to apply the file retention policy: %v", err)
+				} else if retentionDays > 0 {
+					logrus.Infof("Uploaded files are kept for %d days", retentionDays)
+				}
+			}
+
+			// Session recordings are kept in their own bucket
+			err = minioClient.CreateBucket(context.Background(), minioConfig.recordingBucketName, "us-east-1")
+			if err != nil {
+				logrus.Warnf("Failed to create MinIO recording bucket: %v", err)
+				logrus.Warn("Session recording will not work")
+			} else {
+				recordingStore = minio.NewRecordingStore(minioClient, minioConfig.recordingBucketName)
+				logrus.Infof("Session recordings will be stored in bucket: %s", minioConfig.recordingBucketName)
 			}
+
+			// Infected uploads of strictly scanned sandboxes are kept apart from all other files
+			err = minioClient.CreateBucket(context.Background(), minioConfig.quarantineBucketName, "us-east-1")
+			if err != nil {
+				logrus.Warnf("Failed to create MinIO quarantine bucket: %v", err)
+				logrus.Warn("Infected uploads will be refused without being quarantined")
+			} else {
+				quarantineStore = minio.NewQuarantineStore(minioClient, minioConfig.quarantineBucketName)
+				logrus.Infof("Infected uploads will be quarantined in bucket: %s", minioConfig.quarantineBucketName)
+			}
+		}
+	}
+
+	// Largest file users may upload into a sandbox
+	maxUploadBytes := int64(100 << 20)
+	if size := os.Getenv("MAX_UPLOAD_SIZE_MB"); size != "" {
+		if mb, err := strconv.ParseInt(size, 10, 64); err == nil && mb > 0 {
+			maxUploadBytes = mb << 20
+		} else {
+			logrus.Warnf("Invalid MAX_UPLOAD_SIZE_MB %q, using %d MiB", size, maxUploadBytes>>20)
+		}
+	}
+
+	// Largest file users may upload into a sandbox in several requests
+	maxResumableUploadBytes := int64(2048 << 20)
+	if size := os.Getenv("MAX_RESUMABLE_UPLOAD_SIZE_MB"); size != "" {
+		if mb, err := strconv.ParseInt(size, 10, 64); err == nil && mb > 0 {
+			maxResumableUploadBytes = mb << 20
+		} else {
+			logrus.Warnf("Invalid MAX_RESUMABLE_UPLOAD_SIZE_MB %q, using %d MiB", size, maxResumableUploadBytes>>20)
 		}
 	}
 
@@ -195,6 +282,21 @@ func main() {
 		logrus.Infof("Using ClamAV address from environment: %s", clamavAddr)
 	}
 
+	// Malware scanners, the ClamAV API unless others are listed
+	scannerAddrs := os.Getenv("MALWARE_SCANNERS")
+	if scannerAddrs == "" {
+		scannerAddrs = clamavAddr
+	}
+	scanPolicy, err := scanner.ParsePolicy(os.Getenv("MALWARE_SCAN_POLICY"))
+	if err != nil {
+		logrus.Fatalf("Invalid MALWARE_SCAN_POLICY: %v", err)
+	}
+	malwareScanner, err := scanner.NewFromList(scannerAddrs, scanPolicy, 10*time.Second)
+	if err != nil {
+		logrus.Fatalf("Invalid malware scanner configuration: %v", err)
+	}
+	logrus.Infof("Scanning files with %s (%s)", malwareScanner.Name(), scanPolicy)
+
 	// Parse command line flags
 	helpFlag := flag.Bool("h", false, "Display help information")
 	flag.Parse()
@@ -240,7 +342,23 @@ func main() {
 		k8sNamespace = os.Getenv("KUBERNETES_NAMESPACE")
 	}
 
+	// Load sandbox profiles, usually mounted from the sandbox-profiles ConfigMap
+	if profilesFile := os.Getenv("SANDBOX_PROFILES_FILE"); profilesFile != "" {
+		if err := k8s.LoadSandboxProfiles(profilesFile); err != nil {
+			logrus.Fatalf("Failed to load sandbox profiles: %v", err)
+		}
+		stopProfilesWatch := make(chan struct{})
+		defer close(stopProfilesWatch)
+		go k8s.WatchSandboxProfiles(profilesFile, 30*time.Second, stopProfilesWatch)
+	}
+
+	// Disconnect viewers of share links revoked through other replicas
+	stopShareWatch := make(chan struct{})
+	defer close(stopShareWatch)
+	go api.WatchShareRevocations(redisClient, stopShareWatch)
+
 	tunnelStore = guac2.NewActiveTunnelStore()
+	metrics.RegisterActiveTunnels(tunnelStore.Count)
 
 	// Initialize Kubernetes client with fallback for local development
 	config, err := rest.InClusterConfig()
@@ -288,6 +406,18 @@ func main() {
 	// Initialize Gin
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
+	// Client addresses, which password resets are rate limited by, are only read from
+	// X-Forwarded-For when the request comes through one of the comma separated
+	// TRUSTED_PROXIES, otherwise they are the remote address of the connection
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		logrus.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 	router.Use(middleware.GinLogger(), gin.Recovery(), middleware.TracingMiddleware("browser-sandbox"))
 
 	// Configure Swagger
@@ -305,13 +435,22 @@ func main() {
 		cfg.AllowOrigins = []string{"http://localhost:5173"}
 	}
 	cfg.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
-	cfg.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	cfg.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization",
+		"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata"}
+	cfg.ExposeHeaders = []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+		"Upload-Offset", "Upload-Length"}
 	router.Use(cors.New(cfg))
 	router.Use(gin.Recovery())
 	router.Use(gin.Logger())
 
+	// History of sandbox sessions, recorded at each transition of their lifecycle
+	var sessionHistory *history.Service
+	if dbConn != nil && queries != nil {
+		sessionHistory = history.NewService(queries)
+	}
+
 	doConnectWrapper := func(request *http.Request) (guac2.Tunnel, error) {
-		return api.DemoDoConnect(request, tunnelStore, redisClient, guacdAddr, cleanupService)
+		return api.DemoDoConnect(request, tunnelStore, redisClient, guacdAddr, cleanupService, recordingStore, downloadStore, malwareScanner, sessionHistory)
 	}
 
 	servlet := guac2.NewServer(doConnectWrapper)
@@ -319,10 +458,71 @@ func main() {
 
 	if k8sClient != nil {
 		cleanupService = cleanup.NewSessionCleanupService(redisClient, k8sClient, "browser-sandbox", tunnelStore, servlet)
+		cleanupService.SetHistory(sessionHistory)
 		cleanupService.Start()
 		defer cleanupService.Stop()
 	}
 
+	// Keep pre-started sandbox pods ready so deploys do not wait for pod startup
+	var warmPool *k8s.WarmPool
+	if sizes := k8s.WarmPoolSizesFromEnv(); k8sClient != nil && len(sizes) > 0 {
+		warmPool = k8s.NewWarmPool(k8sClient, k8sNamespace, sizes)
+		warmPool.Start()
+		defer warmPool.Stop()
+	}
+
+	// Manage sessions through BrowserSession resources when the CRD is installed
+	var browserSessions *k8s.BrowserSessionController
+	if k8sClient != nil {
+		browserSessions, err = k8s.NewBrowserSessionController(config, k8sClient, k8sNamespace, warmPool)
+		if err != nil {
+			logrus.Warnf("BrowserSession controller disabled: %v", err)
+			browserSessions = nil
+		} else {
+			browserSessions.SetHistory(sessionHistory)
+			browserSessions.Start()
+			defer browserSessions.Stop()
+			cleanupService.SetBrowserSessions(browserSessions)
+		}
+	}
+
+	// URL filtering policies of sandbox profiles, applied by the egress proxy
+	var policyService *policy.Service
+	if dbConn != nil && queries != nil {
+		policyService = policy.NewService(queries, dbConn)
+		policyService.Start(30 * time.Second)
+		defer policyService.Stop()
+	}
+
+	// Verdicts of uploaded file hashes, so that known files are not scanned again
+	var fileReputation *reputation.Service
+	if dbConn != nil && queries != nil && os.Getenv("FILE_REPUTATION_ENABLED") != "false" {
+		maxScanAge := 7 * 24 * time.Hour
+		if age := os.Getenv("FILE_REPUTATION_MAX_AGE"); age != "" {
+			if d, err := time.ParseDuration(age); err == nil && d > 0 {
+				maxScanAge = d
+			} else {
+				logrus.Warnf("Invalid FILE_REPUTATION_MAX_AGE %q, using %v", age, maxScanAge)
+			}
+		}
+		fileReputation = reputation.NewService(queries, redisClient, time.Hour, maxScanAge)
+		logrus.Infof("Clean scan verdicts of uploaded files are trusted for %v", maxScanAge)
+	}
+
+	// Egress proxy for sandboxes with URL filtering, reached at EGRESS_PROXY_URL
+	if proxyAddr := os.Getenv("EGRESS_PROXY_ADDR"); proxyAddr != "" && k8sClient != nil {
+		if policyService == nil {
+			logrus.Warn("Egress proxy running without a database - URL policies will not be applied")
+		}
+		egressProxy := policy.NewProxy(policyService, policy.NewPodResolver(k8sClient, k8sNamespace))
+		go func() {
+			logrus.Infof("Egress proxy listening on %s", proxyAddr)
+			if err := http.ListenAndServe(proxyAddr, egressProxy); err != nil {
+				logrus.Errorf("Egress proxy stopped: %v", err)
+			}
+		}()
+	}
+
 	wsServer.OnDisconnect = func(connectionID string, req *http.Request, tunnel guac2.Tunnel) {
 		logrus.Debugf("Websocket disconnected, removing tunnel: %s", connectionID)
 
@@ -360,6 +560,7 @@ func main() {
 		// Increment disconnection count
 		sessiondata.DisconnectionCount++
 		logrus.Infof("Session %s disconnected %d times", uuidParam, sessiondata.DisconnectionCount)
+		sessionHistory.Disconnected(uuidParam)
 
 		// Update session data with preserved TTL (not reset to 10 minutes)
 		err = redis2.SetSessionDataWithContext(ctx, redisClient, uuidParam, sessiondata, currentTTL)
@@ -407,8 +608,18 @@ func main() {
 			if exists > 0 {
 				// No reconnection happened during the grace period, delete the pod
 				logrus.Infof("No reconnection for session %s after grace period, terminating pod %s", uuidParam, podName)
+				sessionHistory.Ended(uuidParam, history.EndDisconnect)
 				if k8sClient != nil {
-					err = k8s.DeletePodGrace(k8sClient, k8sNamespace, podName)
+					// Delete the BrowserSession, which deletes its pod, or the pod if the session has none
+					if browserSessions != nil {
+						err = browserSessions.Delete(bgCtx, uuidParam)
+						if apierrors.IsNotFound(err) {
+							err = k8s.DeletePodGrace(k8sClient, k8sNamespace, podName)
+						}
+					} else {
+						err = k8s.DeletePodGrace(k8sClient, k8sNamespace, podName)
+					}
+					metrics.CleanupActions.WithLabelValues("disconnected_session", metrics.Result(err)).Inc()
 					tunnelStore.Delete(connectionID, req, tunnel)
 					if err != nil {
 						logrus.Errorf("Failed to delete pod %s: %v", podName, err)
@@ -434,107 +645,236 @@ func main() {
 	servletShared := guac2.NewServer(doSharedConnectWrapper)
 	wsServerShared := guac2.NewWebsocketServer(doSharedConnectWrapper)
 
+	// Initialize authentication service and handlers
+	var authService *auth.Service
+	var authHandler *auth.Handler
+	if dbConn != nil && queries != nil {
+		authService = auth.NewService(queries, dbConn)
+		authHandler = auth.NewHandlerWithRedis(authService, redisClient)
+		if adminEmails := os.Getenv("ADMIN_EMAILS"); adminEmails != "" {
+			authService.SetAdminEmails(strings.Split(adminEmails, ","))
+		}
+
+		// Start background cleanup of expired database sessions
+		go func() {
+			ticker := time.NewTicker(1 * time.Hour) // Clean up every hour
+			defer ticker.Stop()
+
+			for range ticker.C {
+				if err := authService.CleanupExpiredSessions(); err != nil {
+					logrus.Warnf("Failed to cleanup expired sessions: %v", err)
+				} else {
+					logrus.Debug("Successfully cleaned up expired sessions")
+				}
+			}
+		}()
+	}
+
+	// Routes are gated by the permissions of the role of the signed in user
+	requirePermission := func(permission auth.Permission) gin.HandlerFunc {
+		if authService == nil {
+			return func(c *gin.Context) { c.Next() }
+		}
+		return auth.RequirePermission(authService, permission)
+	}
+	if authService == nil {
+		logrus.Warn("Access control disabled - database connection required, session and upload routes are open to everyone")
+	}
+
+	// Sessions and their recordings are only reachable by the users who deployed them and
+	// operators, guests join sessions through share links
+	requireSessionOwner := func(c *gin.Context) { c.Next() }
+	requireRecordingAccess := func(c *gin.Context) { c.Next() }
+	if authService != nil {
+		requireSessionOwner = api.RequireSessionOwner(redisClient)
+		requireRecordingAccess = api.RequireRecordingAccess(recordingStore)
+	}
+
 	// Setup routes using Gin
 	// Regular tunnel routes
-	router.Any("/tunnel", GinHandlerAdapter(servlet))
-	router.Any("/tunnel/*path", GinHandlerAdapter(servlet))
-	router.Any("/websocket-tunnel", GinHandlerAdapter(wsServer))
+	router.Any("/tunnel", requirePermission(auth.PermSessionUse), requireSessionOwner, GinHandlerAdapter(servlet))
+	router.Any("/tunnel/*path", requirePermission(auth.PermSessionUse), requireSessionOwner, GinHandlerAdapter(servlet))
+	router.Any("/websocket-tunnel", requirePermission(auth.PermSessionUse), requireSessionOwner, GinHandlerAdapter(wsServer))
 
-	// Shared connection routes - use a different base path to avoid conflicts
+	// Shared connection routes - use a different base path to avoid conflicts. Guests joining
+	// through a share link are authorized by its token and need no account.
 	router.Any("/shared-tunnel", GinHandlerAdapter(servletShared))
 	router.Any("/shared-tunnel/*path", GinHandlerAdapter(servletShared))
 	router.GET("/websocket-tunnel/share", GinHandlerAdapter(wsServerShared))
 
+	// Recorded session playback replays a stored recording instead of connecting to guacd
+	wsServerPlayback := guac2.NewPlaybackWebsocketServer(func(request *http.Request) (io.ReadCloser, error) {
+		return api.OpenRecording(request, recordingStore)
+	})
+	router.GET("/websocket-tunnel/playback", requirePermission(auth.PermRecordingView), requireRecordingAccess, GinHandlerAdapter(wsServerPlayback))
+
 	// Session management handler
-	router.GET("/sessions/", func(c *gin.Context) {
+	router.GET("/sessions/", requirePermission(auth.PermSessionView), func(c *gin.Context) {
 		api.HandlerSession(c, tunnelStore)
 
 	})
 
+	// Prometheus metrics
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Warm pool statistics
+	router.GET("/pool/stats", requirePermission(auth.PermSessionView), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"pools": warmPool.Stats()})
+	})
+
 	// Add test routes for pod creation
 	testRoutes := router.Group("/test")
 	{
 		// New route for deploying and connecting to office pod with RDP credentials
-		testRoutes.POST("/deploy-office", func(c *gin.Context) {
-			api.DeployOffice(c, k8sClient, k8sNamespace, redisClient, tunnelStore)
+		testRoutes.POST("/deploy-office", requirePermission(auth.PermSessionCreate), func(c *gin.Context) {
+			api.DeployOffice(c, k8sClient, k8sNamespace, redisClient, tunnelStore, warmPool, browserSessions, sessionHistory)
 		})
 
 		// New route for deploying and connecting to browser pod with RDP credentials
-		testRoutes.POST("/deploy-browser", func(c *gin.Context) {
-			api.DeployBrowser(c, k8sClient, k8sNamespace, redisClient, tunnelStore)
+		testRoutes.POST("/deploy-browser", requirePermission(auth.PermSessionCreate), func(c *gin.Context) {
+			api.DeployBrowser(c, k8sClient, k8sNamespace, redisClient, tunnelStore, warmPool, browserSessions, sessionHistory)
 		})
 
 		// New endpoint to handle websocket connections using stored parameters
-		testRoutes.GET("/connect/:connectionID", func(c *gin.Context) {
+		testRoutes.GET("/connect/:connectionID", requirePermission(auth.PermSessionUse), requireSessionOwner, func(c *gin.Context) {
 			api.HandlerConnectionID(c, tunnelStore, redisClient)
 		})
 
 		// Share session route
-		testRoutes.GET("/share/:connectionID", func(c *gin.Context) {
+		testRoutes.GET("/share/:connectionID", requirePermission(auth.PermSessionShare), requireSessionOwner, func(c *gin.Context) {
 			api.HandlerShareSession(c, tunnelStore, redisClient)
 		})
 
 		// Test route to create a browser sandbox pod
-		testRoutes.POST("/browser-pod", func(c *gin.Context) {
-			api.HandlerBrowserPod(c, tunnelStore, k8sClient, k8sNamespace)
+		testRoutes.POST("/browser-pod", requirePermission(auth.PermSessionManage), func(c *gin.Context) {
+			api.HandlerBrowserPod(c, tunnelStore, k8sClient, k8sNamespace, browserSessions)
 		})
 
 		// Test route to create an office sandbox pod
-		testRoutes.POST("/office-pod", func(c *gin.Context) {
-			api.HandlerOfficePod(c, tunnelStore, k8sClient, k8sNamespace)
+		testRoutes.POST("/office-pod", requirePermission(auth.PermSessionManage), func(c *gin.Context) {
+			api.HandlerOfficePod(c, tunnelStore, k8sClient, k8sNamespace, browserSessions)
 		})
 	}
 
 	sessionRoutes := router.Group("/sessions")
+	// Uploads of signed in users are kept in their file library
+	if authService != nil {
+		sessionRoutes.Use(auth.OptionalAuthMiddleware(authService))
+	}
 	{
 
 		// Endpoint to stop a specific WebSocket session
-		sessionRoutes.DELETE("/:connectionID/stop", func(c *gin.Context) {
-			api.HandlerStopWSSession(c, redisClient, k8sClient, servlet)
+		sessionRoutes.DELETE("/:connectionID/stop", requirePermission(auth.PermSessionUse), requireSessionOwner, func(c *gin.Context) {
+			api.HandlerStopWSSession(c, redisClient, k8sClient, servlet, browserSessions, sessionHistory)
 		})
 
 		// Endpoint to extend session timeout
-		sessionRoutes.POST("/:connectionID/extend", func(c *gin.Context) {
-			api.HandlerExtendSession(c, redisClient, cleanupService)
+		sessionRoutes.POST("/:connectionID/extend", requirePermission(auth.PermSessionUse), requireSessionOwner, func(c *gin.Context) {
+			api.HandlerExtendSession(c, redisClient, cleanupService, browserSessions, sessionHistory)
 		})
 
 		// Endpoint to get session time remaining
-		sessionRoutes.GET("/:connectionID/time-left", func(c *gin.Context) {
+		sessionRoutes.GET("/:connectionID/time-left", requirePermission(auth.PermSessionUse), requireSessionOwner, func(c *gin.Context) {
 			api.HandlerGetSessionTimeLeft(c, redisClient)
 		})
 
+		// Share links to a session, joined through /websocket-tunnel/share?token=
+		sessionRoutes.POST("/:connectionID/shares", requirePermission(auth.PermSessionShare), requireSessionOwner, func(c *gin.Context) {
+			api.HandlerShareSession(c, tunnelStore, redisClient)
+		})
+		sessionRoutes.GET("/:connectionID/shares", requirePermission(auth.PermSessionShare), requireSessionOwner, func(c *gin.Context) {
+			api.HandlerListShares(c, redisClient)
+		})
+		sessionRoutes.DELETE("/:connectionID/shares/:token", requirePermission(auth.PermSessionShare), requireSessionOwner, func(c *gin.Context) {
+			api.HandlerRevokeShare(c, redisClient)
+		})
+
+		// Endpoint to get the recording of a session for playback
+		sessionRoutes.GET("/:connectionID/recording", requirePermission(auth.PermRecordingView), requireRecordingAccess, func(c *gin.Context) {
+			api.HandlerGetRecording(c, recordingStore)
+		})
+
+		// Files sent from the sandbox, released through short-lived links once scanned
+		sessionRoutes.GET("/:connectionID/downloads", requirePermission(auth.PermSessionUse), requireSessionOwner, func(c *gin.Context) {
+			api.HandlerListDownloads(c, redisClient)
+		})
+		sessionRoutes.POST("/:connectionID/downloads/:downloadID/link", requirePermission(auth.PermSessionUse), requireSessionOwner, func(c *gin.Context) {
+			api.HandlerCreateDownloadLink(c, redisClient)
+		})
+
 		// Tunnel a Pod Rest API to Upload a file to a pod
-		sessionRoutes.POST("/:connectionID/upload", func(c *gin.Context) {
+		sessionRoutes.POST("/:connectionID/upload", requirePermission(auth.PermFileUpload), requireSessionOwner, func(c *gin.Context) {
 			// Check if minioClient is nil before passing it to the handler
 			if minioClient == nil {
-				api.HandlerUploadFileWithoutMinio(c, redisClient, k8sClient, clamavAddr, 10)
+				api.HandlerUploadFileWithoutMinio(c, redisClient, k8sClient, malwareScanner, fileReputation, maxUploadBytes)
 			} else {
-				api.HandlerUploadFile(c, redisClient, k8sClient, minioClient.Client, minioConfig.bucketName, clamavAddr, 10)
+				api.HandlerUploadFile(c, redisClient, k8sClient, fileLibrary, quarantineStore, malwareScanner, fileReputation, maxUploadBytes)
 			}
 		})
-	}
-
-	// Initialize authentication service and handlers
-	var authService *auth.Service
-	var authHandler *auth.Handler
-	if dbConn != nil && queries != nil {
-		authService = auth.NewService(queries, dbConn)
-		authHandler = auth.NewHandlerWithRedis(authService, redisClient)
 
-		// Start background cleanup of expired database sessions
-		go func() {
-			ticker := time.NewTicker(1 * time.Hour) // Clean up every hour
-			defer ticker.Stop()
+		// Resumable uploads through the tus protocol, for files too large for a single request
+		resumableUploads := func(c *gin.Context) *api.ResumableUploads {
+			if resumableStore == nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "resumable uploads require MinIO storage"})
+				return nil
+			}
+			return &api.ResumableUploads{
+				RedisClient:    redisClient,
+				Store:          resumableStore,
+				Library:        fileLibrary,
+				Quarantine:     quarantineStore,
+				MalwareScanner: malwareScanner,
+				Reputation:     fileReputation,
+				MaxUploadBytes: maxResumableUploadBytes,
+			}
+		}
+		sessionRoutes.OPTIONS("/:connectionID/uploads", func(c *gin.Context) {
+			if uploads := resumableUploads(c); uploads != nil {
+				api.HandlerResumableUploadOptions(c, uploads)
+			}
+		})
+		sessionRoutes.POST("/:connectionID/uploads", requirePermission(auth.PermFileUpload), requireSessionOwner, func(c *gin.Context) {
+			if uploads := resumableUploads(c); uploads != nil {
+				api.HandlerCreateResumableUpload(c, uploads)
+			}
+		})
+		sessionRoutes.HEAD("/:connectionID/uploads/:uploadID", requirePermission(auth.PermFileUpload), requireSessionOwner, func(c *gin.Context) {
+			if uploads := resumableUploads(c); uploads != nil {
+				api.HandlerResumableUploadOffset(c, uploads)
+			}
+		})
+		sessionRoutes.PATCH("/:connectionID/uploads/:uploadID", requirePermission(auth.PermFileUpload), requireSessionOwner, func(c *gin.Context) {
+			if uploads := resumableUploads(c); uploads != nil {
+				api.HandlerPatchResumableUpload(c, uploads)
+			}
+		})
+		sessionRoutes.GET("/:connectionID/uploads/:uploadID", requirePermission(auth.PermFileUpload), requireSessionOwner, func(c *gin.Context) {
+			if uploads := resumableUploads(c); uploads != nil {
+				api.HandlerGetResumableUpload(c, uploads)
+			}
+		})
+		sessionRoutes.DELETE("/:connectionID/uploads/:uploadID", requirePermission(auth.PermFileUpload), requireSessionOwner, func(c *gin.Context) {
+			if uploads := resumableUploads(c); uploads != nil {
+				api.HandlerDeleteResumableUpload(c, uploads)
+			}
+		})
 
-			for range ticker.C {
-				if err := authService.CleanupExpiredSessions(); err != nil {
-					logrus.Warnf("Failed to cleanup expired sessions: %v", err)
-				} else {
-					logrus.Debug("Successfully cleaned up expired sessions")
-				}
+		// Send a file from the library of the user without uploading it again
+		sessionRoutes.POST("/:connectionID/files/:fileID", requirePermission(auth.PermFileUpload), requireSessionOwner, func(c *gin.Context) {
+			if fileLibrary == nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the file library requires MinIO storage"})
+				return
 			}
-		}()
+			api.HandlerSendLibraryFile(c, redisClient, fileLibrary, quarantineStore, malwareScanner, fileReputation)
+		})
+	}
+
+	// Short-lived download links created through /sessions/:connectionID/downloads
+	router.GET("/downloads/:token", func(c *gin.Context) {
+		api.HandlerDownload(c, redisClient, downloadStore)
+	})
 
+	if authService != nil {
 		// Add authentication routes
 		authRoutes := router.Group("/auth")
 		{
@@ -548,6 +888,10 @@ func main() {
 			authRoutes.POST("/verify-email", authHandler.VerifyEmail)
 			authRoutes.POST("/resend-verification", authHandler.ResendVerificationEmail)
 
+			// Password reset
+			authRoutes.POST("/forgot-password", authHandler.ForgotPassword)
+			authRoutes.POST("/reset-password", authHandler.ResetPassword)
+
 			// OAuth authentication
 			authRoutes.GET("/oauth/:provider", authHandler.BeginOAuth)
 			authRoutes.GET("/oauth/:provider/callback", authHandler.CallbackOAuth)
@@ -571,6 +915,97 @@ func main() {
 			authRoutes.GET("/profile", auth.AuthMiddleware(authService), authHandler.GetUserProfile)
 			authRoutes.PUT("/profile", auth.AuthMiddleware(authService), authHandler.UpdateProfile)
 			authRoutes.PUT("/password", auth.AuthMiddleware(authService), authHandler.UpdatePassword)
+
+			// Two-factor authentication, the challenge routes complete logins that need a code
+			authRoutes.POST("/mfa/challenge", authHandler.CompleteMFALogin)
+			authRoutes.POST("/mfa/challenge/enroll", authHandler.BeginChallengeEnrollment)
+			authRoutes.GET("/mfa", auth.AuthMiddleware(authService), authHandler.GetMFAStatus)
+			authRoutes.POST("/mfa/enroll", auth.AuthMiddleware(authService), authHandler.BeginMFAEnrollment)
+			authRoutes.POST("/mfa/enroll/verify", auth.AuthMiddleware(authService), authHandler.ConfirmMFAEnrollment)
+			authRoutes.POST("/mfa/recovery-codes", auth.AuthMiddleware(authService), authHandler.RegenerateRecoveryCodes)
+			authRoutes.POST("/mfa/disable", auth.AuthMiddleware(authService), authHandler.DisableMFA)
+		}
+
+		// Users, their roles and the roles that require two-factor authentication
+		adminRoutes := router.Group("/admin", requirePermission(auth.PermUserManage))
+		{
+			adminRoutes.GET("/users", authHandler.ListUsers)
+			adminRoutes.PUT("/users/:id/role", authHandler.UpdateUserRole)
+			adminRoutes.GET("/mfa/roles", authHandler.ListMFARoles)
+			adminRoutes.PUT("/mfa/roles/:role", authHandler.UpdateMFARole)
+		}
+
+		// Sandbox sessions of the signed in user
+		meRoutes := router.Group("/me", auth.AuthMiddleware(authService))
+		{
+			meRoutes.GET("/sessions", func(c *gin.Context) {
+				api.HandlerListSessionHistory(c, sessionHistory)
+			})
+		}
+
+		// URL filtering policies, domain categories and the blocked request log
+		policyRoutes := router.Group("/policies", auth.AuthMiddleware(authService))
+		{
+			policyRoutes.GET("", requirePermission(auth.PermAuditRead), func(c *gin.Context) {
+				api.HandlerListPolicies(c, policyService)
+			})
+			policyRoutes.GET("/categories", requirePermission(auth.PermAuditRead), func(c *gin.Context) {
+				api.HandlerListDomainCategories(c, policyService)
+			})
+			policyRoutes.PUT("/categories/:domain", requirePermission(auth.PermPolicyManage), func(c *gin.Context) {
+				api.HandlerPutDomainCategories(c, policyService)
+			})
+			policyRoutes.DELETE("/categories/:domain", requirePermission(auth.PermPolicyManage), func(c *gin.Context) {
+				api.HandlerDeleteDomainCategories(c, policyService)
+			})
+			policyRoutes.GET("/blocked", requirePermission(auth.PermAuditRead), func(c *gin.Context) {
+				api.HandlerListBlockedRequests(c, policyService)
+			})
+			policyRoutes.GET("/:profile", requirePermission(auth.PermAuditRead), func(c *gin.Context) {
+				api.HandlerGetPolicy(c, policyService)
+			})
+			policyRoutes.PUT("/:profile", requirePermission(auth.PermPolicyManage), func(c *gin.Context) {
+				api.HandlerPutPolicy(c, policyService)
+			})
+			policyRoutes.DELETE("/:profile", requirePermission(auth.PermPolicyManage), func(c *gin.Context) {
+				api.HandlerDeletePolicy(c, policyService)
+			})
+		}
+
+		// Files the user uploaded, which can be sent to later sessions
+		router.GET("/files", requirePermission(auth.PermFileUpload), func(c *gin.Context) {
+			if fileLibrary == nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the file library requires MinIO storage"})
+				return
+			}
+			api.HandlerListLibraryFiles(c, fileLibrary)
+		})
+
+		// Verdicts of uploaded file hashes and the sessions that received each file
+		reputationRoutes := router.Group("/reputation", auth.AuthMiddleware(authService), func(c *gin.Context) {
+			if fileReputation == nil {
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "file reputation is disabled"})
+			}
+		})
+		{
+			reputationRoutes.GET("/hashes", requirePermission(auth.PermAuditRead), func(c *gin.Context) {
+				api.HandlerListFileReputations(c, fileReputation)
+			})
+			reputationRoutes.POST("/hashes/import", requirePermission(auth.PermReputationManage), func(c *gin.Context) {
+				api.HandlerImportFileReputations(c, fileReputation)
+			})
+			reputationRoutes.GET("/hashes/:sha256", requirePermission(auth.PermAuditRead), func(c *gin.Context) {
+				api.HandlerGetFileReputation(c, fileReputation)
+			})
+			reputationRoutes.PUT("/hashes/:sha256", requirePermission(auth.PermReputationManage), func(c *gin.Context) {
+				api.HandlerPutFileReputation(c, fileReputation)
+			})
+			reputationRoutes.DELETE("/hashes/:sha256", requirePermission(auth.PermReputationManage), func(c *gin.Context) {
+				api.HandlerDeleteFileReputation(c, fileReputation)
+			})
+			reputationRoutes.GET("/hashes/:sha256/deliveries", requirePermission(auth.PermAuditRead), func(c *gin.Context) {
+				api.HandlerListFileDeliveries(c, fileReputation)
+			})
 		}
 
 		// Apply optional auth middleware to all routes for user context
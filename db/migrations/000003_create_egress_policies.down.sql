@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_egress_blocked_requests_created_at;
+DROP INDEX IF EXISTS idx_egress_blocked_requests_connection_id;
+
+DROP TABLE IF EXISTS egress_blocked_requests;
+DROP TABLE IF EXISTS domain_categories;
+DROP TABLE IF EXISTS egress_policies;
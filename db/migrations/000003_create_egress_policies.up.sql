@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS egress_policies (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  profile VARCHAR(255) NOT NULL UNIQUE,
+  default_action VARCHAR(10) NOT NULL DEFAULT 'allow',
+  allow_domains TEXT[] NOT NULL DEFAULT '{}',
+  deny_domains TEXT[] NOT NULL DEFAULT '{}',
+  allow_categories TEXT[] NOT NULL DEFAULT '{}',
+  deny_categories TEXT[] NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS domain_categories (
+  domain VARCHAR(255) NOT NULL,
+  category VARCHAR(100) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (domain, category)
+);
+
+CREATE TABLE IF NOT EXISTS egress_blocked_requests (
+  id BIGSERIAL PRIMARY KEY,
+  connection_id VARCHAR(255) NOT NULL,
+  pod_name VARCHAR(255) NOT NULL,
+  profile VARCHAR(255) NOT NULL,
+  host VARCHAR(255) NOT NULL,
+  url TEXT NOT NULL,
+  reason VARCHAR(255) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_egress_blocked_requests_connection_id ON egress_blocked_requests(connection_id);
+CREATE INDEX IF NOT EXISTS idx_egress_blocked_requests_created_at ON egress_blocked_requests(created_at);
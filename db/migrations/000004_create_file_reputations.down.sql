@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_file_deliveries_connection_id;
+DROP INDEX IF EXISTS idx_file_deliveries_sha256;
+DROP INDEX IF EXISTS idx_file_reputations_updated_at;
+
+DROP TABLE IF EXISTS file_deliveries;
+DROP TABLE IF EXISTS file_reputations;
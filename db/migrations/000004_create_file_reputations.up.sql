@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS file_reputations (
+  sha256 CHAR(64) PRIMARY KEY,
+  verdict VARCHAR(20) NOT NULL,
+  threats TEXT[] NOT NULL DEFAULT '{}',
+  source VARCHAR(20) NOT NULL,
+  scanner VARCHAR(255) NOT NULL DEFAULT '',
+  note TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS file_deliveries (
+  id BIGSERIAL PRIMARY KEY,
+  sha256 CHAR(64) NOT NULL,
+  connection_id VARCHAR(255) NOT NULL,
+  filename TEXT NOT NULL,
+  size BIGINT NOT NULL,
+  verdict VARCHAR(20) NOT NULL,
+  delivered BOOLEAN NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_reputations_updated_at ON file_reputations(updated_at);
+CREATE INDEX IF NOT EXISTS idx_file_deliveries_sha256 ON file_deliveries(sha256);
+CREATE INDEX IF NOT EXISTS idx_file_deliveries_connection_id ON file_deliveries(connection_id);
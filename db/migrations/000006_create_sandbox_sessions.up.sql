@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS sandbox_sessions (
+  connection_id VARCHAR(255) PRIMARY KEY,
+  user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+  profile VARCHAR(255) NOT NULL DEFAULT '',
+  pod_name VARCHAR(255) NOT NULL DEFAULT '',
+  node_name VARCHAR(255) NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  connected_at TIMESTAMPTZ,
+  ended_at TIMESTAMPTZ,
+  end_reason VARCHAR(20),
+  extension_count INTEGER NOT NULL DEFAULT 0,
+  disconnection_count INTEGER NOT NULL DEFAULT 0,
+  bytes_transferred BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_sandbox_sessions_user_id_created_at ON sandbox_sessions(user_id, created_at);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_password_reset_requests_ip_address;
+DROP INDEX IF EXISTS idx_password_reset_requests_email;
+DROP INDEX IF EXISTS idx_password_reset_tokens_expires_at;
+DROP INDEX IF EXISTS idx_password_reset_tokens_user_id;
+DROP TABLE IF EXISTS password_reset_requests;
+DROP TABLE IF EXISTS password_reset_tokens;
@@ -0,0 +1,117 @@
+-- Enrollment replaces the secret of a pending enrollment, never of an enabled one
+-- name: UpsertUserMFASecret :execrows
+INSERT INTO user_mfa (
+  user_id, totp_secret
+) VALUES (
+  $1, $2
+)
+ON CONFLICT (user_id) DO UPDATE
+SET totp_secret = EXCLUDED.totp_secret,
+    last_used_step = 0,
+    created_at = NOW()
+WHERE user_mfa.enabled = FALSE;
+
+-- name: GetUserMFA :one
+SELECT * FROM user_mfa
+WHERE user_id = $1 LIMIT 1;
+
+-- name: EnableUserMFA :exec
+UPDATE user_mfa
+SET enabled = TRUE, enabled_at = NOW(), last_used_step = $2
+WHERE user_id = $1;
+
+-- Codes are accepted once, later uses of the same time step are replays
+-- name: UpdateUserMFALastUsedStep :execrows
+UPDATE user_mfa
+SET last_used_step = $2
+WHERE user_id = $1 AND last_used_step < $2;
+
+-- Wrong codes are counted per user, whatever login challenge they were entered for
+-- name: IncrementMFAFailures :one
+UPDATE user_mfa
+SET failed_attempts = failed_attempts + 1
+WHERE user_id = $1
+RETURNING failed_attempts;
+
+-- name: LockUserMFA :exec
+UPDATE user_mfa
+SET failed_attempts = 0, locked_until = $2
+WHERE user_id = $1;
+
+-- name: ResetMFAFailures :exec
+UPDATE user_mfa
+SET failed_attempts = 0
+WHERE user_id = $1;
+
+-- name: DeleteUserMFA :exec
+DELETE FROM user_mfa
+WHERE user_id = $1;
+
+-- Recovery code queries
+-- name: CreateRecoveryCode :exec
+INSERT INTO user_recovery_codes (
+  user_id, code_hash
+) VALUES (
+  $1, $2
+);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM user_recovery_codes
+WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE user_recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: CountUnusedRecoveryCodes :one
+SELECT COUNT(*) FROM user_recovery_codes
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- Login challenge queries
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges (
+  token_hash, user_id, expires_at
+) VALUES (
+  $1, $2, $3
+);
+
+-- name: GetMFAChallenge :one
+SELECT * FROM mfa_challenges
+WHERE token_hash = $1 AND expires_at > NOW() LIMIT 1;
+
+-- name: IncrementMFAChallengeAttempts :one
+UPDATE mfa_challenges
+SET attempts = attempts + 1
+WHERE token_hash = $1
+RETURNING attempts;
+
+-- name: DeleteMFAChallenge :exec
+DELETE FROM mfa_challenges
+WHERE token_hash = $1;
+
+-- name: DeleteUserMFAChallenges :exec
+DELETE FROM mfa_challenges
+WHERE user_id = $1;
+
+-- name: DeleteExpiredMFAChallenges :exec
+DELETE FROM mfa_challenges
+WHERE expires_at <= NOW();
+
+-- Roles whose users must use two-factor authentication
+-- name: ListMFARequiredRoles :many
+SELECT role FROM mfa_required_roles
+ORDER BY role;
+
+-- name: IsMFARequiredForRole :one
+SELECT EXISTS (
+  SELECT 1 FROM mfa_required_roles WHERE role = $1
+);
+
+-- name: AddMFARequiredRole :exec
+INSERT INTO mfa_required_roles (role) VALUES ($1)
+ON CONFLICT (role) DO NOTHING;
+
+-- name: RemoveMFARequiredRole :exec
+DELETE FROM mfa_required_roles
+WHERE role = $1;
@@ -0,0 +1,47 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (
+  token_hash, user_id, expires_at
+) VALUES (
+  $1, $2, $3
+);
+
+-- Tokens are deleted when used, so that each one resets a password once
+-- name: UsePasswordResetToken :one
+DELETE FROM password_reset_tokens
+WHERE token_hash = $1 AND expires_at > NOW()
+RETURNING user_id;
+
+-- name: DeleteUserPasswordResetTokens :exec
+DELETE FROM password_reset_tokens
+WHERE user_id = $1;
+
+-- name: DeleteExpiredPasswordResetTokens :exec
+DELETE FROM password_reset_tokens
+WHERE expires_at <= NOW();
+
+-- Requests are recorded whether the email has an account or not, to rate limit them.
+-- Nothing is inserted once the email or the address reached its limit since the time given.
+-- name: CreatePasswordResetRequestWithinLimits :execrows
+INSERT INTO password_reset_requests (
+  email, ip_address
+)
+SELECT sqlc.arg(email)::text, sqlc.arg(ip_address)::text
+WHERE (
+  SELECT COUNT(*) FROM password_reset_requests
+  WHERE email = sqlc.arg(email) AND created_at > sqlc.arg(since)
+) < sqlc.arg(email_limit)::bigint
+AND (
+  SELECT COUNT(*) FROM password_reset_requests
+  WHERE ip_address = sqlc.arg(ip_address) AND created_at > sqlc.arg(since)
+) < sqlc.arg(ip_limit)::bigint;
+
+-- Concurrent requests for the same email or address would each count the others out, so
+-- they take turns until committed. The email is always locked before the address, each in
+-- its own lock space, so that requests cannot deadlock.
+-- name: LockPasswordResetRequests :exec
+SELECT pg_advisory_xact_lock(1, hashtext(sqlc.arg(email)::text)),
+  pg_advisory_xact_lock(2, hashtext(sqlc.arg(ip_address)::text));
+
+-- name: DeletePasswordResetRequestsBefore :exec
+DELETE FROM password_reset_requests
+WHERE created_at <= $1;
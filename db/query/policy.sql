@@ -0,0 +1,63 @@
+-- name: ListEgressPolicies :many
+SELECT * FROM egress_policies
+ORDER BY profile;
+
+-- name: GetEgressPolicy :one
+SELECT * FROM egress_policies
+WHERE profile = $1 LIMIT 1;
+
+-- name: UpsertEgressPolicy :one
+INSERT INTO egress_policies (
+  profile, default_action, allow_domains, deny_domains, allow_categories, deny_categories
+) VALUES (
+  $1, $2, $3, $4, $5, $6
+)
+ON CONFLICT (profile) DO UPDATE
+SET default_action = EXCLUDED.default_action,
+    allow_domains = EXCLUDED.allow_domains,
+    deny_domains = EXCLUDED.deny_domains,
+    allow_categories = EXCLUDED.allow_categories,
+    deny_categories = EXCLUDED.deny_categories,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: DeleteEgressPolicy :execrows
+DELETE FROM egress_policies
+WHERE profile = $1;
+
+-- Domain category queries
+-- name: ListDomainCategories :many
+SELECT * FROM domain_categories
+ORDER BY domain, category;
+
+-- name: AddDomainCategory :exec
+INSERT INTO domain_categories (
+  domain, category
+) VALUES (
+  $1, $2
+)
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteDomainCategories :execrows
+DELETE FROM domain_categories
+WHERE domain = $1;
+
+-- Blocked request log queries
+-- name: CreateBlockedRequest :one
+INSERT INTO egress_blocked_requests (
+  connection_id, pod_name, profile, host, url, reason
+) VALUES (
+  $1, $2, $3, $4, $5, $6
+)
+RETURNING *;
+
+-- name: ListBlockedRequests :many
+SELECT * FROM egress_blocked_requests
+ORDER BY created_at DESC
+LIMIT $1;
+
+-- name: ListBlockedRequestsByConnection :many
+SELECT * FROM egress_blocked_requests
+WHERE connection_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
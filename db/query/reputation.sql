@@ -0,0 +1,55 @@
+-- name: GetFileReputation :one
+SELECT * FROM file_reputations
+WHERE sha256 = $1 LIMIT 1;
+
+-- name: ListFileReputations :many
+SELECT * FROM file_reputations
+ORDER BY updated_at DESC
+LIMIT $1;
+
+-- Verdicts of scans never replace verdicts set by administrators
+-- name: RecordScanReputation :exec
+INSERT INTO file_reputations (
+  sha256, verdict, threats, source, scanner
+) VALUES (
+  $1, $2, $3, 'scan', $4
+)
+ON CONFLICT (sha256) DO UPDATE
+SET verdict = EXCLUDED.verdict,
+    threats = EXCLUDED.threats,
+    scanner = EXCLUDED.scanner,
+    updated_at = NOW()
+WHERE file_reputations.source = 'scan';
+
+-- name: UpsertFileReputation :one
+INSERT INTO file_reputations (
+  sha256, verdict, threats, source, note
+) VALUES (
+  $1, $2, $3, 'admin', $4
+)
+ON CONFLICT (sha256) DO UPDATE
+SET verdict = EXCLUDED.verdict,
+    threats = EXCLUDED.threats,
+    source = EXCLUDED.source,
+    scanner = '',
+    note = EXCLUDED.note,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: DeleteFileReputation :execrows
+DELETE FROM file_reputations
+WHERE sha256 = $1;
+
+-- File delivery log queries
+-- name: CreateFileDelivery :exec
+INSERT INTO file_deliveries (
+  sha256, connection_id, filename, size, verdict, delivered
+) VALUES (
+  $1, $2, $3, $4, $5, $6
+);
+
+-- name: ListFileDeliveries :many
+SELECT * FROM file_deliveries
+WHERE sha256 = $1
+ORDER BY created_at DESC
+LIMIT $2;
@@ -0,0 +1,44 @@
+-- name: CreateSandboxSession :exec
+INSERT INTO sandbox_sessions (
+  connection_id, user_id, profile, pod_name, node_name
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+ON CONFLICT (connection_id) DO NOTHING;
+
+-- Only the first connection to a session is recorded
+-- name: MarkSandboxSessionConnected :exec
+UPDATE sandbox_sessions
+SET connected_at = COALESCE(connected_at, NOW())
+WHERE connection_id = $1;
+
+-- name: IncrementSandboxSessionExtensions :exec
+UPDATE sandbox_sessions
+SET extension_count = extension_count + 1
+WHERE connection_id = $1;
+
+-- name: IncrementSandboxSessionDisconnections :exec
+UPDATE sandbox_sessions
+SET disconnection_count = disconnection_count + 1
+WHERE connection_id = $1;
+
+-- name: AddSandboxSessionBytes :exec
+UPDATE sandbox_sessions
+SET bytes_transferred = bytes_transferred + $2
+WHERE connection_id = $1;
+
+-- Only the first end of a session is recorded, later cleanups keep its reason
+-- name: EndSandboxSession :exec
+UPDATE sandbox_sessions
+SET ended_at = NOW(), end_reason = $2
+WHERE connection_id = $1 AND ended_at IS NULL;
+
+-- name: ListUserSandboxSessions :many
+SELECT * FROM sandbox_sessions
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT $2 OFFSET $3;
+
+-- name: CountUserSandboxSessions :one
+SELECT COUNT(*) FROM sandbox_sessions
+WHERE user_id = $1;
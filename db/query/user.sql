@@ -89,6 +89,12 @@ SET password_hash = $2, updated_at = NOW()
 WHERE id = $1
 RETURNING *;
 
+-- name: UpdateUserRole :one
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
 -- name: UpdateUserSettings :one
 UPDATE users
 SET username = $2, name = $3, avatar_url = $4, updated_at = NOW()
@@ -13,7 +13,8 @@ CREATE TABLE users (
   email_verification_token VARCHAR(255),
   email_verification_expires_at TIMESTAMPTZ,
   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  role VARCHAR(20) NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'operator', 'auditor', 'admin'))
 );
 
 CREATE TABLE user_sessions (
@@ -32,3 +33,133 @@ CREATE INDEX idx_users_email ON users(email);
 CREATE UNIQUE INDEX idx_users_provider ON users(provider, provider_id) WHERE provider_id IS NOT NULL;
 CREATE INDEX idx_users_email_verification_token ON users(email_verification_token);
 CREATE INDEX idx_users_email_verified ON users(email_verified);
+
+CREATE TABLE egress_policies (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  profile VARCHAR(255) NOT NULL UNIQUE,
+  default_action VARCHAR(10) NOT NULL DEFAULT 'allow',
+  allow_domains TEXT[] NOT NULL DEFAULT '{}',
+  deny_domains TEXT[] NOT NULL DEFAULT '{}',
+  allow_categories TEXT[] NOT NULL DEFAULT '{}',
+  deny_categories TEXT[] NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE domain_categories (
+  domain VARCHAR(255) NOT NULL,
+  category VARCHAR(100) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (domain, category)
+);
+
+CREATE TABLE egress_blocked_requests (
+  id BIGSERIAL PRIMARY KEY,
+  connection_id VARCHAR(255) NOT NULL,
+  pod_name VARCHAR(255) NOT NULL,
+  profile VARCHAR(255) NOT NULL,
+  host VARCHAR(255) NOT NULL,
+  url TEXT NOT NULL,
+  reason VARCHAR(255) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_egress_blocked_requests_connection_id ON egress_blocked_requests(connection_id);
+CREATE INDEX idx_egress_blocked_requests_created_at ON egress_blocked_requests(created_at);
+
+CREATE TABLE file_reputations (
+  sha256 CHAR(64) PRIMARY KEY,
+  verdict VARCHAR(20) NOT NULL,
+  threats TEXT[] NOT NULL DEFAULT '{}',
+  source VARCHAR(20) NOT NULL,
+  scanner VARCHAR(255) NOT NULL DEFAULT '',
+  note TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE file_deliveries (
+  id BIGSERIAL PRIMARY KEY,
+  sha256 CHAR(64) NOT NULL,
+  connection_id VARCHAR(255) NOT NULL,
+  filename TEXT NOT NULL,
+  size BIGINT NOT NULL,
+  verdict VARCHAR(20) NOT NULL,
+  delivered BOOLEAN NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_file_reputations_updated_at ON file_reputations(updated_at);
+CREATE INDEX idx_file_deliveries_sha256 ON file_deliveries(sha256);
+CREATE INDEX idx_file_deliveries_connection_id ON file_deliveries(connection_id);
+
+CREATE TABLE sandbox_sessions (
+  connection_id VARCHAR(255) PRIMARY KEY,
+  user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+  profile VARCHAR(255) NOT NULL DEFAULT '',
+  pod_name VARCHAR(255) NOT NULL DEFAULT '',
+  node_name VARCHAR(255) NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  connected_at TIMESTAMPTZ,
+  ended_at TIMESTAMPTZ,
+  end_reason VARCHAR(20),
+  extension_count INTEGER NOT NULL DEFAULT 0,
+  disconnection_count INTEGER NOT NULL DEFAULT 0,
+  bytes_transferred BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_sandbox_sessions_user_id_created_at ON sandbox_sessions(user_id, created_at);
+
+CREATE TABLE user_mfa (
+  user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  totp_secret VARCHAR(64) NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT FALSE,
+  last_used_step BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  enabled_at TIMESTAMPTZ,
+  failed_attempts INTEGER NOT NULL DEFAULT 0,
+  locked_until TIMESTAMPTZ
+);
+
+CREATE TABLE user_recovery_codes (
+  id BIGSERIAL PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash CHAR(64) NOT NULL,
+  used_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE mfa_challenges (
+  token_hash CHAR(64) PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE mfa_required_roles (
+  role VARCHAR(20) PRIMARY KEY CHECK (role IN ('user', 'operator', 'auditor', 'admin')),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_user_recovery_codes_user_id ON user_recovery_codes(user_id);
+CREATE INDEX idx_mfa_challenges_expires_at ON mfa_challenges(expires_at);
+
+CREATE TABLE password_reset_tokens (
+  token_hash CHAR(64) PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE password_reset_requests (
+  id BIGSERIAL PRIMARY KEY,
+  email VARCHAR(255) NOT NULL,
+  ip_address VARCHAR(45) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_password_reset_tokens_user_id ON password_reset_tokens(user_id);
+CREATE INDEX idx_password_reset_tokens_expires_at ON password_reset_tokens(expires_at);
+CREATE INDEX idx_password_reset_requests_email ON password_reset_requests(email, created_at);
+CREATE INDEX idx_password_reset_requests_ip_address ON password_reset_requests(ip_address, created_at);
@@ -11,6 +11,99 @@ import (
 	"github.com/google/uuid"
 )
 
+type DomainCategory struct {
+	Domain    string    `json:"domain"`
+	Category  string    `json:"category"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type EgressBlockedRequest struct {
+	ID           int64     `json:"id"`
+	ConnectionID string    `json:"connection_id"`
+	PodName      string    `json:"pod_name"`
+	Profile      string    `json:"profile"`
+	Host         string    `json:"host"`
+	Url          string    `json:"url"`
+	Reason       string    `json:"reason"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type EgressPolicy struct {
+	ID              uuid.UUID `json:"id"`
+	Profile         string    `json:"profile"`
+	DefaultAction   string    `json:"default_action"`
+	AllowDomains    []string  `json:"allow_domains"`
+	DenyDomains     []string  `json:"deny_domains"`
+	AllowCategories []string  `json:"allow_categories"`
+	DenyCategories  []string  `json:"deny_categories"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type FileDelivery struct {
+	ID           int64     `json:"id"`
+	Sha256       string    `json:"sha256"`
+	ConnectionID string    `json:"connection_id"`
+	Filename     string    `json:"filename"`
+	Size         int64     `json:"size"`
+	Verdict      string    `json:"verdict"`
+	Delivered    bool      `json:"delivered"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type FileReputation struct {
+	Sha256    string    `json:"sha256"`
+	Verdict   string    `json:"verdict"`
+	Threats   []string  `json:"threats"`
+	Source    string    `json:"source"`
+	Scanner   string    `json:"scanner"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type MfaChallenge struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    uuid.UUID `json:"user_id"`
+	Attempts  int32     `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MfaRequiredRole struct {
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PasswordResetRequest struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	IpAddress string    `json:"ip_address"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PasswordResetToken struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SandboxSession struct {
+	ConnectionID       string         `json:"connection_id"`
+	UserID             uuid.NullUUID  `json:"user_id"`
+	Profile            string         `json:"profile"`
+	PodName            string         `json:"pod_name"`
+	NodeName           string         `json:"node_name"`
+	CreatedAt          time.Time      `json:"created_at"`
+	ConnectedAt        sql.NullTime   `json:"connected_at"`
+	EndedAt            sql.NullTime   `json:"ended_at"`
+	EndReason          sql.NullString `json:"end_reason"`
+	ExtensionCount     int32          `json:"extension_count"`
+	DisconnectionCount int32          `json:"disconnection_count"`
+	BytesTransferred   int64          `json:"bytes_transferred"`
+}
+
 type User struct {
 	ID                         uuid.UUID      `json:"id"`
 	Username                   sql.NullString `json:"username"`
@@ -25,6 +118,26 @@ type User struct {
 	EmailVerificationExpiresAt sql.NullTime   `json:"email_verification_expires_at"`
 	CreatedAt                  time.Time      `json:"created_at"`
 	UpdatedAt                  time.Time      `json:"updated_at"`
+	Role                       string         `json:"role"`
+}
+
+type UserMfa struct {
+	UserID         uuid.UUID    `json:"user_id"`
+	TotpSecret     string       `json:"totp_secret"`
+	Enabled        bool         `json:"enabled"`
+	LastUsedStep   int64        `json:"last_used_step"`
+	CreatedAt      time.Time    `json:"created_at"`
+	EnabledAt      sql.NullTime `json:"enabled_at"`
+	FailedAttempts int32        `json:"failed_attempts"`
+	LockedUntil    sql.NullTime `json:"locked_until"`
+}
+
+type UserRecoveryCode struct {
+	ID        int64        `json:"id"`
+	UserID    uuid.UUID    `json:"user_id"`
+	CodeHash  string       `json:"code_hash"`
+	UsedAt    sql.NullTime `json:"used_at"`
+	CreatedAt time.Time    `json:"created_at"`
 }
 
 type UserSession struct {
@@ -6,34 +6,108 @@ package db
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type Querier interface {
+	AddDomainCategory(ctx context.Context, arg AddDomainCategoryParams) error
+	AddMFARequiredRole(ctx context.Context, role string) error
+	AddSandboxSessionBytes(ctx context.Context, arg AddSandboxSessionBytesParams) error
+	CountUnusedRecoveryCodes(ctx context.Context, userID uuid.UUID) (int64, error)
+	CountUserSandboxSessions(ctx context.Context, userID uuid.NullUUID) (int64, error)
+	// Blocked request log queries
+	CreateBlockedRequest(ctx context.Context, arg CreateBlockedRequestParams) (EgressBlockedRequest, error)
 	CreateEmailUser(ctx context.Context, arg CreateEmailUserParams) (User, error)
+	// File delivery log queries
+	CreateFileDelivery(ctx context.Context, arg CreateFileDeliveryParams) error
+	// Login challenge queries
+	CreateMFAChallenge(ctx context.Context, arg CreateMFAChallengeParams) error
 	CreateOAuthUser(ctx context.Context, arg CreateOAuthUserParams) (User, error)
+	// Requests are recorded whether the email has an account or not, to rate limit them.
+	// Nothing is inserted once the email or the address reached its limit since the time given.
+	CreatePasswordResetRequestWithinLimits(ctx context.Context, arg CreatePasswordResetRequestWithinLimitsParams) (int64, error)
+	CreatePasswordResetToken(ctx context.Context, arg CreatePasswordResetTokenParams) error
+	// Recovery code queries
+	CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error
+	CreateSandboxSession(ctx context.Context, arg CreateSandboxSessionParams) error
 	// Session management queries
 	CreateSession(ctx context.Context, arg CreateSessionParams) (UserSession, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	DeleteDomainCategories(ctx context.Context, domain string) (int64, error)
+	DeleteEgressPolicy(ctx context.Context, profile string) (int64, error)
+	DeleteExpiredMFAChallenges(ctx context.Context) error
+	DeleteExpiredPasswordResetTokens(ctx context.Context) error
 	DeleteExpiredSessions(ctx context.Context) error
+	DeleteFileReputation(ctx context.Context, sha256 string) (int64, error)
+	DeleteMFAChallenge(ctx context.Context, tokenHash string) error
+	DeletePasswordResetRequestsBefore(ctx context.Context, createdAt time.Time) error
+	DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error
 	DeleteSession(ctx context.Context, sessionToken string) error
 	DeleteUser(ctx context.Context, id uuid.UUID) error
+	DeleteUserMFA(ctx context.Context, userID uuid.UUID) error
+	DeleteUserMFAChallenges(ctx context.Context, userID uuid.UUID) error
+	DeleteUserPasswordResetTokens(ctx context.Context, userID uuid.UUID) error
 	DeleteUserSessions(ctx context.Context, userID uuid.UUID) error
+	EnableUserMFA(ctx context.Context, arg EnableUserMFAParams) error
+	// Only the first end of a session is recorded, later cleanups keep its reason
+	EndSandboxSession(ctx context.Context, arg EndSandboxSessionParams) error
+	GetEgressPolicy(ctx context.Context, profile string) (EgressPolicy, error)
+	GetFileReputation(ctx context.Context, sha256 string) (FileReputation, error)
+	GetMFAChallenge(ctx context.Context, tokenHash string) (MfaChallenge, error)
 	GetSession(ctx context.Context, sessionToken string) (GetSessionRow, error)
 	GetUser(ctx context.Context, id uuid.UUID) (User, error)
 	GetUserByEmail(ctx context.Context, lower string) (User, error)
 	// Email verification queries
 	GetUserByEmailVerificationToken(ctx context.Context, dollar_1 string) (User, error)
 	GetUserByProvider(ctx context.Context, arg GetUserByProviderParams) (User, error)
+	GetUserMFA(ctx context.Context, userID uuid.UUID) (UserMfa, error)
+	IncrementMFAChallengeAttempts(ctx context.Context, tokenHash string) (int32, error)
+	// Wrong codes are counted per user, whatever login challenge they were entered for
+	IncrementMFAFailures(ctx context.Context, userID uuid.UUID) (int32, error)
+	IncrementSandboxSessionDisconnections(ctx context.Context, connectionID string) error
+	IncrementSandboxSessionExtensions(ctx context.Context, connectionID string) error
+	IsMFARequiredForRole(ctx context.Context, role string) (bool, error)
+	ListBlockedRequests(ctx context.Context, limit int32) ([]EgressBlockedRequest, error)
+	ListBlockedRequestsByConnection(ctx context.Context, arg ListBlockedRequestsByConnectionParams) ([]EgressBlockedRequest, error)
+	// Domain category queries
+	ListDomainCategories(ctx context.Context) ([]DomainCategory, error)
+	ListEgressPolicies(ctx context.Context) ([]EgressPolicy, error)
+	ListFileDeliveries(ctx context.Context, arg ListFileDeliveriesParams) ([]FileDelivery, error)
+	ListFileReputations(ctx context.Context, limit int32) ([]FileReputation, error)
+	// Roles whose users must use two-factor authentication
+	ListMFARequiredRoles(ctx context.Context) ([]string, error)
+	ListUserSandboxSessions(ctx context.Context, arg ListUserSandboxSessionsParams) ([]SandboxSession, error)
 	ListUsers(ctx context.Context) ([]User, error)
+	// Concurrent requests for the same email or address would each count the others out, so
+	// they take turns until committed. The email is always locked before the address, each in
+	// its own lock space, so that requests cannot deadlock.
+	LockPasswordResetRequests(ctx context.Context, arg LockPasswordResetRequestsParams) error
+	LockUserMFA(ctx context.Context, arg LockUserMFAParams) error
+	// Only the first connection to a session is recorded
+	MarkSandboxSessionConnected(ctx context.Context, connectionID string) error
+	// Verdicts of scans never replace verdicts set by administrators
+	RecordScanReputation(ctx context.Context, arg RecordScanReputationParams) error
+	RemoveMFARequiredRole(ctx context.Context, role string) error
 	ResendEmailVerification(ctx context.Context, arg ResendEmailVerificationParams) (User, error)
+	ResetMFAFailures(ctx context.Context, userID uuid.UUID) error
 	UpdateEmailVerificationToken(ctx context.Context, arg UpdateEmailVerificationTokenParams) (User, error)
 	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
+	// Codes are accepted once, later uses of the same time step are replays
+	UpdateUserMFALastUsedStep(ctx context.Context, arg UpdateUserMFALastUsedStepParams) (int64, error)
 	UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) (User, error)
 	// Profile and settings management queries
 	UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error)
+	UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) (User, error)
 	UpdateUserSettings(ctx context.Context, arg UpdateUserSettingsParams) (User, error)
+	UpsertEgressPolicy(ctx context.Context, arg UpsertEgressPolicyParams) (EgressPolicy, error)
+	UpsertFileReputation(ctx context.Context, arg UpsertFileReputationParams) (FileReputation, error)
+	// Enrollment replaces the secret of a pending enrollment, never of an enabled one
+	UpsertUserMFASecret(ctx context.Context, arg UpsertUserMFASecretParams) (int64, error)
+	// Tokens are deleted when used, so that each one resets a password once
+	UsePasswordResetToken(ctx context.Context, tokenHash string) (uuid.UUID, error)
+	UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error)
 	VerifyUserEmail(ctx context.Context, dollar_1 string) (User, error)
 }
 
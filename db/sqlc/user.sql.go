@@ -19,7 +19,7 @@ INSERT INTO users (
 ) VALUES (
   $1, $2, 'email', $3, $4
 )
-RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at
+RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role
 `
 
 type CreateEmailUserParams struct {
@@ -51,6 +51,7 @@ func (q *Queries) CreateEmailUser(ctx context.Context, arg CreateEmailUserParams
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -61,7 +62,7 @@ INSERT INTO users (
 ) VALUES (
   $1, $2, $3, $4, $5, $6, NULL
 )
-RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at
+RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role
 `
 
 type CreateOAuthUserParams struct {
@@ -97,6 +98,7 @@ func (q *Queries) CreateOAuthUser(ctx context.Context, arg CreateOAuthUserParams
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -137,7 +139,7 @@ INSERT INTO users (
 ) VALUES (
   $1, $2, $3, $4, $5, $6, $7
 )
-RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at
+RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role
 `
 
 type CreateUserParams struct {
@@ -175,6 +177,7 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -220,7 +223,7 @@ func (q *Queries) DeleteUserSessions(ctx context.Context, userID uuid.UUID) erro
 }
 
 const getSession = `-- name: GetSession :one
-SELECT s.id, s.user_id, s.session_token, s.expires_at, s.created_at, s.updated_at, u.email, u.username, u.name, u.avatar_url, u.provider
+SELECT s.id, s.user_id, s.session_token, s.expires_at, s.created_at, s.updated_at, u.email, u.username, u.name, u.avatar_url, u.provider, u.role
 FROM user_sessions s
 JOIN users u ON s.user_id = u.id
 WHERE s.session_token = $1 AND s.expires_at > NOW()
@@ -239,6 +242,7 @@ type GetSessionRow struct {
 	Name         sql.NullString `json:"name"`
 	AvatarUrl    sql.NullString `json:"avatar_url"`
 	Provider     sql.NullString `json:"provider"`
+	Role         string         `json:"role"`
 }
 
 func (q *Queries) GetSession(ctx context.Context, sessionToken string) (GetSessionRow, error) {
@@ -256,12 +260,13 @@ func (q *Queries) GetSession(ctx context.Context, sessionToken string) (GetSessi
 		&i.Name,
 		&i.AvatarUrl,
 		&i.Provider,
+		&i.Role,
 	)
 	return i, err
 }
 
 const getUser = `-- name: GetUser :one
-SELECT id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at FROM users
+SELECT id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role FROM users
 WHERE id = $1 LIMIT 1
 `
 
@@ -282,12 +287,13 @@ func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at FROM users
+SELECT id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role FROM users
 WHERE LOWER(email) = LOWER($1) LIMIT 1
 `
 
@@ -308,12 +314,13 @@ func (q *Queries) GetUserByEmail(ctx context.Context, lower string) (User, error
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
 
 const getUserByEmailVerificationToken = `-- name: GetUserByEmailVerificationToken :one
-SELECT id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at FROM users
+SELECT id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role FROM users
 WHERE email_verification_token = $1::text AND email_verification_expires_at > NOW()
 LIMIT 1
 `
@@ -336,12 +343,13 @@ func (q *Queries) GetUserByEmailVerificationToken(ctx context.Context, dollar_1
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
 
 const getUserByProvider = `-- name: GetUserByProvider :one
-SELECT id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at FROM users
+SELECT id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role FROM users
 WHERE provider = $1 AND provider_id = $2 LIMIT 1
 `
 
@@ -367,12 +375,13 @@ func (q *Queries) GetUserByProvider(ctx context.Context, arg GetUserByProviderPa
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
 
 const listUsers = `-- name: ListUsers :many
-SELECT id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at FROM users
+SELECT id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role FROM users
 ORDER BY created_at
 `
 
@@ -399,6 +408,7 @@ func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
 			&i.EmailVerificationExpiresAt,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Role,
 		); err != nil {
 			return nil, err
 		}
@@ -417,7 +427,7 @@ const resendEmailVerification = `-- name: ResendEmailVerification :one
 UPDATE users
 SET email_verification_token = $2, email_verification_expires_at = $3, updated_at = NOW()
 WHERE email = $1 AND email_verified = FALSE
-RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at
+RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role
 `
 
 type ResendEmailVerificationParams struct {
@@ -443,6 +453,7 @@ func (q *Queries) ResendEmailVerification(ctx context.Context, arg ResendEmailVe
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -451,7 +462,7 @@ const updateEmailVerificationToken = `-- name: UpdateEmailVerificationToken :one
 UPDATE users
 SET email_verification_token = $2, email_verification_expires_at = $3, updated_at = NOW()
 WHERE id = $1
-RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at
+RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role
 `
 
 type UpdateEmailVerificationTokenParams struct {
@@ -477,6 +488,7 @@ func (q *Queries) UpdateEmailVerificationToken(ctx context.Context, arg UpdateEm
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -485,7 +497,7 @@ const updateUser = `-- name: UpdateUser :one
 UPDATE users
 SET username = $2, email = $3, updated_at = NOW()
 WHERE id = $1
-RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at
+RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role
 `
 
 type UpdateUserParams struct {
@@ -511,6 +523,7 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -519,7 +532,7 @@ const updateUserPassword = `-- name: UpdateUserPassword :one
 UPDATE users
 SET password_hash = $2, updated_at = NOW()
 WHERE id = $1
-RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at
+RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role
 `
 
 type UpdateUserPasswordParams struct {
@@ -544,6 +557,7 @@ func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPassword
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -552,7 +566,7 @@ const updateUserProfile = `-- name: UpdateUserProfile :one
 UPDATE users
 SET username = $2, name = $3, avatar_url = $4, updated_at = NOW()
 WHERE id = $1
-RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at
+RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role
 `
 
 type UpdateUserProfileParams struct {
@@ -585,6 +599,41 @@ func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfilePa
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
+	)
+	return i, err
+}
+
+const updateUserRole = `-- name: UpdateUserRole :one
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role
+`
+
+type UpdateUserRoleParams struct {
+	ID   uuid.UUID `json:"id"`
+	Role string    `json:"role"`
+}
+
+func (q *Queries) UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Provider,
+		&i.ProviderID,
+		&i.AvatarUrl,
+		&i.Name,
+		&i.EmailVerified,
+		&i.EmailVerificationToken,
+		&i.EmailVerificationExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -593,7 +642,7 @@ const updateUserSettings = `-- name: UpdateUserSettings :one
 UPDATE users
 SET username = $2, name = $3, avatar_url = $4, updated_at = NOW()
 WHERE id = $1
-RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at
+RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role
 `
 
 type UpdateUserSettingsParams struct {
@@ -625,6 +674,7 @@ func (q *Queries) UpdateUserSettings(ctx context.Context, arg UpdateUserSettings
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -633,7 +683,7 @@ const verifyUserEmail = `-- name: VerifyUserEmail :one
 UPDATE users
 SET email_verified = TRUE, email_verification_token = NULL, email_verification_expires_at = NULL, updated_at = NOW()
 WHERE email_verification_token = $1::text AND email_verification_expires_at > NOW()
-RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at
+RETURNING id, username, email, password_hash, provider, provider_id, avatar_url, name, email_verified, email_verification_token, email_verification_expires_at, created_at, updated_at, role
 `
 
 func (q *Queries) VerifyUserEmail(ctx context.Context, dollar_1 string) (User, error) {
@@ -653,6 +703,7 @@ func (q *Queries) VerifyUserEmail(ctx context.Context, dollar_1 string) (User, e
 		&i.EmailVerificationExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -294,7 +294,7 @@ const ShareWSSession = () => {
               <div className="w-full h-[600px] rounded-lg overflow-hidden">
                 <GuacClient
                   query={{
-                    uuid: sessionState.connectionId,
+                    token: sessionState.connectionId,
                     width: Math.round(window.innerWidth * (window.devicePixelRatio || 1)),
                     height: Math.round(window.innerHeight * (window.devicePixelRatio || 1))
                   }}
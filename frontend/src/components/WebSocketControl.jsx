@@ -70,6 +70,48 @@ function WebSocketControl({
     }
   }, [connectionState, errorMessage]);
 
+  // Files sent from the sandbox are held back until they are scanned, start the download
+  // of clean files and report the others
+  const handledDownloads = useRef(new Set());
+  useEffect(() => {
+    if (!connectionId) return;
+
+    const pollDownloads = async () => {
+      try {
+        const response = await fetch(`/sessions/${connectionId}/downloads`);
+        if (!response.ok) return;
+        const data = await response.json();
+
+        for (const download of data.downloads || []) {
+          if (handledDownloads.current.has(download.id)) continue;
+
+          if (download.status === "clean") {
+            handledDownloads.current.add(download.id);
+            const linkResponse = await fetch(`/sessions/${connectionId}/downloads/${download.id}/link`, { method: "POST" });
+            if (!linkResponse.ok) continue;
+            const link = await linkResponse.json();
+            const anchor = document.createElement("a");
+            anchor.href = link.download_url;
+            anchor.download = download.filename;
+            anchor.click();
+          } else if (download.status === "infected" || download.status === "failed") {
+            handledDownloads.current.add(download.id);
+            const reason = download.status === "infected" ? "malware was detected" : "it could not be scanned";
+            toast.error(`Download of ${download.filename} blocked: ${reason}`, {
+              duration: 5000,
+              position: "top-right",
+            });
+          }
+        }
+      } catch (err) {
+        console.error("Failed to check downloads:", err);
+      }
+    };
+
+    const interval = setInterval(pollDownloads, 5000);
+    return () => clearInterval(interval);
+  }, [connectionId]);
+
   // Clear animation timeout on unmount
   useEffect(() => {
     return () => {
@@ -238,9 +280,10 @@ function WebSocketControl({
     if (!connectionId) return;
 
     try {
-      const response = await fetch(`/test/share/${connectionId}`, {
-        method: "GET",
-        redirect: "follow",
+      const response = await fetch(`/sessions/${connectionId}/shares`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ mode: "view-only" }),
       });
 
       if (response.ok) {
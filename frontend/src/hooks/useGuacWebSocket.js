@@ -51,6 +51,7 @@ const useGuacWebSocket = (
 
   // Session sharing state
   const [isSessionOwner, setIsSessionOwner] = useState(false);
+  const [shareToken, setShareToken] = useState(null);
 
   // Maximum reconnection attempts
   const MAX_RECONNECT_ATTEMPTS = 10;
@@ -623,11 +624,15 @@ const useGuacWebSocket = (
     if (!sessionUUID) return false;
 
     try {
-      const response = await fetch(`/test/share/${sessionUUID}`, {
-        method: "GET",
+      const response = await fetch(`/sessions/${sessionUUID}/shares`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ mode: "view-only" }),
       });
 
       if (response.ok) {
+        const data = await response.json();
+        setShareToken(data.share.token);
         setIsSessionOwner(true);
         return true;
       }
@@ -638,9 +643,9 @@ const useGuacWebSocket = (
     }
   };
   const getShareUrl = () => {
-    if (!sessionUUID) return null;
+    if (!shareToken) return null;
     const baseUrl = `${window.location.protocol}//${window.location.host}`;
-    return `${baseUrl}/share-ws-url?uuid=${sessionUUID}`;
+    return `${baseUrl}/share-ws-url?uuid=${shareToken}`;
   };
   // Function to manually clear session (for intentional disconnects)
   const clearSession = useCallback(() => {
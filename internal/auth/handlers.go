@@ -4,14 +4,17 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/browsersec/KubeBrowse/internal/metrics"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
@@ -138,7 +141,12 @@ func (h *Handler) LoginWithEmail(c *gin.Context) {
 		return
 	}
 
-	user, session, err := h.service.LoginWithEmail(req.Email, req.Password)
+	user, session, challenge, err := h.service.LoginWithEmail(req.Email, req.Password)
+	result := loginResult(err)
+	if challenge != nil {
+		result = "mfa_required"
+	}
+	metrics.Logins.WithLabelValues("email", result).Inc()
 	if err != nil {
 		if err == ErrInvalidCredentials {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
@@ -156,6 +164,22 @@ func (h *Handler) LoginWithEmail(c *gin.Context) {
 		return
 	}
 
+	// The session cookie is only set once the second factor is verified
+	if challenge != nil {
+		message := "Two-factor authentication code required"
+		if challenge.EnrollmentRequired {
+			message = "Two-factor authentication must be set up to sign in"
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required":        true,
+			"mfa_token":           challenge.Token,
+			"expires_at":          challenge.ExpiresAt,
+			"enrollment_required": challenge.EnrollmentRequired,
+			"message":             message,
+		})
+		return
+	}
+
 	// Set session cookie
 	h.setSessionCookie(c, session.SessionToken)
 
@@ -165,6 +189,20 @@ func (h *Handler) LoginWithEmail(c *gin.Context) {
 	})
 }
 
+// loginResult returns the result label of an email login
+func loginResult(err error) string {
+	switch err {
+	case nil:
+		return metrics.ResultSuccess
+	case ErrInvalidCredentials:
+		return "invalid_credentials"
+	case ErrEmailNotVerified:
+		return "email_not_verified"
+	default:
+		return metrics.ResultFailure
+	}
+}
+
 // generateStateToken generates a random state token
 func (h *Handler) generateStateToken() (string, error) {
 	b := make([]byte, 32)
@@ -278,6 +316,7 @@ func (h *Handler) CallbackOAuth(c *gin.Context) {
 	gothUser, err := gothic.CompleteUserAuth(c.Writer, c.Request)
 	if err != nil {
 		logrus.Errorf("OAuth callback error: %v", err)
+		metrics.Logins.WithLabelValues("oauth", metrics.ResultFailure).Inc()
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "OAuth authentication failed",
 			"details": err.Error(),
@@ -303,6 +342,7 @@ func (h *Handler) callbackOAuthWithRedis(c *gin.Context, provider, state, code s
 	storedProvider, err := h.redisClient.Get(ctx, stateKey).Result()
 	if err == redis.Nil {
 		logrus.Errorf("State token not found in Redis: %s", state)
+		metrics.Logins.WithLabelValues("oauth", "invalid_state").Inc()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired state token"})
 		return
 	} else if err != nil {
@@ -345,6 +385,7 @@ func (h *Handler) callbackOAuthWithRedis(c *gin.Context, provider, state, code s
 	_, err = sess.Authorize(githubProvider, params)
 	if err != nil {
 		logrus.Errorf("Failed to authorize: %v", err)
+		metrics.Logins.WithLabelValues("oauth", metrics.ResultFailure).Inc()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete OAuth authorization"})
 		return
 	}
@@ -352,6 +393,7 @@ func (h *Handler) callbackOAuthWithRedis(c *gin.Context, provider, state, code s
 	user, err := githubProvider.FetchUser(sess)
 	if err != nil {
 		logrus.Errorf("Failed to fetch user: %v", err)
+		metrics.Logins.WithLabelValues("oauth", metrics.ResultFailure).Inc()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user information"})
 		return
 	}
@@ -374,6 +416,7 @@ func (h *Handler) processOAuthUser(c *gin.Context, gothUser goth.User) {
 	)
 	if err != nil {
 		logrus.Errorf("Failed to create/update OAuth user: %v", err)
+		metrics.Logins.WithLabelValues("oauth", metrics.ResultFailure).Inc()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process OAuth user"})
 		return
 	}
@@ -382,9 +425,11 @@ func (h *Handler) processOAuthUser(c *gin.Context, gothUser goth.User) {
 	session, err := h.service.CreateSession(user.ID)
 	if err != nil {
 		logrus.Errorf("Failed to create session: %v", err)
+		metrics.Logins.WithLabelValues("oauth", metrics.ResultFailure).Inc()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
+	metrics.Logins.WithLabelValues("oauth", metrics.ResultSuccess).Inc()
 
 	// Set session cookie
 	h.setSessionCookie(c, session.SessionToken)
@@ -417,15 +462,15 @@ func (h *Handler) Logout(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
-// GetCurrentUser returns the current authenticated user
+// GetCurrentUser returns the current authenticated user and the permissions of its role
 func (h *Handler) GetCurrentUser(c *gin.Context) {
-	user, exists := c.Get(UserContextKey)
+	user, exists := CurrentUser(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"user": user})
+	c.JSON(http.StatusOK, gin.H{"user": user, "permissions": user.Role.Permissions()})
 }
 
 // UpdateProfileRequest represents the request body for profile updates
@@ -488,14 +533,13 @@ func (h *Handler) UpdatePassword(c *gin.Context) {
 	}
 
 	// Verify current password
-	_, session, err := h.service.LoginWithEmail(authUser.Email, req.CurrentPassword)
-	if err != nil {
+	if err := h.service.CheckPassword(authUser.Email, req.CurrentPassword); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
 		return
 	}
 
 	// Update password
-	err = h.service.UpdateUserPassword(authUser.ID, req.NewPassword)
+	err := h.service.UpdateUserPassword(authUser.ID, req.NewPassword)
 	if err != nil {
 		logrus.Errorf("Failed to update password: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
@@ -503,8 +547,8 @@ func (h *Handler) UpdatePassword(c *gin.Context) {
 	}
 
 	// Delete old session and create new one
-	if session != nil {
-		if err := h.service.DeleteSession(session.SessionToken); err != nil {
+	if sessionToken, err := c.Cookie(SessionCookieName); err == nil && sessionToken != "" {
+		if err := h.service.DeleteSession(sessionToken); err != nil {
 			logrus.Errorf("Failed to delete old session: %v", err)
 		}
 	}
@@ -613,6 +657,15 @@ func (h *Handler) VerifyEmail(c *gin.Context) {
 		return
 	}
 
+	// Users whose role requires a second factor set it up when they sign in
+	if status, err := h.service.MFAStatus(user); err != nil || status.Required {
+		if err != nil {
+			logrus.Errorf("Failed to get two-factor status after verification: %v", err)
+		}
+		h.respondEmailVerified(c, user)
+		return
+	}
+
 	// Create session for the verified user
 	session, err := h.service.CreateSession(user.ID)
 	if err != nil {
@@ -624,6 +677,11 @@ func (h *Handler) VerifyEmail(c *gin.Context) {
 	// Set session cookie
 	h.setSessionCookie(c, session.SessionToken)
 
+	h.respondEmailVerified(c, user)
+}
+
+// respondEmailVerified answers a verified email link or request
+func (h *Handler) respondEmailVerified(c *gin.Context, user *User) {
 	// If this is a GET request (from email link), redirect to frontend
 	if c.Request.Method == "GET" {
 		frontendURL := os.Getenv("FRONTEND_URL")
@@ -665,6 +723,69 @@ func (h *Handler) ResendVerificationEmail(c *gin.Context) {
 	})
 }
 
+// ForgotPasswordRequest represents the request body for requesting a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest represents the request body for setting a new password with a reset token
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
+// ForgotPassword handles password reset requests. The response is the same whether the email
+// has an account or not.
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.service.RequestPasswordReset(req.Email, c.ClientIP())
+	if errors.Is(err, ErrTooManyResetRequests) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many password reset requests, please try again later"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to request password reset: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request password reset"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "If an account exists for this email, a password reset link has been sent",
+	})
+}
+
+// ResetPassword handles setting a new password from a password reset email, which signs the
+// user out everywhere
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.ResetPassword(req.Token, req.NewPassword)
+	if errors.Is(err, ErrInvalidResetToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired password reset token"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to reset password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	logrus.Infof("User %s reset their password", user.Email)
+	h.clearSessionCookie(c)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password reset successfully, please sign in with your new password",
+	})
+}
+
 // clearSessionCookie clears the session cookie
 func (h *Handler) clearSessionCookie(c *gin.Context) {
 	c.SetCookie(
@@ -677,3 +798,61 @@ func (h *Handler) clearSessionCookie(c *gin.Context) {
 		true,
 	)
 }
+
+// UpdateUserRoleRequest represents the request body for role changes
+type UpdateUserRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// ListUsers returns every user with its role
+func (h *Handler) ListUsers(c *gin.Context) {
+	users, err := h.service.ListUsers()
+	if err != nil {
+		logrus.Errorf("Failed to list users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// UpdateUserRole changes the role of a user
+func (h *Handler) UpdateUserRole(c *gin.Context) {
+	admin, exists := CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req UpdateUserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.SetUserRole(admin.ID, userID, Role(req.Role))
+	switch {
+	case errors.Is(err, ErrInvalidRole), errors.Is(err, ErrOwnRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to update user role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	logrus.Infof("User %s changed the role of %s to %s", admin.Email, user.Email, user.Role)
+	c.JSON(http.StatusOK, gin.H{
+		"user":    user,
+		"message": "Role updated successfully",
+	})
+}
@@ -0,0 +1,535 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sqlc "github.com/browsersec/KubeBrowse/db/sqlc"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled      = errors.New("two-factor authentication enrollment was not started")
+	ErrMFAUnsupported      = errors.New("two-factor authentication is only available to email accounts")
+	ErrMFARequiredByRole   = errors.New("two-factor authentication is required for this role")
+	ErrInvalidMFACode      = errors.New("invalid two-factor authentication code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired two-factor authentication challenge")
+	ErrMFALocked           = errors.New("too many invalid two-factor authentication codes, try again later")
+)
+
+const (
+	// mfaChallengeTTL is how long users have to enter their code after their password
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaChallengeAttempts is how many wrong codes a challenge accepts before it is dropped
+	mfaChallengeAttempts = 5
+	// mfaLockoutFailures is how many wrong codes in a row, over all the challenges of a user,
+	// lock their second factor for mfaLockoutDuration
+	mfaLockoutFailures = 10
+	mfaLockoutDuration = 15 * time.Minute
+)
+
+// MFAChallenge is returned instead of a session by logins that need a second factor
+type MFAChallenge struct {
+	Token     string    `json:"mfa_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// EnrollmentRequired is set when the role of the user requires a second factor they
+	// have not set up yet, they enroll with the challenge before completing the login
+	EnrollmentRequired bool `json:"enrollment_required"`
+}
+
+// MFAEnrollment is the TOTP secret of a pending enrollment
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth URI to render as a QR code
+}
+
+// MFAStatus describes the second factor of a user
+type MFAStatus struct {
+	Enabled                bool  `json:"enabled"`
+	Required               bool  `json:"required"` // Whether the role of the user requires it
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+}
+
+// loginChallenge returns a challenge for users who signed in with their password and have a
+// second factor or a role that requires one, nil for the others
+func (s *Service) loginChallenge(dbUser sqlc.User) (*MFAChallenge, error) {
+	mfa, err := s.db.GetUserMFA(s.ctx, dbUser.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get two-factor settings: %w", err)
+	}
+	enabled := err == nil && mfa.Enabled
+
+	if !enabled {
+		required, err := s.db.IsMFARequiredForRole(s.ctx, dbUser.Role)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if two-factor authentication is required: %w", err)
+		}
+		if !required {
+			return nil, nil
+		}
+	}
+
+	token, err := s.generateSessionToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate challenge token: %w", err)
+	}
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+	err = s.db.CreateMFAChallenge(s.ctx, sqlc.CreateMFAChallengeParams{
+		TokenHash: hashSecretToken(token),
+		UserID:    dbUser.ID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create challenge: %w", err)
+	}
+
+	return &MFAChallenge{
+		Token:              token,
+		ExpiresAt:          expiresAt,
+		EnrollmentRequired: !enabled,
+	}, nil
+}
+
+// challengeUser returns the user of a pending login challenge
+func (s *Service) challengeUser(token string) (sqlc.User, error) {
+	challenge, err := s.db.GetMFAChallenge(s.ctx, hashSecretToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sqlc.User{}, ErrInvalidMFAChallenge
+		}
+		return sqlc.User{}, fmt.Errorf("failed to get challenge: %w", err)
+	}
+
+	dbUser, err := s.db.GetUser(s.ctx, challenge.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sqlc.User{}, ErrInvalidMFAChallenge
+		}
+		return sqlc.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	return dbUser, nil
+}
+
+// BeginChallengeEnrollment starts the enrollment of a user whose role requires a second
+// factor they have not set up, from the challenge of their login
+func (s *Service) BeginChallengeEnrollment(token string) (*MFAEnrollment, error) {
+	dbUser, err := s.challengeUser(token)
+	if err != nil {
+		return nil, err
+	}
+	return s.beginEnrollment(dbUser.ID, dbUser.Email)
+}
+
+// CompleteMFALogin signs in the user of a login challenge with a code of their
+// authenticator, or one of their recovery codes. Users who enrolled during the login get
+// their recovery codes.
+func (s *Service) CompleteMFALogin(token, code, recoveryCode string) (*User, *Session, []string, error) {
+	dbUser, err := s.challengeUser(token)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	mfa, err := s.db.GetUserMFA(s.ctx, dbUser.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, nil, ErrMFANotEnrolled
+		}
+		return nil, nil, nil, fmt.Errorf("failed to get two-factor settings: %w", err)
+	}
+
+	if err = checkMFALockout(mfa, time.Now()); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var recoveryCodes []string
+	switch {
+	case mfa.Enabled && recoveryCode != "":
+		err = s.useRecoveryCode(dbUser.ID, recoveryCode)
+	case mfa.Enabled:
+		err = s.verifyTOTP(mfa, code)
+	default:
+		recoveryCodes, err = s.enableMFA(mfa, code)
+	}
+	if errors.Is(err, ErrInvalidMFACode) {
+		s.failChallenge(token)
+		s.failMFA(dbUser.ID)
+		return nil, nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	s.resetMFAFailures(mfa)
+
+	// challenges are single use
+	if err = s.db.DeleteMFAChallenge(s.ctx, hashSecretToken(token)); err != nil {
+		logrus.Warnf("Failed to delete two-factor challenge of %s: %v", dbUser.Email, err)
+	}
+
+	session, err := s.CreateSession(dbUser.ID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	user := s.convertDBUser(dbUser)
+	session.User = user
+
+	return user, session, recoveryCodes, nil
+}
+
+// failChallenge counts a wrong code entered for a challenge, which is dropped after too many
+func (s *Service) failChallenge(token string) {
+	tokenHash := hashSecretToken(token)
+	attempts, err := s.db.IncrementMFAChallengeAttempts(s.ctx, tokenHash)
+	if err != nil {
+		logrus.Warnf("Failed to count two-factor challenge attempt: %v", err)
+		return
+	}
+	if attempts >= mfaChallengeAttempts {
+		if err = s.db.DeleteMFAChallenge(s.ctx, tokenHash); err != nil {
+			logrus.Warnf("Failed to delete two-factor challenge: %v", err)
+		}
+	}
+}
+
+// mfaFailureQueries are the queries counting the wrong codes of users
+type mfaFailureQueries interface {
+	IncrementMFAFailures(ctx context.Context, userID uuid.UUID) (int32, error)
+	LockUserMFA(ctx context.Context, arg sqlc.LockUserMFAParams) error
+}
+
+// recordMFAFailure counts a wrong code of a user, whatever challenge it was entered for,
+// and locks their second factor once there were mfaLockoutFailures in a row. Dropping a
+// challenge after a few attempts alone would let users get new ones by signing in again.
+func recordMFAFailure(ctx context.Context, queries mfaFailureQueries, userID uuid.UUID, now time.Time) error {
+	failures, err := queries.IncrementMFAFailures(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to count two-factor failure: %w", err)
+	}
+	if failures < mfaLockoutFailures {
+		return nil
+	}
+	err = queries.LockUserMFA(ctx, sqlc.LockUserMFAParams{
+		UserID:      userID,
+		LockedUntil: sql.NullTime{Time: now.Add(mfaLockoutDuration), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to lock two-factor authentication: %w", err)
+	}
+	return nil
+}
+
+// checkMFALockout refuses the codes of a second factor that is locked
+func checkMFALockout(mfa sqlc.UserMfa, now time.Time) error {
+	if mfa.LockedUntil.Valid && now.Before(mfa.LockedUntil.Time) {
+		return ErrMFALocked
+	}
+	return nil
+}
+
+// failMFA counts a wrong code of a user, see recordMFAFailure
+func (s *Service) failMFA(userID uuid.UUID) {
+	if err := recordMFAFailure(s.ctx, s.db, userID, time.Now()); err != nil {
+		logrus.Warnf("Failed to count two-factor failure of user %s: %v", userID, err)
+	}
+}
+
+// resetMFAFailures forgets the wrong codes of a user who entered a valid one
+func (s *Service) resetMFAFailures(mfa sqlc.UserMfa) {
+	if mfa.FailedAttempts == 0 {
+		return
+	}
+	if err := s.db.ResetMFAFailures(s.ctx, mfa.UserID); err != nil {
+		logrus.Warnf("Failed to reset two-factor failures of user %s: %v", mfa.UserID, err)
+	}
+}
+
+// BeginMFAEnrollment starts the enrollment of a second factor for a signed in user, it is
+// enabled once a code of the authenticator is confirmed
+func (s *Service) BeginMFAEnrollment(user *User) (*MFAEnrollment, error) {
+	if user.Provider != "email" {
+		return nil, ErrMFAUnsupported
+	}
+	return s.beginEnrollment(user.ID, user.Email)
+}
+
+func (s *Service) beginEnrollment(userID uuid.UUID, email string) (*MFAEnrollment, error) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+
+	updated, err := s.db.UpsertUserMFASecret(s.ctx, sqlc.UpsertUserMFASecretParams{
+		UserID:     userID,
+		TotpSecret: secret,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save TOTP secret: %w", err)
+	}
+	if updated == 0 {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	return &MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(s.mfaIssuer, email, secret),
+	}, nil
+}
+
+// ConfirmMFAEnrollment enables the second factor of a user with a code of their
+// authenticator and returns their recovery codes
+func (s *Service) ConfirmMFAEnrollment(userID uuid.UUID, code string) ([]string, error) {
+	mfa, err := s.db.GetUserMFA(s.ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMFANotEnrolled
+		}
+		return nil, fmt.Errorf("failed to get two-factor settings: %w", err)
+	}
+	if mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	return s.enableMFA(mfa, code)
+}
+
+// enableMFA enables a pending enrollment if the code matches its secret
+func (s *Service) enableMFA(mfa sqlc.UserMfa, code string) ([]string, error) {
+	step, ok := validateTOTP(mfa.TotpSecret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	var recoveryCodes []string
+	err := s.inTx(func(queries *sqlc.Queries) error {
+		err := queries.EnableUserMFA(s.ctx, sqlc.EnableUserMFAParams{
+			UserID:       mfa.UserID,
+			LastUsedStep: step,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to enable two-factor authentication: %w", err)
+		}
+		recoveryCodes, err = s.replaceRecoveryCodes(queries, mfa.UserID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// MFAStatus returns whether a user has a second factor and whether their role requires one
+func (s *Service) MFAStatus(user *User) (*MFAStatus, error) {
+	required, err := s.db.IsMFARequiredForRole(s.ctx, string(user.Role))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if two-factor authentication is required: %w", err)
+	}
+	status := &MFAStatus{Required: required}
+
+	mfa, err := s.db.GetUserMFA(s.ctx, user.ID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !mfa.Enabled) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get two-factor settings: %w", err)
+	}
+	status.Enabled = true
+
+	status.RecoveryCodesRemaining, err = s.db.CountUnusedRecoveryCodes(s.ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return status, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user, who confirms it with a
+// code of their authenticator
+func (s *Service) RegenerateRecoveryCodes(userID uuid.UUID, code string) ([]string, error) {
+	mfa, err := s.enabledMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.confirmTOTP(mfa, code); err != nil {
+		return nil, err
+	}
+
+	var recoveryCodes []string
+	err = s.inTx(func(queries *sqlc.Queries) error {
+		recoveryCodes, err = s.replaceRecoveryCodes(queries, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// DisableMFA removes the second factor of a user, who confirms it with a code of their
+// authenticator. Users whose role requires a second factor cannot remove it.
+func (s *Service) DisableMFA(user *User, code string) error {
+	mfa, err := s.enabledMFA(user.ID)
+	if err != nil {
+		return err
+	}
+	required, err := s.db.IsMFARequiredForRole(s.ctx, string(user.Role))
+	if err != nil {
+		return fmt.Errorf("failed to check if two-factor authentication is required: %w", err)
+	}
+	if required {
+		return ErrMFARequiredByRole
+	}
+	if err = s.confirmTOTP(mfa, code); err != nil {
+		return err
+	}
+
+	return s.inTx(func(queries *sqlc.Queries) error {
+		if err := queries.DeleteRecoveryCodes(s.ctx, user.ID); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		if err := queries.DeleteUserMFA(s.ctx, user.ID); err != nil {
+			return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListMFARequiredRoles returns the roles whose users must sign in with a second factor
+func (s *Service) ListMFARequiredRoles() ([]Role, error) {
+	names, err := s.db.ListMFARequiredRoles(s.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles requiring two-factor authentication: %w", err)
+	}
+	roles := make([]Role, 0, len(names))
+	for _, name := range names {
+		roles = append(roles, Role(name))
+	}
+	return roles, nil
+}
+
+// SetMFARequired sets whether users of a role must sign in with a second factor. Users
+// without one are asked to enroll at their next login.
+func (s *Service) SetMFARequired(role Role, required bool) error {
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+
+	var err error
+	if required {
+		err = s.db.AddMFARequiredRole(s.ctx, string(role))
+	} else {
+		err = s.db.RemoveMFARequiredRole(s.ctx, string(role))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update roles requiring two-factor authentication: %w", err)
+	}
+	return nil
+}
+
+// enabledMFA returns the enabled second factor of a user
+func (s *Service) enabledMFA(userID uuid.UUID) (sqlc.UserMfa, error) {
+	mfa, err := s.db.GetUserMFA(s.ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !mfa.Enabled) {
+		return sqlc.UserMfa{}, ErrMFANotEnabled
+	}
+	if err != nil {
+		return sqlc.UserMfa{}, fmt.Errorf("failed to get two-factor settings: %w", err)
+	}
+	return mfa, nil
+}
+
+// confirmTOTP checks a code a signed in user confirms a change of their second factor with,
+// counting wrong codes like those of logins
+func (s *Service) confirmTOTP(mfa sqlc.UserMfa, code string) error {
+	if err := checkMFALockout(mfa, time.Now()); err != nil {
+		return err
+	}
+	err := s.verifyTOTP(mfa, code)
+	if errors.Is(err, ErrInvalidMFACode) {
+		s.failMFA(mfa.UserID)
+	} else if err == nil {
+		s.resetMFAFailures(mfa)
+	}
+	return err
+}
+
+// verifyTOTP checks a code of the authenticator of a user and marks its time step used
+func (s *Service) verifyTOTP(mfa sqlc.UserMfa, code string) error {
+	step, ok := validateTOTP(mfa.TotpSecret, code, time.Now(), mfa.LastUsedStep)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	updated, err := s.db.UpdateUserMFALastUsedStep(s.ctx, sqlc.UpdateUserMFALastUsedStepParams{
+		UserID:       mfa.UserID,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record TOTP use: %w", err)
+	}
+	if updated == 0 {
+		// another request used the code first
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// useRecoveryCode consumes one of the recovery codes of a user
+func (s *Service) useRecoveryCode(userID uuid.UUID, code string) error {
+	used, err := s.db.UseRecoveryCode(s.ctx, sqlc.UseRecoveryCodeParams{
+		UserID:   userID,
+		CodeHash: hashSecretToken(code),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if used == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes generates new recovery codes for a user, only their hashes are kept
+func (s *Service) replaceRecoveryCodes(queries *sqlc.Queries, userID uuid.UUID) ([]string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+
+	if err = queries.DeleteRecoveryCodes(s.ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	for _, code := range codes {
+		err = queries.CreateRecoveryCode(s.ctx, sqlc.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: hashSecretToken(code),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to save recovery code: %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// inTx runs queries in a transaction, which is committed if fn succeeds
+func (s *Service) inTx(fn func(queries *sqlc.Queries) error) error {
+	tx, err := s.dbConn.BeginTx(s.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			logrus.Warnf("Failed to roll back transaction: %v", err)
+		}
+	}()
+
+	if err = fn(s.db.WithTx(tx)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
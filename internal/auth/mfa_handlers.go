@@ -0,0 +1,269 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/browsersec/KubeBrowse/internal/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// MFAChallengeRequest represents the request body for the second step of a login
+type MFAChallengeRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAChallengeEnrollRequest represents the request body for enrolling during a login
+type MFAChallengeEnrollRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// MFACodeRequest represents the request body of actions confirmed with an authenticator code
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// UpdateMFARoleRequest represents the request body for requiring a second factor of a role
+type UpdateMFARoleRequest struct {
+	Required *bool `json:"required" binding:"required"`
+}
+
+// respondMFAError answers the errors of two-factor authentication requests
+func respondMFAError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidMFAChallenge):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "code": "MFA_CHALLENGE_EXPIRED"})
+	case errors.Is(err, ErrMFALocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrMFAAlreadyEnabled), errors.Is(err, ErrMFARequiredByRole):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrMFANotEnabled), errors.Is(err, ErrMFANotEnrolled), errors.Is(err, ErrMFAUnsupported):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logrus.Errorf("Failed to %s: %v", action, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action})
+	}
+}
+
+// CompleteMFALogin handles the second step of a login, the session cookie is set once the
+// code of the authenticator or a recovery code is verified
+func (h *Handler) CompleteMFALogin(c *gin.Context) {
+	var req MFAChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code or recovery_code is required"})
+		return
+	}
+
+	user, session, recoveryCodes, err := h.service.CompleteMFALogin(req.MFAToken, req.Code, req.RecoveryCode)
+	metrics.Logins.WithLabelValues("mfa", mfaLoginResult(err)).Inc()
+	if err != nil {
+		respondMFAError(c, err, "complete login")
+		return
+	}
+	if req.RecoveryCode != "" {
+		logrus.Infof("User %s signed in with a recovery code", user.Email)
+	}
+
+	h.setSessionCookie(c, session.SessionToken)
+
+	response := gin.H{
+		"user":    user,
+		"message": "Login successful",
+	}
+	// users who enrolled while signing in only see their recovery codes now
+	if recoveryCodes != nil {
+		response["recovery_codes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// mfaLoginResult returns the result label of the second step of a login
+func mfaLoginResult(err error) string {
+	switch {
+	case err == nil:
+		return metrics.ResultSuccess
+	case errors.Is(err, ErrInvalidMFACode):
+		return "invalid_code"
+	case errors.Is(err, ErrInvalidMFAChallenge):
+		return "invalid_challenge"
+	case errors.Is(err, ErrMFALocked):
+		return "locked"
+	default:
+		return metrics.ResultFailure
+	}
+}
+
+// BeginChallengeEnrollment starts the enrollment of a user whose role requires a second
+// factor, with the challenge of their login
+func (h *Handler) BeginChallengeEnrollment(c *gin.Context) {
+	var req MFAChallengeEnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	enrollment, err := h.service.BeginChallengeEnrollment(req.MFAToken)
+	if err != nil {
+		respondMFAError(c, err, "start two-factor enrollment")
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// GetMFAStatus returns whether the current user has a second factor
+func (h *Handler) GetMFAStatus(c *gin.Context) {
+	user, exists := CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	status, err := h.service.MFAStatus(user)
+	if err != nil {
+		respondMFAError(c, err, "get two-factor status")
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// BeginMFAEnrollment returns a new TOTP secret for the current user and its provisioning URI
+func (h *Handler) BeginMFAEnrollment(c *gin.Context) {
+	user, exists := CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	enrollment, err := h.service.BeginMFAEnrollment(user)
+	if err != nil {
+		respondMFAError(c, err, "start two-factor enrollment")
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmMFAEnrollment enables the second factor of the current user and returns their
+// recovery codes, which are not shown again
+func (h *Handler) ConfirmMFAEnrollment(c *gin.Context) {
+	user, exists := CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recoveryCodes, err := h.service.ConfirmMFAEnrollment(user.ID, req.Code)
+	if err != nil {
+		respondMFAError(c, err, "enable two-factor authentication")
+		return
+	}
+
+	logrus.Infof("User %s enabled two-factor authentication", user.Email)
+	c.JSON(http.StatusOK, gin.H{
+		"recovery_codes": recoveryCodes,
+		"message":        "Two-factor authentication enabled",
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	user, exists := CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recoveryCodes, err := h.service.RegenerateRecoveryCodes(user.ID, req.Code)
+	if err != nil {
+		respondMFAError(c, err, "regenerate recovery codes")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+// DisableMFA removes the second factor of the current user
+func (h *Handler) DisableMFA(c *gin.Context) {
+	user, exists := CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.DisableMFA(user, req.Code); err != nil {
+		respondMFAError(c, err, "disable two-factor authentication")
+		return
+	}
+
+	logrus.Infof("User %s disabled two-factor authentication", user.Email)
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// ListMFARoles returns the roles whose users must sign in with a second factor
+func (h *Handler) ListMFARoles(c *gin.Context) {
+	roles, err := h.service.ListMFARequiredRoles()
+	if err != nil {
+		logrus.Errorf("Failed to list roles requiring two-factor authentication: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list roles"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// UpdateMFARole sets whether users of a role must sign in with a second factor
+func (h *Handler) UpdateMFARole(c *gin.Context) {
+	admin, exists := CurrentUser(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	var req UpdateMFARoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := Role(c.Param("role"))
+	err := h.service.SetMFARequired(role, *req.Required)
+	if errors.Is(err, ErrInvalidRole) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to update two-factor requirement of %s: %v", role, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	logrus.Infof("User %s set two-factor authentication required=%v for role %s", admin.Email, *req.Required, role)
+	c.JSON(http.StatusOK, gin.H{
+		"role":     role,
+		"required": *req.Required,
+	})
+}
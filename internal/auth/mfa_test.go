@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	sqlc "github.com/browsersec/KubeBrowse/db/sqlc"
+	"github.com/google/uuid"
+)
+
+// fakeMFAFailures keeps the failure count of one user like the user_mfa table
+type fakeMFAFailures struct {
+	mfa sqlc.UserMfa
+}
+
+func (f *fakeMFAFailures) IncrementMFAFailures(ctx context.Context, userID uuid.UUID) (int32, error) {
+	f.mfa.FailedAttempts++
+	return f.mfa.FailedAttempts, nil
+}
+
+func (f *fakeMFAFailures) LockUserMFA(ctx context.Context, arg sqlc.LockUserMFAParams) error {
+	f.mfa.FailedAttempts = 0
+	f.mfa.LockedUntil = arg.LockedUntil
+	return nil
+}
+
+func TestMFALockoutSpansChallenges(t *testing.T) {
+	now := time.Now()
+	queries := &fakeMFAFailures{mfa: sqlc.UserMfa{UserID: uuid.New()}}
+
+	// every challenge is dropped after its attempts, the attacker signs in again for the next
+	challenges := mfaLockoutFailures / mfaChallengeAttempts
+	for challenge := 0; challenge < challenges; challenge++ {
+		for attempt := 0; attempt < mfaChallengeAttempts; attempt++ {
+			if err := checkMFALockout(queries.mfa, now); err != nil {
+				t.Fatalf("locked at attempt %d of challenge %d: %v", attempt, challenge, err)
+			}
+			if err := recordMFAFailure(context.Background(), queries, queries.mfa.UserID, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := checkMFALockout(queries.mfa, now); !errors.Is(err, ErrMFALocked) {
+		t.Fatalf("after %d challenges checkMFALockout()=%v, want %v", challenges, err, ErrMFALocked)
+	}
+	if err := checkMFALockout(queries.mfa, now.Add(mfaLockoutDuration)); err != nil {
+		t.Errorf("checkMFALockout()=%v once the lockout passed, want nil", err)
+	}
+	if queries.mfa.FailedAttempts != 0 {
+		t.Errorf("FailedAttempts=%d after the lockout, want 0", queries.mfa.FailedAttempts)
+	}
+}
@@ -10,43 +10,53 @@ import (
 // AuthMiddleware is a middleware that validates user sessions
 func AuthMiddleware(service *Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get session token from cookie
-		sessionToken, err := c.Cookie(SessionCookieName)
-		if err != nil {
-			logrus.Debugf("AuthMiddleware: No session cookie found: %v", err)
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
-			c.Abort()
+		if _, ok := authenticate(c, service); !ok {
 			return
 		}
 
-		logrus.Debugf("AuthMiddleware: Found session token: %s", sessionToken)
+		c.Next()
+	}
+}
 
-		// Validate session
-		logrus.Debugf("AuthMiddleware: Validating session token: %s", sessionToken)
-		user, session, err := service.ValidateSession(sessionToken)
-		if err != nil {
-			if err == ErrSessionExpired {
-				logrus.Debugf("AuthMiddleware: Session expired for token: %s", sessionToken)
-				// Clear invalid cookie
-				c.SetCookie(SessionCookieName, "", -1, "/", "", false, true)
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
-				c.Abort()
-				return
-			}
-			logrus.Errorf("Session validation error: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Session validation failed"})
+// authenticate validates the session of a request and sets its user in the context. The
+// request is aborted if it has no valid session.
+func authenticate(c *gin.Context, service *Service) (*User, bool) {
+	// Get session token from cookie
+	sessionToken, err := c.Cookie(SessionCookieName)
+	if err != nil {
+		logrus.Debugf("AuthMiddleware: No session cookie found: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		c.Abort()
+		return nil, false
+	}
+
+	logrus.Debugf("AuthMiddleware: Found session token: %s", sessionToken)
+
+	// Validate session
+	logrus.Debugf("AuthMiddleware: Validating session token: %s", sessionToken)
+	user, session, err := service.ValidateSession(sessionToken)
+	if err != nil {
+		if err == ErrSessionExpired {
+			logrus.Debugf("AuthMiddleware: Session expired for token: %s", sessionToken)
+			// Clear invalid cookie
+			c.SetCookie(SessionCookieName, "", -1, "/", "", false, true)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
 			c.Abort()
-			return
+			return nil, false
 		}
+		logrus.Errorf("Session validation error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Session validation failed"})
+		c.Abort()
+		return nil, false
+	}
 
-		logrus.Debugf("AuthMiddleware: Session validated successfully for user: %s", user.Email)
+	logrus.Debugf("AuthMiddleware: Session validated successfully for user: %s", user.Email)
 
-		// Set user in context
-		c.Set(UserContextKey, user)
-		c.Set("session", session)
+	// Set user in context
+	setCurrentUser(c, user)
+	c.Set("session", session)
 
-		c.Next()
-	}
+	return user, true
 }
 
 // OptionalAuthMiddleware is a middleware that optionally validates user sessions
@@ -74,7 +84,7 @@ func OptionalAuthMiddleware(service *Service) gin.HandlerFunc {
 		}
 
 		// Set user in context
-		c.Set(UserContextKey, user)
+		setCurrentUser(c, user)
 		c.Set("session", session)
 
 		c.Next()
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	sqlc "github.com/browsersec/KubeBrowse/db/sqlc"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidResetToken    = errors.New("invalid or expired password reset token")
+	ErrTooManyResetRequests = errors.New("too many password reset requests")
+)
+
+const (
+	// passwordResetTTL is how long the link of a password reset email can be used
+	passwordResetTTL = time.Hour
+	// passwordResetWindow is the period over which password reset requests are counted
+	passwordResetWindow = time.Hour
+	// passwordResetEmailLimit is how many resets of an email are accepted in the window
+	passwordResetEmailLimit = 3
+	// passwordResetIPLimit is how many resets a client address may request in the window
+	passwordResetIPLimit = 10
+)
+
+// RequestPasswordReset emails a password reset link to the account of an email. Emails
+// without an account or without a password succeed silently, not to reveal which emails
+// have an account.
+func (s *Service) RequestPasswordReset(email, ipAddress string) error {
+	if !s.emailService.IsConfigured() {
+		return fmt.Errorf("email service not configured")
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	since := time.Now().Add(-passwordResetWindow)
+
+	var recorded int64
+	err := s.inTx(func(queries *sqlc.Queries) error {
+		err := queries.LockPasswordResetRequests(s.ctx, sqlc.LockPasswordResetRequestsParams{
+			Email:     email,
+			IpAddress: ipAddress,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to lock password reset requests: %w", err)
+		}
+		recorded, err = queries.CreatePasswordResetRequestWithinLimits(s.ctx, sqlc.CreatePasswordResetRequestWithinLimitsParams{
+			Email:      email,
+			IpAddress:  ipAddress,
+			Since:      since,
+			EmailLimit: passwordResetEmailLimit,
+			IpLimit:    passwordResetIPLimit,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record password reset request: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if recorded == 0 {
+		logrus.Warnf("Password reset of %s from %s rate limited", email, ipAddress)
+		return ErrTooManyResetRequests
+	}
+
+	dbUser, err := s.db.GetUserByEmail(s.ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		logrus.Infof("Password reset requested for unknown email %s", email)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	// OAuth users sign in with their provider and have no password to reset
+	if !dbUser.PasswordHash.Valid {
+		logrus.Infof("Password reset requested for %s, which has no password", email)
+		return nil
+	}
+
+	token, err := s.generateVerificationToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate password reset token: %w", err)
+	}
+	err = s.db.CreatePasswordResetToken(s.ctx, sqlc.CreatePasswordResetTokenParams{
+		TokenHash: hashSecretToken(token),
+		UserID:    dbUser.ID,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create password reset token: %w", err)
+	}
+
+	name := dbUser.Email
+	if dbUser.Name.Valid {
+		name = dbUser.Name.String
+	}
+
+	// the email is sent in the background so that the response time does not tell
+	// whether the email has an account
+	go func() {
+		if err := s.emailService.SendPasswordResetEmail(dbUser.Email, name, token); err != nil {
+			logrus.Errorf("Failed to send password reset email to %s: %v", dbUser.Email, err)
+		}
+	}()
+
+	return nil
+}
+
+// ResetPassword sets a new password with the token of a password reset email. The token
+// and the other pending tokens of the user are dropped, and every session of the user is
+// signed out.
+func (s *Service) ResetPassword(token, newPassword string) (*User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	var dbUser sqlc.User
+	err = s.inTx(func(queries *sqlc.Queries) error {
+		userID, err := queries.UsePasswordResetToken(s.ctx, hashSecretToken(token))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return fmt.Errorf("failed to use password reset token: %w", err)
+		}
+
+		dbUser, err = queries.UpdateUserPassword(s.ctx, sqlc.UpdateUserPasswordParams{
+			ID:           userID,
+			PasswordHash: sql.NullString{String: string(hashedPassword), Valid: true},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+
+		if err = queries.DeleteUserPasswordResetTokens(s.ctx, userID); err != nil {
+			return fmt.Errorf("failed to delete password reset tokens: %w", err)
+		}
+		if err = queries.DeleteUserSessions(s.ctx, userID); err != nil {
+			return fmt.Errorf("failed to delete sessions: %w", err)
+		}
+		// challenges were opened with the old password
+		if err = queries.DeleteUserMFAChallenges(s.ctx, userID); err != nil {
+			return fmt.Errorf("failed to delete two-factor challenges: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.convertDBUser(dbUser), nil
+}
+
+// cleanupPasswordResets removes expired reset tokens and requests that no longer count
+// towards the rate limits
+func (s *Service) cleanupPasswordResets() error {
+	if err := s.db.DeleteExpiredPasswordResetTokens(s.ctx); err != nil {
+		return fmt.Errorf("failed to cleanup expired password reset tokens: %w", err)
+	}
+	if err := s.db.DeletePasswordResetRequestsBefore(s.ctx, time.Now().Add(-passwordResetWindow)); err != nil {
+		return fmt.Errorf("failed to cleanup password reset requests: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	sqlc "github.com/browsersec/KubeBrowse/db/sqlc"
+	"github.com/google/uuid"
+)
+
+var userColumns = []string{"id", "username", "email", "password_hash", "provider", "provider_id", "avatar_url", "name",
+	"email_verified", "email_verification_token", "email_verification_expires_at", "created_at", "updated_at", "role"}
+
+// newMockService returns a service on a mocked database with a configured email service
+func newMockService(t *testing.T) (*Service, sqlmock.Sqlmock) {
+	t.Helper()
+	// reset emails are sent in the background, to a server that refuses them
+	t.Setenv("SMTP_HOST", "127.0.0.1")
+	t.Setenv("SMTP_PORT", "1")
+	t.Setenv("SMTP_USERNAME", "kubebrowse")
+	t.Setenv("SMTP_PASSWORD", "secret")
+	t.Setenv("FROM_EMAIL", "noreply@example.com")
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New()=%v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return NewService(sqlc.New(db), db), mock
+}
+
+func userRow(id uuid.UUID, email string) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows(userColumns).AddRow(id, nil, email, "$2a$10$hash", "email", nil, nil, nil,
+		true, nil, nil, now, now, string(RoleUser))
+}
+
+// fromNow matches a time about d from now
+type fromNow time.Duration
+
+func (d fromNow) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && time.Until(t) > time.Duration(d)-time.Minute && time.Until(t) <= time.Duration(d)
+}
+
+func TestRequestPasswordResetRateLimits(t *testing.T) {
+	service, mock := newMockService(t)
+
+	// the database counts the requests of each email and address within the window
+	emails, addresses := map[string]int{}, map[string]int{}
+	var requests []struct{ email, ip string }
+	for i := 0; i < 4; i++ {
+		requests = append(requests, struct{ email, ip string }{" Reset@Example.com", fmt.Sprintf("192.0.2.%d", i)})
+	}
+	for i := 0; i < 11; i++ {
+		requests = append(requests, struct{ email, ip string }{fmt.Sprintf("user%d@example.com", i), "198.51.100.1"})
+	}
+
+	for i, request := range requests {
+		email := strings.ToLower(strings.TrimSpace(request.email))
+		recorded := emails[email] < passwordResetEmailLimit && addresses[request.ip] < passwordResetIPLimit
+		if recorded {
+			emails[email]++
+			addresses[request.ip]++
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("pg_advisory_xact_lock").WithArgs(email, request.ip).WillReturnResult(sqlmock.NewResult(0, 1))
+		rows := int64(0)
+		if recorded {
+			rows = 1
+		}
+		mock.ExpectExec("INSERT INTO password_reset_requests").
+			WithArgs(email, request.ip, fromNow(-passwordResetWindow), passwordResetEmailLimit, passwordResetIPLimit).
+			WillReturnResult(sqlmock.NewResult(0, rows))
+		mock.ExpectCommit()
+		if recorded {
+			mock.ExpectQuery("FROM users").WithArgs(email).WillReturnError(sql.ErrNoRows)
+		}
+
+		err := service.RequestPasswordReset(request.email, request.ip)
+		switch {
+		case recorded && err != nil:
+			t.Errorf("request %d of %s from %s: %v", i, email, request.ip, err)
+		case !recorded && !errors.Is(err, ErrTooManyResetRequests):
+			t.Errorf("request %d of %s from %s=%v, want %v", i, email, request.ip, err, ErrTooManyResetRequests)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRequestPasswordResetTokenExpires(t *testing.T) {
+	service, mock := newMockService(t)
+	userID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO password_reset_requests").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("FROM users").WithArgs("reset@example.com").WillReturnRows(userRow(userID, "reset@example.com"))
+	mock.ExpectExec("INSERT INTO password_reset_tokens").
+		WithArgs(sqlmock.AnyArg(), userID, fromNow(passwordResetTTL)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := service.RequestPasswordReset("reset@example.com", "192.0.2.1"); err != nil {
+		t.Fatalf("RequestPasswordReset()=%v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	service, mock := newMockService(t)
+	userID := uuid.New()
+	token := "reset-token"
+
+	// the token is deleted when it is used, and only while it has not expired
+	useToken := `DELETE FROM password_reset_tokens\s+WHERE token_hash = \$1 AND expires_at > NOW\(\)\s+RETURNING user_id`
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(useToken).WithArgs(hashSecretToken(token)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(userID))
+	mock.ExpectQuery("UPDATE users").WithArgs(userID, sqlmock.AnyArg()).WillReturnRows(userRow(userID, "reset@example.com"))
+	mock.ExpectExec("DELETE FROM password_reset_tokens").WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM user_sessions").WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM mfa_challenges").WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, err := service.ResetPassword(token, "new password")
+	if err != nil {
+		t.Fatalf("ResetPassword()=%v", err)
+	}
+	if user.ID != userID {
+		t.Errorf("ResetPassword() reset the password of %s, want %s", user.ID, userID)
+	}
+
+	// a used or expired token is gone, nothing is changed
+	for _, name := range []string{"used", "expired"} {
+		mock.ExpectBegin()
+		mock.ExpectQuery(useToken).WithArgs(hashSecretToken(token)).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		if _, err = service.ResetPassword(token, "other password"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("ResetPassword() with a %s token=%v, want %v", name, err, ErrInvalidResetToken)
+		}
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResetPasswordRollsBack(t *testing.T) {
+	service, mock := newMockService(t)
+	userID := uuid.New()
+
+	// sessions must not outlive the old password, the password is only changed with them
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM password_reset_tokens").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(userID))
+	mock.ExpectQuery("UPDATE users").WillReturnRows(userRow(userID, "reset@example.com"))
+	mock.ExpectExec("DELETE FROM password_reset_tokens").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM user_sessions").WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	if _, err := service.ResetPassword("reset-token", "new password"); err == nil {
+		t.Fatal("ResetPassword() succeeded without signing out the sessions")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Role is the role of a user, which grants it a set of permissions
+type Role string
+
+// Roles of users. New users are given RoleUser.
+const (
+	RoleUser     Role = "user"
+	RoleOperator Role = "operator"
+	RoleAuditor  Role = "auditor"
+	RoleAdmin    Role = "admin"
+)
+
+// Permission allows an action on the API
+type Permission string
+
+const (
+	// PermSessionCreate allows deploying sandbox sessions
+	PermSessionCreate Permission = "session:create"
+	// PermSessionUse allows connecting to, extending and stopping sessions
+	PermSessionUse Permission = "session:use"
+	// PermSessionShare allows creating and revoking share links to sessions
+	PermSessionShare Permission = "session:share"
+	// PermSessionView allows listing the active sessions and sandbox pools
+	PermSessionView Permission = "session:view"
+	// PermSessionManage allows acting on the sessions of every user and creating bare pods
+	PermSessionManage Permission = "session:manage"
+	// PermFileUpload allows uploading files to sessions and using the file library
+	PermFileUpload Permission = "file:upload"
+	// PermRecordingView allows playing back session recordings
+	PermRecordingView Permission = "recording:view"
+	// PermAuditRead allows reading policies, the blocked request log and file reputations
+	PermAuditRead Permission = "audit:read"
+	// PermPolicyManage allows changing URL filtering policies and domain categories
+	PermPolicyManage Permission = "policy:manage"
+	// PermReputationManage allows changing the verdicts of file hashes
+	PermReputationManage Permission = "reputation:manage"
+	// PermUserManage allows listing users and changing their roles
+	PermUserManage Permission = "user:manage"
+)
+
+var ErrInvalidRole = errors.New("invalid role")
+
+var userPermissions = []Permission{
+	PermSessionCreate,
+	PermSessionUse,
+	PermSessionShare,
+	PermFileUpload,
+	PermRecordingView,
+}
+
+// rolePermissions lists the permissions granted by each role
+var rolePermissions = map[Role][]Permission{
+	RoleUser:     userPermissions,
+	RoleOperator: append([]Permission{PermSessionView, PermSessionManage}, userPermissions...),
+	RoleAuditor:  {PermSessionView, PermRecordingView, PermAuditRead},
+	RoleAdmin: {
+		PermSessionCreate,
+		PermSessionUse,
+		PermSessionShare,
+		PermSessionView,
+		PermSessionManage,
+		PermFileUpload,
+		PermRecordingView,
+		PermAuditRead,
+		PermPolicyManage,
+		PermReputationManage,
+		PermUserManage,
+	},
+}
+
+// ParseRole returns the role with the given name
+func ParseRole(name string) (Role, error) {
+	role := Role(name)
+	if _, ok := rolePermissions[role]; !ok {
+		return "", ErrInvalidRole
+	}
+	return role, nil
+}
+
+// Permissions returns the permissions granted by the role
+func (r Role) Permissions() []Permission {
+	return rolePermissions[r]
+}
+
+// Can reports whether the role grants a permission
+func (r Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the role of the user grants a permission
+func (u *User) Can(permission Permission) bool {
+	return u.Role.Can(permission)
+}
+
+// RequirePermission is a middleware that only lets through users whose role grants a
+// permission. Users already set in the context by another middleware are not validated again.
+func RequirePermission(service *Service, permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := CurrentUser(c)
+		if !ok {
+			if user, ok = authenticate(c, service); !ok {
+				return
+			}
+		}
+
+		if !user.Can(permission) {
+			logrus.Warnf("RequirePermission: user %s with role %s denied %s on %s %s",
+				user.Email, user.Role, permission, c.Request.Method, c.FullPath())
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied", "permission": permission})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// CurrentUser returns the user a middleware authenticated for the request
+func CurrentUser(c *gin.Context) (*User, bool) {
+	value, exists := c.Get(UserContextKey)
+	if !exists {
+		return nil, false
+	}
+	user, ok := value.(*User)
+	return user, ok
+}
+
+// requestUserKey is the key of the authenticated user in the context of a request
+type requestUserKey struct{}
+
+// setCurrentUser sets the authenticated user of a request. It is also set in the context
+// of the http.Request, for handlers that only get the request such as the tunnel servers.
+func setCurrentUser(c *gin.Context, user *User) {
+	c.Set(UserContextKey, user)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestUserKey{}, user))
+}
+
+// RequestUser returns the user a middleware authenticated for an http.Request
+func RequestUser(r *http.Request) (*User, bool) {
+	user, ok := r.Context().Value(requestUserKey{}).(*User)
+	return user, ok
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRolePermissions(t *testing.T) {
+	for _, test := range []struct {
+		role       Role
+		permission Permission
+		want       bool
+	}{
+		{RoleUser, PermSessionCreate, true},
+		{RoleUser, PermSessionManage, false},
+		{RoleUser, PermAuditRead, false},
+		{RoleOperator, PermSessionManage, true},
+		{RoleOperator, PermPolicyManage, false},
+		{RoleAuditor, PermAuditRead, true},
+		{RoleAuditor, PermSessionCreate, false},
+		{RoleAdmin, PermUserManage, true},
+		{Role(""), PermSessionUse, false},
+	} {
+		if got := test.role.Can(test.permission); got != test.want {
+			t.Errorf("%q.Can(%s)=%v, want %v", test.role, test.permission, got, test.want)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	if role, err := ParseRole("auditor"); err != nil || role != RoleAuditor {
+		t.Errorf("ParseRole(auditor)=%q, %v", role, err)
+	}
+	if _, err := ParseRole("root"); err != ErrInvalidRole {
+		t.Errorf("ParseRole(root) returned %v, want ErrInvalidRole", err)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, test := range []struct {
+		user *User
+		want int
+	}{
+		{&User{Email: "user@example.com", Role: RoleUser}, http.StatusForbidden},
+		{&User{Email: "admin@example.com", Role: RoleAdmin}, http.StatusOK},
+	} {
+		router := gin.New()
+		router.GET("/admin", func(c *gin.Context) {
+			c.Set(UserContextKey, test.user)
+		}, RequirePermission(nil, PermUserManage), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin", nil))
+		if recorder.Code != test.want {
+			t.Errorf("role %s got status %d, want %d", test.user.Role, recorder.Code, test.want)
+		}
+	}
+}
+
+func TestRequirePermissionWithoutSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/sessions", RequirePermission(nil, PermSessionView), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/sessions", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("request without a session got status %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequestUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	user := &User{Email: "auditor@example.com", Role: RoleAuditor}
+	var got *User
+	router := gin.New()
+	router.GET("/websocket-tunnel", func(c *gin.Context) {
+		setCurrentUser(c, user)
+	}, gin.WrapF(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = RequestUser(r)
+	}))
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/websocket-tunnel", nil))
+	if got != user {
+		t.Errorf("RequestUser()=%v, want %v", got, user)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	sqlc "github.com/browsersec/KubeBrowse/db/sqlc"
@@ -23,6 +25,7 @@ var (
 	ErrSessionExpired     = errors.New("session expired")
 	ErrEmailNotVerified   = errors.New("email not verified")
 	ErrInvalidToken       = errors.New("invalid or expired verification token")
+	ErrOwnRole            = errors.New("users cannot change their own role")
 )
 
 type Service struct {
@@ -30,14 +33,23 @@ type Service struct {
 	dbConn       *sql.DB
 	ctx          context.Context
 	emailService *email.Service
+	adminEmails  map[string]bool
+	// mfaIssuer names the service in the authenticator apps of users
+	mfaIssuer string
 }
 
 func NewService(db *sqlc.Queries, dbConn *sql.DB) *Service {
+	mfaIssuer := os.Getenv("MFA_ISSUER")
+	if mfaIssuer == "" {
+		mfaIssuer = "KubeBrowse"
+	}
+
 	return &Service{
 		db:           db,
 		dbConn:       dbConn,
 		ctx:          context.Background(),
 		emailService: email.NewService(),
+		mfaIssuer:    mfaIssuer,
 	}
 }
 
@@ -50,6 +62,7 @@ type User struct {
 	AvatarURL     *string   `json:"avatar_url"`
 	Name          *string   `json:"name"`
 	EmailVerified bool      `json:"email_verified"`
+	Role          Role      `json:"role"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
@@ -97,6 +110,8 @@ func (s *Service) RegisterWithEmail(email, password string) (*User, error) {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
+	dbUser = s.grantAdminRole(dbUser)
+
 	// Send verification email if email service is configured
 	if s.emailService.IsConfigured() {
 		name := email // Use email as name if no name provided
@@ -109,43 +124,66 @@ func (s *Service) RegisterWithEmail(email, password string) (*User, error) {
 	return s.convertDBUser(dbUser), nil
 }
 
-// LoginWithEmail authenticates a user with email and password
-func (s *Service) LoginWithEmail(email, password string) (*User, *Session, error) {
-	// Get user by email
-	dbUser, err := s.db.GetUserByEmail(s.ctx, email)
+// LoginWithEmail authenticates a user with email and password. Users with a second factor,
+// or whose role requires one, get a challenge instead of a session until their code is verified.
+func (s *Service) LoginWithEmail(email, password string) (*User, *Session, *MFAChallenge, error) {
+	dbUser, err := s.checkPassword(email, password)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil, ErrInvalidCredentials
-		}
-		return nil, nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, nil, nil, err
 	}
 
-	// Check if user has a password (OAuth users might not have passwords)
-	if !dbUser.PasswordHash.Valid {
-		return nil, nil, ErrInvalidCredentials
+	// Check if email is verified for email-based signup
+	if dbUser.Provider.String == "email" && (!dbUser.EmailVerified.Valid || !dbUser.EmailVerified.Bool) {
+		return nil, nil, nil, ErrEmailNotVerified
 	}
 
-	// Verify password
-	err = bcrypt.CompareHashAndPassword([]byte(dbUser.PasswordHash.String), []byte(password))
+	challenge, err := s.loginChallenge(dbUser)
 	if err != nil {
-		return nil, nil, ErrInvalidCredentials
+		return nil, nil, nil, err
 	}
-
-	// Check if email is verified for email-based signup
-	if dbUser.Provider.String == "email" && (!dbUser.EmailVerified.Valid || !dbUser.EmailVerified.Bool) {
-		return nil, nil, ErrEmailNotVerified
+	if challenge != nil {
+		return s.convertDBUser(dbUser), nil, challenge, nil
 	}
 
 	// Create session
 	session, err := s.CreateSession(dbUser.ID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create session: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to create session: %w", err)
 	}
 
 	user := s.convertDBUser(dbUser)
 	session.User = user
 
-	return user, session, nil
+	return user, session, nil, nil
+}
+
+// CheckPassword verifies the password of a user without signing them in
+func (s *Service) CheckPassword(email, password string) error {
+	_, err := s.checkPassword(email, password)
+	return err
+}
+
+func (s *Service) checkPassword(email, password string) (sqlc.User, error) {
+	// Get user by email
+	dbUser, err := s.db.GetUserByEmail(s.ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return sqlc.User{}, ErrInvalidCredentials
+		}
+		return sqlc.User{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	// Check if user has a password (OAuth users might not have passwords)
+	if !dbUser.PasswordHash.Valid {
+		return sqlc.User{}, ErrInvalidCredentials
+	}
+
+	// Verify password
+	err = bcrypt.CompareHashAndPassword([]byte(dbUser.PasswordHash.String), []byte(password))
+	if err != nil {
+		return sqlc.User{}, ErrInvalidCredentials
+	}
+	return dbUser, nil
 }
 
 // CreateOrUpdateOAuthUser creates or updates a user from OAuth provider
@@ -181,6 +219,7 @@ func (s *Service) CreateOrUpdateOAuthUser(email, provider, providerID, avatarURL
 	if err != nil {
 		return nil, fmt.Errorf("failed to create OAuth user: %w", err)
 	}
+	dbUser = s.grantAdminRole(dbUser)
 
 	return s.convertDBUser(dbUser), nil
 }
@@ -308,6 +347,7 @@ func (s *Service) ValidateSession(token string) (*User, *Session, error) {
 		ID:       dbSession.UserID,
 		Email:    dbSession.Email,
 		Provider: dbSession.Provider.String,
+		Role:     Role(dbSession.Role),
 	}
 
 	if dbSession.Username.Valid {
@@ -326,8 +366,8 @@ func (s *Service) ValidateSession(token string) (*User, *Session, error) {
 	}
 
 	// Add debug logging for final user object
-	logrus.Debugf("ValidateSession: Created user object - ID: %s, Email: %s, Provider: %s",
-		user.ID, user.Email, user.Provider)
+	logrus.Debugf("ValidateSession: Created user object - ID: %s, Email: %s, Provider: %s, Role: %s",
+		user.ID, user.Email, user.Provider, user.Role)
 
 	session := &Session{
 		ID:           dbSession.ID,
@@ -349,13 +389,17 @@ func (s *Service) DeleteSession(token string) error {
 	return nil
 }
 
-// CleanupExpiredSessions removes expired sessions
+// CleanupExpiredSessions removes expired sessions, two-factor login challenges and password
+// reset tokens
 func (s *Service) CleanupExpiredSessions() error {
 	err := s.db.DeleteExpiredSessions(s.ctx)
 	if err != nil {
 		return fmt.Errorf("failed to cleanup expired sessions: %w", err)
 	}
-	return nil
+	if err = s.db.DeleteExpiredMFAChallenges(s.ctx); err != nil {
+		return fmt.Errorf("failed to cleanup expired challenges: %w", err)
+	}
+	return s.cleanupPasswordResets()
 }
 
 // VerifyEmail verifies a user's email using the verification token
@@ -414,6 +458,84 @@ func (s *Service) ResendVerificationEmail(email string) error {
 	return nil
 }
 
+// ListUsers returns every user, oldest first
+func (s *Service) ListUsers() ([]*User, error) {
+	dbUsers, err := s.db.ListUsers(s.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	users := make([]*User, 0, len(dbUsers))
+	for _, dbUser := range dbUsers {
+		users = append(users, s.convertDBUser(dbUser))
+	}
+	return users, nil
+}
+
+// SetUserRole changes the role of a user on behalf of another one, users cannot change
+// their own role so that the last admin cannot lock everyone out
+func (s *Service) SetUserRole(changedBy, userID uuid.UUID, role Role) (*User, error) {
+	if _, err := ParseRole(string(role)); err != nil {
+		return nil, err
+	}
+	if changedBy == userID {
+		return nil, ErrOwnRole
+	}
+
+	dbUser, err := s.db.UpdateUserRole(s.ctx, sqlc.UpdateUserRoleParams{
+		ID:   userID,
+		Role: string(role),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to update user role: %w", err)
+	}
+
+	return s.convertDBUser(dbUser), nil
+}
+
+// SetAdminEmails makes admins of the users with the given email addresses, now and when
+// they sign up. It bootstraps the first admin, who can then give roles to other users.
+func (s *Service) SetAdminEmails(emails []string) {
+	s.adminEmails = make(map[string]bool, len(emails))
+	for _, email := range emails {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email == "" {
+			continue
+		}
+		s.adminEmails[email] = true
+
+		dbUser, err := s.db.GetUserByEmail(s.ctx, email)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				logrus.Warnf("Failed to look up admin %s: %v", email, err)
+			}
+			continue
+		}
+		s.grantAdminRole(dbUser)
+	}
+}
+
+// grantAdminRole makes an admin of a user whose email address is one of the admin emails
+func (s *Service) grantAdminRole(dbUser sqlc.User) sqlc.User {
+	if !s.adminEmails[strings.ToLower(dbUser.Email)] || Role(dbUser.Role) == RoleAdmin {
+		return dbUser
+	}
+
+	updated, err := s.db.UpdateUserRole(s.ctx, sqlc.UpdateUserRoleParams{
+		ID:   dbUser.ID,
+		Role: string(RoleAdmin),
+	})
+	if err != nil {
+		logrus.Errorf("Failed to make %s an admin: %v", dbUser.Email, err)
+		return dbUser
+	}
+	logrus.Infof("User %s is an admin", dbUser.Email)
+	return updated
+}
+
 // generateSessionToken generates a secure random session token
 func (s *Service) generateSessionToken() (string, error) {
 	bytes := make([]byte, 32)
@@ -439,6 +561,7 @@ func (s *Service) convertDBUser(dbUser sqlc.User) *User {
 		Email:         dbUser.Email,
 		Provider:      dbUser.Provider.String,
 		EmailVerified: dbUser.EmailVerified.Valid && dbUser.EmailVerified.Bool,
+		Role:          Role(dbUser.Role),
 		CreatedAt:     dbUser.CreatedAt,
 		UpdatedAt:     dbUser.UpdatedAt,
 	}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the test vectors of RFC 6238
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	key := []byte("12345678901234567890")
+	// the last six digits of the eight digit codes of RFC 6238
+	for _, test := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		if got := totpCode(key, totpStep(time.Unix(test.unix, 0))); got != test.want {
+			t.Errorf("code at %d is %s, want %s", test.unix, got, test.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	step, ok := validateTOTP(rfc6238Secret, "081804", now, 0)
+	if !ok || step != totpStep(now) {
+		t.Fatalf("current code refused, step %d", step)
+	}
+	if _, ok = validateTOTP(rfc6238Secret, "081804", now.Add(totpPeriod), 0); !ok {
+		t.Error("code of the previous step refused")
+	}
+	if _, ok = validateTOTP(rfc6238Secret, "081804", now.Add(3*totpPeriod), 0); ok {
+		t.Error("code of an old step accepted")
+	}
+	if _, ok = validateTOTP(rfc6238Secret, "081804", now, step); ok {
+		t.Error("code of a used step accepted")
+	}
+	if _, ok = validateTOTP(rfc6238Secret, "000000", now, 0); ok {
+		t.Error("wrong code accepted")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := totpProvisioningURI("KubeBrowse", "user@example.com", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/KubeBrowse:user@example.com?") {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	if !strings.Contains(uri, "secret=ABC") || !strings.Contains(uri, "issuer=KubeBrowse") {
+		t.Errorf("URI %s lacks its secret or issuer", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	if len(codes[0]) != 19 {
+		t.Errorf("unexpected code %q", codes[0])
+	}
+	// codes are accepted however they are typed
+	if hashSecretToken(codes[0]) != hashSecretToken(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))) {
+		t.Error("hash depends on the case and dashes of the code")
+	}
+}
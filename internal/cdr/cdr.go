@@ -0,0 +1,78 @@
+// Package cdr implements Content Disarm and Reconstruction for the documents users upload
+// into sandboxes. Office Open XML documents are rebuilt without macros, embedded objects,
+// ActiveX controls and external references, PDFs are flattened by rebuilding them from
+// their pages alone. The original is never modified, a disarmed copy is written instead.
+package cdr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Formats of the documents that can be disarmed
+const (
+	FormatOOXML = "ooxml"
+	FormatPDF   = "pdf"
+)
+
+// ErrUnsupported is returned for files that are not a supported document
+var ErrUnsupported = errors.New("unsupported document type")
+
+// ooxmlExtensions are the Word, Excel and PowerPoint formats, with and without macros
+var ooxmlExtensions = map[string]bool{
+	".docx": true, ".docm": true, ".dotx": true, ".dotm": true,
+	".xlsx": true, ".xlsm": true, ".xltx": true, ".xltm": true,
+	".pptx": true, ".pptm": true, ".potx": true, ".potm": true,
+	".ppsx": true, ".ppsm": true,
+}
+
+// Report lists what was removed from a document
+type Report struct {
+	Format  string   `json:"format"`
+	Removed []string `json:"removed"`
+}
+
+func (r *Report) remove(what string) {
+	r.Removed = append(r.Removed, what)
+}
+
+// Format returns the format of a file from its name, "" if it cannot be disarmed
+func Format(filename string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	switch {
+	case ooxmlExtensions[ext]:
+		return FormatOOXML
+	case ext == ".pdf":
+		return FormatPDF
+	default:
+		return ""
+	}
+}
+
+// Supported reports whether a file can be disarmed, judging by its name
+func Supported(filename string) bool {
+	return Format(filename) != ""
+}
+
+// Disarm writes a disarmed copy of the document src of the given size to dst and reports
+// what was removed. Documents that cannot be disarmed safely fail, dst then holds no usable
+// document.
+func Disarm(filename string, src io.ReaderAt, size int64, dst io.Writer) (*Report, error) {
+	var err error
+	report := &Report{Format: Format(filename), Removed: []string{}}
+	switch report.Format {
+	case FormatOOXML:
+		err = disarmOOXML(src, size, dst, report)
+	case FormatPDF:
+		err = disarmPDF(src, size, dst, report)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", report.Format, err)
+	}
+	return report, nil
+}
@@ -0,0 +1,301 @@
+package cdr
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func buildZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.WriteString(w, content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		_ = rc.Close()
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestDisarmOOXML(t *testing.T) {
+	doc := buildZip(t, map[string]string{
+		"[Content_Types].xml": `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="bin" ContentType="application/vnd.ms-office.vbaProject"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/word/document.xml" ContentType="application/vnd.ms-word.document.macroEnabled.main+xml"/>` +
+			`<Override PartName="/word/vbaData.xml" ContentType="application/vnd.ms-word.vbaData+xml"/>` +
+			`</Types>`,
+		"_rels/.rels":       `<Relationships><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/></Relationships>`,
+		"word/document.xml": `<w:document/>`,
+		"word/_rels/document.xml.rels": `<Relationships>` +
+			`<Relationship Id="rId1" Type="http://schemas.microsoft.com/office/2006/relationships/vbaProject" Target="vbaProject.bin"/>` +
+			`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/oleObject" Target="embeddings/oleObject1.bin"/>` +
+			`<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink" Target="https://example.com" TargetMode="External"/>` +
+			`<Relationship Id="rId4" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/attachedTemplate" Target="http://evil.example.com/t.dotm" TargetMode="External"/>` +
+			`<Relationship Id="rId5" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+			`</Relationships>`,
+		"word/styles.xml":                `<w:styles/>`,
+		"word/vbaProject.bin":            "VBA",
+		"word/vbaData.xml":               `<wne:vbaSuppData/>`,
+		"word/embeddings/oleObject1.bin": "OLE",
+	})
+
+	var out bytes.Buffer
+	report, err := Disarm("report.docm", bytes.NewReader(doc), int64(len(doc)), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Format != FormatOOXML {
+		t.Fatalf("Format=%q, want %q", report.Format, FormatOOXML)
+	}
+
+	files := readZip(t, out.Bytes())
+	for _, removed := range []string{"word/vbaProject.bin", "word/vbaData.xml", "word/embeddings/oleObject1.bin"} {
+		if _, ok := files[removed]; ok {
+			t.Errorf("%s was not removed", removed)
+		}
+	}
+	for _, kept := range []string{"word/document.xml", "word/styles.xml", "_rels/.rels"} {
+		if _, ok := files[kept]; !ok {
+			t.Errorf("%s was removed", kept)
+		}
+	}
+
+	rels := files["word/_rels/document.xml.rels"]
+	for _, id := range []string{`"rId1"`, `"rId2"`, `"rId4"`} {
+		if strings.Contains(rels, id) {
+			t.Errorf("relationship %s was kept: %s", id, rels)
+		}
+	}
+	for _, id := range []string{`"rId3"`, `"rId5"`} {
+		if !strings.Contains(rels, id) {
+			t.Errorf("relationship %s was removed: %s", id, rels)
+		}
+	}
+
+	types := files["[Content_Types].xml"]
+	if strings.Contains(types, "vbaProject") || strings.Contains(types, "vbaData") {
+		t.Errorf("VBA content types were kept: %s", types)
+	}
+	if !strings.Contains(types, "/word/document.xml") {
+		t.Errorf("document content type was removed: %s", types)
+	}
+
+	if got := strings.Join(report.Removed, "\n"); !strings.Contains(got, "external attachedTemplate: http://evil.example.com/t.dotm") || len(report.Removed) != 4 {
+		t.Errorf("Removed=%q", report.Removed)
+	}
+}
+
+func TestDisarmOOXMLRenamedParts(t *testing.T) {
+	doc := buildZip(t, map[string]string{
+		"[Content_Types].xml": `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Default Extension="png" ContentType="image/png"/>` +
+			`<Override PartName="/word/document.xml" ContentType="application/vnd.ms-word.document.macroEnabled.main+xml"/>` +
+			`<Override PartName="/word/media/y.png" ContentType="application/vnd.openxmlformats-officedocument.oleObject"/>` +
+			`</Types>`,
+		"word/document.xml": `<w:document/>`,
+		"word/_rels/document.xml.rels": `<Relationships>` +
+			`<Relationship Id="rId1" Type="http://schemas.microsoft.com/office/2006/relationships/vbaProject" Target="media/x.bin"/>` +
+			`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/image" Target="media/image1.png"/>` +
+			`</Relationships>`,
+		"word/media/x.bin":      "VBA",
+		"word/media/y.png":      "OLE",
+		"word/media/image1.png": "PNG",
+	})
+
+	var out bytes.Buffer
+	report, err := Disarm("report.docm", bytes.NewReader(doc), int64(len(doc)), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := readZip(t, out.Bytes())
+	// x.bin is found by its relationship, y.png by its content type
+	for _, removed := range []string{"word/media/x.bin", "word/media/y.png"} {
+		if _, ok := files[removed]; ok {
+			t.Errorf("%s was not removed", removed)
+		}
+	}
+	if _, ok := files["word/media/image1.png"]; !ok {
+		t.Errorf("word/media/image1.png was removed")
+	}
+	if rels := files["word/_rels/document.xml.rels"]; strings.Contains(rels, `"rId1"`) || !strings.Contains(rels, `"rId2"`) {
+		t.Errorf("relationships=%s, want only rId2", rels)
+	}
+	if len(report.Removed) != 2 {
+		t.Errorf("Removed=%q", report.Removed)
+	}
+}
+
+func TestDisarmOOXMLKeepsUsedDefaults(t *testing.T) {
+	doc := buildZip(t, map[string]string{
+		"[Content_Types].xml": `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="bin" ContentType="application/vnd.ms-office.vbaProject"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.ms-excel.sheet.macroEnabled.main+xml"/>` +
+			`<Override PartName="/xl/printerSettings/printerSettings1.bin" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.printerSettings"/>` +
+			`<Override PartName="/xl/activeX/activeX1.xml" ContentType="application/vnd.ms-office.activeX+xml"/>` +
+			`</Types>`,
+		"xl/workbook.xml":                         `<workbook/>`,
+		"xl/vbaProject.bin":                       "VBA",
+		"xl/activeX/activeX1.xml":                 `<ax:ocx/>`,
+		"xl/printerSettings/printerSettings1.bin": "PRINTER",
+	})
+
+	var out bytes.Buffer
+	if _, err := Disarm("book.xlsm", bytes.NewReader(doc), int64(len(doc)), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readZip(t, out.Bytes())
+	if _, ok := files["xl/printerSettings/printerSettings1.bin"]; !ok {
+		t.Fatal("printer settings were removed")
+	}
+	types := files["[Content_Types].xml"]
+	// the printer settings have an override, the default of their extension is still kept
+	for _, kept := range []string{`Extension="bin"`, `Extension="xml"`, "/xl/printerSettings/printerSettings1.bin", "/xl/workbook.xml"} {
+		if !strings.Contains(types, kept) {
+			t.Errorf("content type %s was removed: %s", kept, types)
+		}
+	}
+	if strings.Contains(types, "/xl/activeX/activeX1.xml") {
+		t.Errorf("override of a removed part was kept: %s", types)
+	}
+}
+
+func TestDisarmPDF(t *testing.T) {
+	t.Run("KeepsOnlyPages", func(t *testing.T) {
+		pdf := "%PDF-1.4\n" +
+			"1 0 obj\n<< /Type /Catalog /Pages 2 0 R /OpenAction 4 0 R /Names << /J#61vaScript 5 0 R >> /AcroForm << /Fields [] >> >>\nendobj\n" +
+			"2 0 obj\n<< /Type /Pages /Kids [3 0 R] /Count 1 >>\nendobj\n" +
+			"3 0 obj\n<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 6 0 R /Annots [7 0 R] /AA << /O 4 0 R >> >>\nendobj\n" +
+			"4 0 obj\n<< /S /JavaScript /JS (app.alert\\(\"endobj\"\\)) >>\nendobj\n" +
+			"5 0 obj\n<< /Names [(x) 4 0 R] >>\nendobj\n" +
+			"6 0 obj\n<< /Length 12 >>\nstream\nBT /JS Tf ET\nendstream\nendobj\n" +
+			"7 0 obj\n<< /Type /Annot /Subtype /FileAttachment /FS << /Type /Filespec /EF << /F 8 0 R >> >> >>\nendobj\n" +
+			"8 0 obj\n<< /Type /EmbeddedFile /Length 5 >>\nstream\nMZ...\nendstream\nendobj\n" +
+			"trailer\n<< /Root 1 0 R >>\n%%EOF\n"
+
+		var out bytes.Buffer
+		report, err := Disarm("doc.PDF", strings.NewReader(pdf), int64(len(pdf)), &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := out.String()
+		for _, want := range []string{"/Type /Catalog /Pages 2 0 R >>", "/MediaBox [0 0 612 792]", "stream\nBT /JS Tf ET\nendstream"} {
+			if !strings.Contains(got, want) {
+				t.Errorf("output does not contain %q:\n%s", want, got)
+			}
+		}
+		for _, active := range []string{"JavaScript", "app.alert", "/OpenAction", "/AcroForm", "/Annots", "/AA", "EmbeddedFile", "MZ..."} {
+			if strings.Contains(got, active) {
+				t.Errorf("output contains %q:\n%s", active, got)
+			}
+		}
+		want := []string{"/AA (1)", "/AcroForm (1)", "/Annots (1)", "/Names (1)", "/OpenAction (1)", "4 objects the pages do not use"}
+		if strings.Join(report.Removed, ",") != strings.Join(want, ",") {
+			t.Errorf("Removed=%q, want %q", report.Removed, want)
+		}
+
+		// the rebuilt document is a valid one, which rebuilds to itself
+		var again bytes.Buffer
+		report, err = Disarm("doc.pdf", bytes.NewReader(out.Bytes()), int64(out.Len()), &again)
+		if err != nil {
+			t.Fatalf("Disarm of the rebuilt document=%v", err)
+		}
+		if again.String() != got || len(report.Removed) != 0 {
+			t.Errorf("rebuilt document changed, removed %q:\n%s", report.Removed, again.String())
+		}
+	})
+
+	t.Run("RemovesCompressedActiveContent", func(t *testing.T) {
+		objects := "1 0 2 52 3 105 " +
+			"<< /Type /Catalog /Pages 2 0 R /OpenAction 3 0 R >> " +
+			"<< /Type /Pages /Kids [4 0 R] /Count 1 /AA << >> >> " +
+			"<< /S /JavaScript /JS (x) >>"
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		_, _ = io.WriteString(zw, objects)
+		_ = zw.Close()
+
+		pdf := "%PDF-1.5\n5 0 obj\n<< /Type /ObjStm /N 3 /First 15 /Filter /FlateDecode /Length " +
+			strconv.Itoa(compressed.Len()) + " >>\nstream\n" + compressed.String() + "\nendstream\nendobj\n" +
+			"4 0 obj\n<< /Type /Page /Parent 2 0 R /MediaBox [0 0 10 10] >>\nendobj\n" +
+			"6 0 obj\n<< /Type /XRef /Root 1 0 R /Size 7 >>\nstream\n\nendstream\nendobj\n%%EOF\n"
+
+		var out bytes.Buffer
+		report, err := Disarm("doc.pdf", strings.NewReader(pdf), int64(len(pdf)), &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := out.String(); strings.Contains(got, "/JS") || strings.Contains(got, "/AA") || !strings.Contains(got, "/MediaBox [0 0 10 10]") {
+			t.Errorf("unexpected output:\n%s", got)
+		}
+		if !strings.Contains(strings.Join(report.Removed, ","), "/OpenAction (1)") {
+			t.Errorf("Removed=%q, want the open action", report.Removed)
+		}
+	})
+
+	t.Run("RefusesEncrypted", func(t *testing.T) {
+		pdf := "%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n" +
+			"2 0 obj\n<< /Type /Pages /Kids [3 0 R] /Count 1 >>\nendobj\n" +
+			"3 0 obj\n<< /Type /Page /Parent 2 0 R >>\nendobj\n" +
+			"trailer\n<< /Root 1 0 R /Encrypt << /Filter /Standard >> >>\n%%EOF\n"
+
+		_, err := Disarm("doc.pdf", strings.NewReader(pdf), int64(len(pdf)), io.Discard)
+		if !errors.Is(err, errEncryptedPDF) {
+			t.Fatalf("Disarm err=%v, want %v", err, errEncryptedPDF)
+		}
+	})
+
+	t.Run("RefusesWithoutPages", func(t *testing.T) {
+		pdf := "%PDF-1.4\n1 0 obj\n<< /Type /Catalog /OpenAction << /S /JavaScript /JS (x) >> >>\nendobj\n%%EOF\n"
+
+		_, err := Disarm("doc.pdf", strings.NewReader(pdf), int64(len(pdf)), io.Discard)
+		if !errors.Is(err, errNoPDFPages) {
+			t.Fatalf("Disarm err=%v, want %v", err, errNoPDFPages)
+		}
+	})
+}
+
+func TestSupported(t *testing.T) {
+	for filename, want := range map[string]bool{
+		"a.docx": true, "a.XLSM": true, "a.pptx": true, "a.pdf": true,
+		"a.doc": false, "a.txt": false, "pdf": false,
+	} {
+		if got := Supported(filename); got != want {
+			t.Errorf("Supported(%q)=%v, want %v", filename, got, want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package cdr
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// maxOOXMLXMLPart bounds the relationship and content type parts that are rewritten
+const maxOOXMLXMLPart = 10 << 20
+
+var (
+	relationshipPattern = regexp.MustCompile(`(?s)<Relationship\b[^>]*?(?:/>|>\s*</Relationship>)`)
+	contentTypePattern  = regexp.MustCompile(`(?s)<(?:Override|Default)\b[^>]*?(?:/>|>\s*</(?:Override|Default)>)`)
+	attributePattern    = regexp.MustCompile(`([A-Za-z]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// activeRelationshipTypes are the ends of the types of relationships to active parts
+var activeRelationshipTypes = []string{
+	"/vbaProject",
+	"/vbaProjectSignature",
+	"/oleObject",
+	"/control",
+	"/activeXControlBinary",
+	"/attachedTemplate",
+	"/xlMacrosheet",
+	"/xlIntlMacrosheet",
+}
+
+// activeOOXMLPart reports whether a part of a document holds active content: VBA projects
+// and their signatures, Excel 4.0 macro sheets, embedded OLE objects, ActiveX controls and
+// ribbon customizations that call macros
+func activeOOXMLPart(name string) bool {
+	name = strings.ToLower(name)
+	base := path.Base(name)
+	return strings.HasPrefix(base, "vbaproject") ||
+		base == "vbadata.xml" ||
+		strings.Contains(name, "/macrosheets/") ||
+		strings.Contains(name, "/embeddings/") ||
+		strings.Contains(name, "/activex/") ||
+		strings.HasPrefix(name, "customui/")
+}
+
+// activeContentType reports whether a content type is the one of VBA, macro sheet, OLE or
+// ActiveX parts
+func activeContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, active := range []string{"vbaproject", "vbadata", "macrosheet", "oleobject", "activex"} {
+		if strings.Contains(contentType, active) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeOOXMLParts returns the lowercase names of the active parts of a document. Parts are
+// recognized by their name, by the type of the relationships pointing to them and by their
+// content type, so that renaming an active part does not keep it.
+func activeOOXMLParts(files []*zip.File) (map[string]bool, error) {
+	active := map[string]bool{}
+	overrides := map[string]string{}
+	defaults := map[string]string{}
+
+	for _, f := range files {
+		if activeOOXMLPart(f.Name) {
+			active[strings.ToLower(f.Name)] = true
+		}
+		switch {
+		case f.Name == "[Content_Types].xml":
+			data, err := readZipFile(f)
+			if err != nil {
+				return nil, err
+			}
+			for _, element := range contentTypePattern.FindAll(data, -1) {
+				attributes := xmlAttributes(element)
+				if part := attributes["PartName"]; part != "" {
+					overrides[strings.ToLower(strings.TrimPrefix(part, "/"))] = attributes["ContentType"]
+				} else if extension := attributes["Extension"]; extension != "" {
+					defaults[strings.ToLower(extension)] = attributes["ContentType"]
+				}
+			}
+		case strings.HasSuffix(strings.ToLower(f.Name), ".rels"):
+			data, err := readZipFile(f)
+			if err != nil {
+				return nil, err
+			}
+			for _, element := range relationshipPattern.FindAll(data, -1) {
+				attributes := xmlAttributes(element)
+				if attributes["TargetMode"] != "External" && activeRelationship(attributes["Type"]) {
+					active[strings.ToLower(relationshipPart(f.Name, attributes["Target"]))] = true
+				}
+			}
+		}
+	}
+
+	// the content type of a part is its override, or else the default of its extension
+	for _, f := range files {
+		name := strings.ToLower(f.Name)
+		contentType, ok := overrides[name]
+		if !ok {
+			contentType = defaults[strings.TrimPrefix(path.Ext(name), ".")]
+		}
+		if activeContentType(contentType) {
+			active[name] = true
+		}
+	}
+	return active, nil
+}
+
+func activeRelationship(relationshipType string) bool {
+	for _, active := range activeRelationshipTypes {
+		if strings.HasSuffix(relationshipType, active) {
+			return true
+		}
+	}
+	return false
+}
+
+// relationshipPart returns the name of the part the target of a relationship of a .rels
+// part points to
+func relationshipPart(rels, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return strings.TrimPrefix(target, "/")
+	}
+	// word/_rels/document.xml.rels holds the relationships of word/document.xml
+	dir := path.Dir(path.Dir(rels))
+	if dir == "." {
+		dir = ""
+	}
+	return path.Join(dir, target)
+}
+
+// disarmOOXML rebuilds an Office Open XML package without its active parts and without
+// relationships to them or to external resources other than hyperlinks
+func disarmOOXML(src io.ReaderAt, size int64, dst io.Writer, report *Report) error {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return fmt.Errorf("not a valid document: %w", err)
+	}
+
+	removed, err := activeOOXMLParts(zr.File)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if removed[strings.ToLower(f.Name)] {
+			report.remove(f.Name)
+		}
+	}
+
+	zw := zip.NewWriter(dst)
+	for _, f := range zr.File {
+		var rewrite func([]byte) []byte
+		switch {
+		case removed[strings.ToLower(f.Name)]:
+			continue
+		case f.Name == "[Content_Types].xml":
+			rewrite = func(data []byte) []byte {
+				return filterContentTypes(data, removed, zr.File)
+			}
+		case strings.HasSuffix(strings.ToLower(f.Name), ".rels"):
+			name := f.Name
+			rewrite = func(data []byte) []byte {
+				return filterRelationships(name, data, removed, report)
+			}
+		default:
+			// unchanged parts are copied without recompressing them
+			if err := zw.Copy(f); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", f.Name, err)
+			}
+			continue
+		}
+
+		data, err := readZipFile(f)
+		if err != nil {
+			return err
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: f.Modified})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(rewrite(data)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", f.Name, err)
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	data, err := io.ReadAll(io.LimitReader(rc, maxOOXMLXMLPart+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+	}
+	if len(data) > maxOOXMLXMLPart {
+		return nil, fmt.Errorf("%s is too large", f.Name)
+	}
+	return data, nil
+}
+
+// xmlAttributes returns the attributes of a single XML element
+func xmlAttributes(element []byte) map[string]string {
+	attributes := map[string]string{}
+	for _, m := range attributePattern.FindAllSubmatch(element, -1) {
+		attributes[string(m[1])] = string(m[2]) + string(m[3])
+	}
+	return attributes
+}
+
+// filterRelationships removes the relationships of a .rels part that point to removed parts
+// or to external resources other than hyperlinks, like remote templates and OLE links
+func filterRelationships(name string, data []byte, removed map[string]bool, report *Report) []byte {
+	return relationshipPattern.ReplaceAllFunc(data, func(element []byte) []byte {
+		attributes := xmlAttributes(element)
+		target := attributes["Target"]
+		if attributes["TargetMode"] == "External" {
+			if strings.HasSuffix(attributes["Type"], "/hyperlink") {
+				return element
+			}
+			report.remove(fmt.Sprintf("external %s: %s", path.Base(attributes["Type"]), target))
+			return nil
+		}
+
+		if removed[strings.ToLower(relationshipPart(name, target))] {
+			return nil
+		}
+		return element
+	})
+}
+
+// filterContentTypes removes the overrides of removed parts and the active defaults that
+// no part left in the package uses, so that the parts kept keep their content type
+func filterContentTypes(data []byte, removed map[string]bool, files []*zip.File) []byte {
+	keptExtensions := map[string]bool{}
+	for _, f := range files {
+		if name := strings.ToLower(f.Name); !removed[name] {
+			keptExtensions[strings.TrimPrefix(path.Ext(name), ".")] = true
+		}
+	}
+
+	return contentTypePattern.ReplaceAllFunc(data, func(element []byte) []byte {
+		attributes := xmlAttributes(element)
+		if part := attributes["PartName"]; part != "" {
+			if removed[strings.ToLower(strings.TrimPrefix(part, "/"))] {
+				return nil
+			}
+			return element
+		}
+		if activeContentType(attributes["ContentType"]) && !keptExtensions[strings.ToLower(attributes["Extension"])] {
+			return nil
+		}
+		return element
+	})
+}
@@ -0,0 +1,835 @@
+package cdr
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// pdfActiveNames are the names of the dictionary keys and actions that make a PDF run
+// scripts, open files or URLs, submit data or fill in forms. Rebuilt documents keep none of
+// these keys, nor actions of these kinds.
+var pdfActiveNames = map[string]bool{
+	"JavaScript":    true,
+	"JS":            true,
+	"OpenAction":    true,
+	"AA":            true,
+	"Launch":        true,
+	"EmbeddedFile":  true,
+	"EmbeddedFiles": true,
+	"RichMedia":     true,
+	"XFA":           true,
+	"AcroForm":      true,
+	"SubmitForm":    true,
+	"ImportData":    true,
+	"GoToE":         true,
+	"GoToR":         true,
+}
+
+// pdfInactiveTypes are the types of dictionaries dropped wherever they are referenced:
+// annotations, actions and the files embedded in a document
+var pdfInactiveTypes = map[string]bool{
+	"Annot":        true,
+	"Action":       true,
+	"Filespec":     true,
+	"EmbeddedFile": true,
+}
+
+// pdfPagesKeys and pdfPageKeys are the entries kept of the nodes of the page tree, those
+// that set how pages look. Annotations, page actions and the like are left out.
+var (
+	pdfPagesKeys = map[string]bool{
+		"Type": true, "Parent": true, "Kids": true, "Count": true,
+		"Resources": true, "MediaBox": true, "CropBox": true, "Rotate": true,
+	}
+	pdfPageKeys = map[string]bool{
+		"Type": true, "Parent": true, "Resources": true, "Contents": true, "Group": true,
+		"MediaBox": true, "CropBox": true, "BleedBox": true, "TrimBox": true, "ArtBox": true,
+		"Rotate": true, "UserUnit": true,
+	}
+)
+
+var (
+	errEncryptedPDF = errors.New("encrypted documents cannot be rebuilt")
+	errNoPDFPages   = errors.New("no pages found")
+)
+
+const (
+	// maxPDFSize bounds the documents rebuilt, which are read whole
+	maxPDFSize = 256 << 20
+	// maxPDFObjectStream bounds the inflated size of an object stream
+	maxPDFObjectStream = 64 << 20
+	// maxPDFDepth bounds the nesting of arrays and dictionaries and of the page tree
+	maxPDFDepth = 64
+)
+
+// pdfIndirectPattern finds the start of indirect objects and trailers. Streams are skipped
+// as a whole, so their data is never searched.
+var pdfIndirectPattern = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b|trailer\b`)
+
+// disarmPDF flattens a PDF by rebuilding it from its pages alone: the new document has a
+// catalog with the page tree and nothing else, and its pages keep their content, resources
+// and boxes but no annotations or actions. Only the objects the pages use are copied, so
+// scripts, forms and embedded files are left behind, and keys and actions that are active
+// are dropped even from those.
+func disarmPDF(src io.ReaderAt, size int64, dst io.Writer, report *Report) error {
+	if size > maxPDFSize {
+		return fmt.Errorf("larger than %d MiB", maxPDFSize>>20)
+	}
+	data := make([]byte, size)
+	if n, err := src.ReadAt(data, 0); n < len(data) {
+		return fmt.Errorf("failed to read document: %w", err)
+	}
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return fmt.Errorf("not a PDF document")
+	}
+
+	doc, err := parsePDF(data)
+	if err != nil {
+		return err
+	}
+	if doc.encrypted {
+		return errEncryptedPDF
+	}
+
+	r := &pdfRebuilder{
+		doc:      doc,
+		pageTree: map[int]map[string]bool{},
+		numbers:  map[int]int{},
+		removed:  map[string]int{},
+	}
+	if err := r.rebuild(); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(r.removed))
+	for name := range r.removed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		report.remove(fmt.Sprintf("/%s (%d)", name, r.removed[name]))
+	}
+	// the catalog is replaced, it is not counted
+	if unused := len(doc.objects) - len(r.numbers) - 1; unused > 0 {
+		report.remove(fmt.Sprintf("%d objects the pages do not use", unused))
+	}
+
+	return r.write(dst)
+}
+
+// PDF objects as they are parsed. Strings, numbers, booleans and null keep their source
+// text, which is written back unchanged.
+type (
+	pdfObject interface{}
+	pdfName   string
+	pdfRaw    []byte
+	pdfArray  []pdfObject
+	pdfRef    struct{ number, generation int }
+)
+
+var pdfNull = pdfRaw("null")
+
+// pdfDict is a dictionary that keeps the order of its keys
+type pdfDict struct {
+	keys   []pdfName
+	values map[pdfName]pdfObject
+}
+
+func newPDFDict() *pdfDict {
+	return &pdfDict{values: map[pdfName]pdfObject{}}
+}
+
+func (d *pdfDict) get(key string) pdfObject {
+	return d.values[pdfName(key)]
+}
+
+func (d *pdfDict) set(key string, value pdfObject) {
+	if _, ok := d.values[pdfName(key)]; !ok {
+		d.keys = append(d.keys, pdfName(key))
+	}
+	d.values[pdfName(key)] = value
+}
+
+type pdfStream struct {
+	dict *pdfDict
+	data []byte
+}
+
+// decode returns the data of a stream compressed with Flate without a predictor, or not
+// compressed at all, failing once it is longer than limit
+func (s *pdfStream) decode(limit int) ([]byte, error) {
+	filter := s.dict.get("Filter")
+	if filters, ok := filter.(pdfArray); ok && len(filters) == 1 {
+		filter = filters[0]
+	}
+	if filter == nil {
+		return s.data, nil
+	}
+	if name, ok := filter.(pdfName); !ok || name != "FlateDecode" {
+		return nil, fmt.Errorf("unsupported filter")
+	}
+	if params, ok := s.dict.get("DecodeParms").(*pdfDict); ok {
+		if predictor, _ := pdfInt(params.get("Predictor")); predictor > 1 {
+			return nil, fmt.Errorf("unsupported predictor")
+		}
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(s.data))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("too large")
+	}
+	return data, nil
+}
+
+func pdfInt(object pdfObject) (int, bool) {
+	raw, ok := object.(pdfRaw)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(raw))
+	return n, err == nil
+}
+
+// pdfDocument holds the objects of a PDF by number. Later definitions replace earlier
+// ones, the way incremental updates do.
+type pdfDocument struct {
+	objects   map[int]pdfObject
+	root      pdfObject
+	encrypted bool
+}
+
+// parsePDF reads the objects of a PDF in the order they appear, rather than through its
+// cross-reference tables, so that damaged or misleading tables do not matter. Objects that
+// cannot be parsed are left out, like readers repairing a file do.
+func parsePDF(data []byte) (*pdfDocument, error) {
+	doc := &pdfDocument{objects: map[int]pdfObject{}}
+	p := &pdfParser{data: data}
+	for p.pos < len(data) {
+		base := p.pos
+		m := pdfIndirectPattern.FindSubmatchIndex(data[base:])
+		if m == nil {
+			break
+		}
+		start := base + m[0]
+		p.pos = base + m[1]
+		if start > 0 && pdfRegular(data[start-1]) {
+			continue
+		}
+
+		if m[2] < 0 {
+			if trailer, err := p.object(0); err == nil {
+				if dict, ok := trailer.(*pdfDict); ok {
+					doc.trailer(dict)
+				}
+			}
+			continue
+		}
+
+		number, err := strconv.Atoi(string(data[base+m[2] : base+m[3]]))
+		if err != nil {
+			continue
+		}
+		object, err := p.indirectObject()
+		if err != nil {
+			p.pos = base + m[1]
+			continue
+		}
+		if err := doc.add(number, object); err != nil {
+			return nil, err
+		}
+	}
+
+	if doc.root == nil {
+		// without a trailer, the catalog with the highest number is the latest one
+		highest := -1
+		for number, object := range doc.objects {
+			if dict, ok := object.(*pdfDict); ok && dict.get("Type") == pdfName("Catalog") && number > highest {
+				highest = number
+				doc.root = pdfRef{number: number}
+			}
+		}
+	}
+	return doc, nil
+}
+
+func (doc *pdfDocument) add(number int, object pdfObject) error {
+	doc.objects[number] = object
+	stream, ok := object.(*pdfStream)
+	if !ok {
+		return nil
+	}
+	switch stream.dict.get("Type") {
+	case pdfName("XRef"):
+		// cross-reference streams are the trailers of documents that compress them
+		doc.trailer(stream.dict)
+	case pdfName("ObjStm"):
+		if err := doc.addObjectStream(stream); err != nil {
+			return fmt.Errorf("invalid object stream: %w", err)
+		}
+	}
+	return nil
+}
+
+func (doc *pdfDocument) trailer(dict *pdfDict) {
+	if dict.get("Encrypt") != nil {
+		doc.encrypted = true
+	}
+	if root, ok := dict.get("Root").(pdfRef); ok {
+		doc.root = root
+	}
+}
+
+// addObjectStream adds the objects compressed in an object stream. Its header lists the
+// number of each object and where it starts after the First byte.
+func (doc *pdfDocument) addObjectStream(stream *pdfStream) error {
+	data, err := stream.decode(maxPDFObjectStream)
+	if err != nil {
+		return err
+	}
+	count, _ := pdfInt(stream.dict.get("N"))
+	first, _ := pdfInt(stream.dict.get("First"))
+
+	header := &pdfParser{data: data}
+	for i := 0; i < count; i++ {
+		header.skipSpace()
+		number, err := strconv.Atoi(string(header.token()))
+		if err != nil {
+			return fmt.Errorf("invalid header")
+		}
+		header.skipSpace()
+		offset, err := strconv.Atoi(string(header.token()))
+		if err != nil {
+			return fmt.Errorf("invalid header")
+		}
+		if first < 0 || offset < 0 || first+offset >= len(data) {
+			continue
+		}
+
+		p := &pdfParser{data: data, pos: first + offset}
+		if object, err := p.object(0); err == nil {
+			doc.objects[number] = object
+		}
+	}
+	return nil
+}
+
+// resolve follows a reference to the object it points to
+func (doc *pdfDocument) resolve(object pdfObject) pdfObject {
+	if ref, ok := object.(pdfRef); ok {
+		return doc.objects[ref.number]
+	}
+	return object
+}
+
+// pdfParser parses the objects of a PDF from a position in its data
+type pdfParser struct {
+	data []byte
+	pos  int
+}
+
+// skipSpace skips white space and comments
+func (p *pdfParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == '%':
+			for p.pos < len(p.data) && p.data[p.pos] != '\r' && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		case !pdfRegular(c) && c != '/' && c != '(' && c != ')' && c != '<' && c != '>' &&
+			c != '[' && c != ']' && c != '{' && c != '}':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// token reads a run of regular characters
+func (p *pdfParser) token() []byte {
+	start := p.pos
+	for p.pos < len(p.data) && pdfRegular(p.data[p.pos]) {
+		p.pos++
+	}
+	return p.data[start:p.pos]
+}
+
+// indirectObject parses the object following "number generation obj", with its data if
+// it is a stream
+func (p *pdfParser) indirectObject() (pdfObject, error) {
+	object, err := p.object(0)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !bytes.HasPrefix(p.data[p.pos:], []byte("stream")) {
+		return object, nil
+	}
+	dict, ok := object.(*pdfDict)
+	if !ok {
+		return nil, fmt.Errorf("stream without a dictionary")
+	}
+
+	// the data starts after the end of line following the keyword
+	p.pos += len("stream")
+	if p.pos < len(p.data) && p.data[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+		p.pos++
+	}
+	start := p.pos
+
+	// the length is trusted if endstream follows it, else the data ends at endstream
+	end, endstream := -1, -1
+	if length, ok := pdfInt(dict.get("Length")); ok && length >= 0 && length <= len(p.data)-start {
+		rest := bytes.TrimLeft(p.data[start+length:], "\x00\t\n\f\r ")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			end, endstream = start+length, len(p.data)-len(rest)
+		}
+	}
+	if end < 0 {
+		i := bytes.Index(p.data[start:], []byte("endstream"))
+		if i < 0 {
+			return nil, fmt.Errorf("unterminated stream")
+		}
+		end, endstream = start+i, start+i
+		if end > start && p.data[end-1] == '\n' {
+			end--
+		}
+		if end > start && p.data[end-1] == '\r' {
+			end--
+		}
+	}
+	p.pos = endstream + len("endstream")
+	return &pdfStream{dict: dict, data: p.data[start:end]}, nil
+}
+
+func (p *pdfParser) object(depth int) (pdfObject, error) {
+	if depth > maxPDFDepth {
+		return nil, fmt.Errorf("objects nested too deeply")
+	}
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	switch c := p.data[p.pos]; c {
+	case '/':
+		p.pos++
+		return pdfName(pdfDecodeName(p.token())), nil
+	case '(':
+		return p.literalString()
+	case '<':
+		if p.pos+1 < len(p.data) && p.data[p.pos+1] == '<' {
+			p.pos += 2
+			return p.dict(depth)
+		}
+		end := bytes.IndexByte(p.data[p.pos:], '>')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated hex string")
+		}
+		raw := p.data[p.pos : p.pos+end+1]
+		p.pos += end + 1
+		return pdfRaw(raw), nil
+	case '[':
+		p.pos++
+		array := pdfArray{}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if p.data[p.pos] == ']' {
+				p.pos++
+				return array, nil
+			}
+			item, err := p.object(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, item)
+		}
+	default:
+		token := p.token()
+		if len(token) == 0 {
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+		if number, err := strconv.Atoi(string(token)); err == nil && number >= 0 {
+			if ref, ok := p.reference(number); ok {
+				return ref, nil
+			}
+		}
+		return pdfRaw(token), nil
+	}
+}
+
+// reference reads the rest of a reference "number generation R" after its number, leaving
+// the position unchanged if there is none
+func (p *pdfParser) reference(number int) (pdfRef, bool) {
+	start := p.pos
+	p.skipSpace()
+	if generation, err := strconv.Atoi(string(p.token())); err == nil && generation >= 0 {
+		p.skipSpace()
+		if string(p.token()) == "R" {
+			return pdfRef{number: number, generation: generation}, true
+		}
+	}
+	p.pos = start
+	return pdfRef{}, false
+}
+
+func (p *pdfParser) dict(depth int) (pdfObject, error) {
+	dict := newPDFDict()
+	for {
+		p.skipSpace()
+		if bytes.HasPrefix(p.data[p.pos:], []byte(">>")) {
+			p.pos += 2
+			return dict, nil
+		}
+		key, err := p.object(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			return nil, fmt.Errorf("dictionary key is not a name")
+		}
+		value, err := p.object(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		dict.set(string(name), value)
+	}
+}
+
+// literalString reads a string, which may hold balanced parentheses and escapes
+func (p *pdfParser) literalString() (pdfObject, error) {
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '\\':
+			p.pos++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfRaw(p.data[start:p.pos]), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unterminated string")
+}
+
+// pdfRebuilder copies the catalog and page tree of a document, and the objects they use,
+// into a new document whose objects are numbered from 1 in the order they are copied
+type pdfRebuilder struct {
+	doc *pdfDocument
+	// pageTree holds the keys kept of each node of the page tree
+	pageTree map[int]map[string]bool
+	// numbers are the numbers in the new document of the objects copied
+	numbers map[int]int
+	queue   []int
+	objects []pdfObject
+	// removed counts the keys and the kinds of dictionaries left out
+	removed map[string]int
+}
+
+func (r *pdfRebuilder) rebuild() error {
+	catalog, ok := r.doc.resolve(r.doc.root).(*pdfDict)
+	if !ok {
+		return fmt.Errorf("no document catalog found")
+	}
+	pages, ok := catalog.get("Pages").(pdfRef)
+	if !ok || !r.walkPages(pages.number, 0) {
+		return errNoPDFPages
+	}
+	for _, key := range catalog.keys {
+		if key != "Type" && key != "Pages" {
+			r.removed[string(key)]++
+		}
+	}
+
+	newCatalog := newPDFDict()
+	newCatalog.set("Type", pdfName("Catalog"))
+	r.objects = append(r.objects, newCatalog)
+	newCatalog.set("Pages", r.ref(pages.number))
+
+	for len(r.queue) > 0 {
+		number := r.queue[0]
+		r.queue = r.queue[1:]
+		r.objects[r.numbers[number]-1] = r.copyObject(number)
+	}
+	return nil
+}
+
+// walkPages finds the nodes of the page tree under an object, reporting whether it has
+// any page
+func (r *pdfRebuilder) walkPages(number, depth int) bool {
+	if depth > maxPDFDepth || r.pageTree[number] != nil {
+		return false
+	}
+	node, ok := r.doc.objects[number].(*pdfDict)
+	if !ok {
+		return false
+	}
+
+	switch node.get("Type") {
+	case pdfName("Page"):
+		r.pageTree[number] = pdfPageKeys
+		return true
+	case pdfName("Pages"):
+		r.pageTree[number] = pdfPagesKeys
+		found := false
+		kids, _ := r.doc.resolve(node.get("Kids")).(pdfArray)
+		for _, kid := range kids {
+			if ref, ok := kid.(pdfRef); ok && r.walkPages(ref.number, depth+1) {
+				found = true
+			}
+		}
+		return found
+	}
+	return false
+}
+
+// ref returns the reference to an object in the new document, queueing the object to be
+// copied. References to missing objects are null.
+func (r *pdfRebuilder) ref(number int) pdfObject {
+	if copied, ok := r.numbers[number]; ok {
+		return pdfRef{number: copied}
+	}
+	if _, ok := r.doc.objects[number]; !ok {
+		return pdfNull
+	}
+	r.objects = append(r.objects, nil)
+	r.numbers[number] = len(r.objects)
+	r.queue = append(r.queue, number)
+	return pdfRef{number: len(r.objects)}
+}
+
+// copyObject copies an object of the document, keeping only the keys of the page tree
+// that set how pages look
+func (r *pdfRebuilder) copyObject(number int) pdfObject {
+	object := r.doc.objects[number]
+	keys := r.pageTree[number]
+	if keys == nil {
+		return r.sanitize(object)
+	}
+
+	node := object.(*pdfDict)
+	kept := newPDFDict()
+	for _, key := range node.keys {
+		if !keys[string(key)] {
+			r.removed[string(key)]++
+			continue
+		}
+		value := node.values[key]
+		if key == "Kids" {
+			// kids that are not pages are left out
+			kids, _ := r.doc.resolve(value).(pdfArray)
+			pages := pdfArray{}
+			for _, kid := range kids {
+				if ref, ok := kid.(pdfRef); ok && r.pageTree[ref.number] != nil {
+					pages = append(pages, kid)
+				}
+			}
+			value = pages
+		}
+		kept.set(string(key), value)
+	}
+	return r.sanitize(kept)
+}
+
+// sanitize copies an object with its references mapped to the new document, leaving out
+// active keys and replacing annotations, actions and embedded files with null
+func (r *pdfRebuilder) sanitize(object pdfObject) pdfObject {
+	switch o := object.(type) {
+	case pdfRef:
+		return r.ref(o.number)
+	case pdfArray:
+		copied := make(pdfArray, len(o))
+		for i, item := range o {
+			copied[i] = r.sanitize(item)
+		}
+		return copied
+	case *pdfDict:
+		dict, ok := r.sanitizeDict(o, "")
+		if !ok {
+			return pdfNull
+		}
+		return dict
+	case *pdfStream:
+		// the length of the data is written directly, whatever the original referenced
+		dict, ok := r.sanitizeDict(o.dict, "Length")
+		if !ok {
+			return pdfNull
+		}
+		dict.set("Length", pdfRaw(strconv.Itoa(len(o.data))))
+		return &pdfStream{dict: dict, data: o.data}
+	}
+	return object
+}
+
+// sanitizeDict copies a dictionary but for the key skip, reporting false for dictionaries
+// that are dropped whole
+func (r *pdfRebuilder) sanitizeDict(dict *pdfDict, skip string) (*pdfDict, bool) {
+	if action, ok := dict.get("S").(pdfName); ok && pdfActiveNames[string(action)] {
+		r.removed[string(action)]++
+		return nil, false
+	}
+	if kind, ok := dict.get("Type").(pdfName); ok && pdfInactiveTypes[string(kind)] {
+		r.removed[string(kind)]++
+		return nil, false
+	}
+
+	copied := newPDFDict()
+	for _, key := range dict.keys {
+		switch {
+		case string(key) == skip:
+		case pdfActiveNames[string(key)]:
+			r.removed[string(key)]++
+		default:
+			copied.set(string(key), r.sanitize(dict.values[key]))
+		}
+	}
+	return copied, true
+}
+
+// write writes the new document with a cross-reference table
+func (r *pdfRebuilder) write(dst io.Writer) error {
+	w := &pdfWriter{w: bufio.NewWriter(dst)}
+	w.writeString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+
+	offsets := make([]int64, len(r.objects))
+	for i, object := range r.objects {
+		offsets[i] = w.n
+		w.writeString(strconv.Itoa(i+1) + " 0 obj\n")
+		w.object(object)
+		w.writeString("\nendobj\n")
+	}
+
+	xref := w.n
+	w.writeString(fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", len(r.objects)+1))
+	for _, offset := range offsets {
+		w.writeString(fmt.Sprintf("%010d 00000 n \n", offset))
+	}
+	w.writeString(fmt.Sprintf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(r.objects)+1, xref))
+
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// pdfWriter writes objects, counting the bytes written for the cross-reference table
+type pdfWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *pdfWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+}
+
+func (w *pdfWriter) writeString(s string) {
+	w.write([]byte(s))
+}
+
+func (w *pdfWriter) object(object pdfObject) {
+	switch o := object.(type) {
+	case pdfName:
+		w.name(o)
+	case pdfRaw:
+		w.write(o)
+	case pdfRef:
+		w.writeString(fmt.Sprintf("%d %d R", o.number, o.generation))
+	case pdfArray:
+		w.writeString("[")
+		for i, item := range o {
+			if i > 0 {
+				w.writeString(" ")
+			}
+			w.object(item)
+		}
+		w.writeString("]")
+	case *pdfDict:
+		w.writeString("<<")
+		for _, key := range o.keys {
+			w.writeString(" ")
+			w.name(key)
+			w.writeString(" ")
+			w.object(o.values[key])
+		}
+		w.writeString(" >>")
+	case *pdfStream:
+		w.object(o.dict)
+		w.writeString("\nstream\n")
+		w.write(o.data)
+		w.writeString("\nendstream")
+	}
+}
+
+// name writes a name, escaping the characters that cannot appear in it as is
+func (w *pdfWriter) name(name pdfName) {
+	escaped := []byte{'/'}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < '!' || c > '~' || c == '#' || !pdfRegular(c) {
+			escaped = append(escaped, fmt.Sprintf("#%02X", c)...)
+		} else {
+			escaped = append(escaped, c)
+		}
+	}
+	w.write(escaped)
+}
+
+// pdfDecodeName resolves the #xx escapes of a name
+func pdfDecodeName(raw []byte) string {
+	if bytes.IndexByte(raw, '#') < 0 {
+		return string(raw)
+	}
+	decoded := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '#' && i+2 < len(raw) {
+			if v, err := strconv.ParseUint(string(raw[i+1:i+3]), 16, 8); err == nil {
+				decoded = append(decoded, byte(v))
+				i += 2
+				continue
+			}
+		}
+		decoded = append(decoded, raw[i])
+	}
+	return string(decoded)
+}
+
+// pdfRegular reports whether c is neither white space nor a delimiter
+func pdfRegular(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ', '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return false
+	}
+	return true
+}
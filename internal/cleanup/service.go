@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/browsersec/KubeBrowse/internal/k8s"
+	"github.com/browsersec/KubeBrowse/internal/metrics"
 	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
 	"k8s.io/client-go/kubernetes"
@@ -111,6 +112,7 @@ func (s *CleanupService) CleanupOrphanedPods() error {
 		if !sessionExists {
 			logrus.Infof("No active session for pod %s, terminating", podName)
 			err = k8s.DeletePodGrace(s.k8sClient, s.namespace, podName)
+			metrics.CleanupActions.WithLabelValues("orphaned_pod", metrics.Result(err)).Inc()
 			if err != nil {
 				logrus.Errorf("Failed to delete orphaned pod %s: %v", podName, err)
 			}
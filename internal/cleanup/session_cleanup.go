@@ -7,10 +7,13 @@ import (
 	"time"
 
 	guac2 "github.com/browsersec/KubeBrowse/internal/guac"
+	"github.com/browsersec/KubeBrowse/internal/history"
 	"github.com/browsersec/KubeBrowse/internal/k8s"
+	"github.com/browsersec/KubeBrowse/internal/metrics"
 	redis2 "github.com/browsersec/KubeBrowse/internal/redis"
 	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -24,6 +27,11 @@ type SessionCleanupService struct {
 	mutex         sync.RWMutex
 	stopChan      chan struct{}
 	checkInterval time.Duration
+
+	// browserSessions is set when sessions are managed through BrowserSession resources
+	browserSessions *k8s.BrowserSessionController
+	// sessionHistory records the sessions that timed out, nil without a database
+	sessionHistory *history.Service
 }
 
 type SessionMonitor struct {
@@ -47,6 +55,16 @@ func NewSessionCleanupService(redisClient *redis.Client, k8sClient *kubernetes.C
 	}
 }
 
+// SetBrowserSessions makes the service end expired sessions by deleting their BrowserSession
+func (s *SessionCleanupService) SetBrowserSessions(sessions *k8s.BrowserSessionController) {
+	s.browserSessions = sessions
+}
+
+// SetHistory makes the service record the sessions it ends in the session history
+func (s *SessionCleanupService) SetHistory(sessionHistory *history.Service) {
+	s.sessionHistory = sessionHistory
+}
+
 func (s *SessionCleanupService) Start() {
 	logrus.Info("Starting session cleanup service")
 	go s.cleanupLoop()
@@ -202,8 +220,19 @@ func (s *SessionCleanupService) cleanupPod(monitor *SessionMonitor) {
 		}
 	}
 
-	// Delete the pod
-	err = k8s.DeletePod(s.k8sClient, monitor.PodName)
+	// Sessions stopped by their user were recorded as such before their data was deleted
+	s.sessionHistory.Ended(monitor.SessionID, history.EndTimeout)
+
+	// Delete the BrowserSession, which deletes its pod, or the pod if the session has none
+	if s.browserSessions != nil {
+		err = s.browserSessions.Delete(context.Background(), monitor.SessionID)
+		if errors.IsNotFound(err) {
+			err = k8s.DeletePod(s.k8sClient, monitor.PodName)
+		}
+	} else {
+		err = k8s.DeletePod(s.k8sClient, monitor.PodName)
+	}
+	metrics.CleanupActions.WithLabelValues("session_expired", metrics.Result(err)).Inc()
 	if err != nil {
 		logrus.Errorf("Failed to delete pod %s for expired session %s: %v", monitor.PodName, monitor.SessionID, err)
 	} else {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/smtp"
+	"net/url"
 	"os"
 	"strconv"
 
@@ -19,6 +20,7 @@ type Service struct {
 	fromEmail    string
 	fromName     string
 	baseURL      string
+	frontendURL  string
 }
 
 type EmailData struct {
@@ -35,6 +37,13 @@ type VerificationEmailData struct {
 	BaseURL         string
 }
 
+type PasswordResetEmailData struct {
+	Name     string
+	Email    string
+	ResetURL string
+	BaseURL  string
+}
+
 func NewService() *Service {
 	smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
 	if smtpPort == 0 {
@@ -46,6 +55,11 @@ func NewService() *Service {
 		baseURL = "https://localhost:4567"
 	}
 
+	frontendURL := os.Getenv("FRONTEND_URL")
+	if frontendURL == "" {
+		frontendURL = "http://localhost:5173"
+	}
+
 	return &Service{
 		smtpHost:     os.Getenv("SMTP_HOST"),
 		smtpPort:     smtpPort,
@@ -54,6 +68,7 @@ func NewService() *Service {
 		fromEmail:    os.Getenv("FROM_EMAIL"),
 		fromName:     os.Getenv("FROM_NAME"),
 		baseURL:      baseURL,
+		frontendURL:  frontendURL,
 	}
 }
 
@@ -85,6 +100,31 @@ func (s *Service) SendVerificationEmail(to, name, token string) error {
 	return s.sendEmail(to, subject, htmlBody)
 }
 
+// SendPasswordResetEmail sends a link to the page of the frontend where users choose a new password
+func (s *Service) SendPasswordResetEmail(to, name, token string) error {
+	if !s.IsConfigured() {
+		logrus.Warn("Email service not configured - skipping password reset email")
+		return fmt.Errorf("email service not configured")
+	}
+
+	resetURL := fmt.Sprintf("%s/reset-password?token=%s", s.frontendURL, url.QueryEscape(token))
+
+	data := PasswordResetEmailData{
+		Name:     name,
+		Email:    to,
+		ResetURL: resetURL,
+		BaseURL:  s.baseURL,
+	}
+
+	subject := "Reset your password"
+	htmlBody, err := s.renderTemplate("password_reset", data)
+	if err != nil {
+		return fmt.Errorf("failed to render email template: %w", err)
+	}
+
+	return s.sendEmail(to, subject, htmlBody)
+}
+
 func (s *Service) sendEmail(to, subject, htmlBody string) error {
 	auth := smtp.PlainAuth("", s.smtpUsername, s.smtpPassword, s.smtpHost)
 
@@ -108,7 +148,7 @@ func (s *Service) sendEmail(to, subject, htmlBody string) error {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 
-	logrus.Infof("Verification email sent successfully to %s", to)
+	logrus.Infof("Email %q sent successfully to %s", subject, to)
 	return nil
 }
 
@@ -118,6 +158,8 @@ func (s *Service) renderTemplate(templateName string, data interface{}) (string,
 	switch templateName {
 	case "verification":
 		tmplContent = verificationEmailTemplate
+	case "password_reset":
+		tmplContent = passwordResetEmailTemplate
 	default:
 		return "", fmt.Errorf("unknown template: %s", templateName)
 	}
@@ -211,3 +253,79 @@ const verificationEmailTemplate = `
 </body>
 </html>
 `
+
+const passwordResetEmailTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Reset Your Password</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            line-height: 1.6;
+            color: #333;
+            max-width: 600px;
+            margin: 0 auto;
+            padding: 20px;
+        }
+        .header {
+            background-color: #4f46e5;
+            color: white;
+            padding: 20px;
+            text-align: center;
+            border-radius: 8px 8px 0 0;
+        }
+        .content {
+            background-color: #f9fafb;
+            padding: 30px;
+            border-radius: 0 0 8px 8px;
+        }
+        .button {
+            display: inline-block;
+            background-color: #4f46e5;
+            color: white;
+            padding: 12px 24px;
+            text-decoration: none;
+            border-radius: 6px;
+            margin: 20px 0;
+        }
+        .footer {
+            margin-top: 30px;
+            padding-top: 20px;
+            border-top: 1px solid #e5e7eb;
+            font-size: 14px;
+            color: #6b7280;
+        }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>KubeBrowse</h1>
+    </div>
+    <div class="content">
+        <h2>Reset Your Password</h2>
+        <p>Hi{{if .Name}} {{.Name}}{{end}},</p>
+        <p>We received a request to reset the password of your KubeBrowse account. To choose a new password, click the button below:</p>
+        
+        <div style="text-align: center;">
+            <a href="{{.ResetURL}}" class="button">Reset Password</a>
+        </div>
+        
+        <p>If the button doesn't work, you can also copy and paste this link into your browser:</p>
+        <p style="word-break: break-all; background-color: #f3f4f6; padding: 10px; border-radius: 4px;">
+            {{.ResetURL}}
+        </p>
+        
+        <p><strong>This link will expire in 1 hour and can only be used once.</strong> Resetting your password signs you out of every device.</p>
+        
+        <p>If you didn't request a password reset, you can safely ignore this email, your password will not change.</p>
+    </div>
+    <div class="footer">
+        <p>Best regards,<br>The KubeBrowse Team</p>
+        <p>This email was sent to {{.Email}}. If you have any questions, please contact our support team.</p>
+    </div>
+</body>
+</html>
+`
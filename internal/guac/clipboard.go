@@ -0,0 +1,327 @@
+package guac
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/browsersec/KubeBrowse/internal/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// clipboardBufferLimit bounds the clipboard data held per stream when the policy sets no
+// smaller maximum
+const clipboardBufferLimit = 4 << 20
+
+// Clipboard transfer verdicts
+const (
+	ClipboardAllowed = "allowed"
+	ClipboardBlocked = "blocked"
+	// ClipboardFlagged transfers contained a secret but were passed on, see ClipboardPolicy.OnSecret
+	ClipboardFlagged = "flagged"
+)
+
+// ClipboardPolicy limits the clipboard transfers between the user and a sandbox. Every
+// transfer is reassembled before it is passed on and recorded in an audit event.
+type ClipboardPolicy struct {
+	// MaxSize is the largest transfer in bytes, 4 MiB if zero
+	MaxSize int `json:"maxSize,omitempty"`
+	// MimeTypes allowed, like text/plain or text/*, all if empty
+	MimeTypes []string `json:"mimeTypes,omitempty"`
+	// Detectors look for secrets in text transfers
+	Detectors []SecretDetector `json:"detectors,omitempty"`
+	// OnSecret is "block" to drop transfers containing a secret, the default, or "audit"
+	// to only record them
+	OnSecret string `json:"onSecret,omitempty"`
+}
+
+// SecretDetector finds secrets with a regular expression. Detectors without a pattern
+// are built in: aws-access-key, github-token, slack-token, private-key, api-key and
+// credit-card, which also checks the card number.
+type SecretDetector struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+var builtinSecretPatterns = map[string]string{
+	"aws-access-key": `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	"github-token":   `\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	"slack-token":    `\bxox[abposr]-[A-Za-z0-9-]{10,}`,
+	"private-key":    `-----BEGIN (?:[A-Z]+ )*PRIVATE KEY-----`,
+	"api-key":        `(?i)\b(?:api[_-]?key|secret|access[_-]?token)\b["']?\s*[:=]\s*["']?[A-Za-z0-9_\-./+]{16,}`,
+	"credit-card":    `\b\d(?:[ -]?\d){12,18}\b`,
+}
+
+// clipboardEvent is the audit record of a clipboard transfer
+type clipboardEvent struct {
+	SessionID string
+	Direction Direction
+	MimeType  string
+	Size      int
+	Verdict   string
+	Reason    string
+	Secrets   []string
+}
+
+type secretDetector struct {
+	name  string
+	re    *regexp.Regexp
+	check func(string) bool
+}
+
+type compiledClipboardPolicy struct {
+	maxSize     int
+	mimeTypes   []string
+	detectors   []secretDetector
+	blockSecret bool
+}
+
+// Validate checks the mime types and detectors of the policy
+func (p *ClipboardPolicy) Validate() error {
+	_, err := p.compile()
+	return err
+}
+
+func (p *ClipboardPolicy) compile() (*compiledClipboardPolicy, error) {
+	compiled := &compiledClipboardPolicy{
+		maxSize:     p.MaxSize,
+		mimeTypes:   p.MimeTypes,
+		blockSecret: true,
+	}
+	if p.MaxSize < 0 {
+		return nil, fmt.Errorf("clipboard maxSize must not be negative")
+	}
+	if compiled.maxSize == 0 || compiled.maxSize > clipboardBufferLimit {
+		compiled.maxSize = clipboardBufferLimit
+	}
+
+	switch p.OnSecret {
+	case "", "block":
+	case "audit":
+		compiled.blockSecret = false
+	default:
+		return nil, fmt.Errorf("invalid clipboard onSecret %q, must be block or audit", p.OnSecret)
+	}
+
+	for _, detector := range p.Detectors {
+		pattern := detector.Pattern
+		if pattern == "" {
+			var ok bool
+			if pattern, ok = builtinSecretPatterns[detector.Name]; !ok {
+				return nil, fmt.Errorf("unknown clipboard secret detector %q", detector.Name)
+			}
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of clipboard secret detector %q: %w", detector.Name, err)
+		}
+		compiled.detectors = append(compiled.detectors, secretDetector{
+			name:  detector.Name,
+			re:    re,
+			check: secretChecks[detector.Name],
+		})
+	}
+	return compiled, nil
+}
+
+// Further checks of matches of built-in detectors that cut down on false positives
+var secretChecks = map[string]func(string) bool{
+	"credit-card": luhnValid,
+}
+
+// luhnValid reports whether the digits of number have a valid Luhn checksum
+func luhnValid(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		if !unicode.IsDigit(rune(number[i])) {
+			continue
+		}
+		d := int(number[i] - '0')
+		if digits%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+func (p *compiledClipboardPolicy) allowsMimeType(mimeType string) bool {
+	if len(p.mimeTypes) == 0 {
+		return true
+	}
+	// ignore parameters like the charset
+	mimeType = strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0])
+	for _, allowed := range p.mimeTypes {
+		if allowed == mimeType || allowed == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// secrets returns the names of the detectors that find a secret in text
+func (p *compiledClipboardPolicy) secrets(text string) []string {
+	var found []string
+	for _, detector := range p.detectors {
+		for _, match := range detector.re.FindAllString(text, -1) {
+			if detector.check == nil || detector.check(match) {
+				found = append(found, detector.name)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// ClipboardInspector reassembles the clipboard streams of a connection in both directions,
+// holding back their instructions until the whole transfer has been checked against the
+// clipboard policy
+type ClipboardInspector struct {
+	sessionID string
+	policy    *compiledClipboardPolicy
+	// streams holds the open clipboard streams of each direction by index
+	streams [2]map[string]*clipboardStream
+}
+
+type clipboardStream struct {
+	mimeType string
+	held     []byte
+	data     []byte
+}
+
+// NewClipboardInspector creates the inspector of one connection of a session, nil if
+// there is no policy
+func NewClipboardInspector(sessionID string, policy *ClipboardPolicy) (*ClipboardInspector, error) {
+	if policy == nil {
+		return nil, nil
+	}
+	compiled, err := policy.compile()
+	if err != nil {
+		return nil, err
+	}
+	return &ClipboardInspector{
+		sessionID: sessionID,
+		policy:    compiled,
+		streams:   [2]map[string]*clipboardStream{{}, {}},
+	}, nil
+}
+
+// inspect returns what to pass on for an instruction and whether that differs from raw.
+// The instructions of clipboard streams are held back until the end of the stream and
+// then passed on together, unless the transfer is blocked. The index of a blocked stream
+// is added to dropped, so the rest of it is discarded.
+func (i *ClipboardInspector) inspect(direction Direction, opcode string, raw []byte, dropped map[string]bool) ([]byte, bool) {
+	streams := i.streams[direction]
+
+	switch opcode {
+	case "clipboard":
+		ins, err := Parse(raw)
+		if err != nil || len(ins.Args) < 2 {
+			return nil, true
+		}
+		index, mimeType := ins.Args[0], ins.Args[1]
+		if !i.policy.allowsMimeType(mimeType) {
+			dropped[index] = true
+			i.audit(clipboardEvent{Direction: direction, MimeType: mimeType, Verdict: ClipboardBlocked, Reason: "mime type not allowed"})
+			return nil, true
+		}
+		streams[index] = &clipboardStream{mimeType: mimeType, held: append([]byte(nil), raw...)}
+		return nil, true
+
+	case "blob", "end":
+		index, ok := firstArgument(raw)
+		if !ok {
+			return raw, false
+		}
+		stream, ok := streams[index]
+		if !ok {
+			return raw, false
+		}
+
+		if opcode == "end" {
+			delete(streams, index)
+			return i.finish(direction, stream, raw)
+		}
+
+		ins, err := Parse(raw)
+		if err != nil || len(ins.Args) < 2 {
+			return nil, true
+		}
+		data, err := base64.StdEncoding.DecodeString(ins.Args[1])
+		if err != nil {
+			data = []byte(ins.Args[1])
+		}
+		if len(stream.data)+len(data) > i.policy.maxSize {
+			delete(streams, index)
+			dropped[index] = true
+			i.audit(clipboardEvent{
+				Direction: direction,
+				MimeType:  stream.mimeType,
+				Size:      len(stream.data) + len(data),
+				Verdict:   ClipboardBlocked,
+				Reason:    fmt.Sprintf("larger than %d bytes", i.policy.maxSize),
+			})
+			return nil, true
+		}
+		stream.data = append(stream.data, data...)
+		stream.held = append(stream.held, raw...)
+		return nil, true
+	}
+
+	return raw, false
+}
+
+// finish checks a complete transfer and returns its instructions if it may be passed on
+func (i *ClipboardInspector) finish(direction Direction, stream *clipboardStream, end []byte) ([]byte, bool) {
+	event := clipboardEvent{
+		Direction: direction,
+		MimeType:  stream.mimeType,
+		Size:      len(stream.data),
+		Verdict:   ClipboardAllowed,
+	}
+	if strings.HasPrefix(stream.mimeType, "text/") {
+		event.Secrets = i.policy.secrets(string(stream.data))
+	}
+	if len(event.Secrets) > 0 {
+		event.Reason = "secret detected"
+		event.Verdict = ClipboardFlagged
+		if i.policy.blockSecret {
+			event.Verdict = ClipboardBlocked
+		}
+	}
+	i.audit(event)
+
+	if event.Verdict == ClipboardBlocked {
+		return nil, true
+	}
+	return append(stream.held, end...), true
+}
+
+func (i *ClipboardInspector) audit(event clipboardEvent) {
+	event.SessionID = i.sessionID
+	metrics.ClipboardTransfers.WithLabelValues(event.Direction.String(), event.Verdict).Inc()
+
+	entry := logrus.WithFields(logrus.Fields{
+		"audit":      "clipboard",
+		"session_id": event.SessionID,
+		"direction":  event.Direction.String(),
+		"mime_type":  event.MimeType,
+		"size":       event.Size,
+		"verdict":    event.Verdict,
+	})
+	if event.Reason != "" {
+		entry = entry.WithField("reason", event.Reason)
+	}
+	if len(event.Secrets) > 0 {
+		entry = entry.WithField("secrets", event.Secrets)
+	}
+	entry.Info("Clipboard transfer")
+}
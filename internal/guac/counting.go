@@ -0,0 +1,77 @@
+package guac
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// CountingTunnel wraps a Tunnel and counts the bytes read from and written to guacd,
+// which are reported once when the tunnel is closed. Like RecordingTunnel, it counts the
+// traffic of both the WebSocket and the HTTP tunnel.
+type CountingTunnel struct {
+	Tunnel
+	bytes   atomic.Int64
+	onClose func(bytes int64)
+
+	closeOnce sync.Once
+}
+
+// NewCountingTunnel wraps tunnel so that onClose is called with the bytes exchanged with
+// guacd when it is closed
+func NewCountingTunnel(tunnel Tunnel, onClose func(bytes int64)) *CountingTunnel {
+	return &CountingTunnel{
+		Tunnel:  tunnel,
+		onClose: onClose,
+	}
+}
+
+// AcquireReader acquires the underlying reader and returns a reader that counts the
+// instructions it returns
+func (t *CountingTunnel) AcquireReader() InstructionReader {
+	return &countingReader{InstructionReader: t.Tunnel.AcquireReader(), bytes: &t.bytes}
+}
+
+// AcquireWriter acquires the underlying writer and returns a writer that counts what is
+// written to it
+func (t *CountingTunnel) AcquireWriter() io.Writer {
+	return &countingWriter{Writer: t.Tunnel.AcquireWriter(), bytes: &t.bytes}
+}
+
+// Bytes returns the bytes exchanged with guacd so far
+func (t *CountingTunnel) Bytes() int64 {
+	return t.bytes.Load()
+}
+
+// Close closes the underlying tunnel and reports the bytes it exchanged
+func (t *CountingTunnel) Close() error {
+	err := t.Tunnel.Close()
+	t.closeOnce.Do(func() {
+		if t.onClose != nil {
+			t.onClose(t.bytes.Load())
+		}
+	})
+	return err
+}
+
+type countingReader struct {
+	InstructionReader
+	bytes *atomic.Int64
+}
+
+func (r *countingReader) ReadSome() ([]byte, error) {
+	ins, err := r.InstructionReader.ReadSome()
+	r.bytes.Add(int64(len(ins)))
+	return ins, err
+}
+
+type countingWriter struct {
+	io.Writer
+	bytes *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.bytes.Add(int64(n))
+	return n, err
+}
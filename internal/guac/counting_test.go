@@ -0,0 +1,34 @@
+package guac
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCountingTunnel_CountsBothDirections(t *testing.T) {
+	conn := &fakeConn{
+		ToRead: []byte("4.sync,4.1000;4.copy,2.ab;"),
+	}
+	var reported []int64
+	tunnel := NewCountingTunnel(&fakeTunnel{reader: NewStream(conn, time.Minute), writer: &bytes.Buffer{}}, func(bytes int64) {
+		reported = append(reported, bytes)
+	})
+
+	msgWriter := &fakeMessageWriter{}
+	guacdToWs(msgWriter, tunnel.AcquireReader(), nil)
+	if _, err := tunnel.AcquireWriter().Write([]byte("5.mouse,1.1,1.2;")); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	want := int64(len("4.sync,4.1000;4.copy,2.ab;") + len("5.mouse,1.1,1.2;"))
+	if tunnel.Bytes() != want {
+		t.Errorf("Counted %d bytes, want %d", tunnel.Bytes(), want)
+	}
+
+	_ = tunnel.Close()
+	_ = tunnel.Close()
+	if len(reported) != 1 || reported[0] != want {
+		t.Errorf("Expected %d bytes to be reported once, got %v", want, reported)
+	}
+}
@@ -0,0 +1,168 @@
+package guac
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DownloadHandler receives the files the sandbox sends to the user. Instead of reaching the
+// user directly, downloads are handed to the handler, which decides how they are released.
+type DownloadHandler interface {
+	// StartDownload is called when the sandbox starts sending a file and returns the writer
+	// the content of the file is written to. An error refuses the download.
+	StartDownload(filename, mimeType string) (DownloadWriter, error)
+}
+
+// DownloadWriter receives the content of one download
+type DownloadWriter interface {
+	io.Writer
+	// Close is called once the whole file was received
+	Close() error
+	// Abort is called instead of Close when the transfer does not complete
+	Abort(reason error)
+}
+
+// downloadQueueSize is how many blobs of a download may wait for its writer. Downloads
+// whose writer falls further behind are aborted instead of stalling the connection.
+const downloadQueueSize = 64
+
+// DownloadInterceptor takes over the file streams the sandbox opens to send files to the
+// user. It acknowledges their blobs to guacd itself and passes their content to a
+// DownloadHandler, the user never sees the streams. The content is written to the
+// DownloadWriter in the background, so that a slow writer never blocks the connection.
+type DownloadInterceptor struct {
+	// guacd receives the acknowledgements of the intercepted streams
+	guacd   io.Writer
+	handler DownloadHandler
+
+	mu        sync.Mutex
+	downloads map[string]*writeQueue
+}
+
+// NewDownloadInterceptor creates the interceptor of one connection. guacd must be safe to
+// write to while the tunnel writer is in use, like the Stream of the connection.
+func NewDownloadInterceptor(guacd io.Writer, handler DownloadHandler) *DownloadInterceptor {
+	return &DownloadInterceptor{
+		guacd:     guacd,
+		handler:   handler,
+		downloads: map[string]*writeQueue{},
+	}
+}
+
+// intercept returns what to pass on to the user for an instruction from the sandbox
+func (d *DownloadInterceptor) intercept(opcode string, raw []byte) ([]byte, bool) {
+	switch opcode {
+	case "file":
+		ins, err := Parse(raw)
+		if err != nil || len(ins.Args) < 3 {
+			return nil, true
+		}
+		index, mimeType, filename := ins.Args[0], ins.Args[1], ins.Args[2]
+
+		writer, err := d.handler.StartDownload(filename, mimeType)
+		if err != nil {
+			logrus.Warnf("Refused download of %q: %v", filename, err)
+			d.ack(index, err.Error(), ResourceClosed)
+			return nil, true
+		}
+		d.mu.Lock()
+		d.downloads[index] = d.queue(index, writer)
+		d.mu.Unlock()
+		d.ack(index, "OK", Success)
+		return nil, true
+
+	case "blob", "end":
+		index, ok := firstArgument(raw)
+		if !ok {
+			return raw, false
+		}
+		d.mu.Lock()
+		queue, ok := d.downloads[index]
+		if ok && opcode == "end" {
+			delete(d.downloads, index)
+		}
+		d.mu.Unlock()
+		if !ok {
+			return raw, false
+		}
+
+		if opcode == "end" {
+			queue.close(nil)
+			return nil, true
+		}
+
+		if err := queue.write(raw); err != nil {
+			d.mu.Lock()
+			delete(d.downloads, index)
+			d.mu.Unlock()
+			// the queue acknowledged the blob that failed to be written
+			if errors.Is(err, errQueueFull) {
+				logrus.Warnf("Aborted download stream %s: the download fell behind", index)
+				d.ack(index, "Download fell behind", ServerError)
+			}
+		}
+		return nil, true
+	}
+
+	return raw, false
+}
+
+// queue starts the queue writing the blobs of a download stream to its writer. Each blob
+// is acknowledged once written, so that guacd sends the file no faster than it is stored.
+// The writer is closed or aborted when the queue finishes.
+func (d *DownloadInterceptor) queue(index string, writer DownloadWriter) *writeQueue {
+	return newWriteQueue(downloadQueueSize, func(raw []byte) error {
+		if err := d.write(writer, raw); err != nil {
+			d.ack(index, err.Error(), ServerError)
+			return err
+		}
+		d.ack(index, "OK", Success)
+		return nil
+	}, func(err error) {
+		if err != nil {
+			writer.Abort(err)
+			return
+		}
+		if err := writer.Close(); err != nil {
+			logrus.Warnf("Failed to complete download: %v", err)
+		}
+	})
+}
+
+func (d *DownloadInterceptor) write(writer DownloadWriter, raw []byte) error {
+	ins, err := Parse(raw)
+	if err != nil || len(ins.Args) < 2 {
+		return fmt.Errorf("invalid blob")
+	}
+	data, err := base64.StdEncoding.DecodeString(ins.Args[1])
+	if err != nil {
+		return fmt.Errorf("invalid blob: %w", err)
+	}
+	_, err = writer.Write(data)
+	return err
+}
+
+// ack acknowledges an instruction of an intercepted stream, an error status closes the stream
+func (d *DownloadInterceptor) ack(index, message string, status Status) {
+	ack := NewInstruction("ack", index, message, fmt.Sprint(status.GetGuacamoleStatusCode()))
+	if _, err := d.guacd.Write(ack.Byte()); err != nil {
+		logrus.Debugf("Failed to acknowledge download stream %s: %v", index, err)
+	}
+}
+
+// close aborts the downloads still in progress when the connection closes
+func (d *DownloadInterceptor) close() {
+	d.mu.Lock()
+	downloads := d.downloads
+	d.downloads = map[string]*writeQueue{}
+	d.mu.Unlock()
+
+	for _, queue := range downloads {
+		queue.close(fmt.Errorf("connection closed"))
+	}
+}
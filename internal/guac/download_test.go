@@ -0,0 +1,131 @@
+package guac
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type fakeDownload struct {
+	bytes.Buffer
+	// blocked holds back writes until it is closed, if set
+	blocked  chan struct{}
+	finished chan struct{}
+	closed   bool
+	aborted  error
+}
+
+func (d *fakeDownload) Write(p []byte) (int, error) {
+	if d.blocked != nil {
+		<-d.blocked
+	}
+	return d.Buffer.Write(p)
+}
+
+func (d *fakeDownload) Close() error {
+	d.closed = true
+	close(d.finished)
+	return nil
+}
+
+func (d *fakeDownload) Abort(reason error) {
+	d.aborted = reason
+	close(d.finished)
+}
+
+type fakeDownloadHandler struct {
+	downloads map[string]*fakeDownload
+	blocked   chan struct{}
+	err       error
+}
+
+func (h *fakeDownloadHandler) StartDownload(filename, mimeType string) (DownloadWriter, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+	download := &fakeDownload{blocked: h.blocked, finished: make(chan struct{})}
+	h.downloads[filename] = download
+	return download, nil
+}
+
+func TestDownloadInterceptor(t *testing.T) {
+	t.Run("InterceptsFile", func(t *testing.T) {
+		guacd := &bytes.Buffer{}
+		handler := &fakeDownloadHandler{downloads: map[string]*fakeDownload{}}
+		chain := (*FilterChain)(nil).WithDownloadInterceptor(NewDownloadInterceptor(guacd, handler))
+
+		data := []byte("4.file,1.2,10.text/plain,9.notes.txt;4.blob,1.2,8.aGVsbG8=;4.sync,8.12345678;3.end,1.2;")
+		if got, want := string(chain.Filter(GuacdToClient, data)), "4.sync,8.12345678;"; got != want {
+			t.Fatalf("Filter=%q, want %q", got, want)
+		}
+
+		download := handler.downloads["notes.txt"]
+		<-download.finished
+		if download.String() != "hello" || !download.closed {
+			t.Fatalf("download=%+v, want closed download of hello", download)
+		}
+		if got, want := guacd.String(), strings.Repeat("3.ack,1.2,2.OK,1.0;", 2); got != want {
+			t.Fatalf("acks=%q, want %q", got, want)
+		}
+	})
+
+	t.Run("RefusesFile", func(t *testing.T) {
+		guacd := &bytes.Buffer{}
+		handler := &fakeDownloadHandler{err: errors.New("no")}
+		chain := (*FilterChain)(nil).WithDownloadInterceptor(NewDownloadInterceptor(guacd, handler))
+
+		if got := chain.Filter(GuacdToClient, []byte("4.file,1.2,10.text/plain,9.notes.txt;")); len(got) != 0 {
+			t.Fatalf("Filter=%q, want nothing", got)
+		}
+		if got, want := guacd.String(), "3.ack,1.2,2.no,3.518;"; got != want {
+			t.Fatalf("ack=%q, want %q", got, want)
+		}
+	})
+
+	t.Run("AbortsOnClose", func(t *testing.T) {
+		handler := &fakeDownloadHandler{downloads: map[string]*fakeDownload{}}
+		chain := (*FilterChain)(nil).WithDownloadInterceptor(NewDownloadInterceptor(&bytes.Buffer{}, handler))
+
+		chain.Filter(GuacdToClient, []byte("4.file,1.2,10.text/plain,9.notes.txt;"))
+		chain.Close()
+		download := handler.downloads["notes.txt"]
+		<-download.finished
+		if download.aborted == nil || download.closed {
+			t.Fatalf("download=%+v, want aborted", download)
+		}
+	})
+
+	t.Run("AbortsWhenDownloadFallsBehind", func(t *testing.T) {
+		guacd := &bytes.Buffer{}
+		handler := &fakeDownloadHandler{downloads: map[string]*fakeDownload{}, blocked: make(chan struct{})}
+		chain := (*FilterChain)(nil).WithDownloadInterceptor(NewDownloadInterceptor(guacd, handler))
+
+		chain.Filter(GuacdToClient, []byte("4.file,1.2,10.text/plain,9.notes.txt;"))
+		blob := []byte("4.blob,1.2,8.aGVsbG8=;")
+		// the writer holds one blob, the queue the others until it is full
+		for i := 0; i < downloadQueueSize+2; i++ {
+			chain.Filter(GuacdToClient, blob)
+		}
+		if got, want := guacd.String(), "3.ack,1.2,2.OK,1.0;3.ack,1.2,20.Download fell behind,3.512;"; got != want {
+			t.Fatalf("acks=%q, want %q", got, want)
+		}
+
+		close(handler.blocked)
+		download := handler.downloads["notes.txt"]
+		<-download.finished
+		if !errors.Is(download.aborted, errQueueFull) || download.closed {
+			t.Fatalf("download=%+v, want aborted", download)
+		}
+	})
+
+	t.Run("KeepsClientFiles", func(t *testing.T) {
+		handler := &fakeDownloadHandler{downloads: map[string]*fakeDownload{}}
+		chain := (*FilterChain)(nil).WithDownloadInterceptor(NewDownloadInterceptor(&bytes.Buffer{}, handler))
+
+		data := []byte("4.file,1.2,10.text/plain,9.notes.txt;")
+		if got := chain.Filter(ClientToGuacd, data); !bytes.Equal(got, data) {
+			t.Fatalf("Filter=%q, want %q", got, data)
+		}
+	})
+}
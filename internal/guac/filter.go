@@ -0,0 +1,380 @@
+package guac
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// Direction is the way an instruction passes through a tunnel
+type Direction int
+
+const (
+	// ClientToGuacd are instructions sent by the user, like input events
+	ClientToGuacd Direction = iota
+	// GuacdToClient are instructions sent by the remote desktop, like drawing and downloads
+	GuacdToClient
+)
+
+func (d Direction) String() string {
+	if d == ClientToGuacd {
+		return "client"
+	}
+	return "sandbox"
+}
+
+// InstructionFilter inspects the instructions passing through a tunnel
+type InstructionFilter interface {
+	// Matches reports whether the filter inspects instructions with the opcode. Other
+	// instructions are passed on without being parsed.
+	Matches(opcode string) bool
+	// Filter returns the instruction to pass on, which may be rewritten, or nil to drop it
+	Filter(direction Direction, ins *Instruction) *Instruction
+}
+
+// FilteredTunnel is implemented by tunnels whose instructions pass through a FilterChain
+type FilteredTunnel interface {
+	Tunnel
+	// Filters returns the filter chain of the tunnel, nil if nothing is filtered
+	Filters() *FilterChain
+}
+
+type filteredTunnel struct {
+	Tunnel
+	filters *FilterChain
+}
+
+// NewFilteredTunnel passes the instructions of tunnel through filters
+func NewFilteredTunnel(tunnel Tunnel, filters *FilterChain) Tunnel {
+	if filters == nil {
+		return tunnel
+	}
+	return &filteredTunnel{Tunnel: tunnel, filters: filters}
+}
+
+func (t *filteredTunnel) Filters() *FilterChain {
+	return t.filters
+}
+
+func (t *filteredTunnel) Close() error {
+	err := t.Tunnel.Close()
+	t.filters.Close()
+	return err
+}
+
+// tunnelFilters returns the filter chain of a tunnel, or nil if it has none
+func tunnelFilters(tunnel Tunnel) *FilterChain {
+	// the HTTP tunnel keeps its tunnels wrapped to track their last access
+	if accessed, ok := tunnel.(*LastAccessedTunnel); ok {
+		tunnel = accessed.Tunnel
+	}
+	if t, ok := tunnel.(FilteredTunnel); ok {
+		return t.Filters()
+	}
+	return nil
+}
+
+// Opcodes of instructions that open a stream, with the stream index as first argument
+var streamOpcodes = map[string]bool{
+	"audio":     true,
+	"clipboard": true,
+	"file":      true,
+	"pipe":      true,
+	"video":     true,
+}
+
+// FilterChain applies instruction filters in order to the instructions of one connection.
+// When a filter drops an instruction that opens a stream, the blobs and end of that stream
+// are dropped as well, and the side that opened it is told with an ack refusing the stream,
+// which is sent ahead of the next instructions going its way. Clipboard streams the filters
+// pass on may then be inspected as a whole, see WithClipboardInspector, and downloads
+// intercepted, see WithDownloadInterceptor.
+// A FilterChain keeps state and must not be shared between tunnels.
+type FilterChain struct {
+	filters   []InstructionFilter
+	clipboard *ClipboardInspector
+	downloads *DownloadInterceptor
+
+	mu sync.Mutex
+	// droppedStreams holds the indexes of the dropped streams of each direction
+	droppedStreams [2]map[string]bool
+	// replies holds the instructions to send ahead of the next instructions of each direction
+	replies [2][]byte
+}
+
+// NewFilterChain creates a chain of filters, nil if there are no filters
+func NewFilterChain(filters ...InstructionFilter) *FilterChain {
+	chain := &FilterChain{}
+	for _, filter := range filters {
+		if filter != nil {
+			chain.filters = append(chain.filters, filter)
+		}
+	}
+	if len(chain.filters) == 0 {
+		return nil
+	}
+	chain.droppedStreams = [2]map[string]bool{{}, {}}
+	return chain
+}
+
+// WithClipboardInspector inspects the clipboard streams passing through the chain, creating
+// the chain if it is nil
+func (c *FilterChain) WithClipboardInspector(inspector *ClipboardInspector) *FilterChain {
+	if inspector == nil {
+		return c
+	}
+	if c == nil {
+		c = &FilterChain{droppedStreams: [2]map[string]bool{{}, {}}}
+	}
+	c.clipboard = inspector
+	return c
+}
+
+// WithDownloadInterceptor intercepts the files the sandbox sends through the chain, creating
+// the chain if it is nil
+func (c *FilterChain) WithDownloadInterceptor(interceptor *DownloadInterceptor) *FilterChain {
+	if interceptor == nil {
+		return c
+	}
+	if c == nil {
+		c = &FilterChain{droppedStreams: [2]map[string]bool{{}, {}}}
+	}
+	c.downloads = interceptor
+	return c
+}
+
+// Close releases the streams still held by the chain when its tunnel closes
+func (c *FilterChain) Close() {
+	if c == nil || c.downloads == nil {
+		return
+	}
+	c.downloads.close()
+}
+
+// Filter passes a buffer of complete instructions through the chain and returns what
+// is left of it. Data that cannot be parsed is dropped.
+func (c *FilterChain) Filter(direction Direction, data []byte) []byte {
+	if c == nil {
+		return data
+	}
+
+	instructions, opcodes, err := splitInstructions(data)
+	if err != nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var filtered []byte
+	changed := false
+	if replies := c.replies[direction]; len(replies) > 0 {
+		c.replies[direction] = nil
+		changed = true
+		filtered = append(make([]byte, 0, len(replies)+len(data)), replies...)
+	}
+	for i, raw := range instructions {
+		out, unchanged := c.filterOne(direction, opcodes[i], raw)
+		if !unchanged && !changed {
+			// copy what was passed on unchanged so far
+			changed = true
+			filtered = make([]byte, 0, len(data))
+			for _, previous := range instructions[:i] {
+				filtered = append(filtered, previous...)
+			}
+		}
+		if changed {
+			filtered = append(filtered, out...)
+		}
+	}
+	if !changed {
+		return data
+	}
+	return filtered
+}
+
+// filterOne returns what to pass on for an instruction, nil if it is dropped, and whether
+// that is the instruction unchanged
+func (c *FilterChain) filterOne(direction Direction, opcode string, raw []byte) ([]byte, bool) {
+	dropped := c.droppedStreams[direction]
+	if opcode == "blob" || opcode == "end" {
+		if stream, ok := firstArgument(raw); ok && dropped[stream] {
+			if opcode == "end" {
+				delete(dropped, stream)
+			}
+			return nil, false
+		}
+	}
+
+	out, unchanged := c.applyFilters(direction, opcode, raw)
+	if out != nil && c.downloads != nil && direction == GuacdToClient {
+		var changed bool
+		out, changed = c.downloads.intercept(opcode, out)
+		unchanged = unchanged && !changed
+	}
+	if out == nil || c.clipboard == nil {
+		return out, unchanged
+	}
+
+	out, changed := c.clipboard.inspect(direction, opcode, out, dropped)
+	return out, unchanged && !changed
+}
+
+// applyFilters passes an instruction through the filters of the chain
+func (c *FilterChain) applyFilters(direction Direction, opcode string, raw []byte) ([]byte, bool) {
+	dropped := c.droppedStreams[direction]
+	matches := false
+	for _, filter := range c.filters {
+		if filter.Matches(opcode) {
+			matches = true
+			break
+		}
+	}
+	if !matches {
+		return raw, true
+	}
+
+	original, err := Parse(raw)
+	if err != nil {
+		return nil, false
+	}
+	ins := original
+	for _, filter := range c.filters {
+		if !filter.Matches(ins.Opcode) {
+			continue
+		}
+		if ins = filter.Filter(direction, ins); ins == nil {
+			if streamOpcodes[original.Opcode] && len(original.Args) > 0 {
+				dropped[original.Args[0]] = true
+				c.refuseStream(direction, original.Args[0])
+			}
+			return nil, false
+		}
+	}
+
+	if ins == original {
+		return raw, true
+	}
+	return ins.Byte(), false
+}
+
+// refuseStream acknowledges a dropped stream with an error to the side that opened it, so
+// that it does not wait for the stream to be accepted
+func (c *FilterChain) refuseStream(direction Direction, index string) {
+	reply := ClientToGuacd
+	if direction == ClientToGuacd {
+		reply = GuacdToClient
+	}
+	ack := NewInstruction("ack", index, "Forbidden by policy", fmt.Sprint(ClientForbidden.GetGuacamoleStatusCode()))
+	c.replies[reply] = append(c.replies[reply], ack.Byte()...)
+}
+
+// firstArgument returns the first argument of a raw instruction without parsing it fully
+func firstArgument(raw []byte) (string, bool) {
+	comma := bytes.IndexByte(raw, ',')
+	if comma < 0 {
+		return "", false
+	}
+	rest := raw[comma+1:]
+	dot := bytes.IndexByte(rest, '.')
+	if dot <= 0 {
+		return "", false
+	}
+	length, err := strconv.Atoi(string(rest[:dot]))
+	if err != nil || dot+1+length > len(rest) {
+		return "", false
+	}
+	// stream indexes are ASCII digits, so the length in characters is the length in bytes
+	return string(rest[dot+1 : dot+1+length]), true
+}
+
+// viewOnlyDroppedOpcodes are the client instructions that would let a view-only client
+// control the connection: input events, the clipboard and file streams.
+var viewOnlyDroppedOpcodes = map[string]bool{
+	"key":       true,
+	"mouse":     true,
+	"touch":     true,
+	"clipboard": true,
+	"argv":      true,
+	"file":      true,
+	"pipe":      true,
+	"put":       true,
+	"get":       true,
+	"blob":      true,
+	"end":       true,
+}
+
+type viewOnlyFilter struct{}
+
+// ViewOnlyFilter drops the input, clipboard and file stream instructions of clients that
+// may watch the connection but not control it
+func ViewOnlyFilter() InstructionFilter {
+	return viewOnlyFilter{}
+}
+
+func (viewOnlyFilter) Matches(opcode string) bool {
+	return viewOnlyDroppedOpcodes[opcode]
+}
+
+func (viewOnlyFilter) Filter(direction Direction, ins *Instruction) *Instruction {
+	if direction == ClientToGuacd {
+		return nil
+	}
+	return ins
+}
+
+var errIncompleteInstruction = errors.New("guac: incomplete instruction")
+
+// splitInstructions splits a buffer of complete instructions into the individual
+// instructions and their opcodes. Element lengths count unicode characters, not bytes.
+func splitInstructions(data []byte) (instructions [][]byte, opcodes []string, err error) {
+	start := 0
+	for start < len(data) {
+		pos := start
+		opcode := ""
+		first := true
+		for {
+			dot := bytes.IndexByte(data[pos:], '.')
+			if dot <= 0 {
+				return nil, nil, errIncompleteInstruction
+			}
+			length, e := strconv.Atoi(string(data[pos : pos+dot]))
+			if e != nil || length < 0 {
+				return nil, nil, errIncompleteInstruction
+			}
+			pos += dot + 1
+
+			elementStart := pos
+			for i := 0; i < length; i++ {
+				if pos >= len(data) {
+					return nil, nil, errIncompleteInstruction
+				}
+				_, size := utf8.DecodeRune(data[pos:])
+				pos += size
+			}
+			if first {
+				opcode = string(data[elementStart:pos])
+				first = false
+			}
+
+			if pos >= len(data) {
+				return nil, nil, errIncompleteInstruction
+			}
+			terminator := data[pos]
+			pos++
+			if terminator == ';' {
+				break
+			}
+			if terminator != ',' {
+				return nil, nil, errIncompleteInstruction
+			}
+		}
+		instructions = append(instructions, data[start:pos])
+		opcodes = append(opcodes, opcode)
+		start = pos
+	}
+	return instructions, opcodes, nil
+}
@@ -0,0 +1,78 @@
+package guac
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitInstructions(t *testing.T) {
+	t.Run("OKWithUnicode", func(t *testing.T) {
+		data := []byte("4.name,7.rocket🚀;3.key,5.65307,1.1;4.sync,0.;")
+
+		instructions, opcodes, err := splitInstructions(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(instructions), 3; got != want {
+			t.Fatalf("len(instructions)=%v, want %v", got, want)
+		}
+		if got, want := string(instructions[1]), "3.key,5.65307,1.1;"; got != want {
+			t.Fatalf("instructions[1]=%v, want %v", got, want)
+		}
+		if got, want := opcodes, []string{"name", "key", "sync"}; len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+			t.Fatalf("opcodes=%v, want %v", got, want)
+		}
+	})
+
+	t.Run("ErrorIncomplete", func(t *testing.T) {
+		for _, invalid := range []string{"4.name", "4.name,7.rocket", "x.name;", "4.name:1.a;"} {
+			if _, _, err := splitInstructions([]byte(invalid)); err == nil {
+				t.Fatalf("expected error for %q", invalid)
+			}
+		}
+	})
+}
+
+func TestFilterChain_ViewOnly(t *testing.T) {
+	chain := NewFilterChain(ViewOnlyFilter())
+
+	t.Run("KeepsAllowed", func(t *testing.T) {
+		data := []byte("4.sync,8.12345678;3.ack,1.1,2.OK,1.0;")
+		if got := chain.Filter(ClientToGuacd, data); !bytes.Equal(got, data) {
+			t.Fatalf("Filter=%q, want %q", got, data)
+		}
+	})
+
+	t.Run("DropsInput", func(t *testing.T) {
+		data := []byte("5.mouse,2.10,2.20,1.1;4.sync,8.12345678;3.key,5.65307,1.1;9.clipboard,1.0,10.text/plain;")
+		if got, want := string(chain.Filter(ClientToGuacd, data)), "4.sync,8.12345678;"; got != want {
+			t.Fatalf("Filter=%q, want %q", got, want)
+		}
+	})
+
+	t.Run("KeepsSandboxOutput", func(t *testing.T) {
+		data := []byte("9.clipboard,1.0,10.text/plain;4.blob,1.0,4.aGk=;3.end,1.0;")
+		// the clipboard stream dropped above is refused ahead of the output
+		want := "3.ack,1.0,19.Forbidden by policy,3.771;" + string(data)
+		if got := string(chain.Filter(GuacdToClient, data)); got != want {
+			t.Fatalf("Filter=%q, want %q", got, want)
+		}
+		if got := chain.Filter(GuacdToClient, data); !bytes.Equal(got, data) {
+			t.Fatalf("Filter=%q, want %q", got, data)
+		}
+	})
+
+	t.Run("DropsUnparseable", func(t *testing.T) {
+		if got := chain.Filter(ClientToGuacd, []byte("5.mouse,2.10")); len(got) != 0 {
+			t.Fatalf("Filter=%q, want nothing", got)
+		}
+	})
+}
+
+func TestFilterChain_Nil(t *testing.T) {
+	var chain *FilterChain = NewFilterChain()
+	data := []byte("5.mouse,2.10,2.20,1.1;")
+	if got := chain.Filter(ClientToGuacd, data); !bytes.Equal(got, data) {
+		t.Fatalf("Filter=%q, want %q", got, data)
+	}
+}
@@ -0,0 +1,397 @@
+package guac
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Input policy rule actions
+const (
+	ActionAllow   = "allow"
+	ActionDrop    = "drop"
+	ActionRewrite = "rewrite"
+)
+
+// Connection roles input policy rules can be limited to. Rules can also be limited to
+// the role of the signed-in user, such as auditor.
+const (
+	// RoleOwner is the user that started the session
+	RoleOwner = "owner"
+	// RoleViewer is a user that joined the session through a share link
+	RoleViewer = "viewer"
+)
+
+// InputPolicy controls which Guacamole instructions may pass between the user and a
+// sandbox, for example to keep the clipboard of the sandbox from reaching the user,
+// block downloads and printing, or disable key combinations:
+//
+//	rules:
+//	  - {direction: sandbox, opcodes: [clipboard], action: drop}
+//	  - {direction: sandbox, opcodes: [file], args: {1: "^application/pdf$"}, action: drop}
+//	  - {direction: client, opcodes: [key], keys: ["Ctrl+Alt+Delete"], action: drop}
+//
+// The first rule matching an instruction decides what happens to it, instructions no
+// rule matches are passed on.
+type InputPolicy struct {
+	Rules []InputRule `json:"rules"`
+}
+
+// InputRule allows, drops or rewrites the instructions it matches
+type InputRule struct {
+	// Direction is "client" for instructions from the user to the sandbox, "sandbox" for
+	// instructions from the sandbox to the user, or empty for both
+	Direction string   `json:"direction,omitempty"`
+	Opcodes   []string `json:"opcodes"`
+	// Args match arguments by their index with regular expressions
+	Args map[int]string `json:"args,omitempty"`
+	// Keys are key combinations like Ctrl+Alt+Delete the rule matches, only for key instructions
+	Keys []string `json:"keys,omitempty"`
+	// Roles limits the rule to connections with one of the roles, a connection role or the
+	// role of its user. It applies to all if empty.
+	Roles  []string `json:"roles,omitempty"`
+	Action string   `json:"action"`
+	// Rewrite replaces arguments by their index, for the rewrite action
+	Rewrite map[int]string `json:"rewrite,omitempty"`
+}
+
+// Validate checks that the rules of the policy can be compiled
+func (p *InputPolicy) Validate() error {
+	_, err := p.compile(nil)
+	return err
+}
+
+// Filter returns the filter applying the rules of the policy for a connection with the given
+// roles, nil if no rule applies. The filter tracks pressed keys and belongs to one connection.
+func (p *InputPolicy) Filter(roles ...string) (InstructionFilter, error) {
+	if p == nil {
+		return nil, nil
+	}
+	rules, err := p.compile(roles)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+
+	filter := &policyFilter{
+		rules:      rules,
+		opcodes:    map[string]bool{},
+		pressed:    map[int]bool{},
+		suppressed: map[int]bool{},
+	}
+	for _, rule := range rules {
+		for opcode := range rule.opcodes {
+			filter.opcodes[opcode] = true
+		}
+		if len(rule.keys) > 0 {
+			filter.tracksKeys = true
+		}
+	}
+	if filter.tracksKeys {
+		filter.opcodes["key"] = true
+	}
+	return filter, nil
+}
+
+type compiledRule struct {
+	direction *Direction
+	opcodes   map[string]bool
+	args      map[int]*regexp.Regexp
+	keys      []keyCombination
+	action    string
+	rewrite   map[int]string
+}
+
+// compile compiles the rules applying to a connection with one of the roles, all rules if roles is nil
+func (p *InputPolicy) compile(roles []string) ([]compiledRule, error) {
+	var rules []compiledRule
+	for i, rule := range p.Rules {
+		compiled, err := rule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("input rule %d: %w", i+1, err)
+		}
+		if roles == nil || rule.appliesTo(roles) {
+			rules = append(rules, compiled)
+		}
+	}
+	return rules, nil
+}
+
+func (r *InputRule) appliesTo(roles []string) bool {
+	if len(r.Roles) == 0 {
+		return true
+	}
+	for _, role := range r.Roles {
+		for _, has := range roles {
+			if role == has {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *InputRule) compile() (compiledRule, error) {
+	compiled := compiledRule{
+		opcodes: map[string]bool{},
+		args:    map[int]*regexp.Regexp{},
+		action:  r.Action,
+		rewrite: r.Rewrite,
+	}
+
+	switch r.Direction {
+	case "":
+	case ClientToGuacd.String():
+		direction := ClientToGuacd
+		compiled.direction = &direction
+	case GuacdToClient.String():
+		direction := GuacdToClient
+		compiled.direction = &direction
+	default:
+		return compiled, fmt.Errorf("invalid direction %q, must be client or sandbox", r.Direction)
+	}
+
+	if len(r.Opcodes) == 0 {
+		return compiled, fmt.Errorf("opcodes are required")
+	}
+	for _, opcode := range r.Opcodes {
+		compiled.opcodes[opcode] = true
+	}
+
+	switch r.Action {
+	case ActionAllow, ActionDrop:
+		if len(r.Rewrite) > 0 {
+			return compiled, fmt.Errorf("rewrite is only valid with the rewrite action")
+		}
+	case ActionRewrite:
+		if len(r.Rewrite) == 0 {
+			return compiled, fmt.Errorf("the rewrite action requires rewrite")
+		}
+	default:
+		return compiled, fmt.Errorf("invalid action %q", r.Action)
+	}
+
+	for index, pattern := range r.Args {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return compiled, fmt.Errorf("invalid pattern of argument %d: %w", index, err)
+		}
+		compiled.args[index] = re
+	}
+
+	if len(r.Keys) > 0 && (len(r.Opcodes) != 1 || r.Opcodes[0] != "key") {
+		return compiled, fmt.Errorf("keys are only valid for the key opcode")
+	}
+	for _, keys := range r.Keys {
+		combination, err := parseKeyCombination(keys)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.keys = append(compiled.keys, combination)
+	}
+
+	return compiled, nil
+}
+
+// policyFilter applies compiled input rules to the instructions of one connection
+type policyFilter struct {
+	rules      []compiledRule
+	opcodes    map[string]bool
+	tracksKeys bool
+	// pressed are the keysyms the user currently holds down
+	pressed map[int]bool
+	// suppressed are the keysyms whose press was dropped, their release is dropped too
+	suppressed map[int]bool
+}
+
+func (f *policyFilter) Matches(opcode string) bool {
+	return f.opcodes[opcode]
+}
+
+func (f *policyFilter) Filter(direction Direction, ins *Instruction) *Instruction {
+	keysym, pressed := -1, false
+	if f.tracksKeys && direction == ClientToGuacd && ins.Opcode == "key" && len(ins.Args) >= 2 {
+		if sym, err := strconv.Atoi(ins.Args[0]); err == nil {
+			keysym, pressed = sym, ins.Args[1] == "1"
+			if pressed {
+				f.pressed[keysym] = true
+			} else {
+				delete(f.pressed, keysym)
+				if f.suppressed[keysym] {
+					delete(f.suppressed, keysym)
+					return nil
+				}
+			}
+		}
+	}
+
+	for _, rule := range f.rules {
+		if !rule.matches(direction, ins, keysym, pressed, f.pressed) {
+			continue
+		}
+		switch rule.action {
+		case ActionDrop:
+			if keysym >= 0 && pressed {
+				// the press never reaches the sandbox, so neither may the release
+				f.suppressed[keysym] = true
+			}
+			return nil
+		case ActionRewrite:
+			return rule.apply(ins)
+		default:
+			return ins
+		}
+	}
+	return ins
+}
+
+func (r *compiledRule) matches(direction Direction, ins *Instruction, keysym int, pressed bool, held map[int]bool) bool {
+	if r.direction != nil && *r.direction != direction {
+		return false
+	}
+	if !r.opcodes[ins.Opcode] {
+		return false
+	}
+	for index, re := range r.args {
+		if index >= len(ins.Args) || !re.MatchString(ins.Args[index]) {
+			return false
+		}
+	}
+	if len(r.keys) == 0 {
+		return true
+	}
+	if keysym < 0 || !pressed {
+		return false
+	}
+	for _, combination := range r.keys {
+		if combination.completedBy(keysym, held) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns a copy of ins with the arguments of the rule replaced
+func (r *compiledRule) apply(ins *Instruction) *Instruction {
+	args := append([]string(nil), ins.Args...)
+	for index, value := range r.rewrite {
+		if index < len(args) {
+			args[index] = value
+		}
+	}
+	return NewInstruction(ins.Opcode, args...)
+}
+
+// keyCombination is a key pressed while holding modifiers. The key and each modifier may
+// be any of their keysyms.
+type keyCombination struct {
+	modifiers [][]int
+	key       []int
+}
+
+// completedBy reports whether pressing keysym while holding the held keys completes the combination
+func (k keyCombination) completedBy(keysym int, held map[int]bool) bool {
+	if !containsKeysym(k.key, keysym) {
+		return false
+	}
+	for _, modifier := range k.modifiers {
+		down := false
+		for _, sym := range modifier {
+			if held[sym] {
+				down = true
+				break
+			}
+		}
+		if !down {
+			return false
+		}
+	}
+	return true
+}
+
+func containsKeysym(keysyms []int, keysym int) bool {
+	for _, sym := range keysyms {
+		if sym == keysym {
+			return true
+		}
+	}
+	return false
+}
+
+// X11 keysyms of the modifiers, left and right
+var modifierKeysyms = map[string][]int{
+	"ctrl":    {0xffe3, 0xffe4},
+	"control": {0xffe3, 0xffe4},
+	"shift":   {0xffe1, 0xffe2},
+	"alt":     {0xffe9, 0xffea},
+	"meta":    {0xffe7, 0xffe8},
+	"super":   {0xffeb, 0xffec},
+	"win":     {0xffeb, 0xffec},
+}
+
+// X11 keysyms of named keys
+var namedKeysyms = map[string]int{
+	"backspace": 0xff08,
+	"tab":       0xff09,
+	"enter":     0xff0d,
+	"return":    0xff0d,
+	"pause":     0xff13,
+	"escape":    0xff1b,
+	"esc":       0xff1b,
+	"space":     0x0020,
+	"home":      0xff50,
+	"left":      0xff51,
+	"up":        0xff52,
+	"right":     0xff53,
+	"down":      0xff54,
+	"pageup":    0xff55,
+	"pagedown":  0xff56,
+	"end":       0xff57,
+	"print":     0xff61,
+	"insert":    0xff63,
+	"delete":    0xffff,
+	"del":       0xffff,
+}
+
+// parseKeyCombination parses combinations like Ctrl+Alt+Delete, Super+r or Ctrl+0x76
+func parseKeyCombination(combination string) (keyCombination, error) {
+	parts := strings.Split(combination, "+")
+	var k keyCombination
+	for i, part := range parts {
+		name := strings.ToLower(strings.TrimSpace(part))
+		if i < len(parts)-1 {
+			modifier, ok := modifierKeysyms[name]
+			if !ok {
+				return k, fmt.Errorf("invalid key combination %q: unknown modifier %q", combination, part)
+			}
+			k.modifiers = append(k.modifiers, modifier)
+			continue
+		}
+
+		switch {
+		case namedKeysyms[name] != 0:
+			k.key = []int{namedKeysyms[name]}
+		case len(name) > 1 && name[0] == 'f':
+			n, err := strconv.Atoi(name[1:])
+			if err != nil || n < 1 || n > 24 {
+				return k, fmt.Errorf("invalid key combination %q: unknown key %q", combination, part)
+			}
+			k.key = []int{0xffbe + n - 1}
+		case strings.HasPrefix(name, "0x"):
+			sym, err := strconv.ParseInt(name[2:], 16, 32)
+			if err != nil {
+				return k, fmt.Errorf("invalid key combination %q: invalid keysym %q", combination, part)
+			}
+			k.key = []int{int(sym)}
+		case len(name) == 1 && name[0] >= 0x20 && name[0] < 0x7f:
+			// Latin-1 keysyms equal the character, letters match in either case
+			k.key = []int{int(name[0])}
+			if upper := strings.ToUpper(name); upper != name {
+				k.key = append(k.key, int(upper[0]))
+			}
+		default:
+			return k, fmt.Errorf("invalid key combination %q: unknown key %q", combination, part)
+		}
+	}
+	return k, nil
+}
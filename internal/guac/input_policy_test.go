@@ -0,0 +1,116 @@
+package guac
+
+import (
+	"testing"
+)
+
+func mustPolicyChain(t *testing.T, policy *InputPolicy, roles ...string) *FilterChain {
+	t.Helper()
+	filter, err := policy.Filter(roles...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFilterChain(filter)
+}
+
+func TestInputPolicy_DropsSandboxClipboardStream(t *testing.T) {
+	chain := mustPolicyChain(t, &InputPolicy{Rules: []InputRule{
+		{Direction: "sandbox", Opcodes: []string{"clipboard"}, Action: ActionDrop},
+	}})
+
+	data := []byte("9.clipboard,1.3,10.text/plain;4.blob,1.3,4.aGk=;4.blob,1.4,4.aGk=;3.end,1.3;4.sync,1.1;")
+	if got, want := string(chain.Filter(GuacdToClient, data)), "4.blob,1.4,4.aGk=;4.sync,1.1;"; got != want {
+		t.Fatalf("Filter=%q, want %q", got, want)
+	}
+
+	// the user may still paste into the sandbox, and guacd is told the dropped stream is refused
+	data = []byte("9.clipboard,1.0,10.text/plain;")
+	if got, want := string(chain.Filter(ClientToGuacd, data)), "3.ack,1.3,19.Forbidden by policy,3.771;"+string(data); got != want {
+		t.Fatalf("Filter=%q, want %q", got, want)
+	}
+}
+
+func TestInputPolicy_MatchesArgs(t *testing.T) {
+	chain := mustPolicyChain(t, &InputPolicy{Rules: []InputRule{
+		{Direction: "sandbox", Opcodes: []string{"file"}, Args: map[int]string{1: "^application/pdf$"}, Action: ActionDrop},
+	}})
+
+	data := []byte("4.file,1.1,15.application/pdf,12.document.pdf;4.file,1.2,10.text/plain,5.a.txt;")
+	if got, want := string(chain.Filter(GuacdToClient, data)), "4.file,1.2,10.text/plain,5.a.txt;"; got != want {
+		t.Fatalf("Filter=%q, want %q", got, want)
+	}
+}
+
+func TestInputPolicy_Rewrite(t *testing.T) {
+	chain := mustPolicyChain(t, &InputPolicy{Rules: []InputRule{
+		{Direction: "client", Opcodes: []string{"size"}, Action: ActionRewrite, Rewrite: map[int]string{1: "1024", 2: "768"}},
+	}})
+
+	data := []byte("4.size,1.0,4.1920,4.1080;")
+	if got, want := string(chain.Filter(ClientToGuacd, data)), "4.size,1.0,4.1024,3.768;"; got != want {
+		t.Fatalf("Filter=%q, want %q", got, want)
+	}
+}
+
+func TestInputPolicy_KeyCombination(t *testing.T) {
+	chain := mustPolicyChain(t, &InputPolicy{Rules: []InputRule{
+		{Direction: "client", Opcodes: []string{"key"}, Keys: []string{"Ctrl+Alt+Delete"}, Action: ActionDrop},
+	}})
+
+	steps := []struct {
+		ins, want string
+	}{
+		{"3.key,5.65535,1.1;", "3.key,5.65535,1.1;"}, // Delete alone
+		{"3.key,5.65535,1.0;", "3.key,5.65535,1.0;"},
+		{"3.key,5.65507,1.1;", "3.key,5.65507,1.1;"}, // Control_L
+		{"3.key,5.65514,1.1;", "3.key,5.65514,1.1;"}, // Alt_R
+		{"3.key,5.65535,1.1;", ""},                   // Delete completes the combination
+		{"3.key,5.65535,1.0;", ""},                   // and its release is dropped too
+		{"3.key,5.65514,1.0;", "3.key,5.65514,1.0;"},
+		{"3.key,5.65507,1.0;", "3.key,5.65507,1.0;"},
+	}
+	for i, step := range steps {
+		if got := string(chain.Filter(ClientToGuacd, []byte(step.ins))); got != step.want {
+			t.Fatalf("step %d: Filter(%q)=%q, want %q", i, step.ins, got, step.want)
+		}
+	}
+}
+
+func TestInputPolicy_Roles(t *testing.T) {
+	policy := &InputPolicy{Rules: []InputRule{
+		{Opcodes: []string{"clipboard"}, Roles: []string{RoleViewer}, Action: ActionDrop},
+	}}
+
+	if filter, err := policy.Filter(RoleOwner); err != nil || filter != nil {
+		t.Fatalf("Filter(owner)=%v, %v, want no filter", filter, err)
+	}
+	chain := mustPolicyChain(t, policy, RoleViewer)
+	if got := chain.Filter(GuacdToClient, []byte("9.clipboard,1.0,10.text/plain;")); len(got) != 0 {
+		t.Fatalf("Filter=%q, want nothing", got)
+	}
+}
+
+func TestInputPolicy_Validate(t *testing.T) {
+	invalid := []InputRule{
+		{Opcodes: []string{"key"}, Action: "block"},
+		{Opcodes: []string{}, Action: ActionDrop},
+		{Direction: "up", Opcodes: []string{"key"}, Action: ActionDrop},
+		{Opcodes: []string{"key"}, Action: ActionRewrite},
+		{Opcodes: []string{"file"}, Args: map[int]string{0: "("}, Action: ActionDrop},
+		{Opcodes: []string{"mouse"}, Keys: []string{"Ctrl+c"}, Action: ActionDrop},
+		{Opcodes: []string{"key"}, Keys: []string{"Hyper+c"}, Action: ActionDrop},
+	}
+	for _, rule := range invalid {
+		policy := &InputPolicy{Rules: []InputRule{rule}}
+		if err := policy.Validate(); err == nil {
+			t.Errorf("expected error for %+v", rule)
+		}
+	}
+
+	valid := &InputPolicy{Rules: []InputRule{
+		{Opcodes: []string{"key"}, Keys: []string{"Super+r", "Ctrl+Shift+Esc", "Alt+F4", "Ctrl+0x76"}, Action: ActionDrop},
+	}}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
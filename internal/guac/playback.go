@@ -0,0 +1,438 @@
+package guac
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Playback control opcodes. The client sends them as the first argument of an
+// InternalDataOpcode instruction, e.g. "0.,4.seek,5.12000;" seeks to 12 seconds.
+const (
+	PlaybackPlay  = "play"
+	PlaybackPause = "pause"
+	PlaybackSeek  = "seek"
+	PlaybackSpeed = "speed"
+
+	// PlaybackStatus is sent to the client as "0.,8.playback,<state>,<position in ms>;"
+	// whenever the playback state changes
+	PlaybackStatus = "playback"
+
+	maxPlaybackSpeed = 16.0
+)
+
+// Playback states reported with PlaybackStatus
+const (
+	PlaybackStatePlaying = "playing"
+	PlaybackStatePaused  = "paused"
+	PlaybackStateEnded   = "ended"
+)
+
+var (
+	errPlaybackDone    = errors.New("playback client disconnected")
+	errPlaybackRestart = errors.New("playback restart requested")
+)
+
+// NewPlaybackWebsocketServer creates a server which, instead of dialing guacd, replays
+// the recording returned by open to the client. open may be called more than once
+// per connection since seeking backwards restarts the recording.
+func NewPlaybackWebsocketServer(open func(*http.Request) (io.ReadCloser, error)) *WebsocketServer {
+	return &WebsocketServer{
+		openRecording: open,
+	}
+}
+
+func (s *WebsocketServer) servePlayback(ws *websocket.Conn, r *http.Request) {
+	p := newPlayer(func() (io.ReadCloser, error) {
+		return s.openRecording(r)
+	}, ws)
+
+	go p.readControls(ws)
+	defer close(p.stop)
+
+	if err := p.run(); err != nil && err != errPlaybackDone {
+		logrus.Debugf("Playback ended with error: %v", err)
+	}
+}
+
+// player replays a recording to a MessageWriter honoring the timing of the sync
+// instructions it contains
+type player struct {
+	open func() (io.ReadCloser, error)
+	ws   MessageWriter
+
+	controls chan *Instruction
+	done     chan struct{} // closed when the client disconnects
+	stop     chan struct{} // closed when the playback ends
+
+	speed    float64
+	paused   bool
+	position int64 // current position in ms relative to the first sync
+	target   int64 // position to fast-forward to after a seek
+}
+
+func newPlayer(open func() (io.ReadCloser, error), ws MessageWriter) *player {
+	return &player{
+		open:     open,
+		ws:       ws,
+		controls: make(chan *Instruction, 16),
+		done:     make(chan struct{}),
+		stop:     make(chan struct{}),
+		speed:    1,
+	}
+}
+
+// readControls reads control instructions from the client until the connection closes or
+// the playback ends. Everything else the client sends is discarded since there is no guacd to receive it.
+func (p *player) readControls(ws MessageReader) {
+	defer close(p.done)
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			logrus.Traceln("Error reading message from ws", err)
+			return
+		}
+
+		if !bytes.HasPrefix(data, internalOpcodeIns) {
+			continue
+		}
+
+		ins, err := Parse(data)
+		if err != nil || len(ins.Args) == 0 {
+			continue
+		}
+
+		switch ins.Args[0] {
+		case PlaybackPlay, PlaybackPause, PlaybackSeek, PlaybackSpeed:
+			select {
+			case p.controls <- NewInstruction(ins.Args[0], ins.Args[1:]...):
+			case <-p.stop:
+				return
+			}
+		}
+	}
+}
+
+// run plays the recording until the client disconnects
+func (p *player) run() error {
+	for {
+		recording, err := p.open()
+		if err != nil {
+			return err
+		}
+
+		err = p.play(recording)
+		if closeErr := recording.Close(); closeErr != nil {
+			logrus.Traceln("Error closing recording", closeErr)
+		}
+		if err != errPlaybackRestart {
+			return err
+		}
+	}
+}
+
+func (p *player) play(recording io.Reader) error {
+	scanner := newRecordingScanner(recording)
+	frame := bytes.NewBuffer(make([]byte, 0, MaxGuacMessage*2))
+
+	start, last := int64(-1), int64(0)
+	p.position = 0
+
+	if err := p.sendStatus(); err != nil {
+		return err
+	}
+
+	for {
+		raw, ins, err := scanner.Next()
+		if err == io.EOF {
+			if err = p.flush(frame); err != nil {
+				return err
+			}
+			return p.ended()
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err = frame.Write(raw); err != nil {
+			return err
+		}
+
+		if ins.Opcode != "sync" || len(ins.Args) == 0 {
+			if frame.Len() >= MaxGuacMessage {
+				if err = p.flush(frame); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		timestamp, err := strconv.ParseInt(ins.Args[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if start < 0 {
+			start, last = timestamp, timestamp
+		}
+
+		// frames before the seek target are sent without delay so the display catches up
+		if timestamp-start > p.target {
+			if err = p.wait(float64(timestamp - last)); err != nil {
+				return err
+			}
+		} else if err = p.poll(); err != nil {
+			return err
+		}
+
+		last = timestamp
+		p.position = timestamp - start
+		if err = p.flush(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// wait sleeps for the given amount of recording time, scaled by the playback speed,
+// while applying any control instructions received in the meantime
+func (p *player) wait(recordingMs float64) error {
+	for recordingMs > 0 {
+		if p.paused {
+			select {
+			case ins := <-p.controls:
+				if err := p.handle(ins); err != nil {
+					return err
+				}
+			case <-p.done:
+				return errPlaybackDone
+			}
+			if p.target > p.position {
+				return nil
+			}
+			continue
+		}
+
+		started := time.Now()
+		timer := time.NewTimer(time.Duration(recordingMs / p.speed * float64(time.Millisecond)))
+		select {
+		case <-timer.C:
+			return nil
+		case ins := <-p.controls:
+			timer.Stop()
+			recordingMs -= float64(time.Since(started)) / float64(time.Millisecond) * p.speed
+			if err := p.handle(ins); err != nil {
+				return err
+			}
+			if p.target > p.position {
+				return nil
+			}
+		case <-p.done:
+			timer.Stop()
+			return errPlaybackDone
+		}
+	}
+	return nil
+}
+
+// poll applies pending control instructions without blocking
+func (p *player) poll() error {
+	for {
+		select {
+		case ins := <-p.controls:
+			if err := p.handle(ins); err != nil {
+				return err
+			}
+		case <-p.done:
+			return errPlaybackDone
+		default:
+			return nil
+		}
+	}
+}
+
+// ended waits at the end of the recording for the client to seek or disconnect
+func (p *player) ended() error {
+	if err := p.writeStatus(PlaybackStateEnded); err != nil {
+		return err
+	}
+	for {
+		select {
+		case ins := <-p.controls:
+			if err := p.handle(ins); err != nil {
+				return err
+			}
+		case <-p.done:
+			return errPlaybackDone
+		}
+	}
+}
+
+// handle applies a single control instruction
+func (p *player) handle(ins *Instruction) error {
+	switch ins.Opcode {
+	case PlaybackPlay:
+		p.paused = false
+	case PlaybackPause:
+		p.paused = true
+	case PlaybackSpeed:
+		if len(ins.Args) == 0 {
+			return nil
+		}
+		speed, err := strconv.ParseFloat(ins.Args[0], 64)
+		if err != nil || speed <= 0 {
+			return nil
+		}
+		if speed > maxPlaybackSpeed {
+			speed = maxPlaybackSpeed
+		}
+		p.speed = speed
+	case PlaybackSeek:
+		if len(ins.Args) == 0 {
+			return nil
+		}
+		target, err := strconv.ParseInt(ins.Args[0], 10, 64)
+		if err != nil || target < 0 {
+			return nil
+		}
+		p.target = target
+		if target < p.position {
+			// the client display can only be rebuilt by replaying from the beginning
+			return errPlaybackRestart
+		}
+	}
+	return p.sendStatus()
+}
+
+func (p *player) sendStatus() error {
+	state := PlaybackStatePlaying
+	if p.paused {
+		state = PlaybackStatePaused
+	}
+	return p.writeStatus(state)
+}
+
+func (p *player) writeStatus(state string) error {
+	status := NewInstruction(InternalDataOpcode, PlaybackStatus, state, strconv.FormatInt(p.position, 10))
+	return p.write(status.Byte())
+}
+
+func (p *player) flush(frame *bytes.Buffer) error {
+	if frame.Len() == 0 {
+		return nil
+	}
+	err := p.write(frame.Bytes())
+	frame.Reset()
+	return err
+}
+
+func (p *player) write(data []byte) error {
+	if err := p.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+		logrus.Traceln("Failed sending message to ws", err)
+		return err
+	}
+	return nil
+}
+
+// errInstructionTooLarge is returned by a recordingScanner for an instruction longer
+// than its limit
+var errInstructionTooLarge = errors.New("guac: instruction too large")
+
+// recordingScanner reads complete instructions from a recording, or from the body of a
+// tunnel write. Element lengths in the Guacamole protocol are counted in characters,
+// not bytes.
+type recordingScanner struct {
+	r   *bufio.Reader
+	raw bytes.Buffer
+	// limit is the largest instruction read in bytes, 0 for no limit
+	limit int
+}
+
+func newRecordingScanner(r io.Reader) *recordingScanner {
+	return &recordingScanner{r: bufio.NewReaderSize(r, MaxGuacMessage)}
+}
+
+// Next returns the next instruction both as raw bytes and parsed. io.EOF is
+// returned only when the recording ends on an instruction boundary.
+func (s *recordingScanner) Next() ([]byte, *Instruction, error) {
+	s.raw.Reset()
+	elements := make([]string, 0, 4)
+
+	// recordings may be separated by whitespace when concatenated by hand
+	for {
+		c, _, err := s.r.ReadRune()
+		if err != nil {
+			return nil, nil, err
+		}
+		if !unicode.IsSpace(c) {
+			if err = s.r.UnreadRune(); err != nil {
+				return nil, nil, err
+			}
+			break
+		}
+	}
+
+	for {
+		length := 0
+		for {
+			c, err := s.readRune()
+			if err != nil {
+				return nil, nil, err
+			}
+			if c == '.' {
+				break
+			}
+			if c < '0' || c > '9' {
+				return nil, nil, ErrServer.NewError("Non-numeric character in element length:", string(c))
+			}
+			length = length*10 + int(c-'0')
+		}
+
+		var element strings.Builder
+		for i := 0; i < length; i++ {
+			c, err := s.readRune()
+			if err != nil {
+				return nil, nil, err
+			}
+			element.WriteRune(c)
+		}
+		elements = append(elements, element.String())
+
+		terminator, err := s.readRune()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch terminator {
+		case ';':
+			raw := make([]byte, s.raw.Len())
+			copy(raw, s.raw.Bytes())
+			return raw, NewInstruction(elements[0], elements[1:]...), nil
+		case ',':
+			// keep going
+		default:
+			return nil, nil, ErrServer.NewError("Element terminator of instruction was not ';' nor ','")
+		}
+	}
+}
+
+func (s *recordingScanner) readRune() (rune, error) {
+	c, _, err := s.r.ReadRune()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.raw.WriteRune(c)
+	if s.limit > 0 && s.raw.Len() > s.limit {
+		return 0, errInstructionTooLarge
+	}
+	return c, nil
+}
@@ -0,0 +1,105 @@
+package guac
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordingScanner_Next(t *testing.T) {
+	scanner := newRecordingScanner(strings.NewReader("4.copy,1.🚀;\n4.sync,4.1000;4.copy"))
+
+	raw, ins, err := scanner.Next()
+	if err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if string(raw) != "4.copy,1.🚀;" || ins.Opcode != "copy" || ins.Args[0] != "🚀" {
+		t.Error("Unexpected instruction", string(raw))
+	}
+
+	raw, ins, err = scanner.Next()
+	if err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if string(raw) != "4.sync,4.1000;" || ins.Opcode != "sync" {
+		t.Error("Unexpected instruction", string(raw))
+	}
+
+	if _, _, err = scanner.Next(); err != io.ErrUnexpectedEOF {
+		t.Error("Expected unexpected EOF, got", err)
+	}
+}
+
+func TestPlayer_HonorsSyncTiming(t *testing.T) {
+	recording := "4.copy,2.ab;4.sync,3.100;4.copy,2.cd;4.sync,3.160;"
+	msgWriter := &fakeMessageWriter{}
+	p := newPlayer(func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(recording)), nil
+	}, msgWriter)
+
+	go func() {
+		// let the player reach the end of the recording, then disconnect
+		time.Sleep(200 * time.Millisecond)
+		close(p.done)
+	}()
+
+	start := time.Now()
+	if err := p.run(); err != errPlaybackDone {
+		t.Error("Expected playback to end on disconnect, got", err)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Error("Expected playback to wait between frames, took", elapsed)
+	}
+
+	var frames []string
+	for _, msg := range msgWriter.Messages {
+		if !bytes.HasPrefix(msg, internalOpcodeIns) {
+			frames = append(frames, string(msg))
+		}
+	}
+	if len(frames) != 2 || frames[0] != "4.copy,2.ab;4.sync,3.100;" || frames[1] != "4.copy,2.cd;4.sync,3.160;" {
+		t.Error("Unexpected frames", frames)
+	}
+
+	last := string(msgWriter.Messages[len(msgWriter.Messages)-1])
+	if last != "0.,8.playback,5.ended,2.60;" {
+		t.Error("Expected ended status, got", last)
+	}
+}
+
+func TestPlayer_HandleControls(t *testing.T) {
+	p := newPlayer(nil, &fakeMessageWriter{})
+	p.position = 500
+
+	if err := p.handle(NewInstruction(PlaybackSeek, "0")); err != errPlaybackRestart {
+		t.Error("Expected restart, got", err)
+	}
+	if err := p.handle(NewInstruction(PlaybackSpeed, "100")); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if p.speed != maxPlaybackSpeed {
+		t.Error("Expected speed to be capped, got", p.speed)
+	}
+}
+
+func TestPlayer_ReadControlsReturnsWhenPlaybackStops(t *testing.T) {
+	p := newPlayer(nil, &fakeMessageWriter{})
+	reader := &fakeMessageReader{}
+	for i := 0; i < cap(p.controls)+4; i++ {
+		reader.Messages = append(reader.Messages, []byte("0.,5.pause;"))
+	}
+	close(p.stop)
+
+	done := make(chan struct{})
+	go func() {
+		p.readControls(reader)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("readControls blocked after the playback stopped")
+	}
+}
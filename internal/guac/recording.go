@@ -0,0 +1,115 @@
+package guac
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RecordingTunnel wraps a Tunnel and copies every instruction read from guacd
+// into a recording sink. The sink receives the raw instruction stream in the
+// same format guacd writes its own .guac session recordings, so the result
+// can be played back by any Guacamole client.
+//
+// Because both the WebSocket server and the HTTP Server read guacd through
+// Tunnel.AcquireReader, wrapping the tunnel is enough to record either path.
+type RecordingTunnel struct {
+	Tunnel
+	reader *recordingReader
+
+	closeOnce sync.Once
+}
+
+// RecordingSink receives the recording of a session
+type RecordingSink interface {
+	io.WriteCloser
+	// MarkTruncated is called before Close when instructions were left out of the
+	// recording because it fell behind the session
+	MarkTruncated()
+}
+
+// NewRecordingTunnel wraps tunnel so that all guacd output is written to sink.
+// The sink is written from its own goroutine, and closed when the tunnel is closed.
+func NewRecordingTunnel(tunnel Tunnel, sink RecordingSink) *RecordingTunnel {
+	return newRecordingTunnel(tunnel, sink, recordingMemoryLimit, recordingSpillLimit, "")
+}
+
+func newRecordingTunnel(tunnel Tunnel, sink RecordingSink, memoryLimit, spillLimit int64, dir string) *RecordingTunnel {
+	write := func(ins []byte) error {
+		_, err := sink.Write(ins)
+		return err
+	}
+	finish := func(truncated bool, err error) {
+		if err != nil {
+			logrus.Warnf("Failed writing to session recording of %s, recording stopped: %v", tunnel.ConnectionID(), err)
+		}
+		if truncated {
+			sink.MarkTruncated()
+		}
+		if closeErr := sink.Close(); closeErr != nil {
+			logrus.Warnf("Failed to finalize recording for %s: %v", tunnel.ConnectionID(), closeErr)
+		}
+	}
+
+	return &RecordingTunnel{
+		Tunnel: tunnel,
+		reader: &recordingReader{spool: newRecordingSpool(memoryLimit, spillLimit, dir, write, finish)},
+	}
+}
+
+// AcquireReader acquires the underlying reader and returns a reader that tees
+// into the recording sink
+func (t *RecordingTunnel) AcquireReader() InstructionReader {
+	reader := t.Tunnel.AcquireReader()
+	t.reader.Lock()
+	t.reader.InstructionReader = reader
+	t.reader.Unlock()
+	return t.reader
+}
+
+// Close closes the underlying tunnel and finalizes the recording once the instructions
+// still queued are written
+func (t *RecordingTunnel) Close() error {
+	err := t.Tunnel.Close()
+	t.closeOnce.Do(func() {
+		t.reader.spool.close()
+	})
+	return err
+}
+
+// recordingReader is an InstructionReader which queues every instruction it returns for
+// the recording. Recording failures never interrupt the session: when the sink fails or
+// falls behind too far the recording stops and instructions are only passed through.
+type recordingReader struct {
+	sync.Mutex
+	InstructionReader
+
+	spool   *recordingSpool
+	stopped bool
+}
+
+// ReadSome reads the next instruction and records it
+func (r *recordingReader) ReadSome() ([]byte, error) {
+	ins, err := r.InstructionReader.ReadSome()
+	if err != nil || len(ins) == 0 {
+		return ins, err
+	}
+
+	if bytes.HasPrefix(ins, internalOpcodeIns) {
+		// internal instructions are tunnel bookkeeping, not session output
+		return ins, nil
+	}
+
+	if werr := r.spool.write(ins); werr != nil {
+		r.Lock()
+		defer r.Unlock()
+		if !r.stopped && errors.Is(werr, errRecordingTruncated) {
+			logrus.Warnf("Session recording fell behind by more than %d MiB: %v", (recordingMemoryLimit+recordingSpillLimit)>>20, werr)
+		}
+		r.stopped = true
+	}
+	return ins, nil
+}
@@ -0,0 +1,207 @@
+package guac
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// errRecordingTruncated is the error of writes to a recordingSpool that fell behind by
+// more than it may hold
+var errRecordingTruncated = errors.New("recording truncated")
+
+const (
+	// recordingMemoryLimit is how many bytes a recording may fall behind the session in
+	// memory before it spills to disk
+	recordingMemoryLimit = 8 << 20
+	// recordingSpillLimit is how many bytes a recording may hold on disk before it is
+	// truncated
+	recordingSpillLimit = 512 << 20
+	// recordingSpillChunk is how much of the spilled recording is read back at once
+	recordingSpillChunk = 64 << 10
+)
+
+// recordingSpool hands the instructions of a recording over to a goroutine writing them
+// to the sink, so that a slow sink such as a MinIO upload never blocks the session. It is
+// bounded by bytes rather than instructions: up to memoryLimit bytes wait in memory, more
+// spill to a temporary file of up to spillLimit bytes. A recording falling behind further
+// is truncated, the writes already queued are still written but later ones are dropped.
+type recordingSpool struct {
+	memoryLimit, spillLimit int64
+	dir                     string
+
+	mu    sync.Mutex
+	ready *sync.Cond
+	// memory holds the writes queued before those in the file
+	memory      [][]byte
+	memoryBytes int64
+	// file holds the bytes between read and written once the memory is full, later writes
+	// go to the file as well until it is drained
+	file          *os.File
+	read, written int64
+	closed        bool
+	truncated     bool
+	// err is the error of the sink, which stops the writes still pending
+	err error
+
+	done chan struct{}
+}
+
+// newRecordingSpool starts a spool whose file is created in dir, the default directory for
+// temporary files if empty. write is called with the queued bytes in order, and finish
+// once the spool is closed, with whether it was truncated and the first error of write.
+func newRecordingSpool(memoryLimit, spillLimit int64, dir string, write func(p []byte) error, finish func(truncated bool, err error)) *recordingSpool {
+	s := &recordingSpool{
+		memoryLimit: memoryLimit,
+		spillLimit:  spillLimit,
+		dir:         dir,
+		done:        make(chan struct{}),
+	}
+	s.ready = sync.NewCond(&s.mu)
+
+	go func() {
+		defer close(s.done)
+		for {
+			p, err := s.next()
+			if err == nil && p == nil {
+				break
+			}
+			if err == nil {
+				err = write(p)
+			}
+			if err != nil {
+				s.fail(err)
+			}
+		}
+		s.removeFile()
+		finish(s.truncated, s.err)
+	}()
+
+	return s
+}
+
+// write queues a copy of p. It returns errRecordingTruncated once the spool is full, or the
+// error that stopped it.
+func (s *recordingSpool) write(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.err != nil:
+		return s.err
+	case s.truncated:
+		return errRecordingTruncated
+	case s.closed:
+		return errors.New("recording closed")
+	}
+
+	if s.written == 0 && s.memoryBytes+int64(len(p)) <= s.memoryLimit {
+		s.memory = append(s.memory, append([]byte(nil), p...))
+		s.memoryBytes += int64(len(p))
+		s.ready.Signal()
+		return nil
+	}
+
+	if s.written+int64(len(p)) > s.spillLimit {
+		s.truncate()
+		return errRecordingTruncated
+	}
+	if s.file == nil {
+		file, err := os.CreateTemp(s.dir, "recording-*.guac")
+		if err != nil {
+			s.truncate()
+			return fmt.Errorf("%w: failed to spill to disk: %v", errRecordingTruncated, err)
+		}
+		s.file = file
+	}
+	if _, err := s.file.WriteAt(p, s.written); err != nil {
+		s.truncate()
+		return fmt.Errorf("%w: failed to spill to disk: %v", errRecordingTruncated, err)
+	}
+	s.written += int64(len(p))
+	s.ready.Signal()
+	return nil
+}
+
+// next waits for the next bytes to write, returning nil once the spool is closed and
+// drained or stopped by an error
+func (s *recordingSpool) next() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		switch {
+		case s.err != nil:
+			return nil, nil
+		case len(s.memory) > 0:
+			p := s.memory[0]
+			s.memory[0] = nil
+			s.memory = s.memory[1:]
+			s.memoryBytes -= int64(len(p))
+			return p, nil
+		case s.read < s.written:
+			return s.readFile()
+		case s.closed || s.truncated:
+			return nil, nil
+		}
+		s.ready.Wait()
+	}
+}
+
+// readFile reads the next chunk of the file. The bytes already written to it do not
+// change, so they are read without holding the lock.
+func (s *recordingSpool) readFile() ([]byte, error) {
+	p := make([]byte, min(s.written-s.read, recordingSpillChunk))
+	offset := s.read
+	s.mu.Unlock()
+	_, err := s.file.ReadAt(p, offset)
+	s.mu.Lock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spilled recording: %w", err)
+	}
+
+	s.read += int64(len(p))
+	if s.read == s.written {
+		// drained, writes go to memory again and the file is reused from its start
+		s.read, s.written = 0, 0
+	}
+	return p, nil
+}
+
+// truncate stops accepting writes, those queued are still written
+func (s *recordingSpool) truncate() {
+	s.truncated = true
+	s.ready.Broadcast()
+}
+
+// close stops accepting writes, finish is called once those queued are written
+func (s *recordingSpool) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.ready.Broadcast()
+}
+
+// wait blocks until the spool finished
+func (s *recordingSpool) wait() {
+	<-s.done
+}
+
+func (s *recordingSpool) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.memory, s.memoryBytes = nil, 0
+	s.ready.Broadcast()
+}
+
+func (s *recordingSpool) removeFile() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return
+	}
+	_ = s.file.Close()
+	_ = os.Remove(s.file.Name())
+}
@@ -0,0 +1,137 @@
+package guac
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeRecordingSink struct {
+	bytes.Buffer
+	Closed    int
+	Truncated bool
+}
+
+func (f *fakeRecordingSink) MarkTruncated() {
+	f.Truncated = true
+}
+
+func (f *fakeRecordingSink) Close() error {
+	f.Closed++
+	return nil
+}
+
+func TestRecordingTunnel_RecordsGuacdOutput(t *testing.T) {
+	conn := &fakeConn{
+		ToRead: []byte("0.,36.1e7b3e6c-0f6a-4bd5-8b35-7d1c1dbf6b1a;4.sync,4.1000;4.copy,2.ab;"),
+	}
+	sink := &fakeRecordingSink{}
+	tunnel := NewRecordingTunnel(&fakeTunnel{reader: NewStream(conn, time.Minute)}, sink)
+
+	msgWriter := &fakeMessageWriter{}
+	guacdToWs(msgWriter, tunnel.AcquireReader(), nil)
+
+	if err := tunnel.Close(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	if err := tunnel.Close(); err != nil {
+		t.Error("Unexpected error", err)
+	}
+	tunnel.reader.spool.wait()
+
+	if sink.String() != "4.sync,4.1000;4.copy,2.ab;" {
+		t.Error("Unexpected recording", sink.String())
+	}
+	if sink.Closed != 1 {
+		t.Error("Expected sink to be closed once, got", sink.Closed)
+	}
+}
+
+// blockingSink is a recording sink whose writes wait until it is released
+type blockingSink struct {
+	fakeRecordingSink
+	release chan struct{}
+}
+
+func (b *blockingSink) Write(p []byte) (int, error) {
+	<-b.release
+	return b.fakeRecordingSink.Write(p)
+}
+
+// repeatingReader returns the same instruction a number of times, then io.EOF
+type repeatingReader struct {
+	ins   []byte
+	count int
+}
+
+func (r *repeatingReader) ReadSome() ([]byte, error) {
+	if r.count == 0 {
+		return nil, io.EOF
+	}
+	r.count--
+	return r.ins, nil
+}
+
+func (r *repeatingReader) Available() bool {
+	return r.count > 0
+}
+
+func (r *repeatingReader) Flush() {}
+
+func TestRecordingTunnel_SpillsWhenSinkFallsBehind(t *testing.T) {
+	ins := []byte("4.sync,4.1000;")
+	for _, test := range []struct {
+		name       string
+		spillLimit int64
+		truncated  bool
+	}{
+		{"spilled to disk", 100 * int64(len(ins)), false},
+		{"truncated", 20 * int64(len(ins)), true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			count := 50
+			dir := t.TempDir()
+			sink := &blockingSink{release: make(chan struct{})}
+			tunnel := newRecordingTunnel(&fakeTunnel{reader: &repeatingReader{ins: ins, count: count}}, sink, 10*int64(len(ins)), test.spillLimit, dir)
+
+			// the session is read to the end although the sink accepts nothing
+			reader := tunnel.AcquireReader()
+			for {
+				if _, err := reader.ReadSome(); err != nil {
+					break
+				}
+			}
+			if tunnel.reader.stopped != test.truncated {
+				t.Errorf("recording stopped=%v, want %v", tunnel.reader.stopped, test.truncated)
+			}
+
+			close(sink.release)
+			if err := tunnel.Close(); err != nil {
+				t.Error("Unexpected error", err)
+			}
+			tunnel.reader.spool.wait()
+			if sink.Closed != 1 {
+				t.Error("Expected sink to be closed once, got", sink.Closed)
+			}
+			if sink.Truncated != test.truncated {
+				t.Errorf("recording marked truncated=%v, want %v", sink.Truncated, test.truncated)
+			}
+
+			recorded := strings.Count(sink.String(), string(ins))
+			if sink.Len() != recorded*len(ins) {
+				t.Errorf("recording holds partial instructions: %q", sink.String())
+			}
+			// a truncated recording keeps what fit in memory and on disk, and the instruction
+			// the sink may have taken before it blocked
+			if test.truncated && (recorded < 30 || recorded > 31) || !test.truncated && recorded != count {
+				t.Errorf("recorded %d instructions of %d", recorded, count)
+			}
+			if files, _ := os.ReadDir(dir); len(files) != 0 {
+				t.Errorf("spilled recording was not removed: %v", files)
+			}
+		})
+	}
+}
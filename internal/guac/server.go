@@ -1,6 +1,8 @@
 package guac
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,6 +17,9 @@ const (
 	readPrefixLength         = len(readPrefix)
 	writePrefixLength        = len(writePrefix)
 	uuidLength               = 36
+	// maxWriteInstruction is the largest instruction of a write request passed through
+	// filters, which read the request one instruction at a time
+	maxWriteInstruction = 8 * MaxGuacMessage
 )
 
 // Server uses HTTP requests to talk to guacd (as opposed to WebSockets in ws_server.go)
@@ -201,6 +206,7 @@ func (s *Server) doRead(response http.ResponseWriter, request *http.Request, tun
 // writeSome drains the guacd buffer holding instructions into the response
 func (s *Server) writeSome(response http.ResponseWriter, guacd InstructionReader, tunnel Tunnel) (err error) {
 	var message []byte
+	filters := tunnelFilters(tunnel)
 
 	for {
 		message, err = guacd.ReadSome()
@@ -216,7 +222,7 @@ func (s *Server) writeSome(response http.ResponseWriter, guacd InstructionReader
 			return
 		}
 
-		_, e := response.Write(message)
+		_, e := response.Write(filters.Filter(GuacdToClient, message))
 		if e != nil {
 			err = ErrOther.NewError(e.Error())
 			return
@@ -262,14 +268,58 @@ func (s *Server) doWrite(response http.ResponseWriter, request *http.Request, tu
 	writer := tunnel.AcquireWriter()
 	defer tunnel.ReleaseWriter()
 
-	_, err = io.Copy(writer, request.Body)
+	if filters := tunnelFilters(tunnel); filters != nil {
+		err = filterWrite(writer, request.Body, filters)
+	} else {
+		_, err = io.Copy(writer, request.Body)
+	}
 
+	var guacErr *ErrGuac
+	switch {
+	case errors.Is(err, errInstructionTooLarge):
+		err = ErrClientOverrun.NewError(fmt.Sprintf("Write request instruction larger than %d bytes", maxWriteInstruction))
+	case err != nil && !errors.As(err, &guacErr):
+		err = ErrOther.NewError(err.Error())
+	}
 	if err != nil {
 		s.deregisterTunnel(tunnel)
-		if err = tunnel.Close(); err != nil {
-			logger.Debug("Error closing tunnel")
+		if closeErr := tunnel.Close(); closeErr != nil {
+			logger.Debug("Error closing tunnel:", closeErr)
 		}
 	}
 
 	return err
 }
+
+// filterWrite passes the instructions of body through filters to writer. Instructions
+// are read one at a time, so that a body of any length is filtered in bounded memory,
+// and what is left of them is written a message at a time.
+func filterWrite(writer io.Writer, body io.Reader, filters *FilterChain) error {
+	scanner := newRecordingScanner(body)
+	scanner.limit = maxWriteInstruction
+
+	buf := bytes.NewBuffer(make([]byte, 0, MaxGuacMessage*2))
+	for {
+		raw, _, err := scanner.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		buf.Write(filters.Filter(ClientToGuacd, raw))
+		if buf.Len() >= MaxGuacMessage {
+			if _, err = writer.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+	}
+
+	if buf.Len() > 0 {
+		_, err := writer.Write(buf.Bytes())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package guac
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type uuidTunnel struct {
+	fakeTunnel
+	uuid string
+}
+
+func (t *uuidTunnel) GetUUID() string {
+	return t.uuid
+}
+
+// passFilter passes every instruction on, so that a tunnel is filtered without changes
+type passFilter struct{}
+
+func (passFilter) Matches(opcode string) bool {
+	return false
+}
+
+func (passFilter) Filter(direction Direction, ins *Instruction) *Instruction {
+	return ins
+}
+
+func TestServer_doWrite(t *testing.T) {
+	instruction := NewInstruction("key", "65", "1").String()
+	// a file upload the client sends in one request, larger than any single message
+	upload := strings.Repeat(NewInstruction("blob", "1", strings.Repeat("A", 4096)).String(), 32)
+
+	for _, test := range []struct {
+		name    string
+		filters *FilterChain
+		body    string
+		status  int
+		written string
+	}{
+		{"instructions", nil, instruction, http.StatusOK, instruction},
+		{"large write", nil, upload, http.StatusOK, upload},
+		{"filtered instructions", NewFilterChain(ViewOnlyFilter()), instruction + "4.sync,4.1000;", http.StatusOK, "4.sync,4.1000;"},
+		{"filtered large write", NewFilterChain(passFilter{}), upload, http.StatusOK, upload},
+		{"filtered instruction too large", NewFilterChain(passFilter{}), NewInstruction("blob", "1", strings.Repeat("A", maxWriteInstruction)).String(), http.StatusRequestEntityTooLarge, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			written := &bytes.Buffer{}
+			fake := &uuidTunnel{fakeTunnel: fakeTunnel{writer: written}, uuid: "00000000-0000-0000-0000-000000000001"}
+			tunnel := NewFilteredTunnel(fake, test.filters)
+			server := NewServer(nil)
+			server.registerTunnel(tunnel)
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/tunnel?write:"+fake.uuid, strings.NewReader(test.body))
+			server.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("status=%d, want %d", recorder.Code, test.status)
+			}
+			if test.written != "" && written.String() != test.written {
+				t.Errorf("wrote %d bytes, want %d", written.Len(), len(test.written))
+			}
+			if _, err := server.getTunnel(fake.uuid); (err == nil) != (test.status == http.StatusOK) {
+				t.Errorf("tunnel registered=%v after status %d", err == nil, recorder.Code)
+			}
+		})
+	}
+}
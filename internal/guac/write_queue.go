@@ -0,0 +1,102 @@
+package guac
+
+import (
+	"errors"
+	"sync"
+)
+
+// errQueueFull is the error of writes to a writeQueue whose destination fell behind
+var errQueueFull = errors.New("write queue full")
+
+// writeQueue hands writes over to a goroutine, so that a slow destination such as a MinIO
+// upload never blocks the tunnel. It holds a bounded number of writes: a write beyond
+// them fails with errQueueFull and stops the queue instead of waiting.
+type writeQueue struct {
+	writes chan []byte
+	done   chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	// err is the first error of the queue, which stops the writes still pending
+	err error
+}
+
+// newWriteQueue starts a queue of size writes. write is called with each queued write in
+// order, and finish once the queue is closed with its first error, nil if every write
+// succeeded and the queue was closed without a reason.
+func newWriteQueue(size int, write func(p []byte) error, finish func(err error)) *writeQueue {
+	q := &writeQueue{
+		writes: make(chan []byte, size),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(q.done)
+		for p := range q.writes {
+			if q.failure() != nil {
+				continue
+			}
+			if err := write(p); err != nil {
+				q.fail(err)
+			}
+		}
+		finish(q.failure())
+	}()
+
+	return q
+}
+
+// write queues a copy of p. It returns the error that stopped the queue, if any.
+func (q *writeQueue) write(p []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	if q.closed {
+		return errors.New("write queue closed")
+	}
+
+	select {
+	case q.writes <- append([]byte(nil), p...):
+		return nil
+	default:
+		q.err = errQueueFull
+		q.closed = true
+		close(q.writes)
+		return q.err
+	}
+}
+
+// close stops accepting writes. The pending writes are dropped when reason is set, and
+// finish is called after them.
+func (q *writeQueue) close(reason error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if reason != nil && q.err == nil {
+		q.err = reason
+	}
+	if !q.closed {
+		q.closed = true
+		close(q.writes)
+	}
+}
+
+// wait blocks until the queue finished
+func (q *writeQueue) wait() {
+	<-q.done
+}
+
+func (q *writeQueue) failure() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+func (q *writeQueue) fail(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == nil {
+		q.err = err
+	}
+}
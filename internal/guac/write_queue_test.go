@@ -0,0 +1,85 @@
+package guac
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWriteQueue(t *testing.T) {
+	t.Run("WritesInOrder", func(t *testing.T) {
+		var written []string
+		var finished error = errors.New("not finished")
+		q := newWriteQueue(4, func(p []byte) error {
+			written = append(written, string(p))
+			return nil
+		}, func(err error) {
+			finished = err
+		})
+
+		for _, p := range []string{"a", "b", "c"} {
+			if err := q.write([]byte(p)); err != nil {
+				t.Fatalf("write(%s) returned %v", p, err)
+			}
+		}
+		q.close(nil)
+		q.wait()
+
+		if got := len(written); got != 3 || written[0] != "a" || written[2] != "c" {
+			t.Fatalf("written=%v, want [a b c]", written)
+		}
+		if finished != nil {
+			t.Fatalf("finished with %v, want nil", finished)
+		}
+	})
+
+	t.Run("FailsWhenFull", func(t *testing.T) {
+		release := make(chan struct{})
+		var finished error
+		q := newWriteQueue(1, func(p []byte) error {
+			<-release
+			return nil
+		}, func(err error) {
+			finished = err
+		})
+
+		var err error
+		for i := 0; i < 3 && err == nil; i++ {
+			err = q.write([]byte("a"))
+		}
+		if !errors.Is(err, errQueueFull) {
+			t.Fatalf("write returned %v, want errQueueFull", err)
+		}
+		if err = q.write([]byte("a")); !errors.Is(err, errQueueFull) {
+			t.Fatalf("write after overflow returned %v, want errQueueFull", err)
+		}
+
+		close(release)
+		q.wait()
+		if !errors.Is(finished, errQueueFull) {
+			t.Fatalf("finished with %v, want errQueueFull", finished)
+		}
+	})
+
+	t.Run("StopsOnWriteError", func(t *testing.T) {
+		writes := 0
+		var finished error
+		q := newWriteQueue(4, func(p []byte) error {
+			writes++
+			return errors.New("broken")
+		}, func(err error) {
+			finished = err
+		})
+
+		_ = q.write([]byte("a"))
+		_ = q.write([]byte("b"))
+		q.close(nil)
+		q.wait()
+
+		if writes != 1 || finished == nil {
+			t.Fatalf("writes=%d finished=%v, want one write and an error", writes, finished)
+		}
+		if err := q.write([]byte("c")); err == nil {
+			t.Fatal("write after an error succeeded")
+		}
+	})
+}
@@ -6,14 +6,23 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/browsersec/KubeBrowse/internal/metrics"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 )
 
+// Bytes proxied in each direction, resolved once as they are counted for every message
+var (
+	clientToGuacdBytes = metrics.TunnelBytes.WithLabelValues(metrics.DirectionClientToGuacd)
+	guacdToClientBytes = metrics.TunnelBytes.WithLabelValues(metrics.DirectionGuacdToClient)
+)
+
 // WebsocketServer implements a websocket-based connection to guacd.
 type WebsocketServer struct {
 	connect   func(*http.Request) (Tunnel, error)
 	connectWs func(*websocket.Conn, *http.Request) (Tunnel, error)
+	// openRecording is set for playback servers, see NewPlaybackWebsocketServer
+	openRecording func(*http.Request) (io.ReadCloser, error)
 
 	// OnConnect is an optional callback called when a websocket connects.
 	// Deprecated: use OnConnectWs
@@ -78,6 +87,12 @@ func (s *WebsocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	if s.openRecording != nil {
+		logrus.Debug("Starting recording playback")
+		s.servePlayback(ws, r)
+		return
+	}
+
 	logrus.Debug("Connecting to tunnel")
 	var tunnel Tunnel
 	var e error
@@ -118,8 +133,9 @@ func (s *WebsocketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer tunnel.ReleaseWriter()
 	defer tunnel.ReleaseReader()
 
-	go wsToGuacd(ws, writer)
-	guacdToWs(ws, reader)
+	filters := tunnelFilters(tunnel)
+	go wsToGuacd(ws, writer, filters)
+	guacdToWs(ws, reader, filters)
 }
 
 // MessageReader wraps a websocket connection and only permits Reading
@@ -128,7 +144,7 @@ type MessageReader interface {
 	ReadMessage() (int, []byte, error)
 }
 
-func wsToGuacd(ws MessageReader, guacd io.Writer) {
+func wsToGuacd(ws MessageReader, guacd io.Writer, filters *FilterChain) {
 	for {
 		_, data, err := ws.ReadMessage()
 		if err != nil {
@@ -141,10 +157,15 @@ func wsToGuacd(ws MessageReader, guacd io.Writer) {
 			continue
 		}
 
+		if data = filters.Filter(ClientToGuacd, data); len(data) == 0 {
+			continue
+		}
+
 		if _, err = guacd.Write(data); err != nil {
 			logrus.Traceln("Failed writing to guacd", err)
 			return
 		}
+		clientToGuacdBytes.Add(float64(len(data)))
 	}
 }
 
@@ -154,7 +175,7 @@ type MessageWriter interface {
 	WriteMessage(int, []byte) error
 }
 
-func guacdToWs(ws MessageWriter, guacd InstructionReader) {
+func guacdToWs(ws MessageWriter, guacd InstructionReader, filters *FilterChain) {
 	buf := bytes.NewBuffer(make([]byte, 0, MaxGuacMessage*2))
 
 	for {
@@ -169,7 +190,7 @@ func guacdToWs(ws MessageWriter, guacd InstructionReader) {
 			continue
 		}
 
-		if _, err = buf.Write(ins); err != nil {
+		if _, err = buf.Write(filters.Filter(GuacdToClient, ins)); err != nil {
 			logrus.Traceln("Failed to buffer guacd to ws", err)
 			return
 		}
@@ -183,6 +204,7 @@ func guacdToWs(ws MessageWriter, guacd InstructionReader) {
 				logrus.Traceln("Failed sending message to ws", err)
 				return
 			}
+			guacdToClientBytes.Add(float64(buf.Len()))
 			buf.Reset()
 		}
 	}
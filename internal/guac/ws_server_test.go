@@ -25,7 +25,7 @@ func TestWebsocketServer_guacdToWs(t *testing.T) {
 	}
 	guac := NewStream(conn, time.Minute)
 
-	guacdToWs(msgWriter, guac)
+	guacdToWs(msgWriter, guac, nil)
 
 	if len(msgWriter.Messages) != 1 {
 		t.Error("Expected 1 got", len(msgWriter.Messages))
@@ -41,7 +41,8 @@ type fakeMessageWriter struct {
 }
 
 func (f *fakeMessageWriter) WriteMessage(n int, buf []byte) error {
-	f.Messages = append(f.Messages, buf)
+	// callers may reuse buf after the write returns, like websocket.Conn allows
+	f.Messages = append(f.Messages, append([]byte(nil), buf...))
 	return nil
 }
 
@@ -84,3 +85,39 @@ func (f *fakeTunnel) GetUUID() string {
 func (f *fakeTunnel) Close() error {
 	return nil
 }
+
+type fakeMessageReader struct {
+	Messages [][]byte
+}
+
+func (f *fakeMessageReader) ReadMessage() (int, []byte, error) {
+	if len(f.Messages) == 0 {
+		return 0, nil, io.EOF
+	}
+	msg := f.Messages[0]
+	f.Messages = f.Messages[1:]
+	return 1, msg, nil
+}
+
+func TestWebsocketServer_wsToGuacdViewOnly(t *testing.T) {
+	messages := func() *fakeMessageReader {
+		return &fakeMessageReader{Messages: [][]byte{
+			[]byte("0.,4.ping,13.1700000000000;"),
+			[]byte("3.key,5.65307,1.1;"),
+			[]byte("4.sync,8.12345678;"),
+			[]byte("5.mouse,2.10,2.20,1.1;"),
+		}}
+	}
+
+	var guacd bytes.Buffer
+	wsToGuacd(messages(), &guacd, nil)
+	if got, want := guacd.String(), "3.key,5.65307,1.1;4.sync,8.12345678;5.mouse,2.10,2.20,1.1;"; got != want {
+		t.Errorf("interactive got %q, want %q", got, want)
+	}
+
+	guacd.Reset()
+	wsToGuacd(messages(), &guacd, NewFilterChain(ViewOnlyFilter()))
+	if got, want := guacd.String(), "4.sync,8.12345678;"; got != want {
+		t.Errorf("view-only got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,26 @@
+package history
+
+import "time"
+
+// Reasons a sandbox session ended
+const (
+	EndTimeout    = "timeout"     // Its lease expired without being extended
+	EndUserStop   = "user_stop"   // Its user stopped it
+	EndDisconnect = "disconnect"  // Nobody reconnected to it within the grace period
+	EndPodFailure = "pod_failure" // Its pod failed or disappeared
+)
+
+// Session is the record of a sandbox session, kept after its pod is gone
+type Session struct {
+	ConnectionID       string     `json:"connection_id"`
+	Profile            string     `json:"profile"`
+	PodName            string     `json:"pod_name"`
+	NodeName           string     `json:"node_name"`
+	CreatedAt          time.Time  `json:"created_at"`
+	ConnectedAt        *time.Time `json:"connected_at,omitempty"` // First connection to the session
+	EndedAt            *time.Time `json:"ended_at,omitempty"`
+	EndReason          string     `json:"end_reason,omitempty"`
+	ExtensionCount     int32      `json:"extension_count"`
+	DisconnectionCount int32      `json:"disconnection_count"`
+	BytesTransferred   int64      `json:"bytes_transferred"` // Bytes exchanged with guacd over its tunnels
+}
@@ -0,0 +1,143 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	sqlc "github.com/browsersec/KubeBrowse/db/sqlc"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// writeTimeout bounds recording a lifecycle transition, which never holds up the session
+const writeTimeout = 5 * time.Second
+
+// Service keeps the history of sandbox sessions in Postgres. Transitions are recorded on a
+// best effort basis: failures are logged, and nothing is recorded when no service is
+// configured.
+type Service struct {
+	db *sqlc.Queries
+}
+
+func NewService(db *sqlc.Queries) *Service {
+	return &Service{db: db}
+}
+
+// record runs a write of a transition of a session, logging its failure
+func (s *Service) record(transition, connectionID string, write func(ctx context.Context) error) {
+	if s == nil || connectionID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	if err := write(ctx); err != nil {
+		logrus.Errorf("Failed to record %s of session %s: %v", transition, connectionID, err)
+	}
+}
+
+// Created records a session whose pod was deployed, userID is empty when it has no owner
+func (s *Service) Created(session Session, userID string) {
+	s.record("creation", session.ConnectionID, func(ctx context.Context) error {
+		var owner uuid.NullUUID
+		if id, err := uuid.Parse(userID); err == nil {
+			owner = uuid.NullUUID{UUID: id, Valid: true}
+		}
+		return s.db.CreateSandboxSession(ctx, sqlc.CreateSandboxSessionParams{
+			ConnectionID: session.ConnectionID,
+			UserID:       owner,
+			Profile:      session.Profile,
+			PodName:      session.PodName,
+			NodeName:     session.NodeName,
+		})
+	})
+}
+
+// Connected records a connection to a session
+func (s *Service) Connected(connectionID string) {
+	s.record("connection", connectionID, func(ctx context.Context) error {
+		return s.db.MarkSandboxSessionConnected(ctx, connectionID)
+	})
+}
+
+// Extended records an extension of the lease of a session
+func (s *Service) Extended(connectionID string) {
+	s.record("extension", connectionID, func(ctx context.Context) error {
+		return s.db.IncrementSandboxSessionExtensions(ctx, connectionID)
+	})
+}
+
+// Disconnected records the last client of a session disconnecting from it
+func (s *Service) Disconnected(connectionID string) {
+	s.record("disconnection", connectionID, func(ctx context.Context) error {
+		return s.db.IncrementSandboxSessionDisconnections(ctx, connectionID)
+	})
+}
+
+// Transferred adds the bytes exchanged over a closed tunnel of a session
+func (s *Service) Transferred(connectionID string, bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+	s.record("transfer", connectionID, func(ctx context.Context) error {
+		return s.db.AddSandboxSessionBytes(ctx, sqlc.AddSandboxSessionBytesParams{
+			ConnectionID:     connectionID,
+			BytesTransferred: bytes,
+		})
+	})
+}
+
+// Ended records the end of a session, only its first end is kept
+func (s *Service) Ended(connectionID, reason string) {
+	s.record("end", connectionID, func(ctx context.Context) error {
+		return s.db.EndSandboxSession(ctx, sqlc.EndSandboxSessionParams{
+			ConnectionID: connectionID,
+			EndReason:    sql.NullString{String: reason, Valid: true},
+		})
+	})
+}
+
+// ListByUser returns a page of the sessions of a user, newest first, and their total count
+func (s *Service) ListByUser(ctx context.Context, userID uuid.UUID, limit, offset int32) ([]Session, int64, error) {
+	owner := uuid.NullUUID{UUID: userID, Valid: true}
+	dbSessions, err := s.db.ListUserSandboxSessions(ctx, sqlc.ListUserSandboxSessionsParams{
+		UserID: owner,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	total, err := s.db.CountUserSandboxSessions(ctx, owner)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count sessions: %w", err)
+	}
+
+	sessions := make([]Session, 0, len(dbSessions))
+	for _, dbSession := range dbSessions {
+		sessions = append(sessions, convertDBSession(dbSession))
+	}
+	return sessions, total, nil
+}
+
+func convertDBSession(dbSession sqlc.SandboxSession) Session {
+	session := Session{
+		ConnectionID:       dbSession.ConnectionID,
+		Profile:            dbSession.Profile,
+		PodName:            dbSession.PodName,
+		NodeName:           dbSession.NodeName,
+		CreatedAt:          dbSession.CreatedAt,
+		EndReason:          dbSession.EndReason.String,
+		ExtensionCount:     dbSession.ExtensionCount,
+		DisconnectionCount: dbSession.DisconnectionCount,
+		BytesTransferred:   dbSession.BytesTransferred,
+	}
+	if dbSession.ConnectedAt.Valid {
+		session.ConnectedAt = &dbSession.ConnectedAt.Time
+	}
+	if dbSession.EndedAt.Valid {
+		session.EndedAt = &dbSession.EndedAt.Time
+	}
+	return session
+}
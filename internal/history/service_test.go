@@ -0,0 +1,85 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	sqlc "github.com/browsersec/KubeBrowse/db/sqlc"
+	"github.com/google/uuid"
+)
+
+func newMockService(t *testing.T) (*Service, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New()=%v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return NewService(sqlc.New(db)), mock
+}
+
+func TestEndedKeepsFirstEnd(t *testing.T) {
+	service, mock := newMockService(t)
+
+	// sessions that already ended are not updated
+	endSession := `UPDATE sandbox_sessions\s+SET ended_at = NOW\(\), end_reason = \$2\s+WHERE connection_id = \$1 AND ended_at IS NULL`
+	mock.ExpectExec(endSession).WithArgs("session", EndUserStop).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(endSession).WithArgs("session", EndPodFailure).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// the user stops the session, then the controller sees its pod go away
+	service.Ended("session", EndUserStop)
+	service.Ended("session", EndPodFailure)
+	// sessions without a connection ID, and services without a database, record nothing
+	service.Ended("", EndTimeout)
+	var disabled *Service
+	disabled.Ended("session", EndTimeout)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListByUser(t *testing.T) {
+	service, mock := newMockService(t)
+	userID := uuid.New()
+	owner := uuid.NullUUID{UUID: userID, Valid: true}
+	created := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	ended := created.Add(time.Hour)
+
+	columns := []string{"connection_id", "user_id", "profile", "pod_name", "node_name", "created_at", "connected_at",
+		"ended_at", "end_reason", "extension_count", "disconnection_count", "bytes_transferred"}
+	mock.ExpectQuery(`FROM sandbox_sessions\s+WHERE user_id = \$1\s+ORDER BY created_at DESC\s+LIMIT \$2 OFFSET \$3`).
+		WithArgs(owner, 2, 4).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("running", owner, "browser", "pod-1", "node-1", created, created, nil, nil, 0, 0, 0).
+			AddRow("ended", owner, "office", "pod-2", "node-1", created, nil, ended, EndTimeout, 1, 2, 1024))
+	mock.ExpectQuery("SELECT COUNT").WithArgs(owner).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+
+	sessions, total, err := service.ListByUser(context.Background(), userID, 2, 4)
+	if err != nil {
+		t.Fatalf("ListByUser()=%v", err)
+	}
+	if total != 7 || len(sessions) != 2 {
+		t.Fatalf("ListByUser() returned %d sessions of %d, want 2 of 7", len(sessions), total)
+	}
+	if running := sessions[0]; running.ConnectedAt == nil || running.EndedAt != nil || running.EndReason != "" {
+		t.Errorf("running session=%+v", running)
+	}
+	if stopped := sessions[1]; stopped.ConnectedAt != nil || stopped.EndedAt == nil || !stopped.EndedAt.Equal(ended) ||
+		stopped.EndReason != EndTimeout || stopped.BytesTransferred != 1024 {
+		t.Errorf("ended session=%+v", stopped)
+	}
+
+	mock.ExpectQuery("FROM sandbox_sessions").WillReturnError(sql.ErrConnDone)
+	if _, _, err = service.ListByUser(context.Background(), userID, 20, 0); err == nil {
+		t.Error("ListByUser() succeeded without a database")
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,172 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// BrowserSession API group, see deployments/browsersession-crd.yml
+const (
+	BrowserSessionGroup    = "kubebrowse.io"
+	BrowserSessionVersion  = "v1alpha1"
+	BrowserSessionKind     = "BrowserSession"
+	BrowserSessionResource = "browsersessions"
+
+	// BrowserSessionLabel is set on pods to the name of the BrowserSession owning them
+	BrowserSessionLabel = "browser-session"
+)
+
+// BrowserSessionGVR identifies the BrowserSession resource for the dynamic client
+var BrowserSessionGVR = schema.GroupVersionResource{
+	Group:    BrowserSessionGroup,
+	Version:  BrowserSessionVersion,
+	Resource: BrowserSessionResource,
+}
+
+// Phases of a BrowserSession
+const (
+	BrowserSessionPending    = "Pending"
+	BrowserSessionRunning    = "Running"
+	BrowserSessionFailed     = "Failed"
+	BrowserSessionTerminated = "Terminated"
+)
+
+// BrowserSession is a sandbox session managed by the BrowserSession controller
+type BrowserSession struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BrowserSessionSpec   `json:"spec"`
+	Status BrowserSessionStatus `json:"status,omitempty"`
+}
+
+// BrowserSessionSpec is the desired state of a BrowserSession
+type BrowserSessionSpec struct {
+	// Type is the sandbox profile, e.g. browser or office
+	Type string `json:"type"`
+	// User is the ID of the user the session belongs to, empty without authentication
+	User string `json:"user,omitempty"`
+	// TimeoutSeconds is how long the session may run once it is ready, 0 means no limit
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+	// Resources overrides the default resources of the sandbox container
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Share allows the session to be shared with other users
+	Share bool `json:"share,omitempty"`
+}
+
+// BrowserSessionStatus is the observed state of a BrowserSession
+type BrowserSessionStatus struct {
+	Phase    string       `json:"phase,omitempty"`
+	PodName  string       `json:"podName,omitempty"`
+	PodIP    string       `json:"podIP,omitempty"`
+	FQDN     string       `json:"fqdn,omitempty"`
+	ExpireAt *metav1.Time `json:"expireAt,omitempty"`
+	Message  string       `json:"message,omitempty"`
+}
+
+// NewBrowserSession returns a BrowserSession with the given name and spec
+func NewBrowserSession(namespace, name string, spec BrowserSessionSpec) *BrowserSession {
+	return &BrowserSession{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: BrowserSessionGroup + "/" + BrowserSessionVersion,
+			Kind:       BrowserSessionKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"user":         spec.User,
+				"sandbox-type": spec.Type,
+			},
+		},
+		Spec: spec,
+	}
+}
+
+// OwnerReference returns a reference making an object owned by the session, so that it is
+// garbage collected when the session is deleted
+func (s *BrowserSession) OwnerReference() metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: BrowserSessionGroup + "/" + BrowserSessionVersion,
+		Kind:       BrowserSessionKind,
+		Name:       s.Name,
+		UID:        s.UID,
+	}
+}
+
+// BrowserSessionClient reads and writes BrowserSession resources in a namespace
+type BrowserSessionClient struct {
+	client dynamic.ResourceInterface
+}
+
+// NewBrowserSessionClient creates a BrowserSession client for the given namespace
+func NewBrowserSessionClient(client dynamic.Interface, namespace string) *BrowserSessionClient {
+	return &BrowserSessionClient{
+		client: client.Resource(BrowserSessionGVR).Namespace(namespace),
+	}
+}
+
+// Create creates a new BrowserSession
+func (c *BrowserSessionClient) Create(ctx context.Context, session *BrowserSession) (*BrowserSession, error) {
+	obj, err := toUnstructured(session)
+	if err != nil {
+		return nil, err
+	}
+	obj, err = c.client.Create(ctx, obj, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return fromUnstructured(obj)
+}
+
+// Get returns the BrowserSession with the given name
+func (c *BrowserSessionClient) Get(ctx context.Context, name string) (*BrowserSession, error) {
+	obj, err := c.client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return fromUnstructured(obj)
+}
+
+// UpdateStatus writes the status of a BrowserSession
+func (c *BrowserSessionClient) UpdateStatus(ctx context.Context, session *BrowserSession) (*BrowserSession, error) {
+	obj, err := toUnstructured(session)
+	if err != nil {
+		return nil, err
+	}
+	obj, err = c.client.UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return fromUnstructured(obj)
+}
+
+// Delete deletes a BrowserSession. Its pod is deleted by the garbage collector.
+func (c *BrowserSessionClient) Delete(ctx context.Context, name string) error {
+	propagation := metav1.DeletePropagationBackground
+	return c.client.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+// fromUnstructured converts an object returned by the dynamic client to a BrowserSession
+func fromUnstructured(obj *unstructured.Unstructured) (*BrowserSession, error) {
+	var session BrowserSession
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &session); err != nil {
+		return nil, fmt.Errorf("failed to convert BrowserSession %s: %w", obj.GetName(), err)
+	}
+	return &session, nil
+}
+
+func toUnstructured(session *BrowserSession) (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert BrowserSession %s: %w", session.Name, err)
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
@@ -21,11 +21,12 @@ type PodInfo struct {
 }
 
 // GetBrowserSandboxPods returns all pods in the browser-sandbox namespace
-func GetBrowserSandboxPods(clientset *kubernetes.Clientset, namespace string) ([]PodInfo, error) {
+func GetBrowserSandboxPods(clientset kubernetes.Interface, namespace string) ([]PodInfo, error) {
 	ctx := context.Background()
 
 	// List pods with label selector for browser sandbox pods (both browser and office)
-	labelSelector := "app in (browser-sandbox-test, office-sandbox-test)"
+	// Pods waiting in the warm pool are not sessions and must not be cleaned up
+	labelSelector := "app in (browser-sandbox-test, office-sandbox-test),!pool"
 	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
 		LabelSelector: labelSelector,
 	})
@@ -51,11 +52,13 @@ func GetBrowserSandboxPods(clientset *kubernetes.Clientset, namespace string) ([
 }
 
 // DeletePodGrace deletes a specific pod with a grace period
-func DeletePodGrace(clientset *kubernetes.Clientset, namespace, podName string) error {
+func DeletePodGrace(clientset kubernetes.Interface, namespace, podName string) error {
 	ctx := context.Background()
 
 	logrus.Infof("Deleting pod %s in namespace %s", podName, namespace)
 
+	deleteSandboxResources(clientset, namespace, podName)
+
 	err := clientset.CoreV1().Pods(namespace).Delete(ctx, podName, metav1.DeleteOptions{
 		GracePeriodSeconds: &[]int64{30}[0], // 30 second grace period
 	})
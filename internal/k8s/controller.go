@@ -0,0 +1,443 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/browsersec/KubeBrowse/internal/history"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	sessionReadyTimeout    = 120 * time.Second
+	sessionPollInterval    = 2 * time.Second
+	failedSessionRetention = 10 * time.Minute
+	controllerResync       = 5 * time.Minute
+	controllerWorkers      = 2
+)
+
+// BrowserSessionController reconciles BrowserSession resources into sandbox pods.
+// The session owns its pod, so deleting the session deletes the pod, and a session
+// whose pod goes away is deleted as well.
+type BrowserSessionController struct {
+	clientset kubernetes.Interface
+	sessions  *BrowserSessionClient
+	namespace string
+	warmPool  *WarmPool
+	// sessionHistory records how sessions ended, nil without a database
+	sessionHistory *history.Service
+
+	sessionInformer cache.SharedIndexInformer
+	podInformer     cache.SharedIndexInformer
+	queue           workqueue.TypedRateLimitingInterface[string]
+	stopChan        chan struct{}
+}
+
+// NewBrowserSessionController creates a controller for the BrowserSessions in namespace.
+// It returns an error if the BrowserSession CRD is not installed in the cluster.
+// warmPool may be nil.
+func NewBrowserSessionController(config *rest.Config, clientset kubernetes.Interface, namespace string, warmPool *WarmPool) (*BrowserSessionController, error) {
+	if _, err := clientset.Discovery().ServerResourcesForGroupVersion(BrowserSessionGroup + "/" + BrowserSessionVersion); err != nil {
+		return nil, fmt.Errorf("BrowserSession CRD is not installed: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	return newBrowserSessionController(clientset, dynamicClient, namespace, warmPool)
+}
+
+// newBrowserSessionController creates a controller from existing clients
+func newBrowserSessionController(clientset kubernetes.Interface, dynamicClient dynamic.Interface, namespace string, warmPool *WarmPool) (*BrowserSessionController, error) {
+	c := &BrowserSessionController{
+		clientset: clientset,
+		sessions:  NewBrowserSessionClient(dynamicClient, namespace),
+		namespace: namespace,
+		warmPool:  warmPool,
+		sessionInformer: dynamicinformer.NewFilteredDynamicInformer(dynamicClient, BrowserSessionGVR, namespace,
+			controllerResync, cache.Indexers{}, nil).Informer(),
+		podInformer: coreinformers.NewFilteredPodInformer(clientset, namespace, controllerResync, cache.Indexers{},
+			func(options *metav1.ListOptions) {
+				options.LabelSelector = BrowserSessionLabel
+			}),
+		queue:    workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+		stopChan: make(chan struct{}),
+	}
+
+	_, err := c.sessionInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// pod changes are reconciled on the session owning the pod
+	_, err = c.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueuePodOwner,
+		UpdateFunc: func(_, obj interface{}) { c.enqueuePodOwner(obj) },
+		DeleteFunc: c.enqueuePodOwner,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// SetHistory makes the controller record the sessions whose pod failed or that expired in
+// the session history
+func (c *BrowserSessionController) SetHistory(sessionHistory *history.Service) {
+	c.sessionHistory = sessionHistory
+}
+
+// Start runs the informers and reconcile workers in the background
+func (c *BrowserSessionController) Start() {
+	logrus.Info("Starting BrowserSession controller")
+	go c.sessionInformer.Run(c.stopChan)
+	go c.podInformer.Run(c.stopChan)
+
+	go func() {
+		if !cache.WaitForCacheSync(c.stopChan, c.sessionInformer.HasSynced, c.podInformer.HasSynced) {
+			logrus.Error("Failed to sync BrowserSession controller caches")
+			return
+		}
+		for i := 0; i < controllerWorkers; i++ {
+			go c.runWorker()
+		}
+	}()
+}
+
+// Stop stops the controller. Sessions and their pods are left in place.
+func (c *BrowserSessionController) Stop() {
+	logrus.Info("Stopping BrowserSession controller")
+	close(c.stopChan)
+	c.queue.ShutDown()
+}
+
+// Provision creates a BrowserSession named name and waits until its pod accepts RDP
+// connections. The session is deleted if it does not become ready.
+func (c *BrowserSessionController) Provision(ctx context.Context, name string, spec BrowserSessionSpec) (*BrowserSession, error) {
+	session, err := c.sessions.Create(ctx, NewBrowserSession(c.namespace, name, spec))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BrowserSession: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sessionReadyTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := c.Delete(context.Background(), name); err != nil && !errors.IsNotFound(err) {
+				logrus.Errorf("Failed to delete BrowserSession %s: %v", name, err)
+			}
+			return nil, fmt.Errorf("BrowserSession %s not ready after %v", name, sessionReadyTimeout)
+		case <-ticker.C:
+		}
+
+		if latest, ok := c.get(name); ok {
+			session = latest
+		}
+		switch session.Status.Phase {
+		case BrowserSessionRunning:
+			return session, nil
+		case BrowserSessionFailed, BrowserSessionTerminated:
+			return nil, fmt.Errorf("BrowserSession %s %s: %s", name, session.Status.Phase, session.Status.Message)
+		}
+	}
+}
+
+// Extend moves the expiry of a running session
+func (c *BrowserSessionController) Extend(ctx context.Context, name string, expireAt time.Time) error {
+	session, err := c.sessions.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	t := metav1.NewTime(expireAt)
+	session.Status.ExpireAt = &t
+	_, err = c.sessions.UpdateStatus(ctx, session)
+	return err
+}
+
+// Delete ends a session, its pod is deleted by the garbage collector
+func (c *BrowserSessionController) Delete(ctx context.Context, name string) error {
+	logrus.Infof("Deleting BrowserSession %s", name)
+	return c.sessions.Delete(ctx, name)
+}
+
+func (c *BrowserSessionController) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		logrus.Errorf("Failed to get key of BrowserSession: %v", err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *BrowserSessionController) enqueuePodOwner(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	if name := pod.Labels[BrowserSessionLabel]; name != "" {
+		c.queue.Add(pod.Namespace + "/" + name)
+	}
+}
+
+func (c *BrowserSessionController) runWorker() {
+	for c.processNextItem() {
+	}
+}
+
+func (c *BrowserSessionController) processNextItem() bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	requeueAfter, err := c.reconcile(key)
+	if err != nil {
+		logrus.Errorf("Failed to reconcile BrowserSession %s: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	if requeueAfter > 0 {
+		c.queue.AddAfter(key, requeueAfter)
+	}
+	return true
+}
+
+// reconcile moves a session towards its desired state and returns when it should be looked at again
+func (c *BrowserSessionController) reconcile(key string) (time.Duration, error) {
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	session, ok := c.get(name)
+	if !ok || session.DeletionTimestamp != nil {
+		return 0, nil
+	}
+
+	pod, err := c.sessionPod(session)
+	if err != nil {
+		return 0, err
+	}
+
+	switch session.Status.Phase {
+	case "", BrowserSessionPending:
+		return c.reconcilePending(session, pod)
+	case BrowserSessionRunning:
+		return c.reconcileRunning(session, pod)
+	case BrowserSessionFailed:
+		// failed sessions are kept for a while so the reason can be inspected
+		if remaining := time.Until(session.CreationTimestamp.Add(failedSessionRetention)); remaining > 0 {
+			return remaining, nil
+		}
+		return 0, c.deleteSession(session)
+	default:
+		return 0, c.deleteSession(session)
+	}
+}
+
+func (c *BrowserSessionController) reconcilePending(session *BrowserSession, pod *corev1.Pod) (time.Duration, error) {
+	ctx := context.Background()
+
+	if pod == nil {
+		if session.Status.PodName != "" {
+			return c.fail(session, nil, "sandbox pod was deleted before it became ready")
+		}
+
+		pod, err := c.createPod(session)
+		if err != nil {
+			return c.fail(session, nil, fmt.Sprintf("failed to create sandbox pod: %v", err))
+		}
+
+		session.Status.Phase = BrowserSessionPending
+		session.Status.PodName = pod.Name
+		session.Status.FQDN = c.fqdn(pod.Name)
+		if _, err = c.sessions.UpdateStatus(ctx, session); err != nil {
+			return 0, err
+		}
+		logrus.Infof("Created pod %s for BrowserSession %s", pod.Name, session.Name)
+		return sessionPollInterval, nil
+	}
+
+	if err := podFailure(pod); err != nil {
+		observePodReady(session.CreationTimestamp.Time, "pod_failed")
+		return c.fail(session, pod, err.Error())
+	}
+
+	profile, ok := GetSandboxProfile(session.Spec.Type)
+	if !ok {
+		return c.fail(session, pod, fmt.Sprintf("unknown sandbox profile %s", session.Spec.Type))
+	}
+
+	fqdn := c.fqdn(pod.Name)
+	if !isPodReady(pod) || checkPort(fqdn, profile.Port()) != nil {
+		if time.Since(session.CreationTimestamp.Time) > sessionReadyTimeout {
+			observePodReady(session.CreationTimestamp.Time, "timeout")
+			return c.fail(session, pod, fmt.Sprintf("pod not ready or port %d not open after %v", profile.Port(), sessionReadyTimeout))
+		}
+		return sessionPollInterval, nil
+	}
+
+	session.Status.Phase = BrowserSessionRunning
+	session.Status.PodName = pod.Name
+	session.Status.PodIP = pod.Status.PodIP
+	session.Status.FQDN = fqdn
+	session.Status.Message = ""
+	if session.Spec.TimeoutSeconds > 0 {
+		expireAt := metav1.NewTime(time.Now().Add(time.Duration(session.Spec.TimeoutSeconds) * time.Second))
+		session.Status.ExpireAt = &expireAt
+	}
+	if _, err := c.sessions.UpdateStatus(ctx, session); err != nil {
+		return 0, err
+	}
+	observePodReady(session.CreationTimestamp.Time, "")
+	logrus.Infof("BrowserSession %s is running on pod %s", session.Name, pod.Name)
+	return c.untilExpiry(session), nil
+}
+
+func (c *BrowserSessionController) reconcileRunning(session *BrowserSession, pod *corev1.Pod) (time.Duration, error) {
+	if pod == nil || pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		logrus.Infof("Pod of BrowserSession %s is gone, ending the session", session.Name)
+		c.sessionHistory.Ended(session.Name, history.EndPodFailure)
+		return 0, c.deleteSession(session)
+	}
+
+	if session.Status.ExpireAt != nil && time.Now().After(session.Status.ExpireAt.Time) {
+		logrus.Infof("BrowserSession %s expired", session.Name)
+		c.sessionHistory.Ended(session.Name, history.EndTimeout)
+		return 0, c.deleteSession(session)
+	}
+
+	return c.untilExpiry(session), nil
+}
+
+// fail marks the session as failed and deletes its pod
+func (c *BrowserSessionController) fail(session *BrowserSession, pod *corev1.Pod, message string) (time.Duration, error) {
+	logrus.Errorf("BrowserSession %s failed: %s", session.Name, message)
+
+	if pod != nil {
+		if err := DeletePodGrace(c.clientset, c.namespace, pod.Name); err != nil {
+			logrus.Errorf("Failed to delete pod %s of failed BrowserSession %s: %v", pod.Name, session.Name, err)
+		}
+	}
+
+	session.Status.Phase = BrowserSessionFailed
+	session.Status.Message = message
+	if _, err := c.sessions.UpdateStatus(context.Background(), session); err != nil {
+		return 0, err
+	}
+	return failedSessionRetention, nil
+}
+
+func (c *BrowserSessionController) deleteSession(session *BrowserSession) error {
+	err := c.Delete(context.Background(), session.Name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// createPod takes a pod from the warm pool or creates one owned by the session.
+// Pool pods cannot be resized, so sessions with custom resources always get a new pod.
+func (c *BrowserSessionController) createPod(session *BrowserSession) (*corev1.Pod, error) {
+	if session.Spec.Resources == nil {
+		if pod, ok := c.warmPool.AcquireForSession(session); ok {
+			return pod, nil
+		}
+	}
+
+	// the pod is named after the session, its user may be empty and is only a label
+	name := session.Name
+	if len(name) > 8 {
+		name = name[:8]
+	}
+	return CreateSandboxPod(c.clientset, c.namespace, session.Spec.Type, session.Spec.Type+"-"+name, SandboxPodOptions{
+		Labels:          map[string]string{BrowserSessionLabel: session.Name, "user": session.Spec.User},
+		Annotations:     map[string]string{ConnectionIDAnnotation: session.Name},
+		Resources:       session.Spec.Resources,
+		OwnerReferences: []metav1.OwnerReference{session.OwnerReference()},
+	})
+}
+
+// sessionPod returns the pod of a session, or nil if it has none
+func (c *BrowserSessionController) sessionPod(session *BrowserSession) (*corev1.Pod, error) {
+	if session.Status.PodName != "" {
+		obj, exists, err := c.podInformer.GetStore().GetByKey(c.namespace + "/" + session.Status.PodName)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return obj.(*corev1.Pod), nil
+		}
+
+		// the informer may not have seen a pod that was just created
+		pod, err := c.clientset.CoreV1().Pods(c.namespace).Get(context.Background(), session.Status.PodName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return pod, err
+	}
+
+	// adopt a pod created before the status could be written
+	for _, obj := range c.podInformer.GetStore().List() {
+		pod := obj.(*corev1.Pod)
+		if pod.Labels[BrowserSessionLabel] == session.Name {
+			return pod, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *BrowserSessionController) get(name string) (*BrowserSession, bool) {
+	obj, exists, err := c.sessionInformer.GetStore().GetByKey(c.namespace + "/" + name)
+	if err != nil || !exists {
+		return nil, false
+	}
+	session, err := fromUnstructured(obj.(*unstructured.Unstructured))
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return nil, false
+	}
+	return session, true
+}
+
+func (c *BrowserSessionController) fqdn(podName string) string {
+	return fmt.Sprintf("%s.sandbox-instances.%s.svc.cluster.local", podName, c.namespace)
+}
+
+func (c *BrowserSessionController) untilExpiry(session *BrowserSession) time.Duration {
+	if session.Status.ExpireAt == nil {
+		return 0
+	}
+	if d := time.Until(session.Status.ExpireAt.Time); d > 0 {
+		return d
+	}
+	return time.Second
+}
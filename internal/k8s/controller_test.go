@@ -0,0 +1,168 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testSessionName = "0b7c3a52-5d1e-4f0a-9c3e-2f6d8a1b4c7e"
+
+// newTestController returns a controller whose informers are not running, the tests put the
+// session and pods they reconcile in the informer stores themselves
+func newTestController(t *testing.T, session *BrowserSession, pods ...*corev1.Pod) (*BrowserSessionController, *fake.Clientset) {
+	t.Helper()
+
+	obj, err := toUnstructured(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{BrowserSessionGVR: BrowserSessionKind + "List"}, obj)
+
+	clientset := newSandboxClientset()
+	c, err := newBrowserSessionController(clientset, dynamicClient, testNamespace, nil)
+	if err != nil {
+		t.Fatalf("newBrowserSessionController()=%v", err)
+	}
+	if err = c.sessionInformer.GetStore().Add(obj); err != nil {
+		t.Fatal(err)
+	}
+	for _, pod := range pods {
+		if _, err = clientset.CoreV1().Pods(testNamespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.podInformer.GetStore().Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c, clientset
+}
+
+// newTestSession returns a session of the browser profile created age ago
+func newTestSession(phase string, age time.Duration) *BrowserSession {
+	session := NewBrowserSession(testNamespace, testSessionName, BrowserSessionSpec{
+		Type: SandboxTypeBrowser,
+		User: "6f1e2d3c-4b5a-4978-8a6b-5c4d3e2f1a0b",
+	})
+	session.UID = "uid-session"
+	session.CreationTimestamp = metav1.NewTime(time.Now().Add(-age))
+	session.Status.Phase = phase
+	return session
+}
+
+// newSessionPod returns a pod of the test session in the given phase
+func newSessionPod(phase corev1.PodPhase) *corev1.Pod {
+	pod := newPoolPod("browser-sandbox-session", "", phase)
+	delete(pod.Labels, PoolLabel)
+	pod.Labels[BrowserSessionLabel] = testSessionName
+	return pod
+}
+
+func TestReconcilePendingCreatesPod(t *testing.T) {
+	session := newTestSession("", 0)
+	c, clientset := newTestController(t, session)
+
+	requeue, err := c.reconcile(testNamespace + "/" + testSessionName)
+	if err != nil {
+		t.Fatalf("reconcile()=%v", err)
+	}
+	if requeue != sessionPollInterval {
+		t.Errorf("requeue after %v, want %v", requeue, sessionPollInterval)
+	}
+
+	updated, err := c.sessions.Get(context.Background(), testSessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status.Phase != BrowserSessionPending || updated.Status.PodName == "" {
+		t.Fatalf("status=%+v, want a pending session with a pod", updated.Status)
+	}
+
+	pod, err := clientset.CoreV1().Pods(testNamespace).Get(context.Background(), updated.Status.PodName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("pod of the session not created: %v", err)
+	}
+	// the pod is named after the session, the user is only a label
+	if !strings.HasPrefix(pod.Name, "browser-sandbox-browser-"+testSessionName[:8]+"-") {
+		t.Errorf("pod name=%s", pod.Name)
+	}
+	if pod.Labels["user"] != session.Spec.User || pod.Labels[BrowserSessionLabel] != testSessionName {
+		t.Errorf("pod labels=%v", pod.Labels)
+	}
+	if pod.Annotations[ConnectionIDAnnotation] != testSessionName {
+		t.Errorf("pod annotations=%v", pod.Annotations)
+	}
+	if len(pod.OwnerReferences) != 1 || pod.OwnerReferences[0] != session.OwnerReference() {
+		t.Errorf("pod owner references=%v, want the session", pod.OwnerReferences)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	past := metav1.NewTime(time.Now().Add(-time.Minute))
+	future := metav1.NewTime(time.Now().Add(time.Hour))
+
+	for _, test := range []struct {
+		name     string
+		phase    string
+		age      time.Duration
+		expireAt *metav1.Time
+		pod      *corev1.Pod
+		// the phase of the session afterwards, empty if it was deleted
+		want       string
+		podDeleted bool
+	}{
+		{"pending pod failed", BrowserSessionPending, 0, nil, newSessionPod(corev1.PodFailed), BrowserSessionFailed, true},
+		{"pending pod deleted", BrowserSessionPending, 0, nil, nil, BrowserSessionFailed, false},
+		{"pending not ready in time", BrowserSessionPending, 2 * sessionReadyTimeout, nil, newSessionPod(corev1.PodPending), BrowserSessionFailed, true},
+		{"running", BrowserSessionRunning, time.Hour, &future, newSessionPod(corev1.PodRunning), BrowserSessionRunning, false},
+		{"running pod gone", BrowserSessionRunning, time.Hour, &future, nil, "", false},
+		{"running pod failed", BrowserSessionRunning, time.Hour, &future, newSessionPod(corev1.PodFailed), "", false},
+		{"expired", BrowserSessionRunning, time.Hour, &past, newSessionPod(corev1.PodRunning), "", false},
+		{"failed is kept", BrowserSessionFailed, time.Minute, nil, nil, BrowserSessionFailed, false},
+		{"failed is deleted later", BrowserSessionFailed, 2 * failedSessionRetention, nil, nil, "", false},
+		{"terminated", BrowserSessionTerminated, 0, nil, nil, "", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			session := newTestSession(test.phase, test.age)
+			session.Status.PodName = "browser-sandbox-session"
+			session.Status.ExpireAt = test.expireAt
+
+			var pods []*corev1.Pod
+			if test.pod != nil {
+				pods = append(pods, test.pod)
+			}
+			c, clientset := newTestController(t, session, pods...)
+
+			if _, err := c.reconcile(testNamespace + "/" + testSessionName); err != nil {
+				t.Fatalf("reconcile()=%v", err)
+			}
+
+			updated, err := c.sessions.Get(context.Background(), testSessionName)
+			switch {
+			case test.want == "" && !errors.IsNotFound(err):
+				t.Errorf("session was not deleted: %v", err)
+			case test.want != "" && err != nil:
+				t.Errorf("session was deleted: %v", err)
+			case test.want != "" && updated.Status.Phase != test.want:
+				t.Errorf("phase=%s (%s), want %s", updated.Status.Phase, updated.Status.Message, test.want)
+			}
+
+			if test.pod != nil {
+				_, err = clientset.CoreV1().Pods(testNamespace).Get(context.Background(), test.pod.Name, metav1.GetOptions{})
+				if deleted := errors.IsNotFound(err); deleted != test.podDeleted {
+					t.Errorf("pod deleted=%v, want %v", deleted, test.podDeleted)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package k8s
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Environment variables the sandbox images read their login from
+const (
+	RDPUsernameEnv = "RDP_USERNAME"
+	RDPPasswordEnv = "RDP_PASSWORD"
+
+	credentialsUsernameKey = "username"
+	credentialsPasswordKey = "password"
+)
+
+// SandboxCredentials is the login of a single sandbox pod
+type SandboxCredentials struct {
+	Username string
+	Password string
+}
+
+// CredentialsSecretName returns the name of the Secret holding the login of a pod
+func CredentialsSecretName(podName string) string {
+	return podName + "-credentials"
+}
+
+func generateSandboxCredentials() (SandboxCredentials, error) {
+	user := make([]byte, 4)
+	password := make([]byte, 24)
+	if _, err := rand.Read(user); err != nil {
+		return SandboxCredentials{}, err
+	}
+	if _, err := rand.Read(password); err != nil {
+		return SandboxCredentials{}, err
+	}
+	return SandboxCredentials{
+		Username: "user" + hex.EncodeToString(user),
+		Password: hex.EncodeToString(password),
+	}, nil
+}
+
+// createCredentialsSecret generates a login for the pod, stores it in a Secret and
+// makes the sandbox container read it from its environment
+func createCredentialsSecret(clientset kubernetes.Interface, namespace string, pod *corev1.Pod) error {
+	credentials, err := generateSandboxCredentials()
+	if err != nil {
+		return fmt.Errorf("failed to generate sandbox credentials: %w", err)
+	}
+
+	secretName := CredentialsSecretName(pod.Name)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: secretName,
+			Labels: map[string]string{
+				"app":         "browser-sandbox-test",
+				"sandbox-pod": pod.Name,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		StringData: map[string]string{
+			credentialsUsernameKey: credentials.Username,
+			credentialsPasswordKey: credentials.Password,
+		},
+	}
+	if _, err = clientset.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create credentials secret: %w", err)
+	}
+
+	container := &pod.Spec.Containers[0]
+	container.Env = append(container.Env,
+		secretEnvVar(RDPUsernameEnv, secretName, credentialsUsernameKey),
+		secretEnvVar(RDPPasswordEnv, secretName, credentialsPasswordKey),
+	)
+	return nil
+}
+
+// GetSandboxCredentials returns the login of a sandbox pod
+func GetSandboxCredentials(clientset kubernetes.Interface, namespace, podName string) (SandboxCredentials, error) {
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), CredentialsSecretName(podName), metav1.GetOptions{})
+	if err != nil {
+		return SandboxCredentials{}, fmt.Errorf("failed to get credentials of pod %s: %w", podName, err)
+	}
+	return SandboxCredentials{
+		Username: string(secret.Data[credentialsUsernameKey]),
+		Password: string(secret.Data[credentialsPasswordKey]),
+	}, nil
+}
+
+// DeleteCredentialsSecret deletes the login of a sandbox pod
+func DeleteCredentialsSecret(clientset kubernetes.Interface, namespace, podName string) error {
+	err := clientset.CoreV1().Secrets(namespace).Delete(context.Background(), CredentialsSecretName(podName), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting credentials secret of pod %s: %v", podName, err)
+	}
+	return nil
+}
+
+func secretEnvVar(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  key,
+			},
+		},
+	}
+}
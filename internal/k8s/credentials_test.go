@@ -0,0 +1,113 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newSandboxClientset returns a fake clientset that, like the API server, stores the
+// StringData of Secrets as Data and gives pods a UID
+func newSandboxClientset() *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		secret := action.(k8stesting.CreateAction).GetObject().(*corev1.Secret)
+		secret.Data = map[string][]byte{}
+		for key, value := range secret.StringData {
+			secret.Data[key] = []byte(value)
+		}
+		secret.StringData = nil
+		return false, nil, nil
+	})
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+		pod.UID = types.UID("uid-" + pod.Name)
+		return false, nil, nil
+	})
+	return clientset
+}
+
+func TestCreateSandboxPodCredentials(t *testing.T) {
+	clientset := newSandboxClientset()
+	pod, err := CreateSandboxPod(clientset, testNamespace, SandboxTypeBrowser, "user", SandboxPodOptions{})
+	if err != nil {
+		t.Fatalf("CreateSandboxPod()=%v", err)
+	}
+
+	secret, err := clientset.CoreV1().Secrets(testNamespace).Get(context.Background(), CredentialsSecretName(pod.Name), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("credentials secret not created: %v", err)
+	}
+	if secret.Labels["sandbox-pod"] != pod.Name {
+		t.Errorf("secret labels=%v", secret.Labels)
+	}
+	want := metav1.OwnerReference{APIVersion: "v1", Kind: "Pod", Name: pod.Name, UID: pod.UID}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0] != want {
+		t.Errorf("secret owner references=%v, want %v", secret.OwnerReferences, want)
+	}
+
+	credentials, err := GetSandboxCredentials(clientset, testNamespace, pod.Name)
+	if err != nil {
+		t.Fatalf("GetSandboxCredentials()=%v", err)
+	}
+	if len(credentials.Username) != len("user")+8 || len(credentials.Password) != 48 {
+		t.Errorf("credentials=%+v, want a generated username and a 24 byte password", credentials)
+	}
+
+	// the container reads the login from the Secret, it is never in the pod spec itself
+	env := map[string]corev1.EnvVar{}
+	for _, v := range pod.Spec.Containers[0].Env {
+		env[v.Name] = v
+	}
+	for name, key := range map[string]string{RDPUsernameEnv: credentialsUsernameKey, RDPPasswordEnv: credentialsPasswordKey} {
+		v, ok := env[name]
+		if !ok || v.Value != "" || v.ValueFrom == nil || v.ValueFrom.SecretKeyRef == nil {
+			t.Errorf("%s=%+v, want a reference to the credentials secret", name, v)
+			continue
+		}
+		if ref := v.ValueFrom.SecretKeyRef; ref.Name != secret.Name || ref.Key != key {
+			t.Errorf("%s refers to %s/%s, want %s/%s", name, ref.Name, ref.Key, secret.Name, key)
+		}
+	}
+
+	// every pod gets its own login
+	other, err := CreateSandboxPod(clientset, testNamespace, SandboxTypeBrowser, "other", SandboxPodOptions{})
+	if err != nil {
+		t.Fatalf("CreateSandboxPod()=%v", err)
+	}
+	if otherCredentials, _ := GetSandboxCredentials(clientset, testNamespace, other.Name); otherCredentials == credentials {
+		t.Error("two pods got the same credentials")
+	}
+
+	if err = DeletePodGrace(clientset, testNamespace, pod.Name); err != nil {
+		t.Fatalf("DeletePodGrace()=%v", err)
+	}
+	if _, err = GetSandboxCredentials(clientset, testNamespace, pod.Name); err == nil {
+		t.Error("credentials secret was not deleted with its pod")
+	}
+}
+
+func TestCreateSandboxPodFailureDeletesCredentials(t *testing.T) {
+	clientset := newSandboxClientset()
+	clientset.PrependReactor("create", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("quota exceeded")
+	})
+
+	if _, err := CreateSandboxPod(clientset, testNamespace, SandboxTypeBrowser, "user", SandboxPodOptions{}); err == nil {
+		t.Fatal("CreateSandboxPod() succeeded")
+	}
+	secrets, err := clientset.CoreV1().Secrets(testNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Items) != 0 {
+		t.Errorf("credentials of the pod that was not created were kept: %v", secrets.Items)
+	}
+}
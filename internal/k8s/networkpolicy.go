@@ -0,0 +1,273 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// Egress modes of a sandbox NetworkPolicy
+const (
+	// EgressInternetOnly allows public addresses but nothing inside the cluster or private networks
+	EgressInternetOnly = "internet-only"
+	// EgressAllowlist allows only the configured CIDRs
+	EgressAllowlist = "allowlist"
+	// EgressNone blocks all egress, including DNS
+	EgressNone = "none"
+)
+
+// uploadPort is where the API pushes uploaded files into a sandbox
+const uploadPort = 8080
+
+// defaultEgressProxyPort is used when EGRESS_PROXY_URL has no port
+const defaultEgressProxyPort = 3128
+
+// privateCIDRs are excluded from internet-only egress
+var privateCIDRs = map[string][]string{
+	"0.0.0.0/0": {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "169.254.0.0/16"},
+	"::/0":      {"fc00::/7", "fe80::/10"},
+}
+
+// EgressPolicy configures what a sandbox may connect to
+type EgressPolicy struct {
+	Mode  string   `json:"mode"`
+	CIDRs []string `json:"cidrs,omitempty"`
+}
+
+func (e *EgressPolicy) validate() error {
+	switch e.Mode {
+	case EgressInternetOnly, EgressNone:
+	case EgressAllowlist:
+		for _, cidr := range e.CIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid egress CIDR %q: %w", cidr, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown egress mode %q", e.Mode)
+	}
+	return nil
+}
+
+// Allows reports whether the policy lets a sandbox connect to ip
+func (e *EgressPolicy) Allows(ip net.IP) bool {
+	switch e.Mode {
+	case EgressInternetOnly:
+		if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() {
+			return false
+		}
+		for _, except := range privateCIDRs {
+			for _, cidr := range except {
+				if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+					return false
+				}
+			}
+		}
+		return true
+	case EgressAllowlist:
+		for _, cidr := range e.CIDRs {
+			if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// DefaultEgressPolicy returns the egress policy of profiles without one, read from
+// SANDBOX_EGRESS_MODE and, for the allowlist mode, the comma separated SANDBOX_EGRESS_CIDRS
+func DefaultEgressPolicy() EgressPolicy {
+	policy := EgressPolicy{Mode: EgressInternetOnly}
+	if mode := os.Getenv("SANDBOX_EGRESS_MODE"); mode != "" {
+		policy.Mode = mode
+	}
+	for _, cidr := range strings.Split(os.Getenv("SANDBOX_EGRESS_CIDRS"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			policy.CIDRs = append(policy.CIDRs, cidr)
+		}
+	}
+
+	if err := policy.validate(); err != nil {
+		logrus.Errorf("Invalid default sandbox egress policy, blocking all egress: %v", err)
+		return EgressPolicy{Mode: EgressNone}
+	}
+	return policy
+}
+
+// EgressProfilePolicy returns the egress policy of a profile
+func EgressProfilePolicy(profile *SandboxProfile) EgressPolicy {
+	if profile.Egress != nil {
+		return *profile.Egress
+	}
+	return DefaultEgressPolicy()
+}
+
+// EgressProxyURL returns the URL sandboxes with URL filtering reach the egress proxy at,
+// or an empty string if no proxy is configured
+func EgressProxyURL() string {
+	return os.Getenv("EGRESS_PROXY_URL")
+}
+
+// egressProxyEnv points the usual proxy variables of the sandbox at the egress proxy
+func egressProxyEnv(proxyURL string) []corev1.EnvVar {
+	var env []corev1.EnvVar
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		env = append(env, corev1.EnvVar{Name: name, Value: proxyURL})
+	}
+	return append(env,
+		corev1.EnvVar{Name: "NO_PROXY", Value: "localhost,127.0.0.1"},
+		corev1.EnvVar{Name: "no_proxy", Value: "localhost,127.0.0.1"},
+	)
+}
+
+func egressProxyPort(proxyURL string) int {
+	parsed, err := url.Parse(proxyURL)
+	if err != nil || parsed.Port() == "" {
+		return defaultEgressProxyPort
+	}
+	port, err := strconv.Atoi(parsed.Port())
+	if err != nil {
+		return defaultEgressProxyPort
+	}
+	return port
+}
+
+// NetworkPolicyName returns the name of the NetworkPolicy isolating a pod
+func NetworkPolicyName(podName string) string {
+	return podName + "-isolation"
+}
+
+// createNetworkPolicy isolates the pod before it is created. Only guacd and the API may
+// connect to it, and egress follows the policy of the profile. Sandboxes with URL filtering
+// may only reach the egress proxy, which enforces the egress policy instead.
+func createNetworkPolicy(clientset kubernetes.Interface, namespace string, pod *corev1.Pod, profile *SandboxProfile) error {
+	proxyPort := 0
+	if proxyURL := EgressProxyURL(); profile.URLFiltering && proxyURL != "" {
+		proxyPort = egressProxyPort(proxyURL)
+	}
+
+	policy := newSandboxNetworkPolicy(pod, EgressProfilePolicy(profile), proxyPort)
+	if _, err := clientset.NetworkingV1().NetworkPolicies(namespace).Create(context.Background(), policy, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create network policy: %w", err)
+	}
+	return nil
+}
+
+func newSandboxNetworkPolicy(pod *corev1.Pod, egress EgressPolicy, proxyPort int) *networkingv1.NetworkPolicy {
+	protocolPorts := make([]networkingv1.NetworkPolicyPort, 0, len(pod.Spec.Containers[0].Ports))
+	for _, port := range pod.Spec.Containers[0].Ports {
+		protocolPorts = append(protocolPorts, tcpPort(int(port.ContainerPort)))
+	}
+
+	guacd := networkingv1.NetworkPolicyPeer{
+		PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "guacd"}},
+	}
+	api := networkingv1.NetworkPolicyPeer{
+		PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "browser-sandbox-api"}},
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: NetworkPolicyName(pod.Name),
+			Labels: map[string]string{
+				"app":         "browser-sandbox-test",
+				"sandbox-pod": pod.Name,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"session-id": pod.Labels["session-id"]},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					// the API also dials the protocol port to check that the sandbox is ready
+					From:  []networkingv1.NetworkPolicyPeer{guacd, api},
+					Ports: protocolPorts,
+				},
+				{
+					From:  []networkingv1.NetworkPolicyPeer{api},
+					Ports: []networkingv1.NetworkPolicyPort{tcpPort(uploadPort)},
+				},
+			},
+		},
+	}
+
+	switch {
+	case egress.Mode == EgressNone:
+		// no egress rules, so nothing is allowed
+	case proxyPort != 0:
+		policy.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{dnsEgressRule(), {
+			To:    []networkingv1.NetworkPolicyPeer{api},
+			Ports: []networkingv1.NetworkPolicyPort{tcpPort(proxyPort)},
+		}}
+	case egress.Mode == EgressInternetOnly:
+		var peers []networkingv1.NetworkPolicyPeer
+		for cidr, except := range privateCIDRs {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: cidr, Except: except},
+			})
+		}
+		policy.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{dnsEgressRule(), {To: peers}}
+	case egress.Mode == EgressAllowlist:
+		peers := make([]networkingv1.NetworkPolicyPeer, 0, len(egress.CIDRs))
+		for _, cidr := range egress.CIDRs {
+			peers = append(peers, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: cidr},
+			})
+		}
+		policy.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{dnsEgressRule()}
+		if len(peers) > 0 {
+			policy.Spec.Egress = append(policy.Spec.Egress, networkingv1.NetworkPolicyEgressRule{To: peers})
+		}
+	}
+
+	return policy
+}
+
+// dnsEgressRule allows name resolution through the cluster DNS
+func dnsEgressRule() networkingv1.NetworkPolicyEgressRule {
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	dnsPort := intstr.FromInt32(53)
+	return networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{{
+			NamespaceSelector: &metav1.LabelSelector{},
+			PodSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"k8s-app": "kube-dns"}},
+		}},
+		Ports: []networkingv1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &dnsPort},
+			{Protocol: &tcp, Port: &dnsPort},
+		},
+	}
+}
+
+func tcpPort(port int) networkingv1.NetworkPolicyPort {
+	return networkingv1.NetworkPolicyPort{
+		Protocol: ptr.To(corev1.ProtocolTCP),
+		Port:     ptr.To(intstr.FromInt32(int32(port))),
+	}
+}
+
+// DeleteNetworkPolicy deletes the NetworkPolicy isolating a pod
+func DeleteNetworkPolicy(clientset kubernetes.Interface, namespace, podName string) error {
+	err := clientset.NetworkingV1().NetworkPolicies(namespace).Delete(context.Background(), NetworkPolicyName(podName), metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting network policy of pod %s: %v", podName, err)
+	}
+	return nil
+}
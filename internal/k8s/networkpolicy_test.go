@@ -0,0 +1,90 @@
+package k8s
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// describePeers summarizes the peers and ports of a NetworkPolicy rule
+func describePeers(peers []networkingv1.NetworkPolicyPeer, ports []networkingv1.NetworkPolicyPort) string {
+	var names []string
+	for _, peer := range peers {
+		switch {
+		case peer.IPBlock != nil && len(peer.IPBlock.Except) > 0:
+			names = append(names, peer.IPBlock.CIDR+" except "+strings.Join(peer.IPBlock.Except, ","))
+		case peer.IPBlock != nil:
+			names = append(names, peer.IPBlock.CIDR)
+		case peer.PodSelector != nil && peer.PodSelector.MatchLabels["k8s-app"] != "":
+			names = append(names, peer.PodSelector.MatchLabels["k8s-app"])
+		case peer.PodSelector != nil:
+			names = append(names, peer.PodSelector.MatchLabels["app"])
+		}
+	}
+	sort.Strings(names)
+
+	var numbers []string
+	for _, port := range ports {
+		numbers = append(numbers, fmt.Sprintf("%s/%s", *port.Protocol, port.Port.String()))
+	}
+	return strings.Join(names, " ") + " on " + strings.Join(numbers, ",")
+}
+
+func TestNewSandboxNetworkPolicy(t *testing.T) {
+	profile, _ := GetSandboxProfile(SandboxTypeBrowser)
+	pod := newSandboxPod(profile, "user")
+	dns := "kube-dns on UDP/53,TCP/53"
+
+	for _, test := range []struct {
+		name      string
+		egress    EgressPolicy
+		proxyPort int
+		want      []string
+	}{
+		{"none", EgressPolicy{Mode: EgressNone}, 0, nil},
+		{"internet only", EgressPolicy{Mode: EgressInternetOnly}, 0, []string{
+			dns,
+			"0.0.0.0/0 except 10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,100.64.0.0/10,169.254.0.0/16 ::/0 except fc00::/7,fe80::/10 on ",
+		}},
+		{"allowlist", EgressPolicy{Mode: EgressAllowlist, CIDRs: []string{"203.0.113.0/24", "198.51.100.7/32"}}, 0, []string{
+			dns,
+			"198.51.100.7/32 203.0.113.0/24 on ",
+		}},
+		{"empty allowlist", EgressPolicy{Mode: EgressAllowlist}, 0, []string{dns}},
+		{"proxy", EgressPolicy{Mode: EgressInternetOnly}, 3128, []string{dns, "browser-sandbox-api on TCP/3128"}},
+		{"proxy with allowlist", EgressPolicy{Mode: EgressAllowlist, CIDRs: []string{"203.0.113.0/24"}}, 8080, []string{dns, "browser-sandbox-api on TCP/8080"}},
+		{"proxy without egress", EgressPolicy{Mode: EgressNone}, 3128, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			policy := newSandboxNetworkPolicy(pod, test.egress, test.proxyPort)
+
+			if policy.Name != NetworkPolicyName(pod.Name) || policy.Spec.PodSelector.MatchLabels["session-id"] != pod.Labels["session-id"] {
+				t.Errorf("policy %s selects %v, want the pod %s", policy.Name, policy.Spec.PodSelector.MatchLabels, pod.Name)
+			}
+			// without an egress policy type, pods without egress rules could connect anywhere
+			if len(policy.Spec.PolicyTypes) != 2 {
+				t.Errorf("policy types=%v, want ingress and egress", policy.Spec.PolicyTypes)
+			}
+
+			var ingress []string
+			for _, rule := range policy.Spec.Ingress {
+				ingress = append(ingress, describePeers(rule.From, rule.Ports))
+			}
+			wantIngress := []string{"browser-sandbox-api guacd on TCP/3389", "browser-sandbox-api on TCP/8080"}
+			if strings.Join(ingress, "; ") != strings.Join(wantIngress, "; ") {
+				t.Errorf("ingress=%q, want %q", ingress, wantIngress)
+			}
+
+			var egress []string
+			for _, rule := range policy.Spec.Egress {
+				egress = append(egress, describePeers(rule.To, rule.Ports))
+			}
+			if strings.Join(egress, "; ") != strings.Join(test.want, "; ") {
+				t.Errorf("egress=%q, want %q", egress, test.want)
+			}
+		})
+	}
+}
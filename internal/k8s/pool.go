@@ -0,0 +1,345 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Labels used to track pool pods. A pool pod is labelled "pool=warming" until RDP
+// is reachable, then "pool=warm". The label is removed when the pod is handed out,
+// after which it is an ordinary session pod.
+const (
+	PoolLabel          = "pool"
+	PoolStateWarming   = "warming"
+	PoolStateWarm      = "warm"
+	SandboxTypeLabel   = "sandbox-type"
+	poolReadyTimeout   = 120 * time.Second
+	poolRefillInterval = 30 * time.Second
+)
+
+// PoolStats describes the state of the warm pool for one sandbox type
+type PoolStats struct {
+	Type    string `json:"type"`
+	Target  int    `json:"target"`
+	Ready   int    `json:"ready"`
+	Warming int    `json:"warming"`
+	Hits    int64  `json:"hits"`
+	Misses  int64  `json:"misses"`
+}
+
+// WarmPool keeps a number of ready sandbox pods per sandbox type so that deploy
+// requests do not have to wait for a pod to start. The Kubernetes API is the
+// source of truth for pool membership, so several API replicas can share a pool
+// and pods survive restarts.
+type WarmPool struct {
+	clientset kubernetes.Interface
+	namespace string
+	sizes     map[string]int
+
+	hits   map[string]*int64
+	misses map[string]*int64
+
+	// warming holds the pods this process is waiting on to become ready
+	warming sync.Map
+
+	refill   chan struct{}
+	stopChan chan struct{}
+}
+
+// NewWarmPool creates a warm pool keeping sizes[type] ready pods for each sandbox type
+func NewWarmPool(clientset kubernetes.Interface, namespace string, sizes map[string]int) *WarmPool {
+	p := &WarmPool{
+		clientset: clientset,
+		namespace: namespace,
+		sizes:     sizes,
+		hits:      make(map[string]*int64),
+		misses:    make(map[string]*int64),
+		refill:    make(chan struct{}, 1),
+		stopChan:  make(chan struct{}),
+	}
+	for sandboxType := range sizes {
+		p.hits[sandboxType] = new(int64)
+		p.misses[sandboxType] = new(int64)
+	}
+	return p
+}
+
+// WarmPoolSizesFromEnv returns the warmPoolSize of every sandbox profile, overridden for the
+// built-in profiles by WARM_POOL_BROWSER_SIZE and WARM_POOL_OFFICE_SIZE. Types without a
+// positive size are left out, so an empty map means the pool is disabled.
+func WarmPoolSizesFromEnv() map[string]int {
+	sizes := make(map[string]int)
+	for _, name := range SandboxProfileNames() {
+		if profile, ok := GetSandboxProfile(name); ok && profile.WarmPoolSize > 0 {
+			sizes[name] = profile.WarmPoolSize
+		}
+	}
+	for sandboxType, env := range map[string]string{
+		SandboxTypeBrowser: "WARM_POOL_BROWSER_SIZE",
+		SandboxTypeOffice:  "WARM_POOL_OFFICE_SIZE",
+	} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			logrus.Warnf("Invalid %s value %q, warm pool for %s disabled", env, value, sandboxType)
+			continue
+		}
+		if size > 0 {
+			sizes[sandboxType] = size
+		} else {
+			delete(sizes, sandboxType)
+		}
+	}
+	return sizes
+}
+
+// Start begins refilling the pool in the background
+func (p *WarmPool) Start() {
+	logrus.Infof("Starting warm pool with sizes %v", p.sizes)
+	go p.refillLoop()
+}
+
+// Stop stops refilling the pool. Pool pods are left running for the next start.
+func (p *WarmPool) Stop() {
+	logrus.Info("Stopping warm pool")
+	close(p.stopChan)
+}
+
+// Acquire hands out a ready pod of the given type, relabelling it with the user and
+// annotating it with the session. It returns false if the pool is disabled or empty, in which case the
+// caller should create a pod itself.
+func (p *WarmPool) Acquire(sandboxType, userID, sessionID string) (*corev1.Pod, bool) {
+	return p.acquire(sandboxType, userID, sessionID, nil)
+}
+
+// AcquireForSession hands out a ready pod for a BrowserSession and makes the session own it
+func (p *WarmPool) AcquireForSession(session *BrowserSession) (*corev1.Pod, bool) {
+	return p.acquire(session.Spec.Type, session.Spec.User, session.Name, []map[string]interface{}{
+		{"op": "add", "path": "/metadata/labels/" + BrowserSessionLabel, "value": session.Name},
+		{"op": "add", "path": "/metadata/ownerReferences", "value": []metav1.OwnerReference{session.OwnerReference()}},
+	})
+}
+
+func (p *WarmPool) acquire(sandboxType, userID, sessionID string, extraOps []map[string]interface{}) (*corev1.Pod, bool) {
+	if p == nil {
+		return nil, false
+	}
+	if _, ok := p.sizes[sandboxType]; !ok {
+		return nil, false
+	}
+	defer p.triggerRefill()
+
+	pods, err := p.listPods(sandboxType, PoolStateWarm)
+	if err != nil {
+		logrus.Errorf("Failed to list warm pool pods: %v", err)
+		atomic.AddInt64(p.misses[sandboxType], 1)
+		return nil, false
+	}
+
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		claimed, err := p.claim(pod.Name, userID, sessionID, extraOps)
+		if err != nil {
+			// another request claimed the pod first, or it is gone
+			logrus.Debugf("Could not claim warm pod %s: %v", pod.Name, err)
+			continue
+		}
+		atomic.AddInt64(p.hits[sandboxType], 1)
+		logrus.Infof("Handed out warm %s pod %s for session %s", sandboxType, claimed.Name, sessionID)
+		return claimed, true
+	}
+
+	atomic.AddInt64(p.misses[sandboxType], 1)
+	logrus.Infof("Warm pool for %s is empty, falling back to creating a pod", sandboxType)
+	return nil, false
+}
+
+// claim removes the pod from the pool. The JSON patch tests the pool label first so
+// the API server guarantees a pod is only handed out once.
+func (p *WarmPool) claim(podName, userID, sessionID string, extraOps []map[string]interface{}) (*corev1.Pod, error) {
+	ops := []map[string]interface{}{
+		{"op": "test", "path": "/metadata/labels/" + PoolLabel, "value": PoolStateWarm},
+		{"op": "remove", "path": "/metadata/labels/" + PoolLabel},
+		{"op": "replace", "path": "/metadata/labels/user", "value": userID},
+		{"op": "add", "path": "/metadata/annotations/" + ConnectionIDAnnotation, "value": sessionID},
+		{"op": "add", "path": "/metadata/annotations/assigned-at", "value": time.Now().Format("20060102-150405")},
+		{"op": "replace", "path": "/metadata/annotations/last-heartbeat", "value": time.Now().Format("20060102-150405")},
+	}
+	patch, err := json.Marshal(append(ops, extraOps...))
+	if err != nil {
+		return nil, err
+	}
+
+	return p.clientset.CoreV1().Pods(p.namespace).Patch(context.Background(), podName, types.JSONPatchType, patch, metav1.PatchOptions{})
+}
+
+// Stats returns the current state of the pool for every configured sandbox type
+func (p *WarmPool) Stats() []PoolStats {
+	if p == nil {
+		return []PoolStats{}
+	}
+
+	stats := make([]PoolStats, 0, len(p.sizes))
+	for sandboxType, size := range p.sizes {
+		s := PoolStats{
+			Type:   sandboxType,
+			Target: size,
+			Hits:   atomic.LoadInt64(p.hits[sandboxType]),
+			Misses: atomic.LoadInt64(p.misses[sandboxType]),
+		}
+		pods, err := p.listPods(sandboxType, "")
+		if err != nil {
+			logrus.Warnf("Failed to list warm pool pods for stats: %v", err)
+		}
+		for _, pod := range pods {
+			switch pod.Labels[PoolLabel] {
+			case PoolStateWarm:
+				s.Ready++
+			case PoolStateWarming:
+				s.Warming++
+			}
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+func (p *WarmPool) triggerRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+func (p *WarmPool) refillLoop() {
+	ticker := time.NewTicker(poolRefillInterval)
+	defer ticker.Stop()
+
+	p.fill()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			p.fill()
+		case <-p.refill:
+			p.fill()
+		}
+	}
+}
+
+// fill creates pods until every sandbox type has its configured number of ready or warming pods
+func (p *WarmPool) fill() {
+	for sandboxType, size := range p.sizes {
+		pods, err := p.listPods(sandboxType, "")
+		if err != nil {
+			logrus.Errorf("Failed to list warm pool pods for %s: %v", sandboxType, err)
+			continue
+		}
+
+		count := 0
+		for _, pod := range pods {
+			if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			count++
+			// resume waiting on pods left warming by a previous process
+			if pod.Labels[PoolLabel] == PoolStateWarming {
+				p.watchWarming(pod.Name, sandboxType)
+			}
+		}
+
+		for ; count < size; count++ {
+			if err := p.createPod(sandboxType); err != nil {
+				logrus.Errorf("Failed to create warm %s pod: %v", sandboxType, err)
+				break
+			}
+		}
+	}
+}
+
+func (p *WarmPool) createPod(sandboxType string) error {
+	labels := map[string]string{
+		PoolLabel:        PoolStateWarming,
+		SandboxTypeLabel: sandboxType,
+	}
+	poolID := "pool-" + uuid.New().String()[0:8]
+
+	pod, err := CreateSandboxPod(p.clientset, p.namespace, sandboxType, poolID, SandboxPodOptions{Labels: labels})
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Created warm %s pod %s", sandboxType, pod.Name)
+	p.watchWarming(pod.Name, sandboxType)
+	return nil
+}
+
+// watchWarming waits in the background for a pool pod to accept connections and then
+// marks it warm. WaitForPodReady deletes the pod if it never becomes ready.
+func (p *WarmPool) watchWarming(podName, sandboxType string) {
+	if _, loaded := p.warming.LoadOrStore(podName, struct{}{}); loaded {
+		return
+	}
+
+	go func() {
+		defer p.warming.Delete(podName)
+
+		fqdn := fmt.Sprintf("%s.sandbox-instances.%s.svc.cluster.local", podName, p.namespace)
+		port := 3389
+		if profile, ok := GetSandboxProfile(sandboxType); ok {
+			port = profile.Port()
+		}
+		if err := WaitForPodReady(p.clientset, p.namespace, podName, fqdn, port, poolReadyTimeout); err != nil {
+			logrus.Warnf("Warm pool pod %s did not become ready: %v", podName, err)
+			p.triggerRefill()
+			return
+		}
+
+		patch, _ := json.Marshal([]map[string]interface{}{
+			{"op": "test", "path": "/metadata/labels/" + PoolLabel, "value": PoolStateWarming},
+			{"op": "replace", "path": "/metadata/labels/" + PoolLabel, "value": PoolStateWarm},
+		})
+		_, err := p.clientset.CoreV1().Pods(p.namespace).Patch(context.Background(), podName, types.JSONPatchType, patch, metav1.PatchOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			logrus.Errorf("Failed to mark pool pod %s as warm: %v", podName, err)
+			return
+		}
+		logrus.Infof("Warm pool pod %s is ready", podName)
+	}()
+}
+
+// listPods lists the pool pods of a sandbox type, optionally restricted to a pool state
+func (p *WarmPool) listPods(sandboxType, state string) ([]corev1.Pod, error) {
+	selector := fmt.Sprintf("%s,%s=%s", PoolLabel, SandboxTypeLabel, sandboxType)
+	if state != "" {
+		selector = fmt.Sprintf("%s=%s,%s=%s", PoolLabel, state, SandboxTypeLabel, sandboxType)
+	}
+
+	pods, err := p.clientset.CoreV1().Pods(p.namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
@@ -0,0 +1,147 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "browser-sandbox"
+
+// newPoolPod returns a pool pod of the browser profile in the given pool state
+func newPoolPod(name, state string, phase corev1.PodPhase) *corev1.Pod {
+	profile, _ := GetSandboxProfile(SandboxTypeBrowser)
+	pod := newSandboxPod(profile, name)
+	pod.Name = name
+	pod.Namespace = testNamespace
+	pod.Labels[PoolLabel] = state
+	pod.Status.Phase = phase
+	return pod
+}
+
+func TestWarmPoolAcquireClaimsPodOnce(t *testing.T) {
+	warm := newPoolPod("warm-1", PoolStateWarm, corev1.PodRunning)
+	clientset := fake.NewSimpleClientset(warm)
+	// both requests list the pod while it is still warm, as they would if they raced
+	clientset.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &corev1.PodList{Items: []corev1.Pod{*warm}}, nil
+	})
+	pool := NewWarmPool(clientset, testNamespace, map[string]int{SandboxTypeBrowser: 1})
+
+	pod, ok := pool.Acquire(SandboxTypeBrowser, "user-1", "session-1")
+	if !ok {
+		t.Fatal("first Acquire() found no warm pod")
+	}
+	if pod.Labels[PoolLabel] != "" || pod.Labels["user"] != "user-1" || pod.Annotations[ConnectionIDAnnotation] != "session-1" {
+		t.Errorf("claimed pod labels=%v annotations=%v", pod.Labels, pod.Annotations)
+	}
+
+	if pod, ok = pool.Acquire(SandboxTypeBrowser, "user-2", "session-2"); ok {
+		t.Fatalf("second Acquire() claimed pod %s again", pod.Name)
+	}
+
+	stored, err := clientset.CoreV1().Pods(testNamespace).Get(context.Background(), warm.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Labels["user"] != "user-1" || stored.Annotations[ConnectionIDAnnotation] != "session-1" {
+		t.Errorf("pod was reassigned: labels=%v annotations=%v", stored.Labels, stored.Annotations)
+	}
+}
+
+func TestWarmPoolAcquireSkipsUnusablePods(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		sandboxType string
+		pod         *corev1.Pod
+		ok          bool
+	}{
+		{"warm pod", SandboxTypeBrowser, newPoolPod("warm-1", PoolStateWarm, corev1.PodRunning), true},
+		{"warming pod", SandboxTypeBrowser, newPoolPod("warming-1", PoolStateWarming, corev1.PodRunning), false},
+		{"pod not running", SandboxTypeBrowser, newPoolPod("pending-1", PoolStateWarm, corev1.PodPending), false},
+		{"type without pool", SandboxTypeOffice, newPoolPod("warm-1", PoolStateWarm, corev1.PodRunning), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pool := NewWarmPool(fake.NewSimpleClientset(test.pod), testNamespace, map[string]int{SandboxTypeBrowser: 1})
+			if _, ok := pool.Acquire(test.sandboxType, "user", "session"); ok != test.ok {
+				t.Errorf("Acquire()=%v, want %v", ok, test.ok)
+			}
+		})
+	}
+}
+
+func TestWarmPoolFill(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		pods    []runtime.Object
+		created int
+	}{
+		{"empty pool", nil, 2},
+		{"partly filled", []runtime.Object{newPoolPod("warm-1", PoolStateWarm, corev1.PodRunning)}, 1},
+		{"failed pods are replaced", []runtime.Object{
+			newPoolPod("warm-1", PoolStateWarm, corev1.PodRunning),
+			newPoolPod("failed-1", PoolStateWarm, corev1.PodFailed),
+		}, 1},
+		{"full", []runtime.Object{
+			newPoolPod("warm-1", PoolStateWarm, corev1.PodRunning),
+			newPoolPod("warm-2", PoolStateWarm, corev1.PodRunning),
+		}, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(test.pods...)
+			pool := NewWarmPool(clientset, testNamespace, map[string]int{SandboxTypeBrowser: 2})
+			pool.fill()
+
+			warming, err := pool.listPods(SandboxTypeBrowser, PoolStateWarming)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(warming) != test.created {
+				t.Errorf("created %d pods, want %d", len(warming), test.created)
+			}
+			for _, pod := range warming {
+				if pod.Labels[SandboxTypeLabel] != SandboxTypeBrowser {
+					t.Errorf("pod %s has labels %v", pod.Name, pod.Labels)
+				}
+			}
+		})
+	}
+}
+
+func TestWarmPoolStats(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		newPoolPod("warm-1", PoolStateWarm, corev1.PodRunning),
+		newPoolPod("warm-2", PoolStateWarm, corev1.PodRunning),
+		newPoolPod("warming-1", PoolStateWarming, corev1.PodPending),
+	)
+	pool := NewWarmPool(clientset, testNamespace, map[string]int{SandboxTypeBrowser: 3})
+
+	if _, ok := pool.Acquire(SandboxTypeBrowser, "user", "session-1"); !ok {
+		t.Fatal("Acquire() found no warm pod")
+	}
+	if _, ok := pool.Acquire(SandboxTypeBrowser, "user", "session-2"); !ok {
+		t.Fatal("Acquire() found no warm pod")
+	}
+	if _, ok := pool.Acquire(SandboxTypeBrowser, "user", "session-3"); ok {
+		t.Fatal("Acquire() claimed a pod that is not warm")
+	}
+
+	stats := pool.Stats()
+	want := PoolStats{Type: SandboxTypeBrowser, Target: 3, Ready: 0, Warming: 1, Hits: 2, Misses: 1}
+	if len(stats) != 1 || stats[0] != want {
+		t.Errorf("Stats()=%+v, want %+v", stats, want)
+	}
+
+	var nilPool *WarmPool
+	if stats := nilPool.Stats(); len(stats) != 0 {
+		t.Errorf("Stats() of a disabled pool=%v", stats)
+	}
+	if _, ok := nilPool.Acquire(SandboxTypeBrowser, "user", "session"); ok {
+		t.Error("Acquire() of a disabled pool found a pod")
+	}
+}
@@ -0,0 +1,236 @@
+package k8s
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/browsersec/KubeBrowse/internal/guac"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Ports guacd connects to for each protocol a sandbox may allow
+var protocolPorts = map[string]int{
+	"rdp": 3389,
+	"vnc": 5900,
+	"ssh": 22,
+}
+
+// Upload scan modes of sandbox profiles
+const (
+	// UploadScanConcurrent sends uploads to the sandbox while they are scanned, the default
+	UploadScanConcurrent = "concurrent"
+	// UploadScanStrict delivers uploads only once the scanner found them clean and quarantines
+	// infected ones
+	UploadScanStrict = "strict"
+)
+
+// SandboxProfile describes how the pod of a sandbox type is built. The built-in
+// browser and office profiles can be overridden and new profiles added from a
+// YAML file, usually a mounted ConfigMap, see LoadSandboxProfiles.
+type SandboxProfile struct {
+	Name             string                      `json:"name"`
+	Image            string                      `json:"image"`
+	ContainerName    string                      `json:"containerName,omitempty"`
+	Resources        corev1.ResourceRequirements `json:"resources,omitempty"`
+	Env              []corev1.EnvVar             `json:"env,omitempty"`
+	InitContainers   []corev1.Container          `json:"initContainers,omitempty"`
+	Volumes          []corev1.Volume             `json:"volumes,omitempty"`
+	VolumeMounts     []corev1.VolumeMount        `json:"volumeMounts,omitempty"`
+	RuntimeClassName string                      `json:"runtimeClassName,omitempty"`
+	NodeSelector     map[string]string           `json:"nodeSelector,omitempty"`
+	Tolerations      []corev1.Toleration         `json:"tolerations,omitempty"`
+	// Protocols guacd may use to connect to the sandbox, the first one is used for new sessions
+	Protocols []string `json:"protocols,omitempty"`
+	// WarmPoolSize is the number of ready pods kept for this profile
+	WarmPoolSize int `json:"warmPoolSize,omitempty"`
+	// Egress limits what the sandbox may connect to, DefaultEgressPolicy is used if unset
+	Egress *EgressPolicy `json:"egress,omitempty"`
+	// URLFiltering sends all web traffic of the sandbox through the egress proxy, which
+	// applies the URL policy of the profile. It has no effect unless EGRESS_PROXY_URL is set.
+	URLFiltering bool `json:"urlFiltering,omitempty"`
+	// InputPolicy allows, drops or rewrites the Guacamole instructions of sessions,
+	// for example to block the clipboard, downloads or key combinations
+	InputPolicy *guac.InputPolicy `json:"inputPolicy,omitempty"`
+	// Clipboard limits the size and types of clipboard transfers and checks them for secrets
+	Clipboard *guac.ClipboardPolicy `json:"clipboard,omitempty"`
+	// UploadScanMode is "concurrent" or "strict", see UploadScanStrict
+	UploadScanMode string `json:"uploadScanMode,omitempty"`
+	// ContentDisarm strips macros, embedded objects and active content from uploaded office
+	// documents and PDFs before they reach the sandbox, see the cdr package
+	ContentDisarm bool `json:"contentDisarm,omitempty"`
+}
+
+// Protocol returns the protocol new sessions of the profile connect with
+func (p *SandboxProfile) Protocol() string {
+	if len(p.Protocols) == 0 {
+		return "rdp"
+	}
+	return p.Protocols[0]
+}
+
+// ScansUploadsFirst reports whether uploads are scanned before they reach the sandbox
+func (p *SandboxProfile) ScansUploadsFirst() bool {
+	return p.UploadScanMode == UploadScanStrict
+}
+
+// Port returns the port of the protocol new sessions connect with
+func (p *SandboxProfile) Port() int {
+	return protocolPorts[p.Protocol()]
+}
+
+// AllowsProtocol reports whether guacd may connect to the sandbox with protocol
+func (p *SandboxProfile) AllowsProtocol(protocol string) bool {
+	if len(p.Protocols) == 0 {
+		return protocol == "rdp"
+	}
+	for _, allowed := range p.Protocols {
+		if allowed == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *SandboxProfile) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("profile name is required")
+	}
+	if p.Image == "" {
+		return fmt.Errorf("profile %s: image is required", p.Name)
+	}
+	for _, protocol := range p.Protocols {
+		if _, ok := protocolPorts[protocol]; !ok {
+			return fmt.Errorf("profile %s: unsupported protocol %s", p.Name, protocol)
+		}
+	}
+	if p.WarmPoolSize < 0 {
+		return fmt.Errorf("profile %s: warmPoolSize must not be negative", p.Name)
+	}
+	if p.Egress != nil {
+		if err := p.Egress.validate(); err != nil {
+			return fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+	}
+	if p.InputPolicy != nil {
+		if err := p.InputPolicy.Validate(); err != nil {
+			return fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+	}
+	if p.Clipboard != nil {
+		if err := p.Clipboard.Validate(); err != nil {
+			return fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+	}
+	switch p.UploadScanMode {
+	case "", UploadScanConcurrent, UploadScanStrict:
+	default:
+		return fmt.Errorf("profile %s: invalid uploadScanMode %q, must be concurrent or strict", p.Name, p.UploadScanMode)
+	}
+	return nil
+}
+
+// sandboxProfilesFile is the format of the profiles file
+type sandboxProfilesFile struct {
+	Profiles []SandboxProfile `json:"profiles"`
+}
+
+var (
+	profilesMutex   sync.RWMutex
+	sandboxProfiles = builtinSandboxProfiles()
+)
+
+func builtinSandboxProfiles() map[string]*SandboxProfile {
+	return map[string]*SandboxProfile{
+		SandboxTypeBrowser: browserSandboxProfile(),
+		SandboxTypeOffice:  officeSandboxProfile(),
+	}
+}
+
+// GetSandboxProfile returns the profile with the given name
+func GetSandboxProfile(name string) (*SandboxProfile, bool) {
+	profilesMutex.RLock()
+	defer profilesMutex.RUnlock()
+	profile, ok := sandboxProfiles[name]
+	return profile, ok
+}
+
+// SandboxProfileNames returns the names of all known profiles
+func SandboxProfileNames() []string {
+	profilesMutex.RLock()
+	defer profilesMutex.RUnlock()
+	names := make([]string, 0, len(sandboxProfiles))
+	for name := range sandboxProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadSandboxProfiles reads profiles from a YAML file of the form
+//
+//	profiles:
+//	  - name: firefox
+//	    image: ghcr.io/browsersec/rdp-firefox:latest
+//	    resources: {limits: {cpu: "1", memory: 1Gi}}
+//
+// Profiles from the file are added to the built-in ones, replacing those with the same name.
+// The current profiles are kept if the file is invalid.
+func LoadSandboxProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read sandbox profiles: %w", err)
+	}
+
+	var file sandboxProfilesFile
+	if err = yaml.UnmarshalStrict(data, &file); err != nil {
+		return fmt.Errorf("failed to parse sandbox profiles %s: %w", path, err)
+	}
+
+	profiles := builtinSandboxProfiles()
+	for i := range file.Profiles {
+		profile := file.Profiles[i]
+		if err = profile.validate(); err != nil {
+			return fmt.Errorf("invalid sandbox profile in %s: %w", path, err)
+		}
+		profiles[profile.Name] = &profile
+	}
+
+	profilesMutex.Lock()
+	sandboxProfiles = profiles
+	profilesMutex.Unlock()
+
+	logrus.Infof("Loaded sandbox profiles from %s: %v", path, SandboxProfileNames())
+	return nil
+}
+
+// WatchSandboxProfiles reloads the profiles file whenever it changes, which is how
+// Kubernetes delivers ConfigMap updates to mounted files
+func WatchSandboxProfiles(path string, interval time.Duration, stopChan <-chan struct{}) {
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || !info.ModTime().After(lastModified) {
+				continue
+			}
+			lastModified = info.ModTime()
+			if err = LoadSandboxProfiles(path); err != nil {
+				logrus.Errorf("Failed to reload sandbox profiles: %v", err)
+			}
+		}
+	}
+}
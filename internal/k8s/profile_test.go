@@ -0,0 +1,97 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeProfiles writes a profiles file and restores the built-in profiles after the test
+func writeProfiles(t *testing.T, content string) string {
+	t.Helper()
+	t.Cleanup(func() {
+		profilesMutex.Lock()
+		sandboxProfiles = builtinSandboxProfiles()
+		profilesMutex.Unlock()
+	})
+
+	path := filepath.Join(t.TempDir(), "profiles.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSandboxProfiles(t *testing.T) {
+	path := writeProfiles(t, `
+profiles:
+  - name: firefox
+    image: ghcr.io/browsersec/rdp-firefox:latest
+    protocols: [vnc, rdp]
+    warmPoolSize: 2
+    resources: {limits: {cpu: "1", memory: 1Gi}}
+  - name: browser
+    image: registry.example.com/chromium:pinned
+`)
+	if err := LoadSandboxProfiles(path); err != nil {
+		t.Fatalf("LoadSandboxProfiles()=%v", err)
+	}
+
+	if names := strings.Join(SandboxProfileNames(), ","); names != "browser,firefox,office" {
+		t.Errorf("SandboxProfileNames()=%s, want the built-in profiles and firefox", names)
+	}
+
+	firefox, ok := GetSandboxProfile("firefox")
+	if !ok {
+		t.Fatal("firefox profile not loaded")
+	}
+	if firefox.Protocol() != "vnc" || firefox.Port() != 5900 || !firefox.AllowsProtocol("rdp") || firefox.AllowsProtocol("ssh") {
+		t.Errorf("firefox protocols=%v, port %d", firefox.Protocols, firefox.Port())
+	}
+	if firefox.WarmPoolSize != 2 || firefox.Resources.Limits.Memory().String() != "1Gi" {
+		t.Errorf("firefox warmPoolSize=%d, resources=%v", firefox.WarmPoolSize, firefox.Resources)
+	}
+
+	browser, _ := GetSandboxProfile(SandboxTypeBrowser)
+	if browser.Image != "registry.example.com/chromium:pinned" {
+		t.Errorf("browser image=%s, want the profile from the file to replace the built-in one", browser.Image)
+	}
+	if browser.Protocol() != "rdp" || browser.Port() != 3389 || !browser.AllowsProtocol("rdp") || browser.AllowsProtocol("vnc") {
+		t.Errorf("profile without protocols allows %v, port %d, want rdp only", browser.Protocols, browser.Port())
+	}
+}
+
+func TestLoadSandboxProfilesInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"missing name", "profiles: [{image: img}]", "profile name is required"},
+		{"missing image", "profiles: [{name: p}]", "image is required"},
+		{"unsupported protocol", "profiles: [{name: p, image: img, protocols: [telnet]}]", "unsupported protocol telnet"},
+		{"negative warm pool", "profiles: [{name: p, image: img, warmPoolSize: -1}]", "warmPoolSize must not be negative"},
+		{"unknown field", "profiles: [{name: p, image: img, imag: typo}]", "unknown field"},
+		{"not YAML", "profiles: [", "failed to parse"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeProfiles(t, test.content)
+			err := LoadSandboxProfiles(path)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("LoadSandboxProfiles()=%v, want an error containing %q", err, test.err)
+			}
+			// the current profiles are kept
+			if _, ok := GetSandboxProfile("p"); ok {
+				t.Error("invalid profile was loaded")
+			}
+			if names := strings.Join(SandboxProfileNames(), ","); names != "browser,office" {
+				t.Errorf("SandboxProfileNames()=%s, want the built-in profiles", names)
+			}
+		})
+	}
+
+	if err := LoadSandboxProfiles(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadSandboxProfiles() of a missing file succeeded")
+	}
+}
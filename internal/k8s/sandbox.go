@@ -0,0 +1,223 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/browsersec/KubeBrowse/internal/metrics"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// Built-in sandbox types, each has a profile of the same name
+const (
+	SandboxTypeBrowser = "browser"
+	SandboxTypeOffice  = "office"
+)
+
+// ConnectionIDAnnotation holds the connection ID of the session a pod is assigned to
+const ConnectionIDAnnotation = "connection-id"
+
+// SandboxPodOptions customises a sandbox pod before it is created
+type SandboxPodOptions struct {
+	// Labels are added to the default sandbox labels
+	Labels map[string]string
+	// Annotations are added to the default sandbox annotations
+	Annotations map[string]string
+	// Resources replaces the resources of the profile
+	Resources *corev1.ResourceRequirements
+	// OwnerReferences makes the pod owned by another object, e.g. a BrowserSession
+	OwnerReferences []metav1.OwnerReference
+}
+
+func (o SandboxPodOptions) apply(pod *corev1.Pod) {
+	for k, v := range o.Labels {
+		pod.Labels[k] = v
+	}
+	for k, v := range o.Annotations {
+		pod.Annotations[k] = v
+	}
+	if o.Resources != nil {
+		pod.Spec.Containers[0].Resources = *o.Resources
+	}
+	pod.OwnerReferences = append(pod.OwnerReferences, o.OwnerReferences...)
+}
+
+// CreateSandboxPod creates a sandbox pod from the named profile
+func CreateSandboxPod(clientset kubernetes.Interface, namespace, profileName, userID string, opts SandboxPodOptions) (*corev1.Pod, error) {
+	profile, ok := GetSandboxProfile(profileName)
+	if !ok {
+		return nil, fmt.Errorf("unknown sandbox profile %s", profileName)
+	}
+
+	pod := newSandboxPod(profile, userID)
+	opts.apply(pod)
+
+	// every pod gets its own login, the Secret must exist before the pod starts
+	if err := createCredentialsSecret(clientset, namespace, pod); err != nil {
+		logrus.Errorf("Error creating pod: %v", err)
+		metrics.PodCreationFailures.WithLabelValues("create").Inc()
+		return nil, err
+	}
+	// isolate the pod before it starts so that it is never reachable without a policy
+	if err := createNetworkPolicy(clientset, namespace, pod, profile); err != nil {
+		logrus.Errorf("Error creating pod: %v", err)
+		metrics.PodCreationFailures.WithLabelValues("create").Inc()
+		deleteSandboxResources(clientset, namespace, pod.Name)
+		return nil, err
+	}
+
+	result, err := clientset.CoreV1().Pods(namespace).Create(context.Background(), pod, metav1.CreateOptions{})
+	if err != nil {
+		logrus.Errorf("Error creating pod: %v", err)
+		metrics.PodCreationFailures.WithLabelValues("create").Inc()
+		deleteSandboxResources(clientset, namespace, pod.Name)
+		return nil, err
+	}
+	setSandboxResourcesOwner(clientset, namespace, result)
+	return result, nil
+}
+
+// setSandboxResourcesOwner makes the pod own its Secret and NetworkPolicy so that they are
+// garbage collected with the pod even if it is not deleted through DeletePod
+func setSandboxResourcesOwner(clientset kubernetes.Interface, namespace string, pod *corev1.Pod) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"ownerReferences": []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       pod.Name,
+				UID:        pod.UID,
+			}},
+		},
+	})
+	if err != nil {
+		logrus.Warnf("Failed to set owner of resources of pod %s: %v", pod.Name, err)
+		return
+	}
+
+	ctx := context.Background()
+	if _, err = clientset.CoreV1().Secrets(namespace).Patch(ctx, CredentialsSecretName(pod.Name), types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		logrus.Warnf("Failed to set owner of credentials secret of pod %s: %v", pod.Name, err)
+	}
+	if _, err = clientset.NetworkingV1().NetworkPolicies(namespace).Patch(ctx, NetworkPolicyName(pod.Name), types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		logrus.Warnf("Failed to set owner of network policy of pod %s: %v", pod.Name, err)
+	}
+}
+
+// deleteSandboxResources deletes the objects created alongside a sandbox pod
+func deleteSandboxResources(clientset kubernetes.Interface, namespace, podName string) {
+	if err := DeleteCredentialsSecret(clientset, namespace, podName); err != nil {
+		logrus.Errorf("Failed to delete credentials of pod %s: %v", podName, err)
+	}
+	if err := DeleteNetworkPolicy(clientset, namespace, podName); err != nil {
+		logrus.Errorf("Failed to delete network policy of pod %s: %v", podName, err)
+	}
+}
+
+// newSandboxPod builds the pod of a sandbox from its profile
+func newSandboxPod(profile *SandboxProfile, userID string) *corev1.Pod {
+	podName := fmt.Sprintf("browser-sandbox-%s-%s", userID, time.Now().Format("20060102150405"))
+
+	containerName := profile.ContainerName
+	if containerName == "" {
+		containerName = "sandbox"
+	}
+
+	ports := make([]corev1.ContainerPort, 0, len(profile.Protocols))
+	for _, protocol := range profile.Protocols {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          protocol,
+			ContainerPort: int32(protocolPorts[protocol]),
+		})
+	}
+	if len(ports) == 0 {
+		ports = append(ports, corev1.ContainerPort{Name: "rdp", ContainerPort: 3389})
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: podName,
+			Labels: map[string]string{
+				"app":            "browser-sandbox-test",
+				"session-id":     podName,
+				"created-at":     time.Now().Format("20060102-150405"),
+				"user":           userID,
+				"managed-by":     "kubebrowse-cleanup", // Add cleanup label
+				SandboxTypeLabel: profile.Name,
+			},
+			Annotations: map[string]string{
+				"last-heartbeat":    time.Now().Format("20060102-150405"),
+				"connection-status": "active",
+				"cleanup-enabled":   "true", // Mark for cleanup monitoring
+			},
+		},
+
+		Spec: corev1.PodSpec{
+			Hostname:       podName,
+			Subdomain:      "sandbox-instances",
+			Volumes:        profile.Volumes,
+			InitContainers: profile.InitContainers,
+			Containers: []corev1.Container{
+				{
+					Name:         containerName,
+					Image:        profile.Image,
+					Ports:        ports,
+					Env:          append([]corev1.EnvVar(nil), profile.Env...),
+					Resources:    *profile.Resources.DeepCopy(),
+					VolumeMounts: profile.VolumeMounts,
+				},
+			},
+			NodeSelector:                  profile.NodeSelector,
+			Tolerations:                   profile.Tolerations,
+			TerminationGracePeriodSeconds: ptr.To(int64(30)),
+		},
+	}
+
+	if profile.RuntimeClassName != "" {
+		pod.Spec.RuntimeClassName = ptr.To(profile.RuntimeClassName)
+	}
+
+	if proxyURL := EgressProxyURL(); profile.URLFiltering && proxyURL != "" {
+		pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, egressProxyEnv(proxyURL)...)
+	}
+
+	return pod
+}
+
+// logVolume holds the supervisor logs of the built-in sandbox images
+func logVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: "log-volume",
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}
+}
+
+func logVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      "log-volume",
+		MountPath: "/var/log",
+	}
+}
+
+// logDirsInitContainer prepares the log directory for the non-root supervisor of the built-in images
+func logDirsInitContainer() corev1.Container {
+	return corev1.Container{
+		Name:  "init-log-dirs",
+		Image: "busybox:1.36",
+		Command: []string{
+			"sh",
+			"-c",
+			"mkdir -p /var/log/supervisor && chmod 755 /var/log/supervisor && chown -R 1000:1000 /var/log/supervisor",
+		},
+		VolumeMounts: []corev1.VolumeMount{logVolumeMount()},
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/browsersec/KubeBrowse/internal/metrics"
 	log "github.com/sirupsen/logrus"
 
 	corev1 "k8s.io/api/core/v1"
@@ -15,13 +16,14 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
-// DeletePod deletes a pod by name in the given namespace.
-func DeletePod(clientset *kubernetes.Clientset, podName string) error {
+// DeletePod deletes a pod by name in the given namespace, together with its credentials and network policy.
+func DeletePod(clientset kubernetes.Interface, podName string) error {
+	deleteSandboxResources(clientset, "browser-sandbox", podName)
 	return clientset.CoreV1().Pods("browser-sandbox").Delete(context.Background(), podName, metav1.DeleteOptions{})
 }
 
 // CheckPodName checks if a pod with the given name exists in the specified namespace.
-func CheckPodName(k8sClient *kubernetes.Clientset, namespace, podName string) (bool, error) {
+func CheckPodName(k8sClient kubernetes.Interface, namespace, podName string) (bool, error) {
 	_, err := k8sClient.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
@@ -34,71 +36,98 @@ func CheckPodName(k8sClient *kubernetes.Clientset, namespace, podName string) (b
 	return true, nil // Pod exists
 }
 
-func WaitForPodReadyAndRDP(k8sClient *kubernetes.Clientset, namespace, podName, fqdn string, timeout time.Duration) error {
-	deadline := time.Now().Add(timeout)
+func WaitForPodReadyAndRDP(k8sClient kubernetes.Interface, namespace, podName, fqdn string, timeout time.Duration) error {
+	return WaitForPodReady(k8sClient, namespace, podName, fqdn, 3389, timeout)
+}
+
+// WaitForPodReady waits for the pod to be ready and for port to accept connections.
+// The pod is deleted if it fails or does not become ready in time.
+func WaitForPodReady(k8sClient kubernetes.Interface, namespace, podName, fqdn string, port int, timeout time.Duration) error {
+	start := time.Now()
+	deadline := start.Add(timeout)
 	for time.Now().Before(deadline) {
 		// 1. Check pod phase
 		pod, err := k8sClient.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
 		if err != nil {
+			observePodReady(start, "api_error")
 			return err
 		}
 
 		// Check for CrashLoopBackOff or other problematic states
-		if pod.Status.Phase == corev1.PodFailed {
-			err := DeletePodGrace(k8sClient, namespace, podName)
-			if err != nil {
-				log.Errorf("Failed to delete pod %s in namespace %s: %v", podName, namespace, err)
-			}
-			return fmt.Errorf("pod %s is in Failed state", podName)
-		}
-
-		// Check container statuses for CrashLoopBackOff
-		for _, containerStatus := range pod.Status.ContainerStatuses {
-			if containerStatus.State.Waiting != nil {
-				if containerStatus.State.Waiting.Reason == "CrashLoopBackOff" {
-					err := DeletePodGrace(k8sClient, namespace, podName)
-					if err != nil {
-						log.Errorf("Failed to delete pod %s in namespace %s: %v", podName, namespace, err)
-					}
-					return fmt.Errorf("pod %s is in CrashLoopBackOff state", podName)
-				}
-				if containerStatus.State.Waiting.Reason == "ImagePullBackOff" ||
-					containerStatus.State.Waiting.Reason == "ErrImagePull" {
-					err := DeletePodGrace(k8sClient, namespace, podName)
-					if err != nil {
-						log.Errorf("Failed to delete pod %s in namespace %s: %v", podName, namespace, err)
-					}
-					return fmt.Errorf("pod %s has image pull issues: %s", podName, containerStatus.State.Waiting.Reason)
-				}
+		if err := podFailure(pod); err != nil {
+			observePodReady(start, "pod_failed")
+			deleteErr := DeletePodGrace(k8sClient, namespace, podName)
+			if deleteErr != nil {
+				log.Errorf("Failed to delete pod %s in namespace %s: %v", podName, namespace, deleteErr)
 			}
+			return err
 		}
 
-		ready := false
-		for _, cond := range pod.Status.Conditions {
-			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
-				ready = true
-				break
-			}
-		}
-		if !ready {
+		if !isPodReady(pod) {
 			time.Sleep(2 * time.Second)
 			continue
 		}
 		// 2. Check RDP port
-		conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:3389", fqdn), 2*time.Second)
-		if err == nil {
-			err = conn.Close()
-			if err != nil {
-				return err
-			}
+		if err := checkPort(fqdn, port); err == nil {
+			observePodReady(start, "")
 			return nil // Success!
 		}
 		time.Sleep(2 * time.Second)
 	}
 
+	observePodReady(start, "timeout")
 	err := DeletePodGrace(k8sClient, namespace, podName)
 	if err != nil {
 		log.Errorf("Failed to delete pod %s in namespace %s: %v", podName, namespace, err)
 	}
-	return fmt.Errorf("pod not ready or RDP port not open after %v", timeout)
+	return fmt.Errorf("pod not ready or port %d not open after %v", port, timeout)
+}
+
+// observePodReady records how long a pod took to become ready, or why it did not
+func observePodReady(start time.Time, failureReason string) {
+	if failureReason == "" {
+		metrics.PodReadyDuration.WithLabelValues(metrics.ResultSuccess).Observe(time.Since(start).Seconds())
+		return
+	}
+	metrics.PodReadyDuration.WithLabelValues(metrics.ResultFailure).Observe(time.Since(start).Seconds())
+	metrics.PodCreationFailures.WithLabelValues(failureReason).Inc()
+}
+
+// podFailure returns an error if the pod failed or cannot start
+func podFailure(pod *corev1.Pod) error {
+	if pod.Status.Phase == corev1.PodFailed {
+		return fmt.Errorf("pod %s is in Failed state", pod.Name)
+	}
+
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.State.Waiting == nil {
+			continue
+		}
+		switch containerStatus.State.Waiting.Reason {
+		case "CrashLoopBackOff":
+			return fmt.Errorf("pod %s is in CrashLoopBackOff state", pod.Name)
+		case "ImagePullBackOff", "ErrImagePull":
+			return fmt.Errorf("pod %s has image pull issues: %s", pod.Name, containerStatus.State.Waiting.Reason)
+		}
+	}
+	return nil
+}
+
+// isPodReady reports whether the pod's Ready condition is true
+func isPodReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPort dials a port of a sandbox
+func checkPort(fqdn string, port int) error {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", fqdn, port), 2*time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
 }
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kubebrowse"
+
+// Result label values
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Directions of proxied tunnel traffic
+const (
+	DirectionClientToGuacd = "client_to_guacd"
+	DirectionGuacdToClient = "guacd_to_client"
+)
+
+var (
+	// PodReadyDuration is the time from pod creation until the sandbox accepts connections
+	PodReadyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pod_ready_duration_seconds",
+		Help:      "Time until a sandbox pod is ready and its remote desktop port accepts connections.",
+		Buckets:   []float64{1, 2, 5, 10, 15, 20, 30, 45, 60, 90, 120},
+	}, []string{"result"})
+
+	// PodCreationFailures counts sandbox pods that could not be created or never became ready
+	PodCreationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pod_creation_failures_total",
+		Help:      "Sandbox pods that could not be created or did not become ready.",
+	}, []string{"reason"})
+
+	// GuacdHandshakeDuration is the time to connect and complete the handshake with guacd
+	GuacdHandshakeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "guacd_handshake_duration_seconds",
+		Help:      "Time to connect to guacd and complete the Guacamole handshake.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// GuacdHandshakeErrors counts failed guacd connections by the stage that failed
+	GuacdHandshakeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "guacd_handshake_errors_total",
+		Help:      "Failed guacd connections by stage.",
+	}, []string{"stage"})
+
+	// TunnelBytes counts the bytes proxied between websocket clients and guacd
+	TunnelBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tunnel_bytes_total",
+		Help:      "Bytes proxied between websocket clients and guacd.",
+	}, []string{"direction"})
+
+	// Uploads counts upload results per destination service
+	Uploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_total",
+		Help:      "File upload results per service.",
+	}, []string{"service", "result"})
+
+	// Downloads counts the files sandboxes sent to their users by the status they ended in
+	Downloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloads_total",
+		Help:      "Files sent from sandboxes to users by final status.",
+	}, []string{"status"})
+
+	// CleanupActions counts sandbox pods removed by the cleanup paths
+	CleanupActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cleanup_actions_total",
+		Help:      "Sandbox cleanups by reason and result.",
+	}, []string{"action", "result"})
+
+	// ClipboardTransfers counts inspected clipboard transfers by direction and verdict
+	ClipboardTransfers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "clipboard_transfers_total",
+		Help:      "Clipboard transfers between users and sandboxes by direction and verdict.",
+	}, []string{"direction", "verdict"})
+
+	// Logins counts login attempts by method and outcome
+	Logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by method and result.",
+	}, []string{"method", "result"})
+)
+
+// RegisterActiveTunnels exposes the number of active tunnels, read from count on every scrape
+func RegisterActiveTunnels(count func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_tunnels",
+		Help:      "Number of active guacd tunnels.",
+	}, func() float64 {
+		return float64(count())
+	})
+}
+
+// Result returns the result label value of an operation
+func Result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,127 @@
+package minio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const downloadPrefix = "downloads/"
+
+// DownloadStore holds the files sandboxes send to their users until they are released
+type DownloadStore struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewDownloadStore creates a download store backed by the given bucket
+func NewDownloadStore(client *MinioClient, bucket string) *DownloadStore {
+	return &DownloadStore{
+		client: client.Client,
+		bucket: bucket,
+	}
+}
+
+// DownloadObjectName returns the object key of a download of a session
+func DownloadObjectName(connectionID, downloadID string) string {
+	return downloadPrefix + connectionID + "/" + downloadID
+}
+
+// Create starts storing a download. Data written to the returned writer is streamed to
+// MinIO, closing it completes the upload and aborting it removes what was stored.
+func (s *DownloadStore) Create(object, contentType string) *DownloadWriter {
+	pr, pw := io.Pipe()
+	w := &DownloadWriter{
+		store:  s,
+		object: object,
+		pipe:   pw,
+		done:   make(chan error, 1),
+	}
+
+	go func() {
+		_, err := s.client.PutObject(context.Background(), s.bucket, object, pr, -1, minio.PutObjectOptions{
+			ContentType: contentType,
+			PartSize:    recordingPartSize,
+		})
+		if err != nil {
+			// unblock the writer so the session is not stalled by a failed upload
+			_ = pr.CloseWithError(err)
+		}
+		w.done <- err
+	}()
+
+	return w
+}
+
+// Open returns a reader for a stored download and its size
+func (s *DownloadStore) Open(ctx context.Context, object string) (io.ReadCloser, int64, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, object, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		_ = obj.Close()
+		return nil, 0, fmt.Errorf("download %s not found: %w", object, err)
+	}
+	return obj, info.Size, nil
+}
+
+// Remove deletes a stored download
+func (s *DownloadStore) Remove(ctx context.Context, object string) error {
+	return s.client.RemoveObject(ctx, s.bucket, object, minio.RemoveObjectOptions{})
+}
+
+// DownloadWriter streams a download into MinIO through a pipe
+type DownloadWriter struct {
+	store  *DownloadStore
+	object string
+	pipe   *io.PipeWriter
+	size   int64
+	done   chan error
+
+	finishOnce sync.Once
+	finishErr  error
+}
+
+func (w *DownloadWriter) Write(p []byte) (int, error) {
+	n, err := w.pipe.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes written so far
+func (w *DownloadWriter) Size() int64 {
+	return w.size
+}
+
+// Close completes the upload
+func (w *DownloadWriter) Close() error {
+	w.finish(nil)
+	return w.finishErr
+}
+
+// Abort cancels the upload and removes what was stored
+func (w *DownloadWriter) Abort(reason error) {
+	w.finish(reason)
+}
+
+func (w *DownloadWriter) finish(reason error) {
+	w.finishOnce.Do(func() {
+		if reason != nil {
+			_ = w.pipe.CloseWithError(reason)
+		} else {
+			_ = w.pipe.Close()
+		}
+		if err := <-w.done; err != nil {
+			w.finishErr = fmt.Errorf("download upload failed: %w", err)
+		}
+		if reason != nil {
+			// a multipart upload cancelled midway leaves no object, a completed single part might
+			_ = w.store.Remove(context.Background(), w.object)
+		}
+	})
+}
@@ -0,0 +1,259 @@
+package minio
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+const libraryPrefix = "files/"
+
+// Tags of the files in the library
+const (
+	TagSessionID   = "session-id"
+	TagUploader    = "uploader"
+	TagScanVerdict = "scan-verdict"
+)
+
+// Scan verdicts of files in the library. Files are stored while they are scanned, their
+// verdict is pending until the scan completes.
+const (
+	VerdictPending   = "pending"
+	VerdictClean     = "clean"
+	VerdictInfected  = "infected"
+	VerdictUnscanned = "unscanned"
+)
+
+// AnonymousOwner owns the files uploaded by users who did not sign in
+const AnonymousOwner = "anonymous"
+
+// libraryRetentionRule is the ID of the lifecycle rule expiring library files
+const libraryRetentionRule = "kubebrowse-file-retention"
+
+var ErrFileNotFound = errors.New("file not found")
+
+// LibraryFile is a file a user uploaded, kept to be sent to later sessions
+type LibraryFile struct {
+	ID          string    `json:"id"`
+	Object      string    `json:"object"`
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size"`
+	Uploader    string    `json:"uploader"`
+	SessionID   string    `json:"session_id"` // Session the file was uploaded to
+	ScanVerdict string    `json:"scan_verdict"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// FileLibrary stores uploads under a prefix of their owner, tagged with the session they
+// were uploaded to, their uploader and their scan verdict
+type FileLibrary struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewFileLibrary creates a file library backed by the given bucket
+func NewFileLibrary(client *MinioClient, bucket string) *FileLibrary {
+	return &FileLibrary{
+		client: client.Client,
+		bucket: bucket,
+	}
+}
+
+// Bucket returns the bucket of the library
+func (l *FileLibrary) Bucket() string {
+	return l.bucket
+}
+
+// LibraryObjectName returns the object key of a file of an owner
+func LibraryObjectName(owner, id string) string {
+	return libraryPrefix + owner + "/" + id
+}
+
+func newFileID() (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(idBytes), nil
+}
+
+// validFileID reports whether id could have been returned by newFileID
+func validFileID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return err == nil && len(id) == 32
+}
+
+// Put streams a file an owner uploaded to a session into the library with a pending verdict
+func (l *FileLibrary) Put(ctx context.Context, owner, connectionID, filename string, file io.Reader) (*LibraryFile, error) {
+	id, err := newFileID()
+	if err != nil {
+		return nil, fmt.Errorf("error generating file ID: %v", err)
+	}
+	object := LibraryObjectName(owner, id)
+
+	// The size is not known up front, the part size bounds what MinIO buffers
+	info, err := l.client.PutObject(ctx, l.bucket, object, file, -1, minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		PartSize:     recordingPartSize,
+		UserMetadata: map[string]string{"Filename": url.PathEscape(filename)},
+		UserTags: map[string]string{
+			TagSessionID:   connectionID,
+			TagUploader:    owner,
+			TagScanVerdict: VerdictPending,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &LibraryFile{
+		ID:          id,
+		Object:      object,
+		Filename:    filename,
+		Size:        info.Size,
+		Uploader:    owner,
+		SessionID:   connectionID,
+		ScanVerdict: VerdictPending,
+		UploadedAt:  time.Now(),
+	}, nil
+}
+
+// SetVerdict replaces the scan verdict of a stored file
+func (l *FileLibrary) SetVerdict(ctx context.Context, object, verdict string) error {
+	objectTags, err := l.client.GetObjectTagging(ctx, l.bucket, object, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return err
+	}
+	tagMap := objectTags.ToMap()
+	tagMap[TagScanVerdict] = verdict
+
+	objectTags, err = tags.MapToObjectTags(tagMap)
+	if err != nil {
+		return err
+	}
+	return l.client.PutObjectTagging(ctx, l.bucket, object, objectTags, minio.PutObjectTaggingOptions{})
+}
+
+// List returns the newest files of an owner, at most limit
+func (l *FileLibrary) List(ctx context.Context, owner string, limit int) ([]LibraryFile, error) {
+	prefix := LibraryObjectName(owner, "")
+	var files []LibraryFile
+	for obj := range l.client.ListObjects(ctx, l.bucket, minio.ListObjectsOptions{
+		Prefix:       prefix,
+		Recursive:    true,
+		WithMetadata: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		files = append(files, LibraryFile{
+			ID:          strings.TrimPrefix(obj.Key, prefix),
+			Object:      obj.Key,
+			Filename:    libraryFilename(obj.UserMetadata),
+			Size:        obj.Size,
+			Uploader:    obj.UserTags[TagUploader],
+			SessionID:   obj.UserTags[TagSessionID],
+			ScanVerdict: obj.UserTags[TagScanVerdict],
+			UploadedAt:  obj.LastModified,
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].UploadedAt.After(files[j].UploadedAt)
+	})
+	if len(files) > limit {
+		files = files[:limit]
+	}
+	return files, nil
+}
+
+// Open returns a reader for a file of an owner and its description
+func (l *FileLibrary) Open(ctx context.Context, owner, id string) (io.ReadCloser, *LibraryFile, error) {
+	if !validFileID(id) {
+		return nil, nil, ErrFileNotFound
+	}
+	object := LibraryObjectName(owner, id)
+
+	info, err := l.client.StatObject(ctx, l.bucket, object, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	objectTags, err := l.client.GetObjectTagging(ctx, l.bucket, object, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	tagMap := objectTags.ToMap()
+
+	obj, err := l.client.GetObject(ctx, l.bucket, object, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return obj, &LibraryFile{
+		ID:          id,
+		Object:      object,
+		Filename:    libraryFilename(info.UserMetadata),
+		Size:        info.Size,
+		Uploader:    tagMap[TagUploader],
+		SessionID:   tagMap[TagSessionID],
+		ScanVerdict: tagMap[TagScanVerdict],
+		UploadedAt:  info.LastModified,
+	}, nil
+}
+
+// libraryFilename returns the file name in the metadata of a library object, which is
+// prefixed when it comes from listing the bucket
+func libraryFilename(metadata map[string]string) string {
+	for _, key := range []string{"Filename", "X-Amz-Meta-Filename"} {
+		if value, ok := metadata[key]; ok {
+			if filename, err := url.PathUnescape(value); err == nil {
+				return filename
+			}
+			return value
+		}
+	}
+	return ""
+}
+
+// ApplyRetention expires library files days after they were uploaded, or keeps them until
+// they are removed if days is not positive. Only the retention rule of the library is
+// changed, the bucket may hold other lifecycle rules.
+func (l *FileLibrary) ApplyRetention(ctx context.Context, days int) error {
+	config, err := l.client.GetBucketLifecycle(ctx, l.bucket)
+	if minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+		config, err = lifecycle.NewConfiguration(), nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get lifecycle of bucket %s: %w", l.bucket, err)
+	}
+
+	rules := make([]lifecycle.Rule, 0, len(config.Rules)+1)
+	for _, rule := range config.Rules {
+		if rule.ID != libraryRetentionRule {
+			rules = append(rules, rule)
+		}
+	}
+	if days > 0 {
+		rules = append(rules, lifecycle.Rule{
+			ID:         libraryRetentionRule,
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: libraryPrefix},
+			Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(days)},
+		})
+	}
+	config.Rules = rules
+	return l.client.SetBucketLifecycle(ctx, l.bucket, config)
+}
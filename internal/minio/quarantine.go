@@ -0,0 +1,36 @@
+package minio
+
+import (
+	"context"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// QuarantineStore keeps infected uploads for inspection in a bucket of their own, away
+// from the files users and sandboxes can reach
+type QuarantineStore struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewQuarantineStore creates a quarantine store backed by the given bucket
+func NewQuarantineStore(client *MinioClient, bucket string) *QuarantineStore {
+	return &QuarantineStore{
+		client: client.Client,
+		bucket: bucket,
+	}
+}
+
+// Bucket returns the bucket infected files are quarantined in
+func (s *QuarantineStore) Bucket() string {
+	return s.bucket
+}
+
+// Put quarantines a file with metadata describing why, like the scan result
+func (s *QuarantineStore) Put(ctx context.Context, object string, file io.Reader, size int64, metadata map[string]string) (minio.UploadInfo, error) {
+	return s.client.PutObject(ctx, s.bucket, object, file, size, minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		UserMetadata: metadata,
+	})
+}
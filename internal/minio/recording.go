@@ -0,0 +1,309 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	recordingPrefix      = "recordings/"
+	recordingContentType = "application/vnd.guacamole.recording"
+	// recordingPartSize bounds how much of a recording is buffered in memory before it is flushed to MinIO
+	recordingPartSize = 5 << 20
+)
+
+// ErrRecordingNotFound is returned for sessions and tunnels without a stored recording
+var ErrRecordingNotFound = errors.New("recording not found")
+
+// RecordingMetadata describes a session recording and is stored next to it as JSON
+type RecordingMetadata struct {
+	ConnectionID       string    `json:"connection_id"`
+	TunnelConnectionID string    `json:"tunnel_connection_id"`
+	TunnelUUID         string    `json:"tunnel_uuid"`
+	PodName            string    `json:"pod_name"`
+	OwnerID            string    `json:"owner_id,omitempty"` // User who deployed the session
+	Object             string    `json:"object"`
+	StartedAt          time.Time `json:"started_at"`
+	StoppedAt          time.Time `json:"stopped_at,omitempty"`
+	Size               int64     `json:"size"`
+	// Truncated is set when the recording fell behind the session and left out its end
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// RecordingStore persists Guacamole session recordings in a MinIO bucket
+type RecordingStore struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewRecordingStore creates a recording store backed by the given bucket
+func NewRecordingStore(client *MinioClient, bucket string) *RecordingStore {
+	return &RecordingStore{
+		client: client.Client,
+		bucket: bucket,
+	}
+}
+
+// Bucket returns the bucket recordings are stored in
+func (s *RecordingStore) Bucket() string {
+	return s.bucket
+}
+
+// RecordingObjectName returns the object key of the recording of one tunnel of a session.
+// Each tunnel, such as a reconnect, records its own segment of the session.
+func RecordingObjectName(connectionID, tunnelUUID string) string {
+	return recordingSessionPrefix(connectionID) + tunnelUUID + ".guac"
+}
+
+// RecordingMetadataObjectName returns the object key of the metadata for the recording of
+// one tunnel of a session
+func RecordingMetadataObjectName(connectionID, tunnelUUID string) string {
+	return recordingSessionPrefix(connectionID) + tunnelUUID + ".json"
+}
+
+func recordingSessionPrefix(connectionID string) string {
+	return recordingPrefix + connectionID + "/"
+}
+
+// RecordingWriter streams a recording to MinIO
+type RecordingWriter interface {
+	io.WriteCloser
+	// MarkTruncated records that the end of the recording is missing
+	MarkTruncated()
+}
+
+// Create starts a new recording for the tunnel of a session described by meta. Data written
+// to the returned writer is streamed to MinIO; closing it completes the upload
+// and stores the stop metadata.
+func (s *RecordingStore) Create(ctx context.Context, meta RecordingMetadata) (RecordingWriter, error) {
+	if meta.ConnectionID == "" || meta.TunnelUUID == "" {
+		return nil, fmt.Errorf("connection ID and tunnel UUID are required for recording")
+	}
+
+	meta.Object = RecordingObjectName(meta.ConnectionID, meta.TunnelUUID)
+	meta.StartedAt = time.Now()
+	if err := s.putMetadata(ctx, &meta); err != nil {
+		return nil, fmt.Errorf("failed to store recording metadata: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	w := &recordingWriter{
+		store: s,
+		pipe:  pw,
+		meta:  meta,
+		done:  make(chan error, 1),
+	}
+
+	go func() {
+		info, err := s.client.PutObject(context.Background(), s.bucket, meta.Object, pr, -1, minio.PutObjectOptions{
+			ContentType: recordingContentType,
+			PartSize:    recordingPartSize,
+			UserMetadata: map[string]string{
+				"connection-id": meta.ConnectionID,
+				"tunnel-uuid":   meta.TunnelUUID,
+				"pod-name":      meta.PodName,
+			},
+		})
+		if err != nil {
+			// unblock the writer so the session is not stalled by a failed upload
+			_ = pr.CloseWithError(err)
+		} else {
+			w.size = info.Size
+		}
+		w.done <- err
+	}()
+
+	logrus.Infof("Started recording session %s to %s/%s", meta.ConnectionID, s.bucket, meta.Object)
+	return w, nil
+}
+
+// List returns the metadata of the recorded segments of a session, oldest first
+func (s *RecordingStore) List(ctx context.Context, connectionID string) ([]RecordingMetadata, error) {
+	segments := []RecordingMetadata{}
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: recordingSessionPrefix(connectionID)}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list recordings: %w", object.Err)
+		}
+		if !strings.HasSuffix(object.Key, ".json") {
+			continue
+		}
+		meta, err := s.getMetadata(ctx, object.Key)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, *meta)
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("%w for session %s", ErrRecordingNotFound, connectionID)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].StartedAt.Before(segments[j].StartedAt)
+	})
+	return segments, nil
+}
+
+// Open returns a reader for the stored recording of one tunnel of a session, or of all its
+// tunnels one after the other if tunnelUUID is empty
+func (s *RecordingStore) Open(ctx context.Context, connectionID, tunnelUUID string) (io.ReadCloser, error) {
+	if tunnelUUID != "" {
+		return s.openObject(ctx, RecordingObjectName(connectionID, tunnelUUID))
+	}
+
+	segments, err := s.List(ctx, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]string, len(segments))
+	for i, segment := range segments {
+		objects[i] = segment.Object
+	}
+	return &segmentsReader{ctx: ctx, store: s, objects: objects}, nil
+}
+
+func (s *RecordingStore) openObject(ctx context.Context, object string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, object, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy, stat it so a missing recording is reported here rather than on first read
+	if _, err = obj.Stat(); err != nil {
+		_ = obj.Close()
+		return nil, fmt.Errorf("%w: %s: %v", ErrRecordingNotFound, object, err)
+	}
+	return obj, nil
+}
+
+func (s *RecordingStore) getMetadata(ctx context.Context, object string) (*RecordingMetadata, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, object, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := obj.Close(); err != nil {
+			logrus.Errorf("Failed to close recording metadata object: %v", err)
+		}
+	}()
+
+	var meta RecordingMetadata
+	if err := json.NewDecoder(obj).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("failed to decode recording metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+func (s *RecordingStore) putMetadata(ctx context.Context, meta *RecordingMetadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, RecordingMetadataObjectName(meta.ConnectionID, meta.TunnelUUID), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	return err
+}
+
+// segmentsReader reads the recorded segments of a session one after the other, opening
+// each once the previous one is read
+type segmentsReader struct {
+	ctx     context.Context
+	store   *RecordingStore
+	objects []string
+	current io.ReadCloser
+}
+
+func (r *segmentsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.objects) == 0 {
+				return 0, io.EOF
+			}
+			current, err := r.store.openObject(r.ctx, r.objects[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current, r.objects = current, r.objects[1:]
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			_ = r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *segmentsReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
+
+// recordingWriter streams a recording into MinIO through a pipe
+type recordingWriter struct {
+	store *RecordingStore
+	pipe  *io.PipeWriter
+	meta  RecordingMetadata
+	size  int64
+	done  chan error
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+// MarkTruncated records in the metadata that the end of the recording is missing
+func (w *recordingWriter) MarkTruncated() {
+	w.meta.Truncated = true
+}
+
+// Close finishes the upload and records the stop time and final size
+func (w *recordingWriter) Close() error {
+	w.closeOnce.Do(func() {
+		_ = w.pipe.Close()
+		uploadErr := <-w.done
+
+		w.meta.StoppedAt = time.Now()
+		w.meta.Size = w.size
+		if uploadErr != nil {
+			w.meta.Error = uploadErr.Error()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := w.store.putMetadata(ctx, &w.meta); err != nil {
+			logrus.Errorf("Failed to store stop metadata for recording %s: %v", w.meta.ConnectionID, err)
+			if uploadErr == nil {
+				uploadErr = err
+			}
+		}
+
+		if uploadErr != nil {
+			w.closeErr = fmt.Errorf("recording upload failed: %w", uploadErr)
+			return
+		}
+		logrus.Infof("Stored recording for session %s (%d bytes)", w.meta.ConnectionID, w.meta.Size)
+	})
+	return w.closeErr
+}
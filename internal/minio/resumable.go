@@ -0,0 +1,108 @@
+package minio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const resumablePrefix = "resumable/"
+
+// ResumablePartSize is the size of the parts resumable uploads are stored in, the smallest
+// part MinIO accepts for all but the last part of a multipart upload
+const ResumablePartSize = 5 << 20
+
+// ResumableStore assembles uploads sent in several requests in MinIO multipart uploads.
+// MinIO removes multipart uploads that are never completed after a while.
+type ResumableStore struct {
+	core   minio.Core
+	bucket string
+}
+
+// NewResumableStore creates a resumable upload store backed by the given bucket
+func NewResumableStore(client *MinioClient, bucket string) *ResumableStore {
+	return &ResumableStore{
+		core:   minio.Core{Client: client.Client},
+		bucket: bucket,
+	}
+}
+
+// ResumableObjectName returns the object key of a resumable upload of a session
+func ResumableObjectName(connectionID, uploadID string) string {
+	return resumablePrefix + connectionID + "/" + uploadID
+}
+
+func pendingObjectName(object string) string {
+	return object + ".pending"
+}
+
+// Start starts the multipart upload of an object and returns its ID
+func (s *ResumableStore) Start(ctx context.Context, object string) (string, error) {
+	return s.core.NewMultipartUpload(ctx, s.bucket, object, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+}
+
+// PutPart stores a part of a multipart upload and returns its ETag
+func (s *ResumableStore) PutPart(ctx context.Context, object, multipartID string, number int, data []byte) (string, error) {
+	part, err := s.core.PutObjectPart(ctx, s.bucket, object, multipartID, number, bytes.NewReader(data), int64(len(data)), minio.PutObjectPartOptions{})
+	if err != nil {
+		return "", err
+	}
+	return part.ETag, nil
+}
+
+// Complete assembles the object from the parts of its multipart upload
+func (s *ResumableStore) Complete(ctx context.Context, object, multipartID string, parts []minio.CompletePart) error {
+	_, err := s.core.CompleteMultipartUpload(ctx, s.bucket, object, multipartID, parts, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+// Abort cancels a multipart upload and removes its pending data
+func (s *ResumableStore) Abort(ctx context.Context, object, multipartID string) error {
+	_ = s.RemovePending(ctx, object)
+	return s.core.AbortMultipartUpload(ctx, s.bucket, object, multipartID)
+}
+
+// PutPending stores the data of an upload that does not fill a part yet
+func (s *ResumableStore) PutPending(ctx context.Context, object string, data []byte) error {
+	_, err := s.core.Client.PutObject(ctx, s.bucket, pendingObjectName(object), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+// ReadPending reads the pending data of an upload into buf, which must have its size
+func (s *ResumableStore) ReadPending(ctx context.Context, object string, buf []byte) error {
+	obj, err := s.core.Client.GetObject(ctx, s.bucket, pendingObjectName(object), minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = obj.Close()
+	}()
+	if _, err := io.ReadFull(obj, buf); err != nil {
+		return fmt.Errorf("pending data of %s: %w", object, err)
+	}
+	return nil
+}
+
+// RemovePending removes the pending data of an upload
+func (s *ResumableStore) RemovePending(ctx context.Context, object string) error {
+	return s.core.Client.RemoveObject(ctx, s.bucket, pendingObjectName(object), minio.RemoveObjectOptions{})
+}
+
+// Open returns a reader for a completed upload
+func (s *ResumableStore) Open(ctx context.Context, object string) (io.ReadCloser, error) {
+	return s.core.Client.GetObject(ctx, s.bucket, object, minio.GetObjectOptions{})
+}
+
+// Remove deletes a completed upload
+func (s *ResumableStore) Remove(ctx context.Context, object string) error {
+	return s.core.Client.RemoveObject(ctx, s.bucket, object, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,170 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Actions of a policy when no rule matches
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Policy is the URL filtering policy of a sandbox profile. Domains match themselves and
+// all their subdomains, categories are assigned to domains with SetDomainCategories.
+type Policy struct {
+	ID              uuid.UUID `json:"id"`
+	Profile         string    `json:"profile"`
+	DefaultAction   string    `json:"default_action"`
+	AllowDomains    []string  `json:"allow_domains"`
+	DenyDomains     []string  `json:"deny_domains"`
+	AllowCategories []string  `json:"allow_categories"`
+	DenyCategories  []string  `json:"deny_categories"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// ValidationError is returned for invalid policies, domains and categories
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func invalid(format string, args ...any) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// maxCategoryLength is the length of the category column
+const maxCategoryLength = 100
+
+// Decision is the result of checking a host against a policy
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+// normalize lower-cases the lists of the policy and checks that it is usable
+func (p *Policy) normalize() error {
+	if p.Profile == "" {
+		return invalid("profile is required")
+	}
+	if p.DefaultAction == "" {
+		p.DefaultAction = ActionAllow
+	}
+	if p.DefaultAction != ActionAllow && p.DefaultAction != ActionDeny {
+		return invalid("default_action must be %q or %q", ActionAllow, ActionDeny)
+	}
+
+	var err error
+	if p.AllowDomains, err = normalizeDomains(p.AllowDomains); err != nil {
+		return err
+	}
+	if p.DenyDomains, err = normalizeDomains(p.DenyDomains); err != nil {
+		return err
+	}
+	if p.AllowCategories, err = normalizeCategories(p.AllowCategories); err != nil {
+		return err
+	}
+	p.DenyCategories, err = normalizeCategories(p.DenyCategories)
+	return err
+}
+
+// Evaluate decides whether a host with the given categories may be visited. Domain rules
+// take precedence over category rules, and deny rules over allow rules of the same kind.
+func (p *Policy) Evaluate(host string, categories []string) Decision {
+	host = NormalizeDomain(host)
+
+	if domain, ok := matchDomain(host, p.DenyDomains); ok {
+		return Decision{Reason: "domain " + domain + " is denied"}
+	}
+	if _, ok := matchDomain(host, p.AllowDomains); ok {
+		return Decision{Allowed: true}
+	}
+	if category, ok := matchCategory(categories, p.DenyCategories); ok {
+		return Decision{Reason: "category " + category + " is denied"}
+	}
+	if _, ok := matchCategory(categories, p.AllowCategories); ok {
+		return Decision{Allowed: true}
+	}
+
+	if p.DefaultAction == ActionDeny {
+		return Decision{Reason: "not allowed by policy " + p.Profile}
+	}
+	return Decision{Allowed: true}
+}
+
+// NormalizeDomain lower-cases a domain and strips a trailing dot and wildcard prefix
+func NormalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "*.")
+	return strings.TrimSuffix(domain, ".")
+}
+
+func normalizeDomains(domains []string) ([]string, error) {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domain = NormalizeDomain(domain)
+		if domain == "" || strings.ContainsAny(domain, "/: ") {
+			return nil, invalid("invalid domain %q", domain)
+		}
+		normalized = append(normalized, domain)
+	}
+	return normalized, nil
+}
+
+func normalizeCategories(categories []string) ([]string, error) {
+	normalized := make([]string, 0, len(categories))
+	for _, category := range categories {
+		category = strings.ToLower(strings.TrimSpace(category))
+		if category == "" {
+			continue
+		}
+		if len(category) > maxCategoryLength || strings.ContainsAny(category, ",\r\n\t") {
+			return nil, invalid("invalid category %q", category)
+		}
+		normalized = append(normalized, category)
+	}
+	return normalized, nil
+}
+
+// matchDomain returns the domain of the list that host is or is a subdomain of
+func matchDomain(host string, domains []string) (string, bool) {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return domain, true
+		}
+	}
+	return "", false
+}
+
+func matchCategory(categories, rules []string) (string, bool) {
+	for _, category := range categories {
+		for _, rule := range rules {
+			if category == rule {
+				return category, true
+			}
+		}
+	}
+	return "", false
+}
+
+// parentDomains returns host and every domain above it, e.g. a.example.com, example.com, com
+func parentDomains(host string) []string {
+	var domains []string
+	for host != "" {
+		domains = append(domains, host)
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return domains
+}
@@ -0,0 +1,122 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy := &Policy{
+		Profile:         "browser",
+		DefaultAction:   ActionAllow,
+		AllowDomains:    []string{"docs.example.com"},
+		DenyDomains:     []string{"example.com"},
+		AllowCategories: []string{"news"},
+		DenyCategories:  []string{"gambling"},
+	}
+
+	for _, test := range []struct {
+		host       string
+		categories []string
+		want       bool
+	}{
+		{"example.com", nil, false},
+		{"www.example.com", nil, false},
+		{"EXAMPLE.COM.", nil, false},
+		// suffixes only match at a label boundary
+		{"evil-example.com", nil, true},
+		{"example.com.evil.net", nil, true},
+		// deny rules win over allow rules of the same kind
+		{"docs.example.com", nil, false},
+		{"casino.net", []string{"gambling"}, false},
+		{"casino.net", []string{"news", "gambling"}, false},
+		{"paper.net", []string{"news"}, true},
+		{"other.net", nil, true},
+	} {
+		if got := policy.Evaluate(test.host, test.categories); got.Allowed != test.want {
+			t.Errorf("Evaluate(%s, %v)=%+v, want allowed=%v", test.host, test.categories, got, test.want)
+		}
+	}
+}
+
+func TestPolicyEvaluateDomainsBeforeCategories(t *testing.T) {
+	policy := &Policy{
+		Profile:        "browser",
+		DefaultAction:  ActionDeny,
+		AllowDomains:   []string{"example.com"},
+		DenyCategories: []string{"social"},
+	}
+
+	for _, test := range []struct {
+		host       string
+		categories []string
+		want       bool
+	}{
+		{"www.example.com", []string{"social"}, true},
+		{"social.net", []string{"social"}, false},
+		{"unknown.net", nil, false},
+	} {
+		if got := policy.Evaluate(test.host, test.categories); got.Allowed != test.want {
+			t.Errorf("Evaluate(%s, %v)=%+v, want allowed=%v", test.host, test.categories, got, test.want)
+		}
+	}
+}
+
+func TestServiceCheck(t *testing.T) {
+	s := &Service{
+		policies: map[string]*Policy{
+			"browser": {Profile: "browser", DefaultAction: ActionAllow, DenyCategories: []string{"gambling"}},
+		},
+		categories: map[string][]string{
+			"casino.com": {"gambling"},
+		},
+	}
+
+	for _, test := range []struct {
+		profile string
+		host    string
+		want    bool
+	}{
+		{"browser", "casino.com", false},
+		// subdomains inherit the categories of their parents
+		{"browser", "www.casino.com", false},
+		{"browser", "notcasino.com", true},
+		// profiles without a policy are not filtered
+		{"office", "casino.com", true},
+	} {
+		if got := s.Check(test.profile, test.host); got.Allowed != test.want {
+			t.Errorf("Check(%s, %s)=%+v, want allowed=%v", test.profile, test.host, got, test.want)
+		}
+	}
+
+	if got := (*Service)(nil).Check("browser", "casino.com"); !got.Allowed {
+		t.Error("Check without a service blocked the host")
+	}
+}
+
+func TestPolicyNormalize(t *testing.T) {
+	policy := &Policy{
+		Profile:         "browser",
+		AllowDomains:    []string{" *.Example.COM. "},
+		AllowCategories: []string{" News ", ""},
+	}
+	if err := policy.normalize(); err != nil {
+		t.Fatalf("normalize returned %v", err)
+	}
+	if policy.DefaultAction != ActionAllow || policy.AllowDomains[0] != "example.com" || len(policy.AllowCategories) != 1 || policy.AllowCategories[0] != "news" {
+		t.Errorf("normalize gave %+v", policy)
+	}
+
+	for _, invalid := range []*Policy{
+		{},
+		{Profile: "browser", DefaultAction: "maybe"},
+		{Profile: "browser", DenyDomains: []string{"example.com/path"}},
+		{Profile: "browser", DenyDomains: []string{"example.com:443"}},
+		{Profile: "browser", DenyCategories: []string{"a,b"}},
+	} {
+		var validation *ValidationError
+		if err := invalid.normalize(); !errors.As(err, &validation) {
+			t.Errorf("normalize(%+v) returned %v, want a ValidationError", invalid, err)
+		}
+	}
+}
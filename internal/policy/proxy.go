@@ -0,0 +1,306 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/browsersec/KubeBrowse/internal/k8s"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// How long the proxy remembers which sandbox a pod IP belongs to
+const sandboxCacheTTL = 10 * time.Second
+
+// Sandbox identifies the sandbox pod a proxied request came from
+type Sandbox struct {
+	PodName      string
+	Profile      string
+	ConnectionID string
+}
+
+type sandboxContextKey struct{}
+
+// blockedError is returned when a destination is refused by policy
+type blockedError struct {
+	reason string
+}
+
+func (e *blockedError) Error() string {
+	return "blocked: " + e.reason
+}
+
+// PodResolver finds the sandbox pod behind the source address of a proxied request
+type PodResolver struct {
+	clientset *kubernetes.Clientset
+	namespace string
+
+	mu    sync.Mutex
+	cache map[string]resolvedSandbox
+}
+
+type resolvedSandbox struct {
+	sandbox   *Sandbox
+	expiresAt time.Time
+}
+
+func NewPodResolver(clientset *kubernetes.Clientset, namespace string) *PodResolver {
+	return &PodResolver{
+		clientset: clientset,
+		namespace: namespace,
+		cache:     make(map[string]resolvedSandbox),
+	}
+}
+
+// Resolve returns the sandbox pod with the given IP
+func (r *PodResolver) Resolve(ctx context.Context, ip string) (*Sandbox, error) {
+	r.mu.Lock()
+	if cached, ok := r.cache[ip]; ok && time.Now().Before(cached.expiresAt) {
+		r.mu.Unlock()
+		return cached.sandbox, nil
+	}
+	r.mu.Unlock()
+
+	pods, err := r.clientset.CoreV1().Pods(r.namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "status.podIP=" + ip,
+		LabelSelector: k8s.SandboxTypeLabel,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up pod with IP %s: %w", ip, err)
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("no sandbox pod with IP %s", ip)
+	}
+
+	pod := pods.Items[0]
+	sandbox := &Sandbox{
+		PodName:      pod.Name,
+		Profile:      pod.Labels[k8s.SandboxTypeLabel],
+		ConnectionID: pod.Annotations[k8s.ConnectionIDAnnotation],
+	}
+
+	r.mu.Lock()
+	for cachedIP, cached := range r.cache {
+		if time.Now().After(cached.expiresAt) {
+			delete(r.cache, cachedIP)
+		}
+	}
+	r.cache[ip] = resolvedSandbox{sandbox: sandbox, expiresAt: time.Now().Add(sandboxCacheTTL)}
+	r.mu.Unlock()
+	return sandbox, nil
+}
+
+// Proxy is the HTTP egress proxy of sandboxes with URL filtering. Plain HTTP requests are
+// forwarded and HTTPS is tunnelled with CONNECT. The host of every request is checked
+// against the URL policy of the sandbox profile, and the addresses it resolves to against
+// the egress policy of the profile, since the NetworkPolicy of the sandbox only lets it
+// reach the proxy.
+type Proxy struct {
+	policies *Service
+	resolver *PodResolver
+	dialer   *net.Dialer
+	forward  *httputil.ReverseProxy
+}
+
+func NewProxy(policies *Service, resolver *PodResolver) *Proxy {
+	p := &Proxy{
+		policies: policies,
+		resolver: resolver,
+		dialer:   &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second},
+	}
+	p.forward = &httputil.ReverseProxy{
+		// the request already carries the absolute URL of the destination, the Host header is
+		// set from it so that a sandbox cannot reach a denied virtual host of an allowed address
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.Host = pr.Out.URL.Host
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				sandbox, _ := ctx.Value(sandboxContextKey{}).(*Sandbox)
+				return p.dial(ctx, sandbox, addr)
+			},
+			// connections are dialed for a single sandbox and must not be shared
+			DisableKeepAlives:     true,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 60 * time.Second,
+		},
+		ErrorHandler: p.forwardError,
+	}
+	return p
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+
+	sandbox, err := p.resolver.Resolve(r.Context(), remoteIP)
+	if err != nil {
+		logrus.Warnf("Egress proxy refused request from %s: %v", remoteIP, err)
+		http.Error(w, "Unknown sandbox", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r, sandbox)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		http.Error(w, "Absolute URL required", http.StatusBadRequest)
+		return
+	}
+	if decision := p.policies.Check(sandbox.Profile, r.URL.Hostname()); !decision.Allowed {
+		p.block(w, sandbox, r.URL.Hostname(), r.URL.String(), decision.Reason)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), sandboxContextKey{}, sandbox)
+	p.forward.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// tunnel handles CONNECT by splicing the client connection onto the destination
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request, sandbox *Sandbox) {
+	host, err := connectHost(r.Host)
+	if err != nil {
+		http.Error(w, "Invalid CONNECT host", http.StatusBadRequest)
+		return
+	}
+	requestURL := "https://" + r.Host
+
+	if decision := p.policies.Check(sandbox.Profile, host); !decision.Allowed {
+		p.block(w, sandbox, host, requestURL, decision.Reason)
+		return
+	}
+
+	upstream, err := p.dial(r.Context(), sandbox, r.Host)
+	if err != nil {
+		var blocked *blockedError
+		if errors.As(err, &blocked) {
+			p.block(w, sandbox, host, requestURL, blocked.reason)
+			return
+		}
+		logrus.Debugf("Egress proxy failed to connect to %s for %s: %v", r.Host, sandbox.PodName, err)
+		http.Error(w, "Failed to connect", http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		_ = upstream.Close()
+		http.Error(w, "Tunnelling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		_ = upstream.Close()
+		logrus.Errorf("Egress proxy failed to hijack connection: %v", err)
+		return
+	}
+
+	if _, err = client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		_ = client.Close()
+		_ = upstream.Close()
+		return
+	}
+
+	go func() {
+		// the client may have sent data along with the CONNECT request
+		_, _ = io.Copy(upstream, buffered)
+		closeWrite(upstream)
+	}()
+	_, _ = io.Copy(client, upstream)
+	_ = client.Close()
+	_ = upstream.Close()
+}
+
+// connectHost returns the host of the host:port target of a CONNECT request
+func connectHost(target string) (string, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", err
+	}
+	if host == "" || port == "" {
+		return "", fmt.Errorf("invalid CONNECT target %q", target)
+	}
+	return host, nil
+}
+
+// dial connects to addr on behalf of a sandbox. Only addresses the egress policy of the
+// profile allows are dialed, and the checked address is used so DNS cannot change it.
+func (p *Proxy) dial(ctx context.Context, sandbox *Sandbox, addr string) (net.Conn, error) {
+	if sandbox == nil {
+		return nil, &blockedError{reason: "unknown sandbox"}
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	egress := k8s.DefaultEgressPolicy()
+	if profile, ok := k8s.GetSandboxProfile(sandbox.Profile); ok {
+		egress = k8s.EgressProfilePolicy(profile)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range addrs {
+		if egress.Allows(ip.IP) {
+			return p.dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.IP.String(), port))
+		}
+	}
+	return nil, &blockedError{reason: "destination address is not allowed by egress mode " + egress.Mode}
+}
+
+func (p *Proxy) forwardError(w http.ResponseWriter, r *http.Request, err error) {
+	sandbox, _ := r.Context().Value(sandboxContextKey{}).(*Sandbox)
+	var blocked *blockedError
+	if sandbox != nil && errors.As(err, &blocked) {
+		p.block(w, sandbox, r.URL.Hostname(), r.URL.String(), blocked.reason)
+		return
+	}
+	logrus.Debugf("Egress proxy failed to forward %s: %v", r.URL.Host, err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// block refuses a request and adds it to the blocked request log of the session
+func (p *Proxy) block(w http.ResponseWriter, sandbox *Sandbox, host, requestURL, reason string) {
+	logrus.Infof("Egress proxy blocked %s for session %s (pod %s): %s", host, sandbox.ConnectionID, sandbox.PodName, reason)
+	http.Error(w, "Blocked by policy: "+reason, http.StatusForbidden)
+
+	request := BlockedRequest{
+		ConnectionID: sandbox.ConnectionID,
+		PodName:      sandbox.PodName,
+		Profile:      sandbox.Profile,
+		Host:         strings.ToLower(host),
+		URL:          requestURL,
+		Reason:       reason,
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := p.policies.LogBlocked(ctx, request); err != nil {
+			logrus.Warnf("Failed to log blocked request of session %s: %v", request.ConnectionID, err)
+		}
+	}()
+}
+
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+		return
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,59 @@
+package policy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+	"time"
+)
+
+func TestProxyForwardsHostOfURL(t *testing.T) {
+	p := NewProxy(nil, nil)
+
+	in := httptest.NewRequest("GET", "http://allowed.example/", nil)
+	in.Host = "blocked.example"
+	pr := &httputil.ProxyRequest{In: in, Out: in.Clone(in.Context())}
+	p.forward.Rewrite(pr)
+
+	if pr.Out.Host != "allowed.example" {
+		t.Errorf("forwarded Host=%q, want allowed.example", pr.Out.Host)
+	}
+}
+
+func TestConnectHost(t *testing.T) {
+	for _, test := range []struct {
+		target string
+		want   string
+		valid  bool
+	}{
+		{"example.com:443", "example.com", true},
+		{"[2001:db8::1]:443", "2001:db8::1", true},
+		{"example.com", "", false},
+		{":443", "", false},
+		{"example.com:", "", false},
+		{"2001:db8::1:443", "", false},
+	} {
+		host, err := connectHost(test.target)
+		if (err == nil) != test.valid || host != test.want {
+			t.Errorf("connectHost(%s)=%q, %v, want %q valid=%v", test.target, host, err, test.want, test.valid)
+		}
+	}
+}
+
+func TestProxyRefusesInvalidConnect(t *testing.T) {
+	resolver := &PodResolver{cache: map[string]resolvedSandbox{
+		"192.0.2.1": {sandbox: &Sandbox{PodName: "browser-1", Profile: "browser"}, expiresAt: time.Now().Add(time.Minute)},
+	}}
+	p := NewProxy(nil, resolver)
+
+	r := httptest.NewRequest(http.MethodConnect, "http://example.com", nil)
+	r.Host = "example.com"
+	r.RemoteAddr = "192.0.2.1:40000"
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, r)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("CONNECT without a port got status %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
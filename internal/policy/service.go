@@ -0,0 +1,335 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	sqlc "github.com/browsersec/KubeBrowse/db/sqlc"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrPolicyNotFound = errors.New("policy not found")
+	ErrDomainNotFound = errors.New("domain has no categories")
+)
+
+// BlockedRequest is a request of a sandbox that the egress proxy refused
+type BlockedRequest struct {
+	ID           int64     `json:"id"`
+	ConnectionID string    `json:"connection_id"`
+	PodName      string    `json:"pod_name"`
+	Profile      string    `json:"profile"`
+	Host         string    `json:"host"`
+	URL          string    `json:"url"`
+	Reason       string    `json:"reason"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Service stores URL filtering policies in Postgres. Policies and domain categories are
+// cached in memory for the egress proxy and reloaded periodically, so that changes made
+// through other API replicas are picked up.
+type Service struct {
+	db     *sqlc.Queries
+	dbConn *sql.DB
+
+	mu         sync.RWMutex
+	policies   map[string]*Policy
+	categories map[string][]string
+
+	stopChan chan struct{}
+}
+
+func NewService(db *sqlc.Queries, dbConn *sql.DB) *Service {
+	return &Service{
+		db:         db,
+		dbConn:     dbConn,
+		policies:   make(map[string]*Policy),
+		categories: make(map[string][]string),
+		stopChan:   make(chan struct{}),
+	}
+}
+
+// Start loads the policies and keeps reloading them every interval
+func (s *Service) Start(interval time.Duration) {
+	if err := s.Reload(context.Background()); err != nil {
+		logrus.Errorf("Failed to load egress policies: %v", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-ticker.C:
+				if err := s.Reload(context.Background()); err != nil {
+					logrus.Errorf("Failed to reload egress policies: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops reloading the policies
+func (s *Service) Stop() {
+	close(s.stopChan)
+}
+
+// Reload replaces the cached policies and domain categories with those in the database
+func (s *Service) Reload(ctx context.Context) error {
+	dbPolicies, err := s.db.ListEgressPolicies(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list egress policies: %w", err)
+	}
+	dbCategories, err := s.db.ListDomainCategories(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list domain categories: %w", err)
+	}
+
+	policies := make(map[string]*Policy, len(dbPolicies))
+	for _, dbPolicy := range dbPolicies {
+		policies[dbPolicy.Profile] = convertDBPolicy(dbPolicy)
+	}
+	categories := make(map[string][]string)
+	for _, dbCategory := range dbCategories {
+		categories[dbCategory.Domain] = append(categories[dbCategory.Domain], dbCategory.Category)
+	}
+
+	s.mu.Lock()
+	s.policies = policies
+	s.categories = categories
+	s.mu.Unlock()
+	return nil
+}
+
+// Check decides whether a sandbox of the profile may visit host. Profiles without a policy
+// are not filtered, and neither is anything when no service is configured.
+func (s *Service) Check(profile, host string) Decision {
+	if s == nil {
+		return Decision{Allowed: true}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	policy, ok := s.policies[profile]
+	if !ok {
+		return Decision{Allowed: true}
+	}
+
+	var categories []string
+	for _, domain := range parentDomains(NormalizeDomain(host)) {
+		categories = append(categories, s.categories[domain]...)
+	}
+	return policy.Evaluate(host, categories)
+}
+
+// ListPolicies returns all policies
+func (s *Service) ListPolicies(ctx context.Context) ([]*Policy, error) {
+	dbPolicies, err := s.db.ListEgressPolicies(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list egress policies: %w", err)
+	}
+	policies := make([]*Policy, 0, len(dbPolicies))
+	for _, dbPolicy := range dbPolicies {
+		policies = append(policies, convertDBPolicy(dbPolicy))
+	}
+	return policies, nil
+}
+
+// GetPolicy returns the policy of a profile
+func (s *Service) GetPolicy(ctx context.Context, profile string) (*Policy, error) {
+	dbPolicy, err := s.db.GetEgressPolicy(ctx, profile)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPolicyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get egress policy: %w", err)
+	}
+	return convertDBPolicy(dbPolicy), nil
+}
+
+// PutPolicy creates or replaces the policy of a profile
+func (s *Service) PutPolicy(ctx context.Context, policy Policy) (*Policy, error) {
+	if err := policy.normalize(); err != nil {
+		return nil, err
+	}
+
+	dbPolicy, err := s.db.UpsertEgressPolicy(ctx, sqlc.UpsertEgressPolicyParams{
+		Profile:         policy.Profile,
+		DefaultAction:   policy.DefaultAction,
+		AllowDomains:    policy.AllowDomains,
+		DenyDomains:     policy.DenyDomains,
+		AllowCategories: policy.AllowCategories,
+		DenyCategories:  policy.DenyCategories,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save egress policy: %w", err)
+	}
+
+	saved := convertDBPolicy(dbPolicy)
+	s.mu.Lock()
+	s.policies[saved.Profile] = saved
+	s.mu.Unlock()
+	return saved, nil
+}
+
+// DeletePolicy deletes the policy of a profile, which disables its URL filtering
+func (s *Service) DeletePolicy(ctx context.Context, profile string) error {
+	deleted, err := s.db.DeleteEgressPolicy(ctx, profile)
+	if err != nil {
+		return fmt.Errorf("failed to delete egress policy: %w", err)
+	}
+	if deleted == 0 {
+		return ErrPolicyNotFound
+	}
+
+	s.mu.Lock()
+	delete(s.policies, profile)
+	s.mu.Unlock()
+	return nil
+}
+
+// ListDomainCategories returns the categories of every categorised domain
+func (s *Service) ListDomainCategories(ctx context.Context) (map[string][]string, error) {
+	dbCategories, err := s.db.ListDomainCategories(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domain categories: %w", err)
+	}
+	categories := make(map[string][]string)
+	for _, dbCategory := range dbCategories {
+		categories[dbCategory.Domain] = append(categories[dbCategory.Domain], dbCategory.Category)
+	}
+	return categories, nil
+}
+
+// SetDomainCategories replaces the categories of a domain. Subdomains inherit them. Invalid
+// domains and categories are refused with a ValidationError.
+func (s *Service) SetDomainCategories(ctx context.Context, domain string, categories []string) error {
+	domains, err := normalizeDomains([]string{domain})
+	if err != nil {
+		return err
+	}
+	domain = domains[0]
+	if categories, err = normalizeCategories(categories); err != nil {
+		return err
+	}
+	sort.Strings(categories)
+
+	tx, err := s.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			logrus.Warnf("Failed to roll back domain categories of %s: %v", domain, err)
+		}
+	}()
+
+	queries := s.db.WithTx(tx)
+	if _, err = queries.DeleteDomainCategories(ctx, domain); err != nil {
+		return fmt.Errorf("failed to delete domain categories: %w", err)
+	}
+	for _, category := range categories {
+		if err = queries.AddDomainCategory(ctx, sqlc.AddDomainCategoryParams{Domain: domain, Category: category}); err != nil {
+			return fmt.Errorf("failed to add domain category: %w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save domain categories: %w", err)
+	}
+
+	s.mu.Lock()
+	s.categories[domain] = categories
+	s.mu.Unlock()
+	return nil
+}
+
+// DeleteDomainCategories removes all categories of a domain
+func (s *Service) DeleteDomainCategories(ctx context.Context, domain string) error {
+	domain = NormalizeDomain(domain)
+	deleted, err := s.db.DeleteDomainCategories(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("failed to delete domain categories: %w", err)
+	}
+	if deleted == 0 {
+		return ErrDomainNotFound
+	}
+
+	s.mu.Lock()
+	delete(s.categories, domain)
+	s.mu.Unlock()
+	return nil
+}
+
+// LogBlocked records a request the egress proxy refused
+func (s *Service) LogBlocked(ctx context.Context, request BlockedRequest) error {
+	if s == nil {
+		return nil
+	}
+	_, err := s.db.CreateBlockedRequest(ctx, sqlc.CreateBlockedRequestParams{
+		ConnectionID: request.ConnectionID,
+		PodName:      request.PodName,
+		Profile:      request.Profile,
+		Host:         request.Host,
+		Url:          request.URL,
+		Reason:       request.Reason,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to log blocked request: %w", err)
+	}
+	return nil
+}
+
+// ListBlocked returns the most recent blocked requests, only those of a session if
+// connectionID is set
+func (s *Service) ListBlocked(ctx context.Context, connectionID string, limit int32) ([]BlockedRequest, error) {
+	var dbRequests []sqlc.EgressBlockedRequest
+	var err error
+	if connectionID != "" {
+		dbRequests, err = s.db.ListBlockedRequestsByConnection(ctx, sqlc.ListBlockedRequestsByConnectionParams{
+			ConnectionID: connectionID,
+			Limit:        limit,
+		})
+	} else {
+		dbRequests, err = s.db.ListBlockedRequests(ctx, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocked requests: %w", err)
+	}
+
+	requests := make([]BlockedRequest, 0, len(dbRequests))
+	for _, dbRequest := range dbRequests {
+		requests = append(requests, BlockedRequest{
+			ID:           dbRequest.ID,
+			ConnectionID: dbRequest.ConnectionID,
+			PodName:      dbRequest.PodName,
+			Profile:      dbRequest.Profile,
+			Host:         dbRequest.Host,
+			URL:          dbRequest.Url,
+			Reason:       dbRequest.Reason,
+			CreatedAt:    dbRequest.CreatedAt,
+		})
+	}
+	return requests, nil
+}
+
+func convertDBPolicy(dbPolicy sqlc.EgressPolicy) *Policy {
+	return &Policy{
+		ID:              dbPolicy.ID,
+		Profile:         dbPolicy.Profile,
+		DefaultAction:   dbPolicy.DefaultAction,
+		AllowDomains:    dbPolicy.AllowDomains,
+		DenyDomains:     dbPolicy.DenyDomains,
+		AllowCategories: dbPolicy.AllowCategories,
+		DenyCategories:  dbPolicy.DenyCategories,
+		CreatedAt:       dbPolicy.CreatedAt,
+		UpdatedAt:       dbPolicy.UpdatedAt,
+	}
+}
@@ -0,0 +1,196 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Download statuses, only clean downloads are released to the user
+const (
+	DownloadReceiving = "receiving"
+	DownloadScanning  = "scanning"
+	DownloadClean     = "clean"
+	DownloadInfected  = "infected"
+	DownloadFailed    = "failed"
+)
+
+// DownloadTTL is how long downloads are kept after the sandbox sent them
+const DownloadTTL = 24 * time.Hour
+
+var (
+	ErrDownloadNotFound     = errors.New("download not found")
+	ErrDownloadLinkNotFound = errors.New("download link not found")
+)
+
+// Download is a file the sandbox sent to the user, held back until it has been scanned
+type Download struct {
+	ID           string    `json:"id"`
+	ConnectionID string    `json:"connection_id"` // Session the file was sent from
+	Filename     string    `json:"filename"`
+	MimeType     string    `json:"mime_type"`
+	Object       string    `json:"object"` // MinIO object holding the file
+	Size         int64     `json:"size"`
+	Status       string    `json:"status"`
+	Viruses      []string  `json:"viruses,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	ScannedAt    time.Time `json:"scanned_at,omitempty"`
+}
+
+// DownloadLink releases a clean download through a random token until it expires
+type DownloadLink struct {
+	Token        string    `json:"token"`
+	ConnectionID string    `json:"connection_id"`
+	DownloadID   string    `json:"download_id"`
+	ExpireAt     time.Time `json:"expire_at"`
+}
+
+func downloadKey(id string) string {
+	return fmt.Sprintf("download:%s", id)
+}
+
+func sessionDownloadsKey(connectionID string) string {
+	return fmt.Sprintf("downloads:%s", connectionID)
+}
+
+func downloadLinkKey(token string) string {
+	return fmt.Sprintf("download-link:%s", token)
+}
+
+func randomToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(tokenBytes), nil
+}
+
+// NewDownloadID returns a random ID for a new download
+func NewDownloadID() (string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("error generating download ID: %v", err)
+	}
+	return id[:32], nil
+}
+
+// SaveDownload stores a download and adds it to the downloads of its session
+func SaveDownload(client *redis.Client, download *Download) error {
+	ctx := context.Background()
+
+	downloadJSON, err := json.Marshal(download)
+	if err != nil {
+		return fmt.Errorf("error marshaling download: %v", err)
+	}
+
+	downloadsKey := sessionDownloadsKey(download.ConnectionID)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, downloadKey(download.ID), downloadJSON, DownloadTTL)
+		pipe.SAdd(ctx, downloadsKey, download.ID)
+		pipe.Expire(ctx, downloadsKey, DownloadTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error storing download: %v", err)
+	}
+
+	return nil
+}
+
+// GetDownload returns the download with the given ID
+func GetDownload(client *redis.Client, id string) (*Download, error) {
+	ctx := context.Background()
+
+	downloadJSON, err := client.Get(ctx, downloadKey(id)).Result()
+	if err == redis.Nil {
+		return nil, ErrDownloadNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving download: %v", err)
+	}
+
+	var download Download
+	if err = json.Unmarshal([]byte(downloadJSON), &download); err != nil {
+		return nil, fmt.Errorf("error unmarshaling download: %v", err)
+	}
+
+	return &download, nil
+}
+
+// ListDownloads returns the downloads of a session
+func ListDownloads(client *redis.Client, connectionID string) ([]Download, error) {
+	ctx := context.Background()
+
+	ids, err := client.SMembers(ctx, sessionDownloadsKey(connectionID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving downloads: %v", err)
+	}
+
+	downloads := make([]Download, 0, len(ids))
+	for _, id := range ids {
+		download, err := GetDownload(client, id)
+		if errors.Is(err, ErrDownloadNotFound) {
+			// the download expired, drop it from the index
+			client.SRem(ctx, sessionDownloadsKey(connectionID), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		downloads = append(downloads, *download)
+	}
+
+	return downloads, nil
+}
+
+// CreateDownloadLink creates a link to a download that expires after ttl
+func CreateDownloadLink(client *redis.Client, download *Download, ttl time.Duration) (*DownloadLink, error) {
+	ctx := context.Background()
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("error generating download token: %v", err)
+	}
+
+	link := &DownloadLink{
+		Token:        token,
+		ConnectionID: download.ConnectionID,
+		DownloadID:   download.ID,
+		ExpireAt:     time.Now().Add(ttl),
+	}
+	linkJSON, err := json.Marshal(link)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling download link: %v", err)
+	}
+
+	if err = client.Set(ctx, downloadLinkKey(token), linkJSON, ttl).Err(); err != nil {
+		return nil, fmt.Errorf("error storing download link: %v", err)
+	}
+
+	return link, nil
+}
+
+// GetDownloadLink returns the download link with the given token
+func GetDownloadLink(client *redis.Client, token string) (*DownloadLink, error) {
+	ctx := context.Background()
+
+	linkJSON, err := client.Get(ctx, downloadLinkKey(token)).Result()
+	if err == redis.Nil {
+		return nil, ErrDownloadLinkNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving download link: %v", err)
+	}
+
+	var link DownloadLink
+	if err = json.Unmarshal([]byte(linkJSON), &link); err != nil {
+		return nil, fmt.Errorf("error unmarshaling download link: %v", err)
+	}
+
+	return &link, nil
+}
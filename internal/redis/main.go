@@ -3,6 +3,7 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -25,8 +26,14 @@ type SessionData struct {
 	LastExtendedAt     time.Time         `json:"last_extended_at"`
 	TimeoutDuration    time.Duration     `json:"timeout_duration"`
 	ExpireAt           time.Time         `json:"expire_at"` // New field to store absolute expiration
+	Record             bool              `json:"record"`    // Record the guacd output of the session
+	Profile            string            `json:"profile"`   // Sandbox profile the pod was created from
+	OwnerID            string            `json:"owner_id"`  // User who deployed the session, empty without authentication
 }
 
+// ErrSessionNotFound is returned for sessions that expired or never existed
+var ErrSessionNotFound = errors.New("session not found")
+
 var SESSION_TTL int
 
 func init() {
@@ -93,7 +100,7 @@ func GetSessionData(client *redis.Client, podName string) (*SessionData, error)
 	sessionKey := fmt.Sprintf("session:%s", podName)
 	sessionJSON, err := client.Get(ctx, sessionKey).Result()
 	if err == redis.Nil {
-		return nil, fmt.Errorf("session not found for pod: %s", podName)
+		return nil, fmt.Errorf("%w for pod: %s", ErrSessionNotFound, podName)
 	} else if err != nil {
 		return nil, fmt.Errorf("error retrieving session: %v", err)
 	}
@@ -290,7 +297,7 @@ func GetSessionDataWithContext(ctx context.Context, client *redis.Client, podNam
 	sessionKey := fmt.Sprintf("session:%s", podName)
 	sessionJSON, err := client.Get(ctx, sessionKey).Result()
 	if err == redis.Nil {
-		return nil, fmt.Errorf("session not found for pod: %s", podName)
+		return nil, fmt.Errorf("%w for pod: %s", ErrSessionNotFound, podName)
 	} else if err != nil {
 		return nil, fmt.Errorf("error retrieving session: %v", err)
 	}
@@ -0,0 +1,262 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Share link modes
+const (
+	ShareModeViewOnly    = "view-only"
+	ShareModeInteractive = "interactive"
+)
+
+var (
+	ErrShareNotFound = errors.New("share link not found")
+	ErrShareFull     = errors.New("share link has reached its viewer limit")
+)
+
+// ShareLink grants access to a session through a random token until it expires or is revoked
+type ShareLink struct {
+	Token        string    `json:"token"`
+	ConnectionID string    `json:"connection_id"` // Session the link joins
+	Mode         string    `json:"mode"`
+	MaxViewers   int       `json:"max_viewers"`
+	Viewers      int       `json:"viewers"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpireAt     time.Time `json:"expire_at"`
+}
+
+// ShareRevocationsChannel is the pub/sub channel the tokens of revoked share links are
+// published on, so that every API replica disconnects their viewers
+const ShareRevocationsChannel = "shares:revoked"
+
+func shareKey(token string) string {
+	return fmt.Sprintf("share:%s", token)
+}
+
+func shareViewersKey(token string) string {
+	return fmt.Sprintf("share:%s:viewers", token)
+}
+
+func sessionSharesKey(connectionID string) string {
+	return fmt.Sprintf("shares:%s", connectionID)
+}
+
+// joinShareScript counts a viewer of an existing share link unless it is full
+var joinShareScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -2
+end
+local viewers = redis.call("INCR", KEYS[2])
+if viewers > tonumber(ARGV[1]) then
+	redis.call("DECR", KEYS[2])
+	return -1
+end
+return viewers
+`)
+
+// leaveShareScript decrements the viewer count of a share link unless the link is gone,
+// so that revoked links are not recreated without an expiry
+var leaveShareScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("DECR", KEYS[1])
+end
+return 0
+`)
+
+// CreateShareLink creates a share link to a session that expires after ttl
+func CreateShareLink(client *redis.Client, connectionID, mode string, maxViewers int, ttl time.Duration) (*ShareLink, error) {
+	ctx := context.Background()
+
+	if mode != ShareModeViewOnly && mode != ShareModeInteractive {
+		return nil, fmt.Errorf("invalid share mode %q", mode)
+	}
+	if maxViewers <= 0 {
+		return nil, fmt.Errorf("max viewers must be positive")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("expiry must be in the future")
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("error generating share token: %v", err)
+	}
+
+	now := time.Now()
+	share := &ShareLink{
+		Token:        hex.EncodeToString(tokenBytes),
+		ConnectionID: connectionID,
+		Mode:         mode,
+		MaxViewers:   maxViewers,
+		CreatedAt:    now,
+		ExpireAt:     now.Add(ttl),
+	}
+	shareJSON, err := json.Marshal(share)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling share link: %v", err)
+	}
+
+	sharesKey := sessionSharesKey(connectionID)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, shareKey(share.Token), shareJSON, ttl)
+		pipe.Set(ctx, shareViewersKey(share.Token), 0, ttl)
+		pipe.SAdd(ctx, sharesKey, share.Token)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error storing share link: %v", err)
+	}
+
+	// keep the index of the session until its longest lived link expires
+	if keyTTL, err := client.TTL(ctx, sharesKey).Result(); err == nil && keyTTL < ttl {
+		client.Expire(ctx, sharesKey, ttl)
+	}
+
+	return share, nil
+}
+
+// GetShareLink returns the share link with the given token and its current number of viewers
+func GetShareLink(client *redis.Client, token string) (*ShareLink, error) {
+	ctx := context.Background()
+
+	shareJSON, err := client.Get(ctx, shareKey(token)).Result()
+	if err == redis.Nil {
+		return nil, ErrShareNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving share link: %v", err)
+	}
+
+	var share ShareLink
+	if err = json.Unmarshal([]byte(shareJSON), &share); err != nil {
+		return nil, fmt.Errorf("error unmarshaling share link: %v", err)
+	}
+
+	viewers, err := client.Get(ctx, shareViewersKey(token)).Int()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error retrieving share link viewers: %v", err)
+	}
+	share.Viewers = viewers
+
+	return &share, nil
+}
+
+// ListShareLinks returns the active share links of a session
+func ListShareLinks(client *redis.Client, connectionID string) ([]ShareLink, error) {
+	ctx := context.Background()
+
+	tokens, err := client.SMembers(ctx, sessionSharesKey(connectionID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving share links: %v", err)
+	}
+
+	shares := make([]ShareLink, 0, len(tokens))
+	for _, token := range tokens {
+		share, err := GetShareLink(client, token)
+		if errors.Is(err, ErrShareNotFound) {
+			// the link expired, drop it from the index
+			client.SRem(ctx, sessionSharesKey(connectionID), token)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, *share)
+	}
+
+	return shares, nil
+}
+
+// RevokeShareLink deletes a share link of a session and publishes its revocation on
+// ShareRevocationsChannel. It returns the number of viewers the link had.
+func RevokeShareLink(client *redis.Client, connectionID, token string) (int, error) {
+	ctx := context.Background()
+
+	share, err := GetShareLink(client, token)
+	if err != nil {
+		return 0, err
+	}
+	if share.ConnectionID != connectionID {
+		return 0, ErrShareNotFound
+	}
+
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, shareKey(token), shareViewersKey(token))
+		pipe.SRem(ctx, sessionSharesKey(connectionID), token)
+		pipe.Publish(ctx, ShareRevocationsChannel, token)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error revoking share link: %v", err)
+	}
+
+	return share.Viewers, nil
+}
+
+// WatchShareRevocations calls onRevoke with the token of every share link revoked by any
+// API replica until stopChan is closed
+func WatchShareRevocations(client *redis.Client, onRevoke func(token string), stopChan <-chan struct{}) {
+	ctx := context.Background()
+	sub := client.Subscribe(ctx, ShareRevocationsChannel)
+	defer func() {
+		_ = sub.Close()
+	}()
+
+	// the channel of the subscription survives reconnections to Redis
+	messages := sub.Channel()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			onRevoke(msg.Payload)
+		}
+	}
+}
+
+// JoinShareLink counts a new viewer of a share link, failing with ErrShareFull when the
+// link already has its maximum number of viewers
+func JoinShareLink(client *redis.Client, token string) (*ShareLink, error) {
+	ctx := context.Background()
+
+	share, err := GetShareLink(client, token)
+	if err != nil {
+		return nil, err
+	}
+
+	viewers, err := joinShareScript.Run(ctx, client, []string{shareKey(token), shareViewersKey(token)}, share.MaxViewers).Int()
+	if err != nil {
+		return nil, fmt.Errorf("error counting share link viewer: %v", err)
+	}
+	switch viewers {
+	case -2:
+		return nil, ErrShareNotFound
+	case -1:
+		return nil, ErrShareFull
+	}
+
+	share.Viewers = viewers
+	return share, nil
+}
+
+// LeaveShareLink removes a viewer from a share link
+func LeaveShareLink(client *redis.Client, token string) error {
+	ctx := context.Background()
+
+	if err := leaveShareScript.Run(ctx, client, []string{shareViewersKey(token)}).Err(); err != nil && err != redis.Nil {
+		return fmt.Errorf("error removing share link viewer: %v", err)
+	}
+
+	return nil
+}
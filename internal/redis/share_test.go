@@ -0,0 +1,146 @@
+package redis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newTestClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return server, client
+}
+
+func TestJoinShareLinkViewerLimit(t *testing.T) {
+	_, client := newTestClient(t)
+	share, err := CreateShareLink(client, "conn", ShareModeViewOnly, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateShareLink()=%v", err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		leave   bool
+		err     error
+		viewers int
+	}{
+		{"first viewer", false, nil, 1},
+		{"second viewer", false, nil, 2},
+		{"over the limit", false, ErrShareFull, 2},
+		{"viewer leaves", true, nil, 1},
+		{"joins again", false, nil, 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.leave {
+				err = LeaveShareLink(client, share.Token)
+			} else {
+				_, err = JoinShareLink(client, share.Token)
+			}
+			if !errors.Is(err, test.err) {
+				t.Fatalf("err=%v, want %v", err, test.err)
+			}
+			current, err := GetShareLink(client, share.Token)
+			if err != nil {
+				t.Fatalf("GetShareLink()=%v", err)
+			}
+			if current.Viewers != test.viewers {
+				t.Errorf("viewers=%d, want %d", current.Viewers, test.viewers)
+			}
+		})
+	}
+}
+
+func TestRevokeShareLink(t *testing.T) {
+	server, client := newTestClient(t)
+	share, err := CreateShareLink(client, "conn", ShareModeInteractive, 5, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateShareLink()=%v", err)
+	}
+	if _, err = JoinShareLink(client, share.Token); err != nil {
+		t.Fatalf("JoinShareLink()=%v", err)
+	}
+
+	revoked := make(chan string, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go WatchShareRevocations(client, func(token string) {
+		revoked <- token
+	}, stop)
+	// the revocation is only received once the watcher subscribed
+	deadline := time.Now().Add(time.Second)
+	for len(server.PubSubChannels("")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err = RevokeShareLink(client, "other", share.Token); !errors.Is(err, ErrShareNotFound) {
+		t.Fatalf("RevokeShareLink() of another session=%v, want %v", err, ErrShareNotFound)
+	}
+	viewers, err := RevokeShareLink(client, "conn", share.Token)
+	if err != nil || viewers != 1 {
+		t.Fatalf("RevokeShareLink()=%d, %v, want 1 viewer", viewers, err)
+	}
+
+	select {
+	case token := <-revoked:
+		if token != share.Token {
+			t.Errorf("revoked %q, want %q", token, share.Token)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("revocation was not published")
+	}
+
+	// viewers leaving a revoked link must not recreate it without an expiry
+	if err = LeaveShareLink(client, share.Token); err != nil {
+		t.Fatalf("LeaveShareLink()=%v", err)
+	}
+	if server.Exists(shareViewersKey(share.Token)) {
+		t.Error("viewer count of the revoked link was recreated")
+	}
+	if _, err = JoinShareLink(client, share.Token); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("JoinShareLink() of a revoked link=%v, want %v", err, ErrShareNotFound)
+	}
+	shares, err := ListShareLinks(client, "conn")
+	if err != nil || len(shares) != 0 {
+		t.Errorf("ListShareLinks()=%v, %v, want no links", shares, err)
+	}
+}
+
+func TestShareLinkExpiry(t *testing.T) {
+	server, client := newTestClient(t)
+	short, err := CreateShareLink(client, "conn", ShareModeViewOnly, 1, time.Minute)
+	if err != nil {
+		t.Fatalf("CreateShareLink()=%v", err)
+	}
+	long, err := CreateShareLink(client, "conn", ShareModeViewOnly, 1, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateShareLink()=%v", err)
+	}
+
+	if ttl := server.TTL(sessionSharesKey("conn")); ttl != time.Hour {
+		t.Errorf("index of the session expires in %v, want with its longest lived link", ttl)
+	}
+
+	server.FastForward(2 * time.Minute)
+
+	if _, err = JoinShareLink(client, short.Token); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("JoinShareLink() of an expired link=%v, want %v", err, ErrShareNotFound)
+	}
+	shares, err := ListShareLinks(client, "conn")
+	if err != nil {
+		t.Fatalf("ListShareLinks()=%v", err)
+	}
+	if len(shares) != 1 || shares[0].Token != long.Token {
+		t.Errorf("ListShareLinks()=%v, want only the link that did not expire", shares)
+	}
+	if members, _ := server.Members(sessionSharesKey("conn")); len(members) != 1 {
+		t.Errorf("index of the session holds %d links, want the expired one dropped", len(members))
+	}
+}
@@ -0,0 +1,220 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Resumable upload statuses, an upload is delivered to the sandbox once all of it arrived
+const (
+	UploadReceiving  = "receiving"
+	UploadProcessing = "processing"
+	UploadCompleted  = "completed"
+	UploadFailed     = "failed"
+)
+
+// UploadTTL is how long an unfinished resumable upload can be resumed
+const UploadTTL = 24 * time.Hour
+
+var (
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrUploadChanged is returned when an upload was written by another request since it was read
+	ErrUploadChanged = errors.New("upload was changed by another request")
+)
+
+// ResumableUpload is a file uploaded in several requests through the tus protocol. The data
+// is kept in a MinIO multipart upload; bytes that do not fill a part yet wait in a pending
+// object until the next request.
+type ResumableUpload struct {
+	ID           string       `json:"id"`
+	ConnectionID string       `json:"connection_id"` // Session the file is uploaded to
+	Owner        string       `json:"owner"`         // User whose file library keeps the file
+	Filename     string       `json:"filename"`
+	Length       int64        `json:"length"`
+	Offset       int64        `json:"offset"` // Bytes received and stored so far
+	Object       string       `json:"object"` // MinIO object the upload is assembled in
+	MultipartID  string       `json:"multipart_id"`
+	Parts        []UploadPart `json:"parts,omitempty"`
+	PendingSize  int64        `json:"pending_size,omitempty"` // Bytes in the pending object
+	Status       string       `json:"status"`
+	// ProcessingDeadline is when delivering the upload times out, an upload still processing
+	// afterwards was left behind by a server that stopped
+	ProcessingDeadline time.Time `json:"processing_deadline"`
+	// Results are the results of delivering the completed upload, see api.UploadResult
+	Results   json.RawMessage `json:"results,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// ProcessingStalled reports whether the delivery of the upload ended without recording its
+// result, so that it can be retried or deleted
+func (u *ResumableUpload) ProcessingStalled() bool {
+	return u.Status == UploadProcessing && time.Now().After(u.ProcessingDeadline)
+}
+
+// UploadPart is a part of the MinIO multipart upload of a resumable upload
+type UploadPart struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+func uploadKey(id string) string {
+	return fmt.Sprintf("upload:%s", id)
+}
+
+func uploadLockKey(id string) string {
+	return fmt.Sprintf("upload-lock:%s", id)
+}
+
+// NewUploadID returns a random ID for a new resumable upload
+func NewUploadID() (string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("error generating upload ID: %v", err)
+	}
+	return id[:32], nil
+}
+
+// SaveResumableUpload stores a resumable upload, renewing its expiry
+func SaveResumableUpload(client *redis.Client, upload *ResumableUpload) error {
+	upload.UpdatedAt = time.Now()
+	uploadJSON, err := json.Marshal(upload)
+	if err != nil {
+		return fmt.Errorf("error marshaling upload: %v", err)
+	}
+
+	if err = client.Set(context.Background(), uploadKey(upload.ID), uploadJSON, UploadTTL).Err(); err != nil {
+		return fmt.Errorf("error storing upload: %v", err)
+	}
+	return nil
+}
+
+// UpdateResumableUpload stores an upload read before, renewing its expiry. It fails with
+// ErrUploadNotFound if the upload was deleted meanwhile and with ErrUploadChanged if it was
+// stored again, so that a request never overwrites or recreates the upload of another.
+func UpdateResumableUpload(client *redis.Client, upload *ResumableUpload) error {
+	ctx := context.Background()
+	key := uploadKey(upload.ID)
+	updatedAt := time.Now()
+
+	err := client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := getResumableUpload(ctx, tx, upload.ID)
+		if err != nil {
+			return err
+		}
+		if !current.UpdatedAt.Equal(upload.UpdatedAt) {
+			return ErrUploadChanged
+		}
+
+		updated := *upload
+		updated.UpdatedAt = updatedAt
+		uploadJSON, err := json.Marshal(&updated)
+		if err != nil {
+			return fmt.Errorf("error marshaling upload: %v", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, uploadJSON, UploadTTL)
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return ErrUploadChanged
+	}
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) || errors.Is(err, ErrUploadChanged) {
+			return err
+		}
+		return fmt.Errorf("error storing upload: %v", err)
+	}
+	upload.UpdatedAt = updatedAt
+	return nil
+}
+
+// GetResumableUpload returns the resumable upload with the given ID
+func GetResumableUpload(client *redis.Client, id string) (*ResumableUpload, error) {
+	return getResumableUpload(context.Background(), client, id)
+}
+
+func getResumableUpload(ctx context.Context, client redis.Cmdable, id string) (*ResumableUpload, error) {
+	uploadJSON, err := client.Get(ctx, uploadKey(id)).Result()
+	if err == redis.Nil {
+		return nil, ErrUploadNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error retrieving upload: %v", err)
+	}
+
+	var upload ResumableUpload
+	if err = json.Unmarshal([]byte(uploadJSON), &upload); err != nil {
+		return nil, fmt.Errorf("error unmarshaling upload: %v", err)
+	}
+	return &upload, nil
+}
+
+// DeleteResumableUpload removes a resumable upload
+func DeleteResumableUpload(client *redis.Client, id string) error {
+	if err := client.Del(context.Background(), uploadKey(id)).Err(); err != nil {
+		return fmt.Errorf("error deleting upload: %v", err)
+	}
+	return nil
+}
+
+// refreshUploadLockScript extends the lock of an upload if it is still held with the token
+var refreshUploadLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// unlockUploadScript releases the lock of an upload if it is still held with the token, so
+// that a lock that expired and was taken by another request is left alone
+var unlockUploadScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// LockResumableUpload keeps other requests from writing to an upload until it is unlocked
+// or ttl passed. It returns the token the lock is held with, "" if the upload is already
+// locked.
+func LockResumableUpload(client *redis.Client, id string, ttl time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("error generating lock token: %v", err)
+	}
+	locked, err := client.SetNX(context.Background(), uploadLockKey(id), token, ttl).Result()
+	if err != nil {
+		return "", fmt.Errorf("error locking upload: %v", err)
+	}
+	if !locked {
+		return "", nil
+	}
+	return token, nil
+}
+
+// RefreshResumableUploadLock extends the lock of an upload to ttl from now. It reports
+// false if the lock is no longer held with the token.
+func RefreshResumableUploadLock(client *redis.Client, id, token string, ttl time.Duration) (bool, error) {
+	held, err := refreshUploadLockScript.Run(context.Background(), client, []string{uploadLockKey(id)}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("error refreshing upload lock: %v", err)
+	}
+	return held == 1, nil
+}
+
+// UnlockResumableUpload releases the lock of an upload held with the token
+func UnlockResumableUpload(client *redis.Client, id, token string) error {
+	if err := unlockUploadScript.Run(context.Background(), client, []string{uploadLockKey(id)}, token).Err(); err != nil {
+		return fmt.Errorf("error unlocking upload: %v", err)
+	}
+	return nil
+}
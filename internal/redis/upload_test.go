@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUpdateResumableUpload(t *testing.T) {
+	_, client := newTestClient(t)
+	upload := &ResumableUpload{ID: "upload", Length: 100, Status: UploadReceiving}
+	if err := SaveResumableUpload(client, upload); err != nil {
+		t.Fatal(err)
+	}
+
+	// two requests read the upload, the first one to store it wins
+	first, err := GetResumableUpload(client, upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := *first
+	first.Offset = 40
+	if err = UpdateResumableUpload(client, first); err != nil {
+		t.Fatalf("UpdateResumableUpload()=%v", err)
+	}
+	second.Offset = 60
+	if err = UpdateResumableUpload(client, &second); !errors.Is(err, ErrUploadChanged) {
+		t.Fatalf("UpdateResumableUpload() of a stale upload=%v, want %v", err, ErrUploadChanged)
+	}
+	if stored, _ := GetResumableUpload(client, upload.ID); stored.Offset != 40 {
+		t.Errorf("offset=%d, want the first update", stored.Offset)
+	}
+
+	// an upload can be updated again after it was stored
+	first.Offset = 80
+	if err = UpdateResumableUpload(client, first); err != nil {
+		t.Fatalf("UpdateResumableUpload() after an update=%v", err)
+	}
+
+	// a request finishing after the upload was deleted does not store it again
+	if err = DeleteResumableUpload(client, upload.ID); err != nil {
+		t.Fatal(err)
+	}
+	first.Offset = 100
+	if err = UpdateResumableUpload(client, first); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("UpdateResumableUpload() of a deleted upload=%v, want %v", err, ErrUploadNotFound)
+	}
+	if _, err = GetResumableUpload(client, upload.ID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("deleted upload was stored again: %v", err)
+	}
+}
+
+func TestResumableUploadProcessingStalled(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		status   string
+		deadline time.Time
+		stalled  bool
+	}{
+		{"processing", UploadProcessing, time.Now().Add(time.Minute), false},
+		{"past the deadline", UploadProcessing, time.Now().Add(-time.Minute), true},
+		{"without a deadline", UploadProcessing, time.Time{}, true},
+		{"receiving", UploadReceiving, time.Time{}, false},
+		{"failed", UploadFailed, time.Now().Add(-time.Minute), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			upload := &ResumableUpload{Status: test.status, ProcessingDeadline: test.deadline}
+			if stalled := upload.ProcessingStalled(); stalled != test.stalled {
+				t.Errorf("ProcessingStalled()=%v, want %v", stalled, test.stalled)
+			}
+		})
+	}
+}
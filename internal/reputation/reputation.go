@@ -0,0 +1,76 @@
+package reputation
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Verdicts of a file hash
+const (
+	VerdictClean     = "clean"
+	VerdictMalicious = "malicious"
+)
+
+// Sources of a verdict. Verdicts of administrators are never replaced by scans.
+const (
+	SourceScan  = "scan"
+	SourceAdmin = "admin"
+)
+
+// Entry is the verdict known for the SHA-256 hash of a file
+type Entry struct {
+	SHA256    string    `json:"sha256"`
+	Verdict   string    `json:"verdict"`
+	Threats   []string  `json:"threats"`
+	Source    string    `json:"source"`
+	Scanner   string    `json:"scanner,omitempty"` // Scanner that reached a scan verdict
+	Note      string    `json:"note,omitempty"`    // Why an administrator set the verdict
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Malicious reports whether files with the hash must not be delivered
+func (e *Entry) Malicious() bool {
+	return e.Verdict == VerdictMalicious
+}
+
+// Delivery records an upload of a file to a session
+type Delivery struct {
+	ID           int64     `json:"id"`
+	SHA256       string    `json:"sha256"`
+	ConnectionID string    `json:"connection_id"`
+	Filename     string    `json:"filename"`
+	Size         int64     `json:"size"`
+	Verdict      string    `json:"verdict"` // Verdict when it was uploaded, empty if unknown
+	Delivered    bool      `json:"delivered"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// NormalizeHash lower-cases a hex encoded SHA-256 hash and checks that it is one
+func NormalizeHash(hash string) (string, error) {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	if len(hash) != 64 {
+		return "", fmt.Errorf("invalid SHA-256 hash %q", hash)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", fmt.Errorf("invalid SHA-256 hash %q", hash)
+	}
+	return hash, nil
+}
+
+// normalize checks an entry set by an administrator
+func (e *Entry) normalize() error {
+	var err error
+	if e.SHA256, err = NormalizeHash(e.SHA256); err != nil {
+		return err
+	}
+	if e.Verdict != VerdictClean && e.Verdict != VerdictMalicious {
+		return fmt.Errorf("verdict must be %q or %q", VerdictClean, VerdictMalicious)
+	}
+	if e.Threats == nil || e.Verdict == VerdictClean {
+		e.Threats = []string{}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package reputation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHash(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	for input, want := range map[string]string{
+		hash:                               hash,
+		" " + strings.ToUpper(hash) + "\n": hash,
+		hash[:62]:                          "",
+		strings.Repeat("zz", 32):           "",
+	} {
+		got, err := NormalizeHash(input)
+		if want == "" {
+			if err == nil {
+				t.Errorf("NormalizeHash(%q)=%q, want an error", input, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("NormalizeHash(%q)=%q, %v, want %q", input, got, err, want)
+		}
+	}
+}
+
+func TestEntryNormalize(t *testing.T) {
+	entry := Entry{SHA256: strings.Repeat("0", 64), Verdict: VerdictClean, Threats: []string{"Eicar"}}
+	if err := entry.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.Threats) != 0 {
+		t.Errorf("clean entry kept threats %q", entry.Threats)
+	}
+
+	entry = Entry{SHA256: strings.Repeat("0", 64), Verdict: "suspicious"}
+	if err := entry.normalize(); err == nil {
+		t.Error("entry with an unknown verdict was accepted")
+	}
+}
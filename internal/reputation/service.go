@@ -0,0 +1,232 @@
+package reputation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	sqlc "github.com/browsersec/KubeBrowse/db/sqlc"
+	"github.com/browsersec/KubeBrowse/internal/scanner"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrHashNotFound = errors.New("hash not found")
+
+// Service keeps the verdicts of file hashes in Postgres, cached in Redis, so that files
+// which were already scanned or were imported by administrators are not scanned again.
+type Service struct {
+	db          *sqlc.Queries
+	redisClient *redis.Client
+	// cacheTTL is how long verdicts are cached in Redis
+	cacheTTL time.Duration
+	// maxScanAge is how long clean verdicts of scans are trusted, scanners learn of new
+	// threats over time. Malicious verdicts and those of administrators do not expire.
+	maxScanAge time.Duration
+}
+
+func NewService(db *sqlc.Queries, redisClient *redis.Client, cacheTTL, maxScanAge time.Duration) *Service {
+	return &Service{
+		db:          db,
+		redisClient: redisClient,
+		cacheTTL:    cacheTTL,
+		maxScanAge:  maxScanAge,
+	}
+}
+
+func cacheKey(hash string) string {
+	return fmt.Sprintf("file-reputation:%s", hash)
+}
+
+// Lookup returns the verdict known for a hash, nil if files with the hash must be scanned
+func (s *Service) Lookup(ctx context.Context, hash string) (*Entry, error) {
+	entry, err := s.cached(ctx, hash)
+	if err != nil {
+		logrus.Warnf("Failed to read cached reputation of %s: %v", hash, err)
+	}
+	if entry == nil {
+		dbEntry, err := s.db.GetFileReputation(ctx, hash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file reputation: %w", err)
+		}
+		entry = convertDBEntry(dbEntry)
+		s.cache(ctx, entry)
+	}
+
+	if entry.Source == SourceScan && !entry.Malicious() && time.Since(entry.UpdatedAt) > s.maxScanAge {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+func (s *Service) cached(ctx context.Context, hash string) (*Entry, error) {
+	entryJSON, err := s.redisClient.Get(ctx, cacheKey(hash)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entry Entry
+	if err = json.Unmarshal(entryJSON, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *Service) cache(ctx context.Context, entry *Entry) {
+	entryJSON, err := json.Marshal(entry)
+	if err == nil {
+		err = s.redisClient.Set(ctx, cacheKey(entry.SHA256), entryJSON, s.cacheTTL).Err()
+	}
+	if err != nil {
+		logrus.Warnf("Failed to cache reputation of %s: %v", entry.SHA256, err)
+	}
+}
+
+// uncache drops the cached verdict of a hash after it changed
+func (s *Service) uncache(ctx context.Context, hash string) {
+	if err := s.redisClient.Del(ctx, cacheKey(hash)).Err(); err != nil {
+		logrus.Warnf("Failed to drop cached reputation of %s: %v", hash, err)
+	}
+}
+
+// RecordScan records the verdict of a scan of a file, unless an administrator set one
+func (s *Service) RecordScan(ctx context.Context, hash string, result *scanner.Result) error {
+	verdict := VerdictClean
+	threats := []string{}
+	if result.Infected {
+		verdict = VerdictMalicious
+		threats = append(threats, result.Threats...)
+	}
+
+	err := s.db.RecordScanReputation(ctx, sqlc.RecordScanReputationParams{
+		Sha256:  hash,
+		Verdict: verdict,
+		Threats: threats,
+		Scanner: result.Scanner,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record file reputation: %w", err)
+	}
+	s.uncache(ctx, hash)
+	return nil
+}
+
+// List returns the most recently updated verdicts
+func (s *Service) List(ctx context.Context, limit int32) ([]*Entry, error) {
+	dbEntries, err := s.db.ListFileReputations(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list file reputations: %w", err)
+	}
+	entries := make([]*Entry, 0, len(dbEntries))
+	for _, dbEntry := range dbEntries {
+		entries = append(entries, convertDBEntry(dbEntry))
+	}
+	return entries, nil
+}
+
+// Get returns the verdict of a hash, whatever its age
+func (s *Service) Get(ctx context.Context, hash string) (*Entry, error) {
+	dbEntry, err := s.db.GetFileReputation(ctx, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrHashNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file reputation: %w", err)
+	}
+	return convertDBEntry(dbEntry), nil
+}
+
+// Put sets the verdict of a hash as an administrator, blocking or allowing files with it
+func (s *Service) Put(ctx context.Context, entry Entry) (*Entry, error) {
+	if err := entry.normalize(); err != nil {
+		return nil, err
+	}
+
+	dbEntry, err := s.db.UpsertFileReputation(ctx, sqlc.UpsertFileReputationParams{
+		Sha256:  entry.SHA256,
+		Verdict: entry.Verdict,
+		Threats: entry.Threats,
+		Note:    entry.Note,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save file reputation: %w", err)
+	}
+	s.uncache(ctx, entry.SHA256)
+	return convertDBEntry(dbEntry), nil
+}
+
+// Delete forgets the verdict of a hash, files with it are scanned again
+func (s *Service) Delete(ctx context.Context, hash string) error {
+	deleted, err := s.db.DeleteFileReputation(ctx, hash)
+	if err != nil {
+		return fmt.Errorf("failed to delete file reputation: %w", err)
+	}
+	if deleted == 0 {
+		return ErrHashNotFound
+	}
+	s.uncache(ctx, hash)
+	return nil
+}
+
+// LogDelivery records an upload of a file to a session
+func (s *Service) LogDelivery(ctx context.Context, delivery Delivery) error {
+	err := s.db.CreateFileDelivery(ctx, sqlc.CreateFileDeliveryParams{
+		Sha256:       delivery.SHA256,
+		ConnectionID: delivery.ConnectionID,
+		Filename:     delivery.Filename,
+		Size:         delivery.Size,
+		Verdict:      delivery.Verdict,
+		Delivered:    delivery.Delivered,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to log file delivery: %w", err)
+	}
+	return nil
+}
+
+// ListDeliveries returns the most recent uploads of files with a hash
+func (s *Service) ListDeliveries(ctx context.Context, hash string, limit int32) ([]Delivery, error) {
+	dbDeliveries, err := s.db.ListFileDeliveries(ctx, sqlc.ListFileDeliveriesParams{
+		Sha256: hash,
+		Limit:  limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list file deliveries: %w", err)
+	}
+
+	deliveries := make([]Delivery, 0, len(dbDeliveries))
+	for _, dbDelivery := range dbDeliveries {
+		deliveries = append(deliveries, Delivery{
+			ID:           dbDelivery.ID,
+			SHA256:       dbDelivery.Sha256,
+			ConnectionID: dbDelivery.ConnectionID,
+			Filename:     dbDelivery.Filename,
+			Size:         dbDelivery.Size,
+			Verdict:      dbDelivery.Verdict,
+			Delivered:    dbDelivery.Delivered,
+			CreatedAt:    dbDelivery.CreatedAt,
+		})
+	}
+	return deliveries, nil
+}
+
+func convertDBEntry(dbEntry sqlc.FileReputation) *Entry {
+	return &Entry{
+		SHA256:    dbEntry.Sha256,
+		Verdict:   dbEntry.Verdict,
+		Threats:   dbEntry.Threats,
+		Source:    dbEntry.Source,
+		Scanner:   dbEntry.Scanner,
+		Note:      dbEntry.Note,
+		CreatedAt: dbEntry.CreatedAt,
+		UpdatedAt: dbEntry.UpdatedAt,
+	}
+}
@@ -0,0 +1,154 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Policy decides how the verdicts of the scanners of a chain are combined
+type Policy string
+
+const (
+	// PolicyAllMustPass finds a file clean only if every scanner scanned it and found it
+	// clean, a scanner that fails fails the scan
+	PolicyAllMustPass Policy = "all-must-pass"
+	// PolicyAnyFlag finds a file infected if any scanner flags it. Scanners that fail are
+	// ignored as long as one of them reaches a verdict.
+	PolicyAnyFlag Policy = "any-flag"
+)
+
+// ParsePolicy returns the policy with the given name, PolicyAllMustPass if it is empty
+func ParsePolicy(name string) (Policy, error) {
+	switch Policy(name) {
+	case "":
+		return PolicyAllMustPass, nil
+	case PolicyAllMustPass, PolicyAnyFlag:
+		return Policy(name), nil
+	default:
+		return "", fmt.Errorf("invalid scan policy %q, must be %s or %s", name, PolicyAllMustPass, PolicyAnyFlag)
+	}
+}
+
+// Chain scans files with several scanners at once, the file is read once and streamed to
+// all of them
+type Chain struct {
+	policy   Policy
+	scanners []Scanner
+}
+
+// NewChain creates a chain of scanners combining their verdicts with policy
+func NewChain(policy Policy, scanners ...Scanner) (*Chain, error) {
+	if _, err := ParsePolicy(string(policy)); err != nil {
+		return nil, err
+	}
+	if len(scanners) == 0 {
+		return nil, fmt.Errorf("a scanner chain needs at least one scanner")
+	}
+	return &Chain{policy: policy, scanners: scanners}, nil
+}
+
+// Name returns the names of the scanners of the chain
+func (c *Chain) Name() string {
+	names := make([]string, len(c.scanners))
+	for i, s := range c.scanners {
+		names[i] = s.Name()
+	}
+	return strings.Join(names, "+")
+}
+
+// Scan streams file to all scanners of the chain and combines their verdicts
+func (c *Chain) Scan(ctx context.Context, file io.Reader, filename string) (*Result, error) {
+	results := make([]*Result, len(c.scanners))
+	errs := make([]error, len(c.scanners))
+	writers := make([]*io.PipeWriter, len(c.scanners))
+
+	var wg sync.WaitGroup
+	for i, s := range c.scanners {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+
+		wg.Add(1)
+		go func(i int, s Scanner) {
+			defer wg.Done()
+			results[i], errs[i] = s.Scan(ctx, pr, filename)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%s: %w", s.Name(), errs[i])
+			}
+			// a scanner that stopped early no longer holds up the others
+			_ = pr.CloseWithError(errScannerDone)
+		}(i, s)
+	}
+
+	readErr := c.copyTo(writers, file)
+	wg.Wait()
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read file: %w", readErr)
+	}
+	return c.combine(results, errs)
+}
+
+var errScannerDone = errors.New("scanner done")
+
+// copyTo copies file to the writers of the scanners, dropping those that stop reading
+func (c *Chain) copyTo(writers []*io.PipeWriter, file io.Reader) error {
+	active := len(writers)
+	buf := make([]byte, 32<<10)
+	for active > 0 {
+		n, err := file.Read(buf)
+		if n > 0 {
+			for i, w := range writers {
+				if w == nil {
+					continue
+				}
+				if _, werr := w.Write(buf[:n]); werr != nil {
+					writers[i] = nil
+					active--
+				}
+			}
+		}
+		if err != nil {
+			for _, w := range writers {
+				if w == nil {
+					continue
+				}
+				if err == io.EOF {
+					_ = w.Close()
+				} else {
+					_ = w.CloseWithError(err)
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// combine applies the policy of the chain to the verdicts of its scanners
+func (c *Chain) combine(results []*Result, errs []error) (*Result, error) {
+	combined := &Result{Scanner: c.Name()}
+	var failures []error
+	for i, result := range results {
+		if errs[i] != nil {
+			failures = append(failures, errs[i])
+			continue
+		}
+		combined.Results = append(combined.Results, *result)
+		if result.Infected {
+			combined.Infected = true
+			combined.Threats = append(combined.Threats, result.Threats...)
+		}
+	}
+
+	// a file flagged by one scanner is infected whatever happened to the others
+	if len(failures) > 0 && !combined.Infected && (c.policy == PolicyAllMustPass || len(combined.Results) == 0) {
+		return nil, errors.Join(failures...)
+	}
+	return combined, nil
+}
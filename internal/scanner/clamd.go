@@ -0,0 +1,110 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the size of the chunks files are streamed to clamd in, it must stay
+// below the StreamMaxLength of clamd
+const clamdChunkSize = 32 << 10
+
+// ClamdScanner scans files with clamd over TCP using the INSTREAM command
+type ClamdScanner struct {
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner creates a scanner for the clamd listening at address, host:port
+func NewClamdScanner(address string, timeout time.Duration) *ClamdScanner {
+	return &ClamdScanner{address: address, timeout: timeout}
+}
+
+// Name returns the name of the scanner
+func (s *ClamdScanner) Name() string {
+	return "clamd"
+}
+
+// Scan streams file to clamd in length prefixed chunks and reads its verdict
+func (s *ClamdScanner) Scan(ctx context.Context, file io.Reader, filename string) (*Result, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	stop := watchContext(ctx, conn)
+	defer stop()
+
+	// the z prefix makes clamd terminate its reply with a null byte
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return nil, fmt.Errorf("failed to send INSTREAM: %w", err)
+	}
+
+	chunk := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := file.Read(chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk[:4], uint32(n))
+			if _, err := conn.Write(chunk[:4+n]); err != nil {
+				// clamd closes the stream once it exceeds StreamMaxLength, its reply says so
+				return s.readReply(conn, err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read file: %w", readErr)
+		}
+	}
+
+	// a zero length chunk ends the stream
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return s.readReply(conn, err)
+	}
+	return s.readReply(conn, nil)
+}
+
+// readReply reads the verdict of clamd, writeErr is the error that ended the stream early
+func (s *ClamdScanner) readReply(conn net.Conn, writeErr error) (*Result, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(s.timeout))
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		if writeErr != nil {
+			return nil, fmt.Errorf("failed to stream file to clamd: %w", writeErr)
+		}
+		return nil, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamdReply(s.Name(), reply)
+}
+
+// parseClamdReply parses replies like "stream: OK" and "stream: Eicar-Signature FOUND"
+func parseClamdReply(name, reply string) (*Result, error) {
+	reply = strings.TrimRight(reply, "\x00\n")
+	_, verdict, ok := strings.Cut(reply, ": ")
+	if !ok {
+		verdict = reply
+	}
+
+	switch {
+	case verdict == "OK":
+		return &Result{Scanner: name}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return &Result{
+			Scanner:  name,
+			Infected: true,
+			Threats:  []string{strings.TrimSuffix(verdict, " FOUND")},
+		}, nil
+	default:
+		return nil, fmt.Errorf("clamd: %s", verdict)
+	}
+}
@@ -0,0 +1,100 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPScanner scans files with the HTTP API in front of ClamAV, POST /api/v1/scan
+type HTTPScanner struct {
+	scanURL string
+	client  *http.Client
+}
+
+// clamAVScanResponse is the response of the HTTP API in front of ClamAV
+type clamAVScanResponse struct {
+	Success bool `json:"success"`
+	Data    struct {
+		Result []struct {
+			Name       string   `json:"name"`
+			IsInfected bool     `json:"is_infected"`
+			Viruses    []string `json:"viruses,omitempty"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// NewHTTPScanner creates a scanner for the HTTP API at baseURL
+func NewHTTPScanner(baseURL string, timeout time.Duration) *HTTPScanner {
+	// Wait at most timeout for the verdict once the file is sent, so a stuck scan does not block
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+	transport.DisableKeepAlives = true
+
+	return &HTTPScanner{
+		scanURL: strings.TrimSuffix(baseURL, "/") + "/api/v1/scan",
+		client:  &http.Client{Transport: transport},
+	}
+}
+
+// Name returns the name of the scanner
+func (s *HTTPScanner) Name() string {
+	return "clamav-http"
+}
+
+// Scan streams file to the API as the "FILES" field of a multipart form
+func (s *HTTPScanner) Scan(ctx context.Context, file io.Reader, filename string) (*Result, error) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		part, err := writer.CreateFormFile("FILES", filename)
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.scanURL, pr)
+	if err != nil {
+		_ = pr.Close()
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("scan request failed: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("scan failed with HTTP %d", resp.StatusCode)
+	}
+
+	var scanResult clamAVScanResponse
+	if err := json.NewDecoder(resp.Body).Decode(&scanResult); err != nil {
+		return nil, fmt.Errorf("invalid scan response: %w", err)
+	}
+	if !scanResult.Success || len(scanResult.Data.Result) == 0 {
+		return nil, fmt.Errorf("scan returned no verdict")
+	}
+
+	result := &Result{Scanner: s.Name()}
+	for _, file := range scanResult.Data.Result {
+		if file.IsInfected {
+			result.Infected = true
+			result.Threats = append(result.Threats, file.Viruses...)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,158 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const icapDefaultPort = "1344"
+
+// ICAPScanner scans files with an ICAP (RFC 3507) RESPMOD service, as offered by AV gateways.
+// The file is sent as the body of an HTTP response, the service answers 204 if it leaves
+// the file unmodified and flags infected files in its headers.
+type ICAPScanner struct {
+	service *url.URL
+	timeout time.Duration
+}
+
+// NewICAPScanner creates a scanner for the ICAP service at u, icap://host[:port]/service
+func NewICAPScanner(u *url.URL, timeout time.Duration) *ICAPScanner {
+	service := *u
+	if service.Port() == "" {
+		service.Host = net.JoinHostPort(service.Hostname(), icapDefaultPort)
+	}
+	return &ICAPScanner{service: &service, timeout: timeout}
+}
+
+// Name returns the name of the scanner
+func (s *ICAPScanner) Name() string {
+	return "icap"
+}
+
+// Scan sends file to the ICAP service in a RESPMOD request and reads its verdict
+func (s *ICAPScanner) Scan(ctx context.Context, file io.Reader, filename string) (*Result, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.service.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ICAP service: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	stop := watchContext(ctx, conn)
+	defer stop()
+
+	w := bufio.NewWriter(conn)
+	if err := s.writeRequest(w, file, filename); err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(s.timeout))
+	return s.readResponse(bufio.NewReader(conn))
+}
+
+// writeRequest writes a RESPMOD request encapsulating file in a chunked HTTP response
+func (s *ICAPScanner) writeRequest(w *bufio.Writer, file io.Reader, filename string) error {
+	reqHdr := "GET /" + url.PathEscape(filename) + " HTTP/1.1\r\nHost: kubebrowse\r\n\r\n"
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nTransfer-Encoding: chunked\r\n\r\n"
+
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\n", s.service.String())
+	fmt.Fprintf(w, "Host: %s\r\n", s.service.Host)
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Connection: close\r\n")
+	fmt.Fprintf(w, "Encapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n", len(reqHdr), len(reqHdr)+len(resHdr))
+	_, _ = w.WriteString(reqHdr)
+	_, _ = w.WriteString(resHdr)
+
+	chunk := make([]byte, 32<<10)
+	for {
+		n, readErr := file.Read(chunk)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			_, _ = w.Write(chunk[:n])
+			if _, err := w.WriteString("\r\n"); err != nil {
+				return fmt.Errorf("failed to stream file to ICAP service: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("failed to read file: %w", readErr)
+		}
+	}
+
+	_, _ = w.WriteString("0\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to stream file to ICAP service: %w", err)
+	}
+	return nil
+}
+
+// readResponse reads the status and headers of the ICAP response
+func (s *ICAPScanner) readResponse(r *bufio.Reader) (*Result, error) {
+	tp := textproto.NewReader(r)
+	status, err := tp.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ICAP response: %w", err)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ICAP response headers: %w", err)
+	}
+	return parseICAPResponse(s.Name(), status, header)
+}
+
+// parseICAPResponse returns the verdict of an ICAP response from its status line and headers
+func parseICAPResponse(name, status string, header textproto.MIMEHeader) (*Result, error) {
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return nil, fmt.Errorf("invalid ICAP status line %q", status)
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ICAP status line %q", status)
+	}
+
+	switch code {
+	case 204:
+		return &Result{Scanner: name}, nil
+	case 200:
+		// the service changed the file, which it only does to block it
+		return &Result{Scanner: name, Infected: true, Threats: icapThreats(header)}, nil
+	default:
+		return nil, fmt.Errorf("ICAP service returned %s", strings.Join(fields[1:], " "))
+	}
+}
+
+// icapThreats returns the threats named in the headers AV gateways commonly use
+func icapThreats(header textproto.MIMEHeader) []string {
+	var threats []string
+	// X-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Signature;
+	for _, value := range header.Values("X-Infection-Found") {
+		for _, param := range strings.Split(value, ";") {
+			if threat, ok := strings.CutPrefix(strings.TrimSpace(param), "Threat="); ok && threat != "" {
+				threats = append(threats, threat)
+			}
+		}
+	}
+	if len(threats) == 0 {
+		for _, value := range header.Values("X-Virus-ID") {
+			if value = strings.TrimSpace(value); value != "" {
+				threats = append(threats, value)
+			}
+		}
+	}
+	if len(threats) == 0 {
+		threats = append(threats, "blocked by ICAP service")
+	}
+	return threats
+}
@@ -0,0 +1,92 @@
+// Package scanner checks files for malware with pluggable backends: the HTTP API in front
+// of ClamAV, clamd itself over its INSTREAM protocol and ICAP servers such as commercial
+// AV gateways. Several scanners can be chained, see Chain.
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Result is the verdict of a scan
+type Result struct {
+	// Scanner is the name of the scanner that produced the verdict
+	Scanner  string   `json:"scanner"`
+	Infected bool     `json:"infected"`
+	Threats  []string `json:"threats,omitempty"`
+	// Results are the verdicts of the scanners of a chain
+	Results []Result `json:"results,omitempty"`
+}
+
+// Scanner scans files for malware. Scan reads the file until EOF and fails unless the
+// scanner reached a verdict.
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, file io.Reader, filename string) (*Result, error)
+}
+
+// New creates a scanner from its address, the scheme picks the backend:
+//
+//	http://clamd-api:3000          the HTTP API in front of ClamAV
+//	clamd://clamav:3310            clamd, INSTREAM over TCP
+//	icap://gateway:1344/avscan     an ICAP RESPMOD service
+//
+// timeout bounds how long the scanner waits for the verdict once the file is sent.
+func New(address string, timeout time.Duration) (Scanner, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scanner address %q: %w", address, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid scanner address %q: missing host", address)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return NewHTTPScanner(address, timeout), nil
+	case "clamd", "tcp":
+		return NewClamdScanner(u.Host, timeout), nil
+	case "icap":
+		return NewICAPScanner(u, timeout), nil
+	default:
+		return nil, fmt.Errorf("invalid scanner address %q: unsupported scheme %q", address, u.Scheme)
+	}
+}
+
+// NewFromList creates the scanners of a comma separated list of addresses, see New. A
+// single scanner is returned as is, several are chained with the given policy.
+func NewFromList(addresses string, policy Policy, timeout time.Duration) (Scanner, error) {
+	var scanners []Scanner
+	for _, address := range strings.Split(addresses, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		s, err := New(address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		scanners = append(scanners, s)
+	}
+
+	switch len(scanners) {
+	case 0:
+		return nil, fmt.Errorf("no scanner addresses")
+	case 1:
+		return scanners[0], nil
+	default:
+		return NewChain(policy, scanners...)
+	}
+}
+
+// watchContext closes c when ctx is done, so blocked reads and writes of a scan return.
+// The returned function stops watching.
+func watchContext(ctx context.Context, c io.Closer) func() bool {
+	return context.AfterFunc(ctx, func() {
+		_ = c.Close()
+	})
+}
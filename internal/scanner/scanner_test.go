@@ -0,0 +1,275 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// serveOnce accepts one connection on a local listener and hands it to handle
+func serveOnce(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+	return l.Addr().String()
+}
+
+// fakeClamd reads an INSTREAM request and flags files containing the EICAR string
+func fakeClamd(t *testing.T) string {
+	return serveOnce(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		command, err := r.ReadString(0)
+		if err != nil || command != "zINSTREAM\x00" {
+			_, _ = io.WriteString(conn, "UNKNOWN COMMAND\x00")
+			return
+		}
+		var file bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&file, r, int64(size)); err != nil {
+				return
+			}
+		}
+		if strings.Contains(file.String(), eicar) {
+			_, _ = io.WriteString(conn, "stream: Eicar-Signature FOUND\x00")
+		} else {
+			_, _ = io.WriteString(conn, "stream: OK\x00")
+		}
+	})
+}
+
+// fakeICAP reads a RESPMOD request, returns 204 for clean files and blocks files containing
+// the EICAR string
+func fakeICAP(t *testing.T) string {
+	return serveOnce(t, func(conn net.Conn) {
+		tp := textproto.NewReader(bufio.NewReader(conn))
+		if _, err := tp.ReadLine(); err != nil {
+			return
+		}
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		// skip the encapsulated HTTP request and response headers
+		var bodyOffset int
+		for _, part := range strings.Split(header.Get("Encapsulated"), ",") {
+			if offset, ok := strings.CutPrefix(strings.TrimSpace(part), "res-body="); ok {
+				bodyOffset, _ = strconv.Atoi(offset)
+			}
+		}
+		if _, err := io.CopyN(io.Discard, tp.R, int64(bodyOffset)); err != nil {
+			return
+		}
+
+		var file bytes.Buffer
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			size, _ := strconv.ParseInt(line, 16, 64)
+			if size == 0 {
+				_, _ = tp.ReadLine()
+				break
+			}
+			if _, err := io.CopyN(&file, tp.R, size); err != nil {
+				return
+			}
+			_, _ = tp.ReadLine()
+		}
+
+		if strings.Contains(file.String(), eicar) {
+			_, _ = io.WriteString(conn, "ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;\r\nEncapsulated: null-body=0\r\n\r\n")
+		} else {
+			_, _ = io.WriteString(conn, "ICAP/1.0 204 No Content\r\nEncapsulated: null-body=0\r\n\r\n")
+		}
+	})
+}
+
+func TestClamdScanner(t *testing.T) {
+	t.Run("Clean", func(t *testing.T) {
+		s := NewClamdScanner(fakeClamd(t), time.Second)
+		result, err := s.Scan(context.Background(), strings.NewReader("hello"), "hello.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Infected {
+			t.Fatalf("clean file flagged: %+v", result)
+		}
+	})
+
+	t.Run("Infected", func(t *testing.T) {
+		s := NewClamdScanner(fakeClamd(t), time.Second)
+		result, err := s.Scan(context.Background(), strings.NewReader(eicar), "eicar.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Infected || len(result.Threats) != 1 || result.Threats[0] != "Eicar-Signature" {
+			t.Fatalf("Scan=%+v, want Eicar-Signature", result)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		if _, err := parseClamdReply("clamd", "INSTREAM size limit exceeded. ERROR\x00"); err == nil {
+			t.Fatal("error reply accepted")
+		}
+	})
+}
+
+func TestICAPScanner(t *testing.T) {
+	scan := func(t *testing.T, content string) *Result {
+		u, _ := url.Parse("icap://" + fakeICAP(t) + "/avscan")
+		result, err := NewICAPScanner(u, time.Second).Scan(context.Background(), strings.NewReader(content), "file.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if result := scan(t, "hello"); result.Infected {
+		t.Fatalf("clean file flagged: %+v", result)
+	}
+	if result := scan(t, eicar); !result.Infected || result.Threats[0] != "Eicar-Test-Signature" {
+		t.Fatalf("Scan=%+v, want Eicar-Test-Signature", result)
+	}
+}
+
+func TestParseICAPResponse(t *testing.T) {
+	result, err := parseICAPResponse("icap", "ICAP/1.0 200 OK", textproto.MIMEHeader{"X-Virus-Id": {"Trojan.Generic"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Infected || result.Threats[0] != "Trojan.Generic" {
+		t.Fatalf("parseICAPResponse=%+v, want Trojan.Generic", result)
+	}
+
+	if _, err := parseICAPResponse("icap", "ICAP/1.0 500 Server Error", nil); err == nil {
+		t.Fatal("server error accepted")
+	}
+}
+
+type fakeScanner struct {
+	name     string
+	infected bool
+	err      error
+	read     []byte
+}
+
+func (s *fakeScanner) Name() string {
+	return s.name
+}
+
+func (s *fakeScanner) Scan(ctx context.Context, file io.Reader, filename string) (*Result, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.read, _ = io.ReadAll(file)
+	return &Result{Scanner: s.name, Infected: s.infected}, nil
+}
+
+func TestChain(t *testing.T) {
+	scan := func(t *testing.T, policy Policy, scanners ...Scanner) (*Result, error) {
+		chain, err := NewChain(policy, scanners...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chain.Scan(context.Background(), strings.NewReader("content"), "file.txt")
+	}
+
+	t.Run("StreamsToAll", func(t *testing.T) {
+		a, b := &fakeScanner{name: "a"}, &fakeScanner{name: "b"}
+		result, err := scan(t, PolicyAllMustPass, a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Infected || len(result.Results) != 2 {
+			t.Fatalf("Scan=%+v, want two clean results", result)
+		}
+		if string(a.read) != "content" || string(b.read) != "content" {
+			t.Fatalf("scanners read %q and %q", a.read, b.read)
+		}
+	})
+
+	t.Run("AnyFlagInfects", func(t *testing.T) {
+		result, err := scan(t, PolicyAnyFlag, &fakeScanner{name: "a"}, &fakeScanner{name: "b", infected: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Infected {
+			t.Fatal("flagged file not infected")
+		}
+	})
+
+	t.Run("AllMustPassFailsOnError", func(t *testing.T) {
+		_, err := scan(t, PolicyAllMustPass, &fakeScanner{name: "a"}, &fakeScanner{name: "b", err: errors.New("unavailable")})
+		if err == nil {
+			t.Fatal("failed scanner ignored")
+		}
+	})
+
+	t.Run("AnyFlagToleratesError", func(t *testing.T) {
+		result, err := scan(t, PolicyAnyFlag, &fakeScanner{name: "a"}, &fakeScanner{name: "b", err: errors.New("unavailable")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Infected || len(result.Results) != 1 {
+			t.Fatalf("Scan=%+v, want one clean result", result)
+		}
+	})
+
+	t.Run("AnyFlagFailsWithoutVerdict", func(t *testing.T) {
+		_, err := scan(t, PolicyAnyFlag, &fakeScanner{name: "a", err: errors.New("unavailable")})
+		if err == nil {
+			t.Fatal("scan without verdict succeeded")
+		}
+	})
+}
+
+func TestNew(t *testing.T) {
+	for address, name := range map[string]string{
+		"http://clamd-api:3000":      "clamav-http",
+		"clamd://clamav:3310":        "clamd",
+		"icap://gateway:1344/avscan": "icap",
+	} {
+		s, err := New(address, time.Second)
+		if err != nil {
+			t.Fatalf("New(%q): %v", address, err)
+		}
+		if s.Name() != name {
+			t.Fatalf("New(%q).Name()=%q, want %q", address, s.Name(), name)
+		}
+	}
+
+	if _, err := New("ftp://scanner", time.Second); err == nil {
+		t.Fatal("unsupported scheme accepted")
+	}
+}